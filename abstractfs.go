@@ -36,6 +36,8 @@ func newRootCmd() *cobra.Command {
 	rootCmd.AddCommand(cmd.NewJSONCmd())
 	rootCmd.AddCommand(cmd.NewConvertCmd())
 	rootCmd.AddCommand(cmd.NewCASCmd())
+	rootCmd.AddCommand(cmd.NewDiffCmd())
+	rootCmd.AddCommand(cmd.NewLayerDiffCmd())
 	return rootCmd
 }
 
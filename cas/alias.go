@@ -0,0 +1,190 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+
+	"github.com/malt3/abstractfs-core/api"
+	coresri "github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
+	"github.com/malt3/abstractfs/cas/internal/verify"
+)
+
+// Digests splits an sri into single digest sris.
+// As allowed by the SRI spec, an sri may contain several space separated digests,
+// for example of the same content hashed with different algorithms.
+// Options ("?...") are stripped and digests that cannot be parsed are ignored.
+// The returned sris are normalized.
+func Digests(sri string) []string {
+	var digests []string
+	for _, field := range strings.Fields(sri) {
+		field, _, _ = strings.Cut(field, "?")
+		integrity, err := coresri.FromString(field)
+		if err != nil {
+			continue
+		}
+		digests = append(digests, integrity.String())
+	}
+	return digests
+}
+
+// MultiHash hashes written data with several algorithms at once.
+type MultiHash struct {
+	algorithms []coresri.Algorithm
+	hashes     []hash.Hash
+}
+
+// NewMultiHash returns a MultiHash for the algorithms.
+// Duplicate algorithms are hashed once.
+func NewMultiHash(algorithms ...coresri.Algorithm) (*MultiHash, error) {
+	m := &MultiHash{}
+	for _, algorithm := range algorithms {
+		if m.has(algorithm) {
+			continue
+		}
+		h, err := verify.NewHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		m.algorithms = append(m.algorithms, algorithm)
+		m.hashes = append(m.hashes, h)
+	}
+	return m, nil
+}
+
+func (m *MultiHash) Write(p []byte) (int, error) {
+	for _, h := range m.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Sums returns one sri per algorithm, in the order the algorithms were first given.
+func (m *MultiHash) Sums() []string {
+	sums := make([]string, len(m.hashes))
+	for i, h := range m.hashes {
+		sums[i] = coresri.Integrity{Algorithm: m.algorithms[i], Hash: h.Sum(nil)}.String()
+	}
+	return sums
+}
+
+func (m *MultiHash) has(algorithm coresri.Algorithm) bool {
+	for _, known := range m.algorithms {
+		if known == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash reads r and returns its sri for every algorithm (see MultiHash.Sums).
+// The first sri is the one of the first algorithm, the others are its aliases.
+func Hash(r io.Reader, algorithms ...coresri.Algorithm) ([]string, error) {
+	m, err := NewMultiHash(algorithms...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(m, r); err != nil {
+		return nil, err
+	}
+	return m.Sums(), nil
+}
+
+// AliasCAS indexes the blobs of a CAS by their sris in additional algorithms.
+// Blobs are stored in the inner CAS under the sri they are written with
+// and can be opened by any of their aliases.
+// The alias index is only kept in memory and lasts as long as the AliasCAS:
+// blobs outlive it in a persistent inner CAS, but can then only be opened by the sri they were written with.
+// It is meant for CAS that do not outlive the process, like the spill CAS of a source.
+type AliasCAS struct {
+	inner      api.CAS
+	algorithms []coresri.Algorithm
+	mux        sync.RWMutex
+	// aliases maps aliases to the sri the blob is stored under.
+	aliases map[string]string
+}
+
+// NewAliasCAS returns a CAS that additionally hashes written blobs with the algorithms.
+func NewAliasCAS(inner api.CAS, algorithms ...coresri.Algorithm) *AliasCAS {
+	return &AliasCAS{
+		inner:      inner,
+		algorithms: algorithms,
+		aliases:    make(map[string]string),
+	}
+}
+
+// Open opens the blob.
+// The sri may be an alias and may contain several space separated digests.
+func (a *AliasCAS) Open(sri string) (io.ReadCloser, error) {
+	for _, candidate := range a.candidates(sri) {
+		blob, err := a.inner.Open(candidate)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return blob, err
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// Write writes the blob to the inner CAS under the first digest of the sri
+// and indexes it by its sris in the additional algorithms.
+func (a *AliasCAS) Write(sri string, r io.Reader) error {
+	digests := Digests(sri)
+	if len(digests) == 0 {
+		return a.inner.Write(sri, r)
+	}
+	integrity, err := coresri.FromString(digests[0])
+	if err != nil {
+		return err
+	}
+	hasher, err := NewMultiHash(append([]coresri.Algorithm{integrity.Algorithm}, a.algorithms...)...)
+	if err != nil {
+		return err
+	}
+	tee := io.TeeReader(r, hasher)
+	if err := a.inner.Write(digests[0], tee); err != nil {
+		return err
+	}
+	// the inner CAS may skip reading blobs it already has
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return err
+	}
+	sums := hasher.Sums()
+	if sums[0] != digests[0] {
+		return fmt.Errorf("validating sri on write: blob does not match %s", digests[0])
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	for _, alias := range sums[1:] {
+		if _, ok := a.aliases[alias]; !ok {
+			a.aliases[alias] = digests[0]
+		}
+	}
+	return nil
+}
+
+// candidates returns the sris a blob with the sri may be stored under.
+func (a *AliasCAS) candidates(sri string) []string {
+	digests := Digests(sri)
+	if len(digests) == 0 {
+		return []string{sri}
+	}
+	a.mux.RLock()
+	defer a.mux.RUnlock()
+	candidates := make([]string, 0, len(digests))
+	for _, digest := range digests {
+		if stored, ok := a.aliases[digest]; ok {
+			digest = stored
+		}
+		if !casutil.Contains(candidates, digest) {
+			candidates = append(candidates, digest)
+		}
+	}
+	return candidates
+}
+
+var _ api.CAS = (*AliasCAS)(nil)
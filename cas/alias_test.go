@@ -0,0 +1,88 @@
+package cas_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	coresri "github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+func TestAliasCAS(t *testing.T) {
+	const contents = "contents"
+	sris, err := cas.Hash(strings.NewReader(contents), coresri.SHA256, coresri.SHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256, sha512 := sris[0], sris[1]
+	unknown, err := cas.Hash(strings.NewReader("other"), coresri.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner := memory.NewCAS(false)
+	aliases := cas.NewAliasCAS(inner, coresri.SHA512)
+	if err := aliases.Write(sha256, strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		sri     string
+		wantErr error
+	}{
+		"written sri":           {sri: sha256},
+		"alias":                 {sri: sha512},
+		"several digests":       {sri: unknown[0] + " " + sha512},
+		"unknown sri":           {sri: unknown[0], wantErr: fs.ErrNotExist},
+		"invalid sri":           {sri: "not an sri", wantErr: fs.ErrNotExist},
+		"sri with options":      {sri: sha512 + "?opt"},
+		"alias and written sri": {sri: sha512 + " " + sha256},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			blob, err := aliases.Open(tc.sri)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("Open() returned %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer blob.Close()
+			got, err := io.ReadAll(blob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != contents {
+				t.Errorf("got %q, want %q", got, contents)
+			}
+		})
+	}
+
+	t.Run("alias index is not persisted", func(t *testing.T) {
+		reopened := cas.NewAliasCAS(inner, coresri.SHA512)
+		if _, err := reopened.Open(sha512); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open() of the alias returned %v, want %v", err, fs.ErrNotExist)
+		}
+		if _, err := reopened.Open(sha256); err != nil {
+			t.Errorf("Open() of the written sri returned %v", err)
+		}
+	})
+}
+
+func TestAliasCASWriteMismatch(t *testing.T) {
+	sris, err := cas.Hash(strings.NewReader("contents"), coresri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliases := cas.NewAliasCAS(memory.NewCAS(false), coresri.SHA512)
+	if err := aliases.Write(sris[0], strings.NewReader("other")); err == nil {
+		t.Error("Write() succeeded for contents that do not match the sri")
+	}
+}
@@ -0,0 +1,115 @@
+// Package cas defines optional operations of content addressable storages
+// beyond reading and writing blobs (see api.CAS).
+// Backends implement the interfaces they support.
+package cas
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	SRI  string `json:"sri"`
+	Size int64  `json:"size"`
+	// ModTime is the time the blob was written. It is zero if unknown.
+	ModTime time.Time `json:"mtime"`
+	// Aliases are the sris of the blob in other algorithms that the CAS also accepts.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Stater returns information about blobs without reading them.
+type Stater interface {
+	// Stat returns information about the blob. It returns fs.ErrNotExist if the blob does not exist.
+	Stat(sri string) (BlobInfo, error)
+}
+
+// Stat returns information about the blob.
+// If the CAS does not implement Stater, the blob is read to determine its size.
+func Stat(c api.CASReader, sri string) (BlobInfo, error) {
+	if stater, ok := c.(Stater); ok {
+		return stater.Stat(sri)
+	}
+	blob, err := c.Open(sri)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer blob.Close()
+	size, err := io.Copy(io.Discard, blob)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{SRI: sri, Size: size}, nil
+}
+
+// Exists returns true if the CAS has the blob.
+// If the CAS does not implement Stater, the blob is opened, but not read.
+func Exists(c api.CASReader, sri string) (bool, error) {
+	var err error
+	if stater, ok := c.(Stater); ok {
+		_, err = stater.Stat(sri)
+	} else {
+		var blob io.ReadCloser
+		if blob, err = c.Open(sri); err == nil {
+			blob.Close()
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Evicter is implemented by CASs that may drop blobs on their own after they were written,
+// for example to stay within a memory budget.
+type Evicter interface {
+	// Evicts returns true if blobs may be dropped.
+	Evicts() bool
+}
+
+// Evicts returns true if the CAS may drop blobs on its own (see Evicter).
+func Evicts(c api.CASReader) bool {
+	evicter, ok := c.(Evicter)
+	return ok && evicter.Evicts()
+}
+
+// Iterator iterates over blobs.
+// Next returns io.EOF after the last blob.
+type Iterator interface {
+	Next() (BlobInfo, error)
+}
+
+// Lister enumerates the blobs of a CAS.
+type Lister interface {
+	// List returns an iterator over all blobs whose sri starts with the prefix.
+	// The order of the blobs is unspecified.
+	List(prefix string) (Iterator, error)
+}
+
+// Deleter deletes blobs from a CAS.
+type Deleter interface {
+	// Delete deletes the blob. It returns fs.ErrNotExist if the blob does not exist.
+	Delete(sri string) error
+}
+
+// SliceIterator iterates over a slice of blobs.
+type SliceIterator struct {
+	blobs []BlobInfo
+}
+
+func NewSliceIterator(blobs []BlobInfo) *SliceIterator {
+	return &SliceIterator{blobs: blobs}
+}
+
+func (s *SliceIterator) Next() (BlobInfo, error) {
+	if len(s.blobs) == 0 {
+		return BlobInfo{}, io.EOF
+	}
+	blob := s.blobs[0]
+	s.blobs = s.blobs[1:]
+	return blob, nil
+}
@@ -0,0 +1,286 @@
+// Package chunked implements a content addressable storage that deduplicates
+// blobs by splitting them into content defined chunks.
+//
+// Chunks are stored in an underlying CAS by their own sri.
+// A manifest, stored by the sri of the whole blob, lists the chunks of the blob.
+// Blobs that only differ in a few bytes share most of their chunks.
+package chunked
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/gc"
+	"github.com/malt3/abstractfs/cas/internal/verify"
+)
+
+// Options configure the chunk sizes.
+// The average size must be a power of two.
+// Zero values use the defaults.
+type Options struct {
+	MinSize, AvgSize, MaxSize int
+}
+
+// Stats are the deduplication statistics of the blobs written since the CAS was created.
+type Stats struct {
+	// Blobs is the number of blobs written.
+	Blobs uint64
+	// LogicalBytes is the size of all blobs written.
+	LogicalBytes uint64
+	// Chunks is the number of chunks of all blobs written.
+	Chunks uint64
+	// StoredChunks and StoredBytes are the number and size of chunks that were not stored before.
+	StoredChunks uint64
+	StoredBytes  uint64
+}
+
+// Ratio returns the deduplication ratio (logical bytes per stored byte).
+func (s Stats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 0
+	}
+	return float64(s.LogicalBytes) / float64(s.StoredBytes)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d blobs, %d bytes in %d chunks, stored %d bytes in %d new chunks (dedup ratio %.2f)",
+		s.Blobs, s.LogicalBytes, s.Chunks, s.StoredBytes, s.StoredChunks, s.Ratio())
+}
+
+// CAS is a chunking content addressable storage.
+type CAS struct {
+	chunks                    api.CAS
+	manifests                 ManifestStore
+	minSize, avgSize, maxSize int
+
+	blobs, logicalBytes, chunkCount, storedChunks, storedBytes atomic.Uint64
+}
+
+// New returns a CAS that stores chunks in the chunk CAS and manifests in the manifest store.
+// The chunk CAS must not evict chunks (see cas.Evicter), since manifests would then refer to missing chunks.
+func New(chunks api.CAS, manifests ManifestStore, opts Options) (*CAS, error) {
+	if cas.Evicts(chunks) {
+		return nil, errors.New("chunked cas: the chunk store must not evict chunks")
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = DefaultMinSize
+	}
+	if opts.AvgSize == 0 {
+		opts.AvgSize = DefaultAvgSize
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if err := checkSizes(opts.MinSize, opts.AvgSize, opts.MaxSize); err != nil {
+		return nil, err
+	}
+	return &CAS{
+		chunks:    chunks,
+		manifests: manifests,
+		minSize:   opts.MinSize,
+		avgSize:   opts.AvgSize,
+		maxSize:   opts.MaxSize,
+	}, nil
+}
+
+// Open returns the reassembled blob.
+// The blob is verified against the sri while it is read.
+func (c *CAS) Open(sriString string) (io.ReadCloser, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	manifest, err := c.manifests.Get(sriString)
+	if err != nil {
+		return nil, err
+	}
+	return verify.NewReadCloser(&chunkReader{chunks: c.chunks, manifest: manifest, blob: sriString}, integrity)
+}
+
+// Write splits the blob into chunks and stores the chunks that are not stored yet.
+// Chunks use the hash algorithm of the blob.
+func (c *CAS) Write(sriString string, r io.Reader) error {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return fmt.Errorf("checking sri on write: %w", err)
+	}
+	if manifest, err := c.manifests.Get(sriString); err == nil {
+		// storing the manifest again protects the blob from garbage collection (see gc.Options.GracePeriod)
+		return c.manifests.Put(sriString, manifest)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	blobHash, err := verify.NewHash(integrity.Algorithm)
+	if err != nil {
+		return err
+	}
+	chunker, err := newChunker(io.TeeReader(r, blobHash), c.minSize, c.avgSize, c.maxSize)
+	if err != nil {
+		return err
+	}
+	var manifest Manifest
+	var storedChunks, storedBytes uint64
+	for {
+		chunk, err := chunker.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunkSRI, stored, err := c.writeChunk(integrity.Algorithm, chunk)
+		if err != nil {
+			return err
+		}
+		if stored {
+			storedChunks++
+			storedBytes += uint64(len(chunk))
+		}
+		manifest.Chunks = append(manifest.Chunks, Chunk{SRI: chunkSRI, Size: int64(len(chunk))})
+		manifest.Size += int64(len(chunk))
+	}
+	if !bytes.Equal(blobHash.Sum(nil), integrity.Hash) {
+		return fmt.Errorf("validating sri on write: blob does not match %s", integrity)
+	}
+	if err := c.manifests.Put(sriString, manifest); err != nil {
+		return fmt.Errorf("storing manifest of %s: %w", sriString, err)
+	}
+
+	c.blobs.Add(1)
+	c.logicalBytes.Add(uint64(manifest.Size))
+	c.chunkCount.Add(uint64(len(manifest.Chunks)))
+	c.storedChunks.Add(storedChunks)
+	c.storedBytes.Add(storedBytes)
+	return nil
+}
+
+// writeChunk stores the chunk if it is not stored yet.
+// It returns the sri of the chunk and whether it was stored.
+func (c *CAS) writeChunk(algorithm sri.Algorithm, chunk []byte) (string, bool, error) {
+	chunkHash, err := verify.NewHash(algorithm)
+	if err != nil {
+		return "", false, err
+	}
+	chunkHash.Write(chunk)
+	chunkSRI := sri.Integrity{Algorithm: algorithm, Hash: chunkHash.Sum(nil)}.String()
+	exists, err := cas.Exists(c.chunks, chunkSRI)
+	if err != nil {
+		return "", false, err
+	}
+	// existing chunks are written as well: stores skip blobs they have,
+	// but refresh their modification time (see gc.Options.GracePeriod)
+	if err := c.chunks.Write(chunkSRI, bytes.NewReader(chunk)); err != nil {
+		return "", false, fmt.Errorf("storing chunk %s: %w", chunkSRI, err)
+	}
+	return chunkSRI, !exists, nil
+}
+
+// Stat returns the size of the blob.
+// The modification time is only known when listing.
+func (c *CAS) Stat(sri string) (cas.BlobInfo, error) {
+	manifest, err := c.manifests.Get(sri)
+	if err != nil {
+		return cas.BlobInfo{}, err
+	}
+	return cas.BlobInfo{SRI: sri, Size: manifest.Size}, nil
+}
+
+// List returns the blobs with manifests whose sri starts with the prefix.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	return c.manifests.List(prefix)
+}
+
+// Delete deletes the manifest of the blob.
+// The chunks are kept, since other blobs may share them.
+// Unreferenced chunks are deleted by garbage collection (see gc.Sweep).
+func (c *CAS) Delete(sri string) error {
+	return c.manifests.Delete(sri)
+}
+
+// Parts returns the sris of the chunks of the blob.
+func (c *CAS) Parts(sri string) ([]string, error) {
+	manifest, err := c.manifests.Get(sri)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(manifest.Chunks))
+	for i, chunk := range manifest.Chunks {
+		parts[i] = chunk.SRI
+	}
+	return parts, nil
+}
+
+// PartStore returns the chunk store.
+func (c *CAS) PartStore() api.CAS {
+	return c.chunks
+}
+
+// Stats returns the deduplication statistics.
+func (c *CAS) Stats() Stats {
+	return Stats{
+		Blobs:        c.blobs.Load(),
+		LogicalBytes: c.logicalBytes.Load(),
+		Chunks:       c.chunkCount.Load(),
+		StoredChunks: c.storedChunks.Load(),
+		StoredBytes:  c.storedBytes.Load(),
+	}
+}
+
+// chunkReader reads the chunks of a manifest one after another.
+type chunkReader struct {
+	chunks   api.CASReader
+	manifest Manifest
+	blob     string
+	next     int
+	current  io.ReadCloser
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next == len(r.manifest.Chunks) {
+				return 0, io.EOF
+			}
+			chunk := r.manifest.Chunks[r.next]
+			current, err := r.chunks.Open(chunk.SRI)
+			if err != nil {
+				return 0, fmt.Errorf("opening chunk %s of %s: %w", chunk.SRI, r.blob, err)
+			}
+			r.current = current
+			r.next++
+		}
+		n, err := r.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			err = r.current.Close()
+			r.current = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
+
+var (
+	_ api.CAS      = (*CAS)(nil)
+	_ cas.Stater   = (*CAS)(nil)
+	_ cas.Lister   = (*CAS)(nil)
+	_ cas.Deleter  = (*CAS)(nil)
+	_ gc.Composite = (*CAS)(nil)
+)
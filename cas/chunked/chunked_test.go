@@ -0,0 +1,286 @@
+package chunked
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+var testOptions = Options{MinSize: 256, AvgSize: 1024, MaxSize: 4096}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := map[string]struct {
+		size      int
+		algorithm sri.Algorithm
+	}{
+		"empty":              {size: 0, algorithm: sri.SHA256},
+		"smaller than min":   {size: 100, algorithm: sri.SHA256},
+		"several chunks":     {size: 64 << 10, algorithm: sri.SHA256},
+		"sha512":             {size: 64 << 10, algorithm: sri.SHA512},
+		"exactly max":        {size: 4096, algorithm: sri.SHA256},
+		"not a chunk border": {size: 4096*3 + 1, algorithm: sri.SHA256},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for manifestsName, manifests := range manifestStores(t) {
+				t.Run(manifestsName, func(t *testing.T) {
+					chunks := memory.NewCAS(false)
+					c, err := New(chunks, manifests, testOptions)
+					if err != nil {
+						t.Fatal(err)
+					}
+					data := randomBytes(1, tc.size)
+					sriString := sriOf(t, data, tc.algorithm)
+					if err := c.Write(sriString, bytes.NewReader(data)); err != nil {
+						t.Fatal(err)
+					}
+					if got := readBlob(t, c, sriString); !bytes.Equal(got, data) {
+						t.Errorf("read %d bytes that differ from the %d written bytes", len(got), len(data))
+					}
+					info, err := c.Stat(sriString)
+					if err != nil || info.Size != int64(tc.size) {
+						t.Errorf("Stat() = %+v, %v, want size %d", info, err, tc.size)
+					}
+
+					manifest, err := manifests.Get(sriString)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for i, chunk := range manifest.Chunks {
+						if chunk.Size > int64(testOptions.MaxSize) || (chunk.Size < int64(testOptions.MinSize) && i != len(manifest.Chunks)-1) {
+							t.Errorf("chunk %d has size %d, want between %d and %d", i, chunk.Size, testOptions.MinSize, testOptions.MaxSize)
+						}
+						if !strings.HasPrefix(chunk.SRI, string(tc.algorithm)+"-") {
+							t.Errorf("chunk %s does not use %s", chunk.SRI, tc.algorithm)
+						}
+						if exists, err := cas.Exists(chunks, chunk.SRI); err != nil || !exists {
+							t.Errorf("chunk %s is not stored", chunk.SRI)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	c, err := New(memory.NewCAS(false), NewMemoryManifests(), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := randomBytes(1, 64<<10)
+	// change a byte in the middle, so only the chunks around it differ
+	changed := bytes.Clone(original)
+	changed[32<<10] ^= 0xff
+	for _, data := range [][]byte{original, changed} {
+		if err := c.Write(sriOf(t, data, sri.SHA256), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := c.Stats()
+	if stats.Blobs != 2 || stats.LogicalBytes != uint64(2*len(original)) {
+		t.Errorf("got stats %v, want 2 blobs of %d bytes", stats, len(original))
+	}
+	if stats.StoredBytes >= stats.LogicalBytes*3/4 {
+		t.Errorf("got stats %v, want most chunks to be shared", stats)
+	}
+	if got := readBlob(t, c, sriOf(t, changed, sri.SHA256)); !bytes.Equal(got, changed) {
+		t.Error("changed blob differs after reading")
+	}
+
+	// writing a blob again stores nothing
+	before := c.Stats().StoredBytes
+	if err := c.Write(sriOf(t, original, sri.SHA256), bytes.NewReader(original)); err != nil {
+		t.Fatal(err)
+	}
+	if after := c.Stats().StoredBytes; after != before {
+		t.Errorf("stored %d bytes for an existing blob", after-before)
+	}
+}
+
+func TestChunkBoundariesAreStable(t *testing.T) {
+	data := randomBytes(1, 64<<10)
+	first := chunkSRIs(t, data)
+	if len(first) < 2 {
+		t.Fatalf("got %d chunks, want several", len(first))
+	}
+	if second := chunkSRIs(t, data); strings.Join(first, " ") != strings.Join(second, " ") {
+		t.Error("chunking the same data twice resulted in different chunks")
+	}
+	// prepending data shifts the contents, but most chunks are found again
+	shifted := chunkSRIs(t, append(randomBytes(2, 100), data...))
+	var found int
+	for _, chunk := range shifted {
+		for _, other := range first {
+			if chunk == other {
+				found++
+				break
+			}
+		}
+	}
+	if found < len(first)/2 {
+		t.Errorf("found %d of %d chunks after shifting the data", found, len(first))
+	}
+}
+
+func TestWriteMismatch(t *testing.T) {
+	manifests := NewMemoryManifests()
+	c, err := New(memory.NewCAS(false), manifests, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sriString := sriOf(t, []byte("expected"), sri.SHA256)
+	if err := c.Write(sriString, strings.NewReader("something else")); err == nil {
+		t.Fatal("Write() of mismatching contents succeeded")
+	}
+	if _, err := c.Open(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() after failed Write(): got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOpenMissingChunk(t *testing.T) {
+	chunks := memory.NewCAS(false)
+	c, err := New(chunks, NewMemoryManifests(), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randomBytes(1, 16<<10)
+	sriString := sriOf(t, data, sri.SHA256)
+	if err := c.Write(sriString, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := c.Parts(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chunks.Delete(parts[len(parts)-1]); err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("reading a blob with a missing chunk returned %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]struct {
+		chunks  api.CAS
+		opts    Options
+		wantErr bool
+	}{
+		"defaults":                {chunks: memory.NewCAS(false)},
+		"custom sizes":            {chunks: memory.NewCAS(false), opts: testOptions},
+		"evicting chunk store":    {chunks: memory.NewCASWithLimits(false, memory.Limits{Budget: 1 << 20}), wantErr: true},
+		"avg is no power of two":  {chunks: memory.NewCAS(false), opts: Options{MinSize: 256, AvgSize: 1000, MaxSize: 4096}, wantErr: true},
+		"min is larger than avg":  {chunks: memory.NewCAS(false), opts: Options{MinSize: 2048, AvgSize: 1024, MaxSize: 4096}, wantErr: true},
+		"max is smaller than avg": {chunks: memory.NewCAS(false), opts: Options{MinSize: 256, AvgSize: 1024, MaxSize: 512}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(tc.chunks, NewMemoryManifests(), tc.opts)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("New() returned %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDirManifestsList(t *testing.T) {
+	manifests, err := NewDirManifests(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(memory.NewCAS(false), manifests, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, algorithm := range []sri.Algorithm{sri.SHA256, sri.SHA512} {
+		data := randomBytes(int64(i), 1000)
+		if err := c.Write(sriOf(t, data, algorithm), bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	iter, err := c.List("sha512-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := iter.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(blob.SRI, "sha512-") || blob.Size != 1000 || blob.ModTime.IsZero() {
+		t.Errorf("listed %+v, want the sha512 blob with size and modification time", blob)
+	}
+	if _, err := iter.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("listed more than one blob: %v", err)
+	}
+}
+
+func manifestStores(t *testing.T) map[string]ManifestStore {
+	t.Helper()
+	dirManifests, err := NewDirManifests(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]ManifestStore{"memory": NewMemoryManifests(), "dir": dirManifests}
+}
+
+// chunkSRIs returns the sris of the chunks of the data.
+func chunkSRIs(t *testing.T, data []byte) []string {
+	t.Helper()
+	c, err := New(memory.NewCAS(false), NewMemoryManifests(), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sriString := sriOf(t, data, sri.SHA256)
+	if err := c.Write(sriString, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := c.Parts(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parts
+}
+
+func randomBytes(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func sriOf(t *testing.T, data []byte, algorithm sri.Algorithm) string {
+	t.Helper()
+	sris, err := cas.Hash(bytes.NewReader(data), algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c api.CASReader, sriString string) []byte {
+	t.Helper()
+	r, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,138 @@
+package chunked
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Default chunk sizes.
+const (
+	DefaultMinSize = 16 << 10
+	DefaultAvgSize = 64 << 10
+	DefaultMaxSize = 256 << 10
+)
+
+// gear maps bytes to random values for the rolling hash.
+// The values must never change, since they determine the chunk boundaries.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64 with a fixed seed
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker splits a stream into content defined chunks using FastCDC.
+//
+// The gear hash of the bytes since the minimum chunk size is compared against a mask.
+// Before the average size, a mask with more bits is used, after it one with fewer bits
+// (normalized chunking), so chunk sizes are distributed closely around the average.
+// The masks use the high bits of the hash, which depend on the last 64 bytes.
+type chunker struct {
+	r                         io.Reader
+	minSize, avgSize, maxSize int
+	maskSmall, maskLarge      uint64
+
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func newChunker(r io.Reader, minSize, avgSize, maxSize int) (*chunker, error) {
+	if err := checkSizes(minSize, avgSize, maxSize); err != nil {
+		return nil, err
+	}
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &chunker{
+		r:         r,
+		minSize:   minSize,
+		avgSize:   avgSize,
+		maxSize:   maxSize,
+		maskSmall: highBits(avgBits + 1),
+		maskLarge: highBits(avgBits - 1),
+		buf:       make([]byte, maxSize),
+	}, nil
+}
+
+func checkSizes(minSize, avgSize, maxSize int) error {
+	if minSize <= 0 || minSize > avgSize || avgSize > maxSize {
+		return fmt.Errorf("chunk sizes must satisfy 0 < min (%d) <= avg (%d) <= max (%d)", minSize, avgSize, maxSize)
+	}
+	if avgSize&(avgSize-1) != 0 || avgSize < 64 {
+		return errors.New("average chunk size must be a power of two of at least 64")
+	}
+	return nil
+}
+
+// next returns the next chunk or io.EOF.
+// The chunk is only valid until the next call.
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < c.maxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill moves the remaining bytes to the start of the buffer and fills it.
+func (c *chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cut returns the length of the first chunk of the data.
+func (c *chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := c.avgSize
+	if n < normal {
+		normal = n
+	}
+	var hash uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// highBits returns a mask of the n highest bits.
+func highBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
@@ -0,0 +1,217 @@
+package chunked
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+)
+
+// Manifest lists the chunks of a blob in order.
+type Manifest struct {
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk is a chunk of a blob.
+type Chunk struct {
+	SRI  string `json:"sri"`
+	Size int64  `json:"size"`
+}
+
+// ManifestStore stores manifests by the sri of the whole blob.
+type ManifestStore interface {
+	// Get returns the manifest of the blob or fs.ErrNotExist.
+	Get(sri string) (Manifest, error)
+	Put(sri string, manifest Manifest) error
+	// Delete deletes the manifest of the blob or returns fs.ErrNotExist.
+	Delete(sri string) error
+	// List returns the blobs with manifests whose sri starts with the prefix.
+	List(prefix string) (cas.Iterator, error)
+}
+
+// MemoryManifests stores manifests in memory.
+type MemoryManifests struct {
+	mux       sync.RWMutex
+	manifests map[string]storedManifest
+}
+
+type storedManifest struct {
+	Manifest
+	written time.Time
+}
+
+func NewMemoryManifests() *MemoryManifests {
+	return &MemoryManifests{manifests: make(map[string]storedManifest)}
+}
+
+func (m *MemoryManifests) Get(sri string) (Manifest, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	stored, ok := m.manifests[sri]
+	if !ok {
+		return Manifest{}, fs.ErrNotExist
+	}
+	return stored.Manifest, nil
+}
+
+func (m *MemoryManifests) Put(sri string, manifest Manifest) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.manifests[sri] = storedManifest{Manifest: manifest, written: time.Now()}
+	return nil
+}
+
+func (m *MemoryManifests) Delete(sri string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.manifests[sri]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(m.manifests, sri)
+	return nil
+}
+
+func (m *MemoryManifests) List(prefix string) (cas.Iterator, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	var blobs []cas.BlobInfo
+	for sri, stored := range m.manifests {
+		if strings.HasPrefix(sri, prefix) {
+			blobs = append(blobs, cas.BlobInfo{SRI: sri, Size: stored.Size, ModTime: stored.written})
+		}
+	}
+	return cas.NewSliceIterator(blobs), nil
+}
+
+// DirManifests stores manifests as json files in a directory.
+// Manifests are stored under <root>/<algorithm>/<first two hex digits>/<hex>.json.
+type DirManifests struct {
+	root string
+}
+
+func NewDirManifests(root string) (*DirManifests, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("creating manifest root: %w", err)
+	}
+	return &DirManifests{root: root}, nil
+}
+
+func (d *DirManifests) Get(sri string) (Manifest, error) {
+	path, err := d.path(sri)
+	if err != nil {
+		return Manifest{}, fs.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("reading manifest of %s: %w", sri, err)
+	}
+	return manifest, nil
+}
+
+func (d *DirManifests) Put(sri string, manifest Manifest) error {
+	path, err := d.path(sri)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it into place, so readers never see partial manifests
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (d *DirManifests) Delete(sri string) error {
+	path, err := d.path(sri)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	return os.Remove(path)
+}
+
+// List reads all manifests whose sri starts with the prefix.
+func (d *DirManifests) List(prefix string) (cas.Iterator, error) {
+	var blobs []cas.BlobInfo
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			return nil
+		}
+		// <algorithm>/<first two hex digits>/<hex>.json
+		algorithm, err := sri.AlgorithmFromString(filepath.Base(filepath.Dir(filepath.Dir(path))))
+		if err != nil {
+			return nil
+		}
+		hash, err := hex.DecodeString(name)
+		if err != nil {
+			return nil
+		}
+		sriString := sri.Integrity{Algorithm: algorithm, Hash: hash}.String()
+		if !strings.HasPrefix(sriString, prefix) {
+			return nil
+		}
+		manifest, err := d.Get(sriString)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, cas.BlobInfo{SRI: sriString, Size: manifest.Size, ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewSliceIterator(blobs), nil
+}
+
+func (d *DirManifests) path(sriString string) (string, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return "", err
+	}
+	if len(integrity.Hash) == 0 {
+		return "", errors.New("empty hash")
+	}
+	digest := hex.EncodeToString(integrity.Hash)
+	return filepath.Join(d.root, string(integrity.Algorithm), digest[:2], digest+".json"), nil
+}
+
+var (
+	_ ManifestStore = (*MemoryManifests)(nil)
+	_ ManifestStore = (*DirManifests)(nil)
+)
@@ -0,0 +1,209 @@
+package dir
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	coresri "github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+)
+
+// CAS is a content addressable storage backed by a directory.
+// Blobs are stored under <root>/<algorithm>/<first two hex digits>/<hex>.
+type CAS struct {
+	root     string
+	readonly bool
+}
+
+func NewCAS(root string, readonly bool) (*CAS, error) {
+	if !readonly {
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			return nil, fmt.Errorf("creating cas root: %w", err)
+		}
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("opening cas root: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("cas root %q is not a directory", root)
+	}
+	return &CAS{
+		root:     root,
+		readonly: readonly,
+	}, nil
+}
+
+func (c *CAS) Open(sri string) (io.ReadCloser, error) {
+	path, err := c.blobPath(sri)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	return os.Open(path)
+}
+
+func (c *CAS) Write(sri string, r io.Reader) error {
+	path, err := c.blobPath(sri)
+	if err != nil {
+		return fmt.Errorf("checking sri on write: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		if c.readonly {
+			return nil
+		}
+		// the blob is used again: protect it from garbage collection (see gc.Options.GracePeriod)
+		now := time.Now()
+		return os.Chtimes(path, now, now)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	integrity, err := coresri.FromString(sri)
+	if err != nil {
+		return fmt.Errorf("checking sri on write: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file in the same directory and rename it into place.
+	// This ensures that readers never observe partially written blobs.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if err := writeTemp(tmp, integrity, r); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// persist the rename, so a crash cannot lose a blob that was reported as written
+	return syncDir(filepath.Dir(path))
+}
+
+// writeTemp writes the validated blob to the temporary file, syncs and closes it.
+func writeTemp(tmp *os.File, integrity coresri.Integrity, r io.Reader) error {
+	if err := integrity.Validate(io.TeeReader(r, tmp)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("validating sri on write: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(tmp.Name(), 0o444)
+}
+
+// syncDir flushes the entries of the directory to disk.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// List returns the blobs whose sri starts with the prefix.
+// The modification time of a blob is the time it was last written.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	var blobs []cas.BlobInfo
+	err := filepath.WalkDir(c.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.root, path)
+		if err != nil {
+			return err
+		}
+		// <algorithm>/<first two hex digits>/<hex>
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return nil
+		}
+		algorithm, err := coresri.AlgorithmFromString(parts[0])
+		if err != nil {
+			return nil
+		}
+		hash, err := hex.DecodeString(parts[2])
+		if err != nil {
+			return nil
+		}
+		sri := coresri.Integrity{Algorithm: algorithm, Hash: hash}.String()
+		if !strings.HasPrefix(sri, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, cas.BlobInfo{SRI: sri, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cas.NewSliceIterator(blobs), nil
+}
+
+func (c *CAS) Stat(sri string) (cas.BlobInfo, error) {
+	path, err := c.blobPath(sri)
+	if err != nil {
+		return cas.BlobInfo{}, fs.ErrNotExist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return cas.BlobInfo{}, err
+	}
+	return cas.BlobInfo{SRI: sri, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (c *CAS) Delete(sri string) error {
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	path, err := c.blobPath(sri)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	return os.Remove(path)
+}
+
+// blobPath returns the path of the blob for the given sri.
+func (c *CAS) blobPath(sri string) (string, error) {
+	integrity, err := coresri.FromString(sri)
+	if err != nil {
+		return "", err
+	}
+	digest := hex.EncodeToString(integrity.Hash)
+	return filepath.Join(c.root, string(integrity.Algorithm), digest[:2], digest), nil
+}
+
+var (
+	_ api.CAS     = (*CAS)(nil)
+	_ cas.Stater  = (*CAS)(nil)
+	_ cas.Lister  = (*CAS)(nil)
+	_ cas.Deleter = (*CAS)(nil)
+)
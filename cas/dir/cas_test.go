@@ -0,0 +1,191 @@
+package dir_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	casdir "github.com/malt3/abstractfs/cas/dir"
+)
+
+const blob = "hello world"
+
+func TestRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	c := newCAS(t, root, false)
+	sriString := sriOf(t, blob)
+
+	if _, err := c.Open(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Open() of missing blob: got %v, want fs.ErrNotExist", err)
+	}
+	if err := c.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, c, sriString); got != blob {
+		t.Errorf("Open() = %q, want %q", got, blob)
+	}
+	if files := storedFiles(t, root); len(files) != 1 {
+		t.Errorf("got files %v, want only the blob", files)
+	}
+	if info, err := c.Stat(sriString); err != nil || info.Size != int64(len(blob)) {
+		t.Errorf("Stat() = %+v, %v, want size %d", info, err, len(blob))
+	}
+
+	if err := c.Delete(sriString); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Open(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() after Delete(): got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestWriteMismatch(t *testing.T) {
+	root := t.TempDir()
+	c := newCAS(t, root, false)
+	sriString := sriOf(t, blob)
+
+	if err := c.Write(sriString, strings.NewReader("something else")); err == nil {
+		t.Fatal("Write() of mismatching contents succeeded")
+	}
+	if _, err := c.Open(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open() after failed Write(): got %v, want fs.ErrNotExist", err)
+	}
+	// the temporary file is removed
+	if files := storedFiles(t, root); len(files) != 0 {
+		t.Errorf("got files %v after failed Write(), want none", files)
+	}
+}
+
+func TestWriteExistingRefreshesMtime(t *testing.T) {
+	root := t.TempDir()
+	c := newCAS(t, root, false)
+	sriString := sriOf(t, blob)
+	if err := c.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	path := storedFiles(t, root)[0]
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old.Add(time.Minute)) {
+		t.Errorf("mtime %v was not refreshed", info.ModTime())
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	root := t.TempDir()
+	sriString := sriOf(t, blob)
+	if err := newCAS(t, root, false).Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	readonly := newCAS(t, root, true)
+	if got := readBlob(t, readonly, sriString); got != blob {
+		t.Errorf("Open() = %q, want %q", got, blob)
+	}
+	// blobs that exist are accepted
+	if err := readonly.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Errorf("Write() of existing blob: %v", err)
+	}
+	other := sriOf(t, "other")
+	if err := readonly.Write(other, strings.NewReader("other")); err == nil {
+		t.Error("Write() of new blob to readonly cas succeeded")
+	}
+}
+
+func TestList(t *testing.T) {
+	root := t.TempDir()
+	c := newCAS(t, root, false)
+	for _, contents := range []string{"a", "b", "c"} {
+		if err := c.Write(sriOf(t, contents), strings.NewReader(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// leftovers of interrupted writes are not listed
+	if err := os.WriteFile(filepath.Join(root, ".tmp-leftover"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	iter, err := c.List("sha256-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed int
+	for {
+		info, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(info.SRI, "sha256-") || info.Size != 1 {
+			t.Errorf("listed %+v, want a sha256 blob of size 1", info)
+		}
+		listed++
+	}
+	if listed != 3 {
+		t.Errorf("listed %d blobs, want 3", listed)
+	}
+}
+
+func newCAS(t *testing.T, root string, readonly bool) *casdir.CAS {
+	t.Helper()
+	c, err := casdir.NewCAS(root, readonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// storedFiles returns the paths of all files below root.
+func storedFiles(t *testing.T, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func sriOf(t *testing.T, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c *casdir.CAS, sriString string) string {
+	t.Helper()
+	r, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
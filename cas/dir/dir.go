@@ -0,0 +1,59 @@
+package dir
+
+import (
+	"errors"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
+)
+
+type Provider struct {
+	// Path is the root directory of the CAS.
+	Path string `abstractfs:"path"`
+}
+
+func (p Provider) Name() string {
+	return "dir"
+}
+
+func (p Provider) SourceBuilder() provider.SourceBuilder {
+	return &provider.UnsupportedSourceBuilder{}
+}
+
+func (p Provider) SinkBuilder() provider.SinkBuilder {
+	return &provider.UnsupportedSinkBuilder{}
+}
+
+func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) newCAS(readonly bool) (*CAS, error) {
+	if p.Path == "" {
+		return nil, errors.New("dir cas: missing path option")
+	}
+	return NewCAS(p.Path, readonly)
+}
+
+var _ provider.Provider = (*Provider)(nil)
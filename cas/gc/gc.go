@@ -0,0 +1,248 @@
+// Package gc removes blobs that are not referenced by any root from a CAS.
+//
+// Garbage collection is mark and sweep: roots (flattened trees or recordings)
+// mark the blobs they reference, then all unmarked blobs of a CAS that supports
+// enumeration and deletion are deleted.
+// Blobs of composite stores (like chunked.CAS) are made of parts in another store:
+// the parts of all kept blobs are marked and unmarked parts are deleted as well.
+package gc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/cas/recorder"
+	"github.com/malt3/abstractfs/cas"
+	fsjson "github.com/malt3/abstractfs/fs/json"
+)
+
+// Set is a set of sris of referenced blobs.
+type Set map[string]struct{}
+
+// Add marks the blob as referenced.
+// Every digest of an sri with several digests is marked.
+func (s Set) Add(sriString string) error {
+	// normalize the encoding, so sris compare equal to listed sris
+	digests := cas.Digests(sriString)
+	if len(digests) == 0 {
+		return fmt.Errorf("marking %q: invalid sri", sriString)
+	}
+	for _, digest := range digests {
+		s[digest] = struct{}{}
+	}
+	return nil
+}
+
+// Has returns true if the blob is referenced.
+func (s Set) Has(sri string) bool {
+	_, ok := s[sri]
+	return ok
+}
+
+// isLive returns true if the blob is referenced by its sri or any of its aliases.
+func isLive(live Set, blob cas.BlobInfo) bool {
+	if live.Has(blob.SRI) {
+		return true
+	}
+	for _, alias := range blob.Aliases {
+		if live.Has(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkSource marks the contents of all regular files of the source.
+// File contents are not read.
+func MarkSource(live Set, source api.Source) error {
+	for {
+		node, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if node.Stat.Kind != api.KindRegular {
+			continue
+		}
+		if err := live.Add(node.Stat.Payload); err != nil {
+			return err
+		}
+	}
+}
+
+// MarkJSON marks the contents of all regular files of a flattened tree (the output of the json command).
+func MarkJSON(live Set, r io.Reader) error {
+	return MarkSource(live, fsjson.NewSource(r, nil))
+}
+
+// MarkRecording marks all blobs of a recording (see recorder.Encode).
+func MarkRecording(live Set, r io.Reader) error {
+	for {
+		integrity, body, err := recorder.Decode(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// closing skips the payload
+		if err := body.Close(); err != nil {
+			return err
+		}
+		live[integrity.String()] = struct{}{}
+	}
+}
+
+// Store is a CAS that supports enumeration and deletion.
+type Store interface {
+	cas.Lister
+	cas.Deleter
+}
+
+// Composite is a store whose blobs are made of parts stored in another store,
+// for example a chunked CAS.
+// Deleting a blob of a composite store only deletes the list of its parts.
+type Composite interface {
+	Store
+	// Parts returns the sris of the parts of the blob.
+	Parts(sri string) ([]string, error)
+	// PartStore returns the store of the parts.
+	PartStore() api.CAS
+}
+
+// Options configure a sweep.
+type Options struct {
+	// DryRun only reports the blobs that would be deleted.
+	DryRun bool
+	// GracePeriod keeps unreferenced blobs that were written recently,
+	// since they may belong to roots that are still being written.
+	// Blobs with unknown modification time are kept if a grace period is set.
+	GracePeriod time.Duration
+	// Now is the reference time of the grace period. Defaults to the current time.
+	Now time.Time
+	// Force deletes unreferenced blobs even if no blob of the store is referenced.
+	// Otherwise, such a sweep fails, since the roots most likely belong to a different store
+	// (like the chunk store of a chunked CAS, which is swept through the chunked CAS).
+	Force bool
+}
+
+// Report is the result of a sweep.
+type Report struct {
+	// Scanned is the number of blobs in the store.
+	Scanned int
+	// Live is the number of referenced blobs in the store.
+	Live int
+	// Recent is the number of unreferenced blobs kept because of the grace period.
+	Recent int
+	// Deleted holds the deleted blobs (or the blobs that would be deleted in a dry run).
+	Deleted []cas.BlobInfo
+	// ReclaimedBytes is the size of the deleted blobs (including the parts of composite stores).
+	// Blobs of composite stores do not count, since their parts may be shared.
+	ReclaimedBytes int64
+	// Parts is the report of the sweep of the part store of a composite store.
+	Parts *Report
+}
+
+// Sweep deletes all blobs of the store that are not in the live set.
+// If the store is a Composite, parts that are not referenced by a kept blob are deleted afterwards.
+func Sweep(store Store, live Set, opts Options) (Report, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	iter, err := store.List("")
+	if err != nil {
+		return Report{}, fmt.Errorf("listing blobs: %w", err)
+	}
+	// collect garbage before deleting, so deletions do not interfere with the iteration
+	var report Report
+	var kept []string
+	var garbage []cas.BlobInfo
+	for {
+		blob, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("listing blobs: %w", err)
+		}
+		report.Scanned++
+		switch {
+		case isLive(live, blob):
+			report.Live++
+			kept = append(kept, blob.SRI)
+		case opts.GracePeriod > 0 && (blob.ModTime.IsZero() || opts.Now.Sub(blob.ModTime) < opts.GracePeriod):
+			report.Recent++
+			kept = append(kept, blob.SRI)
+		default:
+			garbage = append(garbage, blob)
+		}
+	}
+	if report.Live == 0 && len(garbage) > 0 && !opts.Force {
+		return report, fmt.Errorf("none of the %d blobs of the store is referenced by the roots, refusing to delete them", report.Scanned)
+	}
+	composite, isComposite := store.(Composite)
+	for _, blob := range garbage {
+		if !opts.DryRun {
+			err := store.Delete(blob.SRI)
+			if errors.Is(err, fs.ErrNotExist) {
+				// deleted concurrently
+				continue
+			}
+			if err != nil {
+				return report, fmt.Errorf("deleting %s: %w", blob.SRI, err)
+			}
+		}
+		report.Deleted = append(report.Deleted, blob)
+		if !isComposite {
+			report.ReclaimedBytes += blob.Size
+		}
+	}
+	if !isComposite {
+		return report, nil
+	}
+
+	parts, err := sweepParts(composite, kept, opts)
+	if err != nil {
+		return report, err
+	}
+	report.Parts = &parts
+	report.ReclaimedBytes += parts.ReclaimedBytes
+	return report, nil
+}
+
+// sweepParts deletes all parts of the composite store that are not referenced by the kept blobs.
+func sweepParts(composite Composite, kept []string, opts Options) (Report, error) {
+	partStore, ok := composite.PartStore().(Store)
+	if !ok {
+		return Report{}, errors.New("part store does not support listing and deleting blobs")
+	}
+	live := make(Set)
+	for _, sri := range kept {
+		parts, err := composite.Parts(sri)
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted concurrently
+			continue
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("reading parts of %s: %w", sri, err)
+		}
+		for _, part := range parts {
+			if err := live.Add(part); err != nil {
+				return Report{}, err
+			}
+		}
+	}
+	// all parts are garbage if no blob is kept
+	opts.Force = true
+	parts, err := Sweep(partStore, live, opts)
+	if err != nil {
+		return parts, fmt.Errorf("sweeping parts: %w", err)
+	}
+	return parts, nil
+}
@@ -0,0 +1,283 @@
+package gc_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/chunked"
+	"github.com/malt3/abstractfs/cas/gc"
+	"github.com/malt3/abstractfs/cas/memory"
+	fsjson "github.com/malt3/abstractfs/fs/json"
+)
+
+func TestSweep(t *testing.T) {
+	live, garbage := sriOf(t, "live"), sriOf(t, "garbage")
+	testCases := map[string]struct {
+		live        []string
+		opts        gc.Options
+		wantDeleted []string
+		wantKept    []string
+		wantErr     bool
+	}{
+		"unreferenced blobs are deleted": {
+			live:        []string{live},
+			wantDeleted: []string{garbage},
+			wantKept:    []string{live},
+		},
+		"dry run": {
+			live:        []string{live},
+			opts:        gc.Options{DryRun: true},
+			wantDeleted: []string{garbage},
+			wantKept:    []string{garbage, live},
+		},
+		"grace period keeps recent blobs": {
+			live:     []string{live},
+			opts:     gc.Options{GracePeriod: time.Hour},
+			wantKept: []string{garbage, live},
+		},
+		"grace period is over": {
+			live:        []string{live},
+			opts:        gc.Options{GracePeriod: time.Hour, Now: time.Now().Add(2 * time.Hour)},
+			wantDeleted: []string{garbage},
+			wantKept:    []string{live},
+		},
+		"no blob is referenced": {
+			live:     []string{sriOf(t, "elsewhere")},
+			wantKept: []string{garbage, live},
+			wantErr:  true,
+		},
+		"no blob is referenced with force": {
+			live:        []string{sriOf(t, "elsewhere")},
+			opts:        gc.Options{Force: true},
+			wantDeleted: []string{garbage, live},
+		},
+		"referenced by several digests": {
+			live:        []string{sriOf(t, "elsewhere") + " " + live},
+			wantDeleted: []string{garbage},
+			wantKept:    []string{live},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store := memory.NewCAS(false)
+			writeBlobs(t, store, "live", "garbage")
+			liveSet := make(gc.Set)
+			for _, sri := range tc.live {
+				if err := liveSet.Add(sri); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report, err := gc.Sweep(store, liveSet, tc.opts)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("Sweep() succeeded, want error")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := deletedSRIs(report); !equal(got, tc.wantDeleted) {
+				t.Errorf("deleted %q, want %q", got, tc.wantDeleted)
+			}
+			if got := listSRIs(t, store); !equal(got, tc.wantKept) {
+				t.Errorf("kept %q, want %q", got, tc.wantKept)
+			}
+		})
+	}
+}
+
+func TestSweepAliases(t *testing.T) {
+	store := memory.NewCAS(false).WithAliases(sri.SHA512)
+	writeBlobs(t, store, "live", "garbage")
+	sris, err := cas.Hash(strings.NewReader("live"), sri.SHA512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the blob is referenced by its alias only
+	liveSet := make(gc.Set)
+	if err := liveSet.Add(sris[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gc.Sweep(store, liveSet, gc.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listSRIs(t, store), []string{sriOf(t, "live")}; !equal(got, want) {
+		t.Errorf("kept %q, want %q", got, want)
+	}
+}
+
+func TestSweepComposite(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	shared := make([]byte, 64<<10)
+	rng.Read(shared)
+	tail := make([]byte, 16<<10)
+	rng.Read(tail)
+	kept := string(shared)
+	deleted := string(shared) + string(tail)
+
+	testCases := map[string]struct {
+		opts gc.Options
+		// wantDeleted is true if the garbage blob and its own chunks are deleted.
+		wantDeleted bool
+	}{
+		"unreferenced chunks are deleted": {wantDeleted: true},
+		"dry run":                         {opts: gc.Options{DryRun: true}},
+		"grace period keeps the chunks of recent blobs": {opts: gc.Options{GracePeriod: time.Hour}},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			chunks := memory.NewCAS(false)
+			c, err := chunked.New(chunks, chunked.NewMemoryManifests(), chunked.Options{MinSize: 256, AvgSize: 1024, MaxSize: 4096})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeBlobs(t, c, kept, deleted)
+			keptParts, err := c.Parts(sriOf(t, kept))
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunksBefore := listSRIs(t, chunks)
+			liveSet := make(gc.Set)
+			if err := liveSet.Add(sriOf(t, kept)); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := gc.Sweep(c, liveSet, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Parts == nil {
+				t.Fatal("report has no parts")
+			}
+			chunksAfter := listSRIs(t, chunks)
+			if tc.wantDeleted {
+				if got := listSRIs(t, c); !equal(got, []string{sriOf(t, kept)}) {
+					t.Errorf("kept blobs %q, want only the referenced one", got)
+				}
+				if !equal(chunksAfter, unique(keptParts)) {
+					t.Errorf("kept %d chunks, want the %d chunks of the referenced blob", len(chunksAfter), len(unique(keptParts)))
+				}
+				var reclaimed int64
+				for _, blob := range report.Parts.Deleted {
+					reclaimed += blob.Size
+				}
+				if reclaimed == 0 || report.ReclaimedBytes != reclaimed {
+					t.Errorf("reclaimed %d bytes, want the %d bytes of the deleted chunks", report.ReclaimedBytes, reclaimed)
+				}
+			} else if len(listSRIs(t, c)) != 2 || !equal(chunksAfter, chunksBefore) {
+				t.Errorf("deleted blobs or chunks, want all of them kept")
+			}
+			if got := readBlob(t, c, sriOf(t, kept)); got != kept {
+				t.Error("referenced blob changed")
+			}
+		})
+	}
+}
+
+func TestMarkJSON(t *testing.T) {
+	payload := sriOf(t, "contents")
+	var flat bytes.Buffer
+	err := fsjson.Encode(&flat, []api.Stat{
+		{Name: "/", Kind: api.KindDirectory},
+		{Name: "/f", Kind: api.KindRegular, Payload: payload, Size: 8},
+		{Name: "/l", Kind: api.KindSymlink, Payload: "f"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := make(gc.Set)
+	if err := gc.MarkJSON(live, &flat); err != nil {
+		t.Fatal(err)
+	}
+	if len(live) != 1 || !live.Has(payload) {
+		t.Errorf("marked %v, want the regular file only", live)
+	}
+}
+
+func writeBlobs(t *testing.T, c api.CASWriter, contents ...string) {
+	t.Helper()
+	for _, blob := range contents {
+		if err := c.Write(sriOf(t, blob), strings.NewReader(blob)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func listSRIs(t *testing.T, store cas.Lister) []string {
+	t.Helper()
+	iter, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sris []string
+	for {
+		blob, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			return sris
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sris = append(sris, blob.SRI)
+	}
+}
+
+func deletedSRIs(report gc.Report) []string {
+	var sris []string
+	for _, blob := range report.Deleted {
+		sris = append(sris, blob.SRI)
+	}
+	return sris
+}
+
+// equal compares the sris regardless of their order.
+func equal(a, b []string) bool {
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return strings.Join(a, " ") == strings.Join(b, " ")
+}
+
+func unique(sris []string) []string {
+	seen := make(map[string]struct{})
+	var result []string
+	for _, sri := range sris {
+		if _, ok := seen[sri]; !ok {
+			seen[sri] = struct{}{}
+			result = append(result, sri)
+		}
+	}
+	return result
+}
+
+func sriOf(t *testing.T, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c api.CASReader, sriString string) string {
+	t.Helper()
+	r, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,346 @@
+// Package http implements a client for the CAS http protocol of the cas command.
+//
+// Blobs are addressed as <base url>/cas/<algorithm>/<hex digest>.
+package http
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/internal/verify"
+)
+
+// Defaults of the client options.
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultRetries   = 3
+	DefaultRetryWait = 500 * time.Millisecond
+)
+
+// Options configure the client.
+type Options struct {
+	// Client is used for all requests.
+	// If nil, a client that applies the timeout is used.
+	Client *http.Client
+	// Timeout limits connecting and waiting for the response headers.
+	// Reading and writing blobs is not limited, since blobs can be arbitrarily large.
+	// Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Retries is the number of times a request is retried after a network error
+	// or a 5xx or 429 response.
+	// Zero means DefaultRetries, a negative number disables retries.
+	Retries int
+	// RetryWait is the time to wait before the first retry.
+	// It doubles with every retry. Zero means DefaultRetryWait.
+	RetryWait time.Duration
+}
+
+// CAS is a content addressable storage served over http.
+// Every blob is verified against its sri while it is read.
+// Blobs are only uploaded if the server does not have them yet.
+type CAS struct {
+	baseURL   *url.URL
+	client    *http.Client
+	retries   int
+	retryWait time.Duration
+	readonly  bool
+}
+
+// NewCAS returns a client for the CAS at the base url.
+func NewCAS(baseURL string, opts Options, readonly bool) (*CAS, error) {
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryWait == 0 {
+		opts.RetryWait = DefaultRetryWait
+	}
+	client := opts.Client
+	if client == nil {
+		client = newClient(opts.Timeout)
+	}
+	return &CAS{
+		baseURL:   u,
+		client:    client,
+		retries:   opts.Retries,
+		retryWait: opts.RetryWait,
+		readonly:  readonly,
+	}, nil
+}
+
+// Close closes the idle connections of the client.
+func (c *CAS) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *CAS) Open(sriString string) (io.ReadCloser, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	resp, err := c.do(http.MethodGet, blobURL(c.baseURL, integrity), nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fs.ErrNotExist
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("getting blob %s: %s", integrity, resp.Status)
+	}
+	body, err := verify.NewReadCloser(resp.Body, integrity)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+func (c *CAS) Write(sriString string, r io.Reader) error {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return fmt.Errorf("checking sri on write: %w", err)
+	}
+	exists, err := c.exists(integrity)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	resp, err := c.do(http.MethodPut, blobURL(c.baseURL, integrity), r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("putting blob %s: %s", integrity, resp.Status)
+	}
+	return nil
+}
+
+// exists checks if the server has the blob.
+// Servers that do not support HEAD requests are asked with a GET request instead.
+func (c *CAS) exists(integrity sri.Integrity) (bool, error) {
+	resp, err := c.do(http.MethodHead, blobURL(c.baseURL, integrity), nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		if resp, err = c.do(http.MethodGet, blobURL(c.baseURL, integrity), nil); err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("checking blob %s: %s", integrity, resp.Status)
+}
+
+// do sends a request and retries it on network errors and retryable responses.
+// Requests with a body are only retried if the body is an io.Seeker.
+func (c *CAS) do(method, target string, body io.Reader) (*http.Response, error) {
+	retries := c.retries
+	var start int64
+	seeker, canSeek := body.(io.Seeker)
+	if body != nil && canSeek {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canSeek = false
+		}
+	}
+	if body != nil && !canSeek {
+		retries = 0
+	}
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+			if body != nil {
+				if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+					return nil, err
+				}
+			}
+		}
+		var reqBody io.Reader
+		if body != nil {
+			// the client closes request bodies, but the reader belongs to the caller
+			reqBody = io.NopCloser(body)
+		}
+		req, err := http.NewRequest(method, target, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		if attempt >= retries {
+			return resp, err
+		}
+		if err == nil {
+			if !retryable(resp.StatusCode) {
+				return resp, nil
+			}
+			resp.Body.Close()
+		}
+	}
+}
+
+// Stat returns the size and modification time of the blob.
+func (c *CAS) Stat(sriString string) (cas.BlobInfo, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return cas.BlobInfo{}, fs.ErrNotExist
+	}
+	resp, err := c.do(http.MethodHead, blobURL(c.baseURL, integrity), nil)
+	if err != nil {
+		return cas.BlobInfo{}, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return cas.BlobInfo{}, fs.ErrNotExist
+	default:
+		return cas.BlobInfo{}, fmt.Errorf("checking blob %s: %s", integrity, resp.Status)
+	}
+	info := cas.BlobInfo{SRI: integrity.String(), Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// List returns the blobs of the server whose sri starts with the prefix.
+// The blobs are decoded while they are iterated.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	u := *c.baseURL
+	u.Path += "/cas"
+	u.RawQuery = url.Values{"prefix": {prefix}}.Encode()
+	resp, err := c.do(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("listing blobs: %s", resp.Status)
+	}
+	return &listIterator{body: resp.Body, decoder: json.NewDecoder(resp.Body)}, nil
+}
+
+// Delete deletes the blob from the server.
+func (c *CAS) Delete(sriString string) error {
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	resp, err := c.do(http.MethodDelete, blobURL(c.baseURL, integrity), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return fs.ErrNotExist
+	}
+	return fmt.Errorf("deleting blob %s: %s", integrity, resp.Status)
+}
+
+// listIterator decodes the json lines of a list response.
+type listIterator struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+}
+
+func (l *listIterator) Next() (cas.BlobInfo, error) {
+	if l.body == nil {
+		return cas.BlobInfo{}, io.EOF
+	}
+	var info cas.BlobInfo
+	err := l.decoder.Decode(&info)
+	if err != nil {
+		l.body.Close()
+		l.body = nil
+		if !errors.Is(err, io.EOF) {
+			err = fmt.Errorf("listing blobs: %w", err)
+		}
+		return cas.BlobInfo{}, err
+	}
+	return info, nil
+}
+
+func retryable(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+// newClient returns a client that limits connecting and waiting for response headers.
+func newClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: transport}
+}
+
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cas url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("cas url %q must use http or https", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u, nil
+}
+
+// blobURL returns the url of the blob.
+func blobURL(baseURL *url.URL, integrity sri.Integrity) string {
+	u := *baseURL
+	u.Path += "/cas/" + string(integrity.Algorithm) + "/" + hex.EncodeToString(integrity.Hash)
+	return u.String()
+}
+
+var (
+	_ api.CAS     = (*CAS)(nil)
+	_ cas.Stater  = (*CAS)(nil)
+	_ cas.Lister  = (*CAS)(nil)
+	_ cas.Deleter = (*CAS)(nil)
+)
@@ -0,0 +1,337 @@
+package http_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	cashttp "github.com/malt3/abstractfs/cas/http"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/internal/casserve"
+)
+
+const blob = "hello world"
+
+func TestRoundTrip(t *testing.T) {
+	backend := memory.NewCAS(false)
+	server := newServer(t, backend, nil)
+	client := newClient(t, server.URL)
+	sriString := sriOf(t, blob)
+
+	if _, err := client.Open(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Open() of missing blob: got %v, want fs.ErrNotExist", err)
+	}
+	if err := client.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, client, sriString); got != blob {
+		t.Errorf("Open() = %q, want %q", got, blob)
+	}
+	info, err := client.Stat(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(blob)) {
+		t.Errorf("Stat().Size = %d, want %d", info.Size, len(blob))
+	}
+	if err := client.Delete(sriString); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Stat(sriString); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of deleted blob: got %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOpenRejectsTamperedBody(t *testing.T) {
+	backend := memory.NewCAS(false)
+	sriString := sriOf(t, blob)
+	if err := backend.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(t, backend, func(w http.ResponseWriter, req *http.Request, next http.Handler) {
+		if req.Method != http.MethodGet {
+			next.ServeHTTP(w, req)
+			return
+		}
+		io.WriteString(w, "hello w0rld")
+	})
+	client := newClient(t, server.URL)
+
+	body, err := client.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); err == nil {
+		t.Error("reading a tampered blob succeeded, want error")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	testCases := map[string]struct {
+		failures []int
+		retries  int
+		wantErr  bool
+	}{
+		"server error": {
+			failures: []int{http.StatusServiceUnavailable},
+			retries:  3,
+		},
+		"too many requests": {
+			failures: []int{http.StatusTooManyRequests, http.StatusTooManyRequests},
+			retries:  3,
+		},
+		"retries exhausted": {
+			failures: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			retries:  2,
+			wantErr:  true,
+		},
+		"retries disabled": {
+			failures: []int{http.StatusInternalServerError},
+			retries:  -1,
+			wantErr:  true,
+		},
+		"client errors are not retried": {
+			failures: []int{http.StatusForbidden},
+			retries:  3,
+			wantErr:  true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			backend := memory.NewCAS(false)
+			sriString := sriOf(t, blob)
+			if err := backend.Write(sriString, strings.NewReader(blob)); err != nil {
+				t.Fatal(err)
+			}
+			var mux sync.Mutex
+			failures := tc.failures
+			requests := 0
+			server := newServer(t, backend, func(w http.ResponseWriter, req *http.Request, next http.Handler) {
+				mux.Lock()
+				requests++
+				var status int
+				if len(failures) > 0 {
+					status, failures = failures[0], failures[1:]
+				}
+				mux.Unlock()
+				if status != 0 {
+					w.WriteHeader(status)
+					return
+				}
+				next.ServeHTTP(w, req)
+			})
+			client, err := cashttp.NewCAS(server.URL, cashttp.Options{Retries: tc.retries, RetryWait: time.Millisecond}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := client.Open(sriString)
+			if tc.wantErr {
+				if err == nil {
+					body.Close()
+					t.Fatal("Open() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			if got, err := io.ReadAll(body); err != nil || string(got) != blob {
+				t.Errorf("Open() = %q, %v, want %q", got, err, blob)
+			}
+			if want := len(tc.failures) + 1; requests != want {
+				t.Errorf("got %d requests, want %d", requests, want)
+			}
+		})
+	}
+}
+
+func TestWriteRetriesSeekableBody(t *testing.T) {
+	backend := memory.NewCAS(false)
+	sriString := sriOf(t, blob)
+	failedPut := false
+	server := newServer(t, backend, func(w http.ResponseWriter, req *http.Request, next http.Handler) {
+		if req.Method == http.MethodPut && !failedPut {
+			failedPut = true
+			// consume part of the body, so the retry must rewind it
+			io.CopyN(io.Discard, req.Body, 5)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+	client := newClient(t, server.URL)
+
+	if err := client.Write(sriString, bytes.NewReader([]byte(blob))); err != nil {
+		t.Fatal(err)
+	}
+	if !failedPut {
+		t.Fatal("the blob was not uploaded")
+	}
+	if got := readBlob(t, backend, sriString); got != blob {
+		t.Errorf("stored blob = %q, want %q", got, blob)
+	}
+}
+
+func TestWriteSkipsExistingBlob(t *testing.T) {
+	backend := memory.NewCAS(false)
+	sriString := sriOf(t, blob)
+	if err := backend.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	methods := make(map[string]int)
+	server := newServer(t, backend, func(w http.ResponseWriter, req *http.Request, next http.Handler) {
+		methods[req.Method]++
+		next.ServeHTTP(w, req)
+	})
+	client := newClient(t, server.URL)
+
+	if err := client.Write(sriString, failingReader{}); err != nil {
+		t.Fatal(err)
+	}
+	if methods[http.MethodHead] != 1 {
+		t.Errorf("got %d HEAD requests, want 1", methods[http.MethodHead])
+	}
+	if methods[http.MethodPut] != 0 {
+		t.Errorf("got %d PUT requests, want none", methods[http.MethodPut])
+	}
+}
+
+func TestWriteFallsBackToGet(t *testing.T) {
+	backend := memory.NewCAS(false)
+	existing := sriOf(t, blob)
+	if err := backend.Write(existing, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	methods := make(map[string]int)
+	server := newServer(t, backend, func(w http.ResponseWriter, req *http.Request, next http.Handler) {
+		methods[req.Method]++
+		if req.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+	client := newClient(t, server.URL)
+
+	if err := client.Write(existing, failingReader{}); err != nil {
+		t.Fatal(err)
+	}
+	if methods[http.MethodGet] != 1 || methods[http.MethodPut] != 0 {
+		t.Errorf("writing an existing blob: got %v, want one GET and no PUT", methods)
+	}
+
+	const other = "other blob"
+	missing := sriOf(t, other)
+	if err := client.Write(missing, strings.NewReader(other)); err != nil {
+		t.Fatal(err)
+	}
+	if methods[http.MethodPut] != 1 {
+		t.Errorf("writing a missing blob: got %v, want one PUT", methods)
+	}
+	if got := readBlob(t, backend, missing); got != other {
+		t.Errorf("stored blob = %q, want %q", got, other)
+	}
+}
+
+func TestList(t *testing.T) {
+	backend := memory.NewCAS(false)
+	blobs := []string{"a", "b", "c"}
+	for _, b := range blobs {
+		if err := backend.Write(sriOf(t, b), strings.NewReader(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newServer(t, backend, nil)
+	client := newClient(t, server.URL)
+
+	iter, err := client.List("sha256-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[string]int64)
+	for {
+		info, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		listed[info.SRI] = info.Size
+	}
+	for _, b := range blobs {
+		if size, ok := listed[sriOf(t, b)]; !ok || size != int64(len(b)) {
+			t.Errorf("blob %q: listed %v, %d", b, ok, size)
+		}
+	}
+}
+
+// middleware intercepts requests before they reach the CAS handler.
+type middleware func(w http.ResponseWriter, req *http.Request, next http.Handler)
+
+// newServer serves the backend with the handler of the cas command.
+func newServer(t *testing.T, backend *memory.CAS, intercept middleware) *httptest.Server {
+	t.Helper()
+	handler := casserve.NewHandler(backend)
+	if intercept != nil {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			intercept(w, req, next)
+		})
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newClient(t *testing.T, url string) *cashttp.CAS {
+	t.Helper()
+	client, err := cashttp.NewCAS(url, cashttp.Options{RetryWait: time.Millisecond}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func sriOf(t *testing.T, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c api.CASReader, sriString string) string {
+	t.Helper()
+	body, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	contents, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+// failingReader fails the test's write if the blob is uploaded.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("the blob must not be read")
+}
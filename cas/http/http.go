@@ -0,0 +1,74 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
+)
+
+type Provider struct {
+	// URL is the base url of the CAS server.
+	URL string `abstractfs:"url"`
+	// Timeout limits connecting and waiting for responses (e.g. "30s").
+	Timeout string `abstractfs:"timeout"`
+	// Retries is the number of retries of failed requests.
+	// A negative number disables retries.
+	Retries int `abstractfs:"retries"`
+}
+
+func (p Provider) Name() string {
+	return "http"
+}
+
+func (p Provider) SourceBuilder() provider.SourceBuilder {
+	return &provider.UnsupportedSourceBuilder{}
+}
+
+func (p Provider) SinkBuilder() provider.SinkBuilder {
+	return &provider.UnsupportedSinkBuilder{}
+}
+
+func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, cas.Close, nil
+}
+
+func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, cas.Close, nil
+}
+
+func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, cas.Close, nil
+}
+
+func (p Provider) newCAS(readonly bool) (*CAS, error) {
+	if p.URL == "" {
+		return nil, errors.New("http cas: missing url option")
+	}
+	opts := Options{Retries: p.Retries}
+	if p.Timeout != "" {
+		timeout, err := time.ParseDuration(p.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("http cas: invalid timeout: %w", err)
+		}
+		opts.Timeout = timeout
+	}
+	return NewCAS(p.URL, opts, readonly)
+}
+
+var _ provider.Provider = (*Provider)(nil)
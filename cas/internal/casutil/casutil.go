@@ -0,0 +1,18 @@
+// Package casutil contains helpers shared by the CAS implementations.
+package casutil
+
+// Modes of a CAS, as passed to the readonly parameter of the constructors.
+const (
+	ModeReadWrite = false
+	ModeReadOnly  = true
+)
+
+// Contains returns true if the sri is in the list.
+func Contains(sris []string, sri string) bool {
+	for _, s := range sris {
+		if s == sri {
+			return true
+		}
+	}
+	return false
+}
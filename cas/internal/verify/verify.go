@@ -0,0 +1,56 @@
+// Package verify verifies blobs against their sri.
+package verify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/malt3/abstractfs-core/sri"
+)
+
+// NewHash returns the hash of the sri algorithm.
+func NewHash(algorithm sri.Algorithm) (hash.Hash, error) {
+	switch algorithm {
+	case sri.SHA256:
+		return sha256.New(), nil
+	case sri.SHA384:
+		return sha512.New384(), nil
+	case sri.SHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+}
+
+// ReadCloser verifies the sri of a blob while it is read.
+// Instead of io.EOF, it returns an error if the blob does not match the sri.
+type ReadCloser struct {
+	body      io.ReadCloser
+	integrity sri.Integrity
+	hasher    hash.Hash
+}
+
+// NewReadCloser returns a ReadCloser that verifies the body against the sri.
+func NewReadCloser(body io.ReadCloser, integrity sri.Integrity) (*ReadCloser, error) {
+	hasher, err := NewHash(integrity.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadCloser{body: body, integrity: integrity, hasher: hasher}, nil
+}
+
+func (v *ReadCloser) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hasher.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hasher.Sum(nil), v.integrity.Hash) {
+		return n, fmt.Errorf("blob does not match %s", v.integrity)
+	}
+	return n, err
+}
+
+func (v *ReadCloser) Close() error {
+	return v.body.Close()
+}
@@ -2,71 +2,320 @@ package memory
 
 import (
 	"bytes"
+	"container/list"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/malt3/abstractfs-core/api"
 	coresri "github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
 )
 
+// Limits bound the memory used by the CAS.
+// Zero values mean no limit.
+type Limits struct {
+	// Budget is the maximum number of bytes of all blobs.
+	// If a new blob does not fit, the least recently used blobs are evicted.
+	// Blobs larger than the budget are rejected.
+	Budget int64
+	// MaxBlobSize is the maximum size of a single blob.
+	// Larger blobs are rejected.
+	MaxBlobSize int64
+}
+
+// CAS is a content addressable storage that keeps blobs in memory.
+//
+// Blobs are read and validated outside of the lock,
+// so slow writers do not block other readers and writers.
+// Concurrent writes of the same blob wait for the first one.
+//
+// Blobs can be indexed by their sris in several algorithms (see WithAliases),
+// so the same content written under different algorithms is stored once
+// and can be opened by any of its sris.
 type CAS struct {
-	mux      sync.RWMutex
-	m        map[string][]byte
+	mux sync.Mutex
+	// entries maps sris and their aliases to elements of lru.
+	entries map[string]*list.Element
+	// lru holds the blobs, the most recently used first.
+	lru *list.List
+	// inflight holds the writes that are currently read.
+	inflight map[string]*inflightWrite
+	used     int64
+	limits   Limits
+	aliases  []coresri.Algorithm
 	readonly bool
 }
 
+type entry struct {
+	sri string
+	// aliases are the sris of the blob in other algorithms.
+	aliases []string
+	data    []byte
+	written time.Time
+}
+
+type inflightWrite struct {
+	done chan struct{}
+	err  error
+}
+
 func NewCAS(readonly bool) *CAS {
+	return NewCASWithLimits(readonly, Limits{})
+}
+
+// NewCASWithLimits returns a CAS that keeps at most limits.Budget bytes
+// and rejects blobs larger than limits.MaxBlobSize.
+func NewCASWithLimits(readonly bool, limits Limits) *CAS {
 	return &CAS{
-		m:        map[string][]byte{},
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		inflight: map[string]*inflightWrite{},
+		limits:   limits,
 		readonly: readonly,
 	}
 }
 
+// WithAliases sets the algorithms that written blobs are additionally hashed with.
+// The blobs can then be opened by their sris in these algorithms, too.
+// It must be called before the CAS is used.
+func (c *CAS) WithAliases(algorithms ...coresri.Algorithm) *CAS {
+	c.aliases = algorithms
+	return c
+}
+
+// Open opens the blob.
+// The sri may be any alias of the blob and may contain several space separated digests.
 func (c *CAS) Open(sri string) (io.ReadCloser, error) {
-	c.mux.RLock()
-	defer c.mux.RUnlock()
+	c.mux.Lock()
+	defer c.mux.Unlock()
 
-	b, ok := c.m[sri]
+	element, ok := c.lookup(sri)
 	if !ok {
 		return nil, fs.ErrNotExist
 	}
+	c.lru.MoveToFront(element)
 
-	return io.NopCloser(bytes.NewReader(b)), nil
+	return io.NopCloser(bytes.NewReader(element.Value.(*entry).data)), nil
 }
 
+// Write writes the blob.
+// If the sri contains several digests, the blob must match all of them.
 func (c *CAS) Write(sri string, r io.Reader) error {
+	digests := cas.Digests(sri)
+	if len(digests) == 0 {
+		return fmt.Errorf("checking sri on write: invalid sri %q", sri)
+	}
+	key := digests[0]
+	for {
+		c.mux.Lock()
+		if _, ok := c.lookup(sri); ok {
+			c.mux.Unlock()
+			return nil
+		}
+		w, ok := c.inflight[key]
+		if !ok {
+			break
+		}
+		c.mux.Unlock()
+		// if the other write fails (for example because of invalid contents), try this one
+		<-w.done
+		if w.err == nil {
+			return nil
+		}
+	}
+	if c.readonly {
+		c.mux.Unlock()
+		return errors.New("cas is readonly")
+	}
+	w := &inflightWrite{done: make(chan struct{})}
+	c.inflight[key] = w
+	c.mux.Unlock()
+
+	data, sums, err := c.read(digests, r)
+
 	c.mux.Lock()
 	defer c.mux.Unlock()
-
-	if _, ok := c.m[sri]; ok {
-		return nil
+	delete(c.inflight, key)
+	if err == nil {
+		c.insert(sums, data)
 	}
+	w.err = err
+	close(w.done)
+	return err
+}
 
-	if c.readonly {
-		return errors.New("cas is readonly")
+// read reads the blob and validates it against all digests.
+// It returns the data and the sris of the blob, the digests first, followed by the aliases.
+func (c *CAS) read(digests []string, r io.Reader) ([]byte, []string, error) {
+	algorithms := make([]coresri.Algorithm, 0, len(digests)+len(c.aliases))
+	for _, digest := range digests {
+		integrity, err := coresri.FromString(digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("checking sri on write: %w", err)
+		}
+		algorithms = append(algorithms, integrity.Algorithm)
 	}
-	b, err := io.ReadAll(r)
+	hasher, err := cas.NewMultiHash(append(algorithms, c.aliases...)...)
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("checking sri on write: %w", err)
 	}
-	integrity, err := coresri.FromString(sri)
-	if err != nil {
-		return fmt.Errorf("checking sri on write: %w", err)
+	maxSize := c.limits.MaxBlobSize
+	if c.limits.Budget > 0 && (maxSize <= 0 || c.limits.Budget < maxSize) {
+		maxSize = c.limits.Budget
+	}
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(io.MultiWriter(&buf, hasher), r); err != nil {
+		return nil, nil, err
+	}
+	if maxSize > 0 && int64(buf.Len()) > maxSize {
+		return nil, nil, fmt.Errorf("blob %s exceeds the maximum size of %d bytes", digests[0], maxSize)
+	}
+	sums := hasher.Sums()
+	for _, digest := range digests {
+		if !casutil.Contains(sums, digest) {
+			return nil, nil, fmt.Errorf("validating sri on write: blob does not match %s", digest)
+		}
+	}
+	// the digests come first, so the blob is stored under the sri it was written with
+	sris := make([]string, 0, len(digests)+len(sums))
+	for _, list := range [][]string{digests, sums} {
+		for _, sri := range list {
+			if !casutil.Contains(sris, sri) {
+				sris = append(sris, sri)
+			}
+		}
+	}
+	return buf.Bytes(), sris, nil
+}
+
+// insert stores the blob under the first sri and indexes it by the others.
+// If the blob is already stored under one of the sris (written in a different algorithm),
+// the missing sris are added as aliases instead.
+// The least recently used blobs are evicted to stay within the budget.
+// The caller must hold the lock.
+func (c *CAS) insert(sris []string, data []byte) {
+	for _, sri := range sris {
+		element, ok := c.entries[sri]
+		if !ok {
+			continue
+		}
+		e := element.Value.(*entry)
+		for _, alias := range sris {
+			if _, ok := c.entries[alias]; !ok {
+				e.aliases = append(e.aliases, alias)
+				c.entries[alias] = element
+			}
+		}
+		return
+	}
+	size := int64(len(data))
+	for c.limits.Budget > 0 && c.used+size > c.limits.Budget {
+		c.remove(c.lru.Back())
+	}
+	element := c.lru.PushFront(&entry{sri: sris[0], aliases: sris[1:], data: data, written: time.Now()})
+	for _, sri := range sris {
+		c.entries[sri] = element
+	}
+	c.used += size
+}
+
+// lookup returns the element of the first digest of the sri that is stored.
+// The caller must hold the lock.
+func (c *CAS) lookup(sri string) (*list.Element, bool) {
+	if element, ok := c.entries[sri]; ok {
+		return element, true
+	}
+	for _, digest := range cas.Digests(sri) {
+		if element, ok := c.entries[digest]; ok {
+			return element, true
+		}
 	}
-	if err := integrity.Validate(bytes.NewReader(b)); err != nil {
-		return fmt.Errorf("validating sri on write: %w", err)
+	return nil, false
+}
+
+// remove removes the blob and all of its aliases.
+// The caller must hold the lock.
+func (c *CAS) remove(element *list.Element) {
+	e := c.lru.Remove(element).(*entry)
+	delete(c.entries, e.sri)
+	for _, alias := range e.aliases {
+		delete(c.entries, alias)
+	}
+	c.used -= int64(len(e.data))
+}
+
+// Evicts returns true if the CAS has a budget and evicts the least recently used blobs.
+func (c *CAS) Evicts() bool {
+	return c.limits.Budget > 0
+}
+
+func (c *CAS) Stat(sri string) (cas.BlobInfo, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	element, ok := c.lookup(sri)
+	if !ok {
+		return cas.BlobInfo{}, fs.ErrNotExist
 	}
+	return element.Value.(*entry).info(), nil
+}
+
+// List returns the blobs whose sri or one of its aliases starts with the prefix.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 
-	c.m[sri] = b
+	var blobs []cas.BlobInfo
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		e := element.Value.(*entry)
+		if strings.HasPrefix(e.sri, prefix) || hasPrefix(e.aliases, prefix) {
+			blobs = append(blobs, e.info())
+		}
+	}
+	return cas.NewSliceIterator(blobs), nil
+}
+
+func (c *CAS) Delete(sri string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	element, ok := c.lookup(sri)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	c.remove(element)
 	return nil
 }
 
-const (
-	modeReadWrite = false
-	modeReadOnly  = true
-)
+func (e *entry) info() cas.BlobInfo {
+	return cas.BlobInfo{SRI: e.sri, Size: int64(len(e.data)), ModTime: e.written, Aliases: e.aliases}
+}
 
-var _ api.CAS = (*CAS)(nil)
+func hasPrefix(sris []string, prefix string) bool {
+	for _, s := range sris {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ api.CAS     = (*CAS)(nil)
+	_ cas.Stater  = (*CAS)(nil)
+	_ cas.Lister  = (*CAS)(nil)
+	_ cas.Deleter = (*CAS)(nil)
+	_ cas.Evicter = (*CAS)(nil)
+)
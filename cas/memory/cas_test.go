@@ -0,0 +1,225 @@
+package memory
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+
+	coresri "github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+)
+
+func TestLimits(t *testing.T) {
+	testCases := map[string]struct {
+		limits Limits
+		blobs  []string
+		// open is opened after writing all blobs but the last one, which makes it the most recently used.
+		open    string
+		wantErr bool
+		want    []string
+	}{
+		"no limits": {
+			blobs: []string{"aaaa", "bbbb", "cccc"},
+			want:  []string{"aaaa", "bbbb", "cccc"},
+		},
+		"least recently written blob is evicted": {
+			limits: Limits{Budget: 8},
+			blobs:  []string{"aaaa", "bbbb", "cccc"},
+			want:   []string{"bbbb", "cccc"},
+		},
+		"opening keeps a blob": {
+			limits: Limits{Budget: 8},
+			blobs:  []string{"aaaa", "bbbb", "cccc"},
+			open:   "aaaa",
+			want:   []string{"aaaa", "cccc"},
+		},
+		"several blobs are evicted for a large blob": {
+			limits: Limits{Budget: 8},
+			blobs:  []string{"aaaa", "bbbb", "cccccccc"},
+			want:   []string{"cccccccc"},
+		},
+		"blob larger than the budget": {
+			limits:  Limits{Budget: 8},
+			blobs:   []string{"aaaa", "ccccccccc"},
+			wantErr: true,
+			want:    []string{"aaaa"},
+		},
+		"blob larger than the max blob size": {
+			limits:  Limits{MaxBlobSize: 4},
+			blobs:   []string{"aaaa", "ccccc"},
+			wantErr: true,
+			want:    []string{"aaaa"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := NewCASWithLimits(false, tc.limits)
+			var err error
+			for i, blob := range tc.blobs {
+				if i == len(tc.blobs)-1 && tc.open != "" {
+					readBlob(t, c, sriOf(t, tc.open, coresri.SHA256))
+				}
+				err = c.Write(sriOf(t, blob, coresri.SHA256), strings.NewReader(blob))
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Write() of the last blob returned %v, want error: %v", err, tc.wantErr)
+			}
+			var stored []string
+			var used int64
+			for _, blob := range tc.blobs {
+				if exists, _ := cas.Exists(c, sriOf(t, blob, coresri.SHA256)); exists {
+					stored = append(stored, blob)
+					used += int64(len(blob))
+				}
+			}
+			if strings.Join(stored, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("stored %q, want %q", stored, tc.want)
+			}
+			if c.used != used {
+				t.Errorf("used %d bytes, want %d", c.used, used)
+			}
+		})
+	}
+}
+
+func TestAliases(t *testing.T) {
+	const blob = "contents"
+	sha256, sha512 := sriOf(t, blob, coresri.SHA256), sriOf(t, blob, coresri.SHA512)
+
+	c := NewCAS(false).WithAliases(coresri.SHA512)
+	if err := c.Write(sha256, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, c, sha512); got != blob {
+		t.Errorf("Open() of the alias = %q, want %q", got, blob)
+	}
+	info, err := c.Stat(sha512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SRI != sha256 || len(info.Aliases) != 1 || info.Aliases[0] != sha512 {
+		t.Errorf("Stat() = %+v, want %s with alias %s", info, sha256, sha512)
+	}
+
+	// the same contents written under another algorithm are stored once
+	sha384 := sriOf(t, blob, coresri.SHA384)
+	if err := c.Write(sha384, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if c.lru.Len() != 1 || c.used != int64(len(blob)) {
+		t.Errorf("stored %d blobs with %d bytes, want one", c.lru.Len(), c.used)
+	}
+	if got := readBlob(t, c, sha384); got != blob {
+		t.Errorf("Open() of the new alias = %q, want %q", got, blob)
+	}
+
+	if err := c.Delete(sha512); err != nil {
+		t.Fatal(err)
+	}
+	for _, sri := range []string{sha256, sha384, sha512} {
+		if _, err := c.Open(sri); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open(%s) after Delete() returned %v, want fs.ErrNotExist", sri, err)
+		}
+	}
+}
+
+func TestWriteSeveralDigests(t *testing.T) {
+	const blob = "contents"
+	sha256, sha512 := sriOf(t, blob, coresri.SHA256), sriOf(t, blob, coresri.SHA512)
+	c := NewCAS(false)
+	if err := c.Write(sha256+" "+sriOf(t, "other", coresri.SHA512), strings.NewReader(blob)); err == nil {
+		t.Error("Write() succeeded for a blob that does not match all digests")
+	}
+	if err := c.Write(sha256+" "+sha512, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	for _, sri := range []string{sha256, sha512} {
+		if got := readBlob(t, c, sri); got != blob {
+			t.Errorf("Open(%s) = %q, want %q", sri, got, blob)
+		}
+	}
+}
+
+func TestWriteMismatch(t *testing.T) {
+	sri := sriOf(t, "contents", coresri.SHA256)
+	c := NewCAS(false)
+	if err := c.Write(sri, strings.NewReader("invalid")); err == nil {
+		t.Fatal("Write() of mismatching contents succeeded")
+	}
+	if c.lru.Len() != 0 || c.used != 0 || len(c.inflight) != 0 {
+		t.Errorf("failed Write() left %d blobs, %d bytes and %d inflight writes", c.lru.Len(), c.used, len(c.inflight))
+	}
+	if err := c.Write(sri, strings.NewReader("contents")); err != nil {
+		t.Errorf("Write() after a failed write returned %v", err)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	const blob = "contents"
+	sri := sriOf(t, blob, coresri.SHA256)
+	c := NewCAS(false)
+
+	// a write with invalid contents does not fail the concurrent writes of the same blob
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			contents := blob
+			if i == 0 {
+				contents = "invalid"
+			}
+			errs[i] = c.Write(sri, strings.NewReader(contents))
+		}(i)
+	}
+	wg.Wait()
+	// the invalid write only fails if it is not skipped because the blob was already stored
+	for i, err := range errs[1:] {
+		if err != nil {
+			t.Errorf("Write() %d returned %v", i+1, err)
+		}
+	}
+	if got := readBlob(t, c, sri); got != blob {
+		t.Errorf("Open() = %q, want %q", got, blob)
+	}
+	if c.lru.Len() != 1 || len(c.inflight) != 0 {
+		t.Errorf("got %d blobs and %d inflight writes, want one blob", c.lru.Len(), len(c.inflight))
+	}
+}
+
+func TestReadonly(t *testing.T) {
+	c := NewCAS(true)
+	if err := c.Write(sriOf(t, "contents", coresri.SHA256), strings.NewReader("contents")); err == nil {
+		t.Error("Write() to a readonly cas succeeded")
+	}
+	if err := c.Delete(sriOf(t, "contents", coresri.SHA256)); err == nil {
+		t.Error("Delete() from a readonly cas succeeded")
+	}
+}
+
+func sriOf(t *testing.T, contents string, algorithm coresri.Algorithm) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c *CAS, sri string) string {
+	t.Helper()
+	r, err := c.Open(sri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -3,9 +3,20 @@ package memory
 import (
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
 )
 
-type Provider struct{}
+type Provider struct {
+	// Budget is the maximum number of bytes kept in memory.
+	// The least recently used blobs are evicted to stay within the budget.
+	Budget int64 `abstractfs:"budget"`
+	// MaxBlobSize is the maximum size of a single blob in bytes.
+	MaxBlobSize int64 `abstractfs:"max-blob-size"`
+	// Aliases are the algorithms that blobs are additionally hashed with,
+	// so they can be opened by their sris in these algorithms, too.
+	Aliases []sri.Algorithm `abstractfs:"aliases"`
+}
 
 func (p Provider) Name() string {
 	return "memory"
@@ -20,15 +31,19 @@ func (p Provider) SinkBuilder() provider.SinkBuilder {
 }
 
 func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
-	return NewCAS(modeReadWrite), func() error { return nil }, nil
+	return p.newCAS(casutil.ModeReadWrite), func() error { return nil }, nil
 }
 
 func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
-	return NewCAS(modeReadOnly), nil, nil
+	return p.newCAS(casutil.ModeReadOnly), nil, nil
 }
 
 func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
-	return NewCAS(modeReadWrite), nil, nil
+	return p.newCAS(casutil.ModeReadWrite), nil, nil
+}
+
+func (p Provider) newCAS(readonly bool) *CAS {
+	return NewCASWithLimits(readonly, Limits{Budget: p.Budget, MaxBlobSize: p.MaxBlobSize}).WithAliases(p.Aliases...)
 }
 
 var _ provider.Provider = (*Provider)(nil)
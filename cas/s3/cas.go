@@ -0,0 +1,553 @@
+// Package s3 implements a content addressable storage backed by an S3 compatible object storage.
+//
+// Requests are signed with AWS signature version 4.
+// Blobs are only uploaded if no object exists for them yet.
+// Large blobs are uploaded in parts using multipart uploads.
+package s3
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/internal/verify"
+)
+
+// Layouts of the object keys.
+const (
+	// LayoutSharded stores blobs as <prefix><algorithm>/<first two hex digits>/<hex>,
+	// like the dir CAS.
+	LayoutSharded = "sharded"
+	// LayoutFlat stores blobs as <prefix><algorithm>/<hex>.
+	LayoutFlat = "flat"
+)
+
+const (
+	// DefaultRegion is used if no region is configured.
+	DefaultRegion = "us-east-1"
+	// DefaultPartSize is the default size of the parts of multipart uploads.
+	// Blobs smaller than the part size are uploaded with a single request.
+	DefaultPartSize = 16 << 20
+	// MinPartSize is the minimum part size of S3 multipart uploads.
+	MinPartSize = 5 << 20
+	// maxParts is the maximum number of parts of a multipart upload.
+	maxParts = 10000
+)
+
+// Config configures the S3 CAS.
+type Config struct {
+	// Endpoint is the url of the S3 service (e.g. https://s3.eu-central-1.amazonaws.com).
+	Endpoint string
+	// Bucket is the name of the bucket.
+	Bucket string
+	// Region is the region of the bucket. Defaults to DefaultRegion.
+	Region string
+	// Credentials are used to sign requests.
+	Credentials Credentials
+	// Prefix is prepended to all object keys.
+	Prefix string
+	// Layout is the layout of the object keys. Defaults to LayoutSharded.
+	Layout string
+	// PathStyle addresses the bucket as part of the path instead of the host name.
+	// Most self-hosted S3 implementations require path style requests.
+	PathStyle bool
+	// PartSize is the size of the parts of multipart uploads. Defaults to DefaultPartSize.
+	PartSize int64
+	// Client is used for all requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// CAS is a content addressable storage backed by an S3 bucket.
+type CAS struct {
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	layout    string
+	pathStyle bool
+	partSize  int64
+	client    *http.Client
+	signer    signer
+	readonly  bool
+}
+
+func NewCAS(config Config, readonly bool) (*CAS, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing endpoint: %w", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("endpoint %q must use http or https", config.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	if config.Bucket == "" {
+		return nil, errors.New("missing bucket")
+	}
+	if config.Region == "" {
+		config.Region = DefaultRegion
+	}
+	switch config.Layout {
+	case "":
+		config.Layout = LayoutSharded
+	case LayoutSharded, LayoutFlat:
+	default:
+		return nil, fmt.Errorf("unsupported layout %q", config.Layout)
+	}
+	switch {
+	case config.PartSize == 0:
+		config.PartSize = DefaultPartSize
+	case config.PartSize < MinPartSize:
+		return nil, fmt.Errorf("part size must be at least %d bytes", MinPartSize)
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &CAS{
+		endpoint:  endpoint,
+		bucket:    config.Bucket,
+		prefix:    config.Prefix,
+		layout:    config.Layout,
+		pathStyle: config.PathStyle,
+		partSize:  config.PartSize,
+		client:    config.Client,
+		signer:    signer{credentials: config.Credentials, region: config.Region, service: "s3"},
+		readonly:  readonly,
+	}, nil
+}
+
+func (c *CAS) Open(sriString string) (io.ReadCloser, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	resp, err := c.do(http.MethodGet, c.key(integrity), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fs.ErrNotExist
+	default:
+		defer resp.Body.Close()
+		return nil, responseError("getting blob "+integrity.String(), resp)
+	}
+	body, err := verify.NewReadCloser(resp.Body, integrity)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return body, nil
+}
+
+func (c *CAS) Write(sriString string, r io.Reader) error {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return fmt.Errorf("checking sri on write: %w", err)
+	}
+	key := c.key(integrity)
+	exists, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+
+	hasher, err := verify.NewHash(integrity.Algorithm)
+	if err != nil {
+		return err
+	}
+	r = io.TeeReader(r, hasher)
+	// most blobs are small: the buffer only grows to the part size for large blobs
+	var first bytes.Buffer
+	n, err := first.ReadFrom(io.LimitReader(r, c.partSize))
+	if err != nil {
+		return err
+	}
+	if n < c.partSize {
+		// the blob fits into a single request
+		if !bytes.Equal(hasher.Sum(nil), integrity.Hash) {
+			return fmt.Errorf("validating sri on write: blob does not match %s", integrity)
+		}
+		return c.putObject(key, first.Bytes())
+	}
+	return c.multipartUpload(key, integrity, first.Bytes(), r, hasher)
+}
+
+// exists checks if an object exists for the key.
+func (c *CAS) exists(key string) (bool, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, responseError("checking object "+key, resp)
+}
+
+func (c *CAS) putObject(key string, body []byte) error {
+	resp, err := c.do(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("putting object "+key, resp)
+	}
+	return nil
+}
+
+// multipartUpload uploads the buffered first part and the rest of the reader in parts.
+// The following parts are read into a single buffer of the part size.
+// The upload is only completed if the hasher, which is fed by the reader, matches the sri.
+func (c *CAS) multipartUpload(key string, integrity sri.Integrity, first []byte, r io.Reader, hasher hash.Hash) (retErr error) {
+	uploadID, err := c.createMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			// the upload is incomplete, abort it to free the stored parts
+			_ = c.abortMultipartUpload(key, uploadID)
+		}
+	}()
+
+	var parts []completedPart
+	var buf []byte
+	part := first
+	for {
+		if len(parts) == maxParts {
+			return fmt.Errorf("blob %s has more than %d parts of %d bytes", integrity, maxParts, c.partSize)
+		}
+		etag, err := c.uploadPart(key, uploadID, len(parts)+1, part)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: len(parts) + 1, ETag: etag})
+
+		if buf == nil {
+			buf = make([]byte, c.partSize)
+		}
+		n, err := io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		part = buf[:n]
+	}
+	if !bytes.Equal(hasher.Sum(nil), integrity.Hash) {
+		return fmt.Errorf("validating sri on write: blob does not match %s", integrity)
+	}
+	return c.completeMultipartUpload(key, uploadID, parts)
+}
+
+func (c *CAS) createMultipartUpload(key string) (string, error) {
+	resp, err := c.do(http.MethodPost, key, map[string]string{"uploads": ""}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("creating multipart upload of "+key, resp)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("creating multipart upload of %s: %w", key, err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("creating multipart upload of %s: missing upload id", key)
+	}
+	return result.UploadID, nil
+}
+
+func (c *CAS) uploadPart(key, uploadID string, number int, body []byte) (string, error) {
+	query := map[string]string{"partNumber": strconv.Itoa(number), "uploadId": uploadID}
+	resp, err := c.do(http.MethodPut, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(fmt.Sprintf("uploading part %d of %s", number, key), resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *CAS) completeMultipartUpload(key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, key, map[string]string{"uploadId": uploadID}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("completing multipart upload of "+key, resp)
+	}
+	// S3 may report errors in the body of a successful response
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("completing multipart upload of %s: %w", key, err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("completing multipart upload of %s: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+func (c *CAS) abortMultipartUpload(key, uploadID string) error {
+	resp, err := c.do(http.MethodDelete, key, map[string]string{"uploadId": uploadID}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError("aborting multipart upload of "+key, resp)
+	}
+	return nil
+}
+
+// Stat returns the size and modification time of the blob.
+func (c *CAS) Stat(sriString string) (cas.BlobInfo, error) {
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return cas.BlobInfo{}, fs.ErrNotExist
+	}
+	key := c.key(integrity)
+	resp, err := c.do(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return cas.BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return cas.BlobInfo{}, fs.ErrNotExist
+	default:
+		return cas.BlobInfo{}, responseError("checking object "+key, resp)
+	}
+	info := cas.BlobInfo{SRI: integrity.String(), Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// List returns the blobs whose sri starts with the prefix.
+// Objects are listed page by page while iterating.
+// Objects that do not belong to the layout of the CAS are skipped.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	keyPrefix := c.prefix
+	// the algorithm is part of the key, so listing can be limited to it
+	if algorithm, _, ok := strings.Cut(prefix, "-"); ok {
+		if _, err := sri.AlgorithmFromString(algorithm); err == nil {
+			keyPrefix += algorithm + "/"
+		}
+	}
+	return &listIterator{cas: c, keyPrefix: keyPrefix, sriPrefix: prefix}, nil
+}
+
+// Delete deletes the object of the blob.
+func (c *CAS) Delete(sriString string) error {
+	if c.readonly {
+		return errors.New("cas is readonly")
+	}
+	integrity, err := sri.FromString(sriString)
+	if err != nil {
+		return fs.ErrNotExist
+	}
+	key := c.key(integrity)
+	// S3 does not report deletions of missing objects
+	exists, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fs.ErrNotExist
+	}
+	resp, err := c.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return responseError("deleting object "+key, resp)
+	}
+	return nil
+}
+
+// listIterator iterates over the pages of a ListObjectsV2 request.
+type listIterator struct {
+	cas                  *CAS
+	keyPrefix, sriPrefix string
+	page                 []cas.BlobInfo
+	continuationToken    string
+	done                 bool
+}
+
+func (l *listIterator) Next() (cas.BlobInfo, error) {
+	for len(l.page) == 0 {
+		if l.done {
+			return cas.BlobInfo{}, io.EOF
+		}
+		if err := l.nextPage(); err != nil {
+			return cas.BlobInfo{}, err
+		}
+	}
+	info := l.page[0]
+	l.page = l.page[1:]
+	return info, nil
+}
+
+func (l *listIterator) nextPage() error {
+	query := map[string]string{"list-type": "2", "prefix": l.keyPrefix}
+	if l.continuationToken != "" {
+		query["continuation-token"] = l.continuationToken
+	}
+	resp, err := l.cas.do(http.MethodGet, "", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("listing objects", resp)
+	}
+	var result struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("listing objects: %w", err)
+	}
+	for _, object := range result.Contents {
+		sriString, ok := l.cas.sriOfKey(object.Key)
+		if !ok || !strings.HasPrefix(sriString, l.sriPrefix) {
+			continue
+		}
+		l.page = append(l.page, cas.BlobInfo{SRI: sriString, Size: object.Size, ModTime: object.LastModified})
+	}
+	l.continuationToken = result.NextContinuationToken
+	l.done = !result.IsTruncated || result.NextContinuationToken == ""
+	return nil
+}
+
+// sriOfKey returns the sri of the blob stored under the key.
+func (c *CAS) sriOfKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, c.prefix)
+	if !ok {
+		return "", false
+	}
+	parts := strings.Split(rest, "/")
+	if (c.layout == LayoutFlat && len(parts) != 2) || (c.layout == LayoutSharded && len(parts) != 3) {
+		return "", false
+	}
+	algorithm, err := sri.AlgorithmFromString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	hash, err := hex.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return "", false
+	}
+	return sri.Integrity{Algorithm: algorithm, Hash: hash}.String(), true
+}
+
+// key returns the object key of the blob.
+func (c *CAS) key(integrity sri.Integrity) string {
+	digest := hex.EncodeToString(integrity.Hash)
+	if c.layout == LayoutFlat {
+		return c.prefix + string(integrity.Algorithm) + "/" + digest
+	}
+	return c.prefix + string(integrity.Algorithm) + "/" + digest[:2] + "/" + digest
+}
+
+// do sends a signed request for the object with the key.
+func (c *CAS) do(method, key string, query map[string]string, body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	objectPath := "/" + key
+	if c.pathStyle {
+		objectPath = "/" + c.bucket + objectPath
+	} else {
+		u.Host = c.bucket + "." + u.Host
+	}
+	u.Path += objectPath
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	var bodyReader io.Reader
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+		payloadHash = hashHex(body)
+	}
+	req, err := http.NewRequest(method, u.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	c.signer.sign(req, payloadHash, time.Now())
+	return c.client.Do(req)
+}
+
+// responseError returns an error with the status and the S3 error code of the response.
+func responseError(op string, resp *http.Response) error {
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err); err != nil || s3Err.Code == "" {
+		return fmt.Errorf("%s: %s", op, resp.Status)
+	}
+	return fmt.Errorf("%s: %s: %s: %s", op, resp.Status, s3Err.Code, s3Err.Message)
+}
+
+var (
+	_ api.CAS     = (*CAS)(nil)
+	_ cas.Stater  = (*CAS)(nil)
+	_ cas.Lister  = (*CAS)(nil)
+	_ cas.Deleter = (*CAS)(nil)
+)
@@ -0,0 +1,118 @@
+package s3
+
+import (
+	"errors"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/cas/internal/casutil"
+)
+
+type Provider struct {
+	// Endpoint is the url of the S3 service.
+	Endpoint string `abstractfs:"endpoint"`
+	// Bucket is the name of the bucket.
+	Bucket string `abstractfs:"bucket"`
+	// Region is the region of the bucket.
+	Region string `abstractfs:"region"`
+	// Prefix is prepended to all object keys.
+	Prefix string `abstractfs:"prefix"`
+	// Layout is the layout of the object keys (sharded or flat).
+	Layout string `abstractfs:"layout"`
+	// PathStyle enables path style requests.
+	PathStyle bool `abstractfs:"path-style"`
+	// PartSize is the size of the parts of multipart uploads in bytes.
+	PartSize int64 `abstractfs:"part-size"`
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials.
+	// If not set, they are read from the environment or the credentials file.
+	AccessKeyID     string `abstractfs:"access-key-id"`
+	SecretAccessKey string `abstractfs:"secret-access-key"`
+	SessionToken    string `abstractfs:"session-token"`
+	// CredentialsFile is the path of a shared credentials file.
+	CredentialsFile string `abstractfs:"credentials-file"`
+	// Profile is the profile of the credentials file.
+	Profile string `abstractfs:"profile"`
+}
+
+func (p Provider) Name() string {
+	return "s3"
+}
+
+func (p Provider) SourceBuilder() provider.SourceBuilder {
+	return &provider.UnsupportedSourceBuilder{}
+}
+
+func (p Provider) SinkBuilder() provider.SinkBuilder {
+	return &provider.UnsupportedSinkBuilder{}
+}
+
+func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
+	cas, err := p.newCAS(casutil.ModeReadWrite)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cas, func() error { return nil }, nil
+}
+
+func (p Provider) newCAS(readonly bool) (*CAS, error) {
+	if p.Endpoint == "" {
+		return nil, errors.New("s3 cas: missing endpoint option")
+	}
+	if p.Bucket == "" {
+		return nil, errors.New("s3 cas: missing bucket option")
+	}
+	creds, err := p.credentials()
+	if err != nil {
+		return nil, err
+	}
+	return NewCAS(Config{
+		Endpoint:    p.Endpoint,
+		Bucket:      p.Bucket,
+		Region:      p.Region,
+		Credentials: creds,
+		Prefix:      p.Prefix,
+		Layout:      p.Layout,
+		PathStyle:   p.PathStyle,
+		PartSize:    p.PartSize,
+	}, readonly)
+}
+
+// credentials returns the credentials of the options.
+// Without options, the credentials are read from the environment
+// and, if not set there, from the credentials file.
+func (p Provider) credentials() (Credentials, error) {
+	if p.AccessKeyID != "" || p.SecretAccessKey != "" {
+		if p.AccessKeyID == "" || p.SecretAccessKey == "" {
+			return Credentials{}, errors.New("s3 cas: access-key-id and secret-access-key must be set together")
+		}
+		return Credentials{
+			AccessKeyID:     p.AccessKeyID,
+			SecretAccessKey: p.SecretAccessKey,
+			SessionToken:    p.SessionToken,
+		}, nil
+	}
+	if p.CredentialsFile == "" && p.Profile == "" {
+		if creds, ok := CredentialsFromEnv(); ok {
+			return creds, nil
+		}
+	}
+	return CredentialsFromFile(p.CredentialsFile, p.Profile)
+}
+
+var _ provider.Provider = (*Provider)(nil)
@@ -0,0 +1,401 @@
+// Package tiered implements a content addressable storage that layers multiple backends.
+//
+// Tiers are ordered from the fastest (like memory) to the slowest (like a remote http CAS).
+// Reads go through the tiers in order and blobs found in a slower tier are promoted into
+// all faster tiers.
+// Writes go to the fastest tier and are copied to the slower tiers either immediately
+// (write-through) or in the background (write-back).
+package tiered
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/cas"
+)
+
+// Write modes.
+const (
+	// WriteThrough copies blobs to all tiers before Write returns.
+	WriteThrough = "write-through"
+	// WriteBack writes blobs to the fastest tier and copies them to the slower tiers in the background.
+	// Call Flush or Close to wait for pending copies.
+	// The fastest tier must not evict blobs (see cas.Evicter).
+	WriteBack = "write-back"
+)
+
+// ErrClosed is returned by writes after the CAS was closed.
+var ErrClosed = errors.New("tiered cas is closed")
+
+// writeBackQueueSize is the number of blobs that can wait to be copied to the slower tiers.
+// Writes block if the queue is full.
+const writeBackQueueSize = 1024
+
+// Tier is a backend of the tiered CAS.
+type Tier struct {
+	// Name identifies the tier in statistics.
+	Name string
+	CAS  api.CAS
+}
+
+// Options configure the tiered CAS.
+type Options struct {
+	// WriteMode is WriteThrough (default) or WriteBack.
+	WriteMode string
+	// DisablePromotion disables copying blobs found in slower tiers into faster tiers.
+	DisablePromotion bool
+}
+
+// TierStats are the statistics of a tier.
+type TierStats struct {
+	Name string
+	// Hits and Misses count lookups of blobs in the tier.
+	Hits, Misses uint64
+	// Promotions counts blobs copied into the tier from a slower tier.
+	Promotions uint64
+	// Writes counts blobs written to the tier.
+	Writes uint64
+	// Errors counts failed reads, writes and promotions.
+	Errors uint64
+}
+
+func (s TierStats) String() string {
+	return fmt.Sprintf("%s: %d hits, %d misses, %d promotions, %d writes, %d errors",
+		s.Name, s.Hits, s.Misses, s.Promotions, s.Writes, s.Errors)
+}
+
+// CAS is a tiered content addressable storage.
+type CAS struct {
+	tiers     []Tier
+	stats     []tierCounters
+	writeMode string
+	promote   bool
+
+	// queue holds the blobs that still need to be copied to the slower tiers (write-back only).
+	queue   chan string
+	pending sync.WaitGroup
+	done    chan struct{}
+	errMux  sync.Mutex
+	errs    []error
+	// closeMux guards closed. Writes hold it for reading, so the queue is not closed while they send.
+	closeMux sync.RWMutex
+	closed   bool
+}
+
+type tierCounters struct {
+	hits, misses, promotions, writes, errors atomic.Uint64
+}
+
+// New returns a CAS that layers the tiers, from the fastest to the slowest.
+func New(opts Options, tiers ...Tier) (*CAS, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("tiered cas needs at least one tier")
+	}
+	switch opts.WriteMode {
+	case "":
+		opts.WriteMode = WriteThrough
+	case WriteThrough, WriteBack:
+	default:
+		return nil, fmt.Errorf("unsupported write mode %q", opts.WriteMode)
+	}
+	// with write-back, the fastest tier holds the only copy of a blob until it is copied down
+	if opts.WriteMode == WriteBack && cas.Evicts(tiers[0].CAS) {
+		return nil, fmt.Errorf("write-back needs a fastest tier that does not evict blobs, but %s evicts", tiers[0].Name)
+	}
+	c := &CAS{
+		tiers:     tiers,
+		stats:     make([]tierCounters, len(tiers)),
+		writeMode: opts.WriteMode,
+		promote:   !opts.DisablePromotion,
+	}
+	if c.writeMode == WriteBack {
+		c.queue = make(chan string, writeBackQueueSize)
+		c.done = make(chan struct{})
+		go c.writeBack()
+	}
+	return c, nil
+}
+
+// Open opens the blob from the fastest tier that has it.
+// Tiers that fail with errors other than fs.ErrNotExist are skipped.
+func (c *CAS) Open(sri string) (io.ReadCloser, error) {
+	var firstErr error
+	for i, tier := range c.tiers {
+		blob, err := tier.CAS.Open(sri)
+		if errors.Is(err, fs.ErrNotExist) {
+			c.stats[i].misses.Add(1)
+			continue
+		}
+		if err != nil {
+			c.stats[i].errors.Add(1)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("tier %s: %w", tier.Name, err)
+			}
+			continue
+		}
+		c.stats[i].hits.Add(1)
+		if i == 0 || !c.promote {
+			return blob, nil
+		}
+		served := c.promoteBlob(sri, i, blob)
+		promoted, err := c.tiers[served].CAS.Open(sri)
+		if err != nil && served != i {
+			// the faster tier may have dropped the blob again
+			return tier.CAS.Open(sri)
+		}
+		return promoted, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return nil, fs.ErrNotExist
+}
+
+// promoteBlob copies the blob found in the tier hit into all faster tiers and closes it.
+// It returns the fastest tier that holds the blob.
+func (c *CAS) promoteBlob(sri string, hit int, blob io.ReadCloser) int {
+	served := hit
+	for i := hit - 1; i >= 0; i-- {
+		if i < hit-1 {
+			var err error
+			if blob, err = c.tiers[i+1].CAS.Open(sri); err != nil {
+				c.stats[i+1].errors.Add(1)
+				break
+			}
+		}
+		err := c.tiers[i].CAS.Write(sri, blob)
+		blob.Close()
+		if err != nil {
+			c.stats[i].errors.Add(1)
+			break
+		}
+		c.stats[i].promotions.Add(1)
+		served = i
+	}
+	return served
+}
+
+// Write writes the blob to the fastest tier.
+// Depending on the write mode, the blob is copied to the slower tiers
+// before Write returns or in the background.
+// It returns ErrClosed after Close.
+func (c *CAS) Write(sri string, r io.Reader) error {
+	c.closeMux.RLock()
+	defer c.closeMux.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if err := c.tiers[0].CAS.Write(sri, r); err != nil {
+		c.stats[0].errors.Add(1)
+		return fmt.Errorf("tier %s: %w", c.tiers[0].Name, err)
+	}
+	c.stats[0].writes.Add(1)
+	if len(c.tiers) == 1 {
+		return nil
+	}
+	if c.writeMode == WriteBack {
+		c.pending.Add(1)
+		c.queue <- sri
+		return nil
+	}
+	return c.copyDown(sri)
+}
+
+// copyDown copies the blob from the fastest tier to all slower tiers.
+// Every tier reads the blob from the tier before it.
+func (c *CAS) copyDown(sri string) error {
+	for i := 1; i < len(c.tiers); i++ {
+		blob, err := c.openFaster(sri, i)
+		if err != nil {
+			return err
+		}
+		err = c.tiers[i].CAS.Write(sri, blob)
+		blob.Close()
+		if err != nil {
+			c.stats[i].errors.Add(1)
+			return fmt.Errorf("tier %s: %w", c.tiers[i].Name, err)
+		}
+		c.stats[i].writes.Add(1)
+	}
+	return nil
+}
+
+// openFaster opens the blob from the nearest tier that is faster than the tier to.
+// Evicting tiers may have dropped the blob already, so it falls back to even faster tiers.
+func (c *CAS) openFaster(sri string, to int) (io.ReadCloser, error) {
+	for i := to - 1; i >= 0; i-- {
+		blob, err := c.tiers[i].CAS.Open(sri)
+		if errors.Is(err, fs.ErrNotExist) && cas.Evicts(c.tiers[i].CAS) {
+			continue
+		}
+		if err != nil {
+			c.stats[i].errors.Add(1)
+			return nil, fmt.Errorf("tier %s: %w", c.tiers[i].Name, err)
+		}
+		return blob, nil
+	}
+	return nil, fmt.Errorf("tier %s: blob was evicted by all faster tiers before it was copied: %w", c.tiers[to].Name, fs.ErrNotExist)
+}
+
+// writeBack copies queued blobs to the slower tiers until the queue is closed.
+func (c *CAS) writeBack() {
+	defer close(c.done)
+	for sri := range c.queue {
+		if err := c.copyDown(sri); err != nil {
+			c.errMux.Lock()
+			c.errs = append(c.errs, fmt.Errorf("writing back %s: %w", sri, err))
+			c.errMux.Unlock()
+		}
+		c.pending.Done()
+	}
+}
+
+// Flush waits until all blobs written so far are copied to the slower tiers.
+// It returns the errors of failed copies since the last flush.
+func (c *CAS) Flush() error {
+	if c.writeMode != WriteBack {
+		return nil
+	}
+	c.pending.Wait()
+	c.errMux.Lock()
+	defer c.errMux.Unlock()
+	err := errors.Join(c.errs...)
+	c.errs = nil
+	return err
+}
+
+// Close flushes pending writes and stops the background writer.
+// Writes after Close fail with ErrClosed.
+func (c *CAS) Close() error {
+	c.closeMux.Lock()
+	alreadyClosed := c.closed
+	c.closed = true
+	c.closeMux.Unlock()
+	if alreadyClosed || c.writeMode != WriteBack {
+		return nil
+	}
+	err := c.Flush()
+	close(c.queue)
+	<-c.done
+	return err
+}
+
+// Stat returns information about the blob from the fastest tier that has it.
+// Blobs are not promoted.
+func (c *CAS) Stat(sri string) (cas.BlobInfo, error) {
+	for _, tier := range c.tiers {
+		info, err := cas.Stat(tier.CAS, sri)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return cas.BlobInfo{}, fmt.Errorf("tier %s: %w", tier.Name, err)
+		}
+		return info, nil
+	}
+	return cas.BlobInfo{}, fs.ErrNotExist
+}
+
+// List returns the blobs of all tiers that support listing.
+// Blobs stored in multiple tiers are returned once, with the information of the fastest tier.
+func (c *CAS) List(prefix string) (cas.Iterator, error) {
+	var blobs []cas.BlobInfo
+	seen := make(map[string]struct{})
+	listed := false
+	for _, tier := range c.tiers {
+		lister, ok := tier.CAS.(cas.Lister)
+		if !ok {
+			continue
+		}
+		listed = true
+		iter, err := lister.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("tier %s: %w", tier.Name, err)
+		}
+		for {
+			info, err := iter.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("tier %s: %w", tier.Name, err)
+			}
+			if _, ok := seen[info.SRI]; ok {
+				continue
+			}
+			seen[info.SRI] = struct{}{}
+			blobs = append(blobs, info)
+		}
+	}
+	if !listed {
+		return nil, errors.New("no tier supports listing blobs")
+	}
+	return cas.NewSliceIterator(blobs), nil
+}
+
+// Delete deletes the blob from all tiers.
+// All tiers that have the blob must support deleting.
+func (c *CAS) Delete(sri string) error {
+	found := false
+	for _, tier := range c.tiers {
+		exists, err := cas.Exists(tier.CAS, sri)
+		if err != nil {
+			return fmt.Errorf("tier %s: %w", tier.Name, err)
+		}
+		if !exists {
+			continue
+		}
+		deleter, ok := tier.CAS.(cas.Deleter)
+		if !ok {
+			return fmt.Errorf("tier %s does not support deleting blobs", tier.Name)
+		}
+		if err := deleter.Delete(sri); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("tier %s: %w", tier.Name, err)
+		}
+		found = true
+	}
+	if !found {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// Evicts returns true if all tiers may drop blobs.
+// Blobs are written to all tiers, so they are kept as long as one tier does not evict.
+func (c *CAS) Evicts() bool {
+	for _, tier := range c.tiers {
+		if !cas.Evicts(tier.CAS) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns the statistics of all tiers, from the fastest to the slowest.
+func (c *CAS) Stats() []TierStats {
+	stats := make([]TierStats, len(c.tiers))
+	for i, tier := range c.tiers {
+		stats[i] = TierStats{
+			Name:       tier.Name,
+			Hits:       c.stats[i].hits.Load(),
+			Misses:     c.stats[i].misses.Load(),
+			Promotions: c.stats[i].promotions.Load(),
+			Writes:     c.stats[i].writes.Load(),
+			Errors:     c.stats[i].errors.Load(),
+		}
+	}
+	return stats
+}
+
+var (
+	_ api.CAS     = (*CAS)(nil)
+	_ cas.Stater  = (*CAS)(nil)
+	_ cas.Lister  = (*CAS)(nil)
+	_ cas.Deleter = (*CAS)(nil)
+	_ cas.Evicter = (*CAS)(nil)
+)
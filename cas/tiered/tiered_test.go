@@ -0,0 +1,173 @@
+package tiered_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/cas/tiered"
+)
+
+const blob = "hello world"
+
+func TestWriteModes(t *testing.T) {
+	for _, mode := range []string{tiered.WriteThrough, tiered.WriteBack} {
+		t.Run(mode, func(t *testing.T) {
+			fast, slow := memory.NewCAS(false), memory.NewCAS(false)
+			c := newTiered(t, tiered.Options{WriteMode: mode}, fast, slow)
+			sriString := sriOf(t, blob)
+			if err := c.Write(sriString, strings.NewReader(blob)); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			for name, tier := range map[string]api.CAS{"fast": fast, "slow": slow} {
+				if got := readBlob(t, tier, sriString); got != blob {
+					t.Errorf("%s tier has %q, want %q", name, got, blob)
+				}
+			}
+		})
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	testCases := map[string]struct {
+		disablePromotion bool
+		wantPromoted     bool
+	}{
+		"promoted":           {wantPromoted: true},
+		"promotion disabled": {disablePromotion: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			fast, middle, slow := memory.NewCAS(false), memory.NewCAS(false), memory.NewCAS(false)
+			sriString := sriOf(t, blob)
+			if err := slow.Write(sriString, strings.NewReader(blob)); err != nil {
+				t.Fatal(err)
+			}
+			c := newTiered(t, tiered.Options{DisablePromotion: tc.disablePromotion}, fast, middle, slow)
+			if got := readBlob(t, c, sriString); got != blob {
+				t.Fatalf("Open() = %q, want %q", got, blob)
+			}
+			for name, tier := range map[string]api.CAS{"fast": fast, "middle": middle} {
+				exists, err := cas.Exists(tier, sriString)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if exists != tc.wantPromoted {
+					t.Errorf("%s tier has blob: %v, want %v", name, exists, tc.wantPromoted)
+				}
+			}
+			stats := c.Stats()
+			if stats[2].Hits != 1 || stats[0].Misses != 1 {
+				t.Errorf("got stats %v, want a miss in the fastest and a hit in the slowest tier", stats)
+			}
+		})
+	}
+}
+
+func TestWriteThroughEvictedBlob(t *testing.T) {
+	// the middle tier drops blobs right away, so the slowest tier copies from the fastest
+	fast, slow := memory.NewCAS(false), memory.NewCAS(false)
+	c := newTiered(t, tiered.Options{}, fast, droppingCAS{}, slow)
+	sriString := sriOf(t, blob)
+	if err := c.Write(sriString, strings.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readBlob(t, slow, sriString); got != blob {
+		t.Errorf("slowest tier has %q, want %q", got, blob)
+	}
+
+	// no faster tier keeps the blob
+	c = newTiered(t, tiered.Options{}, droppingCAS{}, slow)
+	if err := c.Write(sriOf(t, "other"), strings.NewReader("other")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Write() returned %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestWriteBackNeedsNonEvictingFastestTier(t *testing.T) {
+	_, err := tiered.New(tiered.Options{WriteMode: tiered.WriteBack},
+		tiered.Tier{Name: "dropping", CAS: droppingCAS{}},
+		tiered.Tier{Name: "slow", CAS: memory.NewCAS(false)},
+	)
+	if err == nil {
+		t.Error("New() succeeded, want error")
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	for _, mode := range []string{tiered.WriteThrough, tiered.WriteBack} {
+		t.Run(mode, func(t *testing.T) {
+			c := newTiered(t, tiered.Options{WriteMode: mode}, memory.NewCAS(false), memory.NewCAS(false))
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Write(sriOf(t, blob), strings.NewReader(blob)); !errors.Is(err, tiered.ErrClosed) {
+				t.Errorf("Write() after Close() returned %v, want %v", err, tiered.ErrClosed)
+			}
+			if err := c.Close(); err != nil {
+				t.Errorf("second Close() returned %v", err)
+			}
+		})
+	}
+}
+
+// droppingCAS evicts every blob right after it was written.
+type droppingCAS struct{}
+
+func (droppingCAS) Open(string) (io.ReadCloser, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (droppingCAS) Write(_ string, r io.Reader) error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func (droppingCAS) Evicts() bool {
+	return true
+}
+
+func newTiered(t *testing.T, opts tiered.Options, backends ...api.CAS) *tiered.CAS {
+	t.Helper()
+	tiers := make([]tiered.Tier, len(backends))
+	for i, backend := range backends {
+		tiers[i] = tiered.Tier{Name: string(rune('a' + i)), CAS: backend}
+	}
+	c, err := tiered.New(opts, tiers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func sriOf(t *testing.T, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readBlob(t *testing.T, c api.CASReader, sriString string) string {
+	t.Helper()
+	r, err := c.Open(sriString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
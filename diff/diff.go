@@ -0,0 +1,207 @@
+// Package diff compares trees of the intermediate representation.
+//
+// Trees compares two trees node by node and reports the added, removed and changed nodes.
+// Layer turns the differences into the tree of an OCI layer, using whiteout nodes
+// and opaque directories for removed nodes.
+package diff
+
+import (
+	stdpath "path"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// Types of changes.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Fields of a node that are compared.
+// The names match the JSON representation of api.Stat.
+const (
+	FieldKind    = "kind"
+	FieldPayload = "payload"
+	FieldSize    = "size"
+	FieldMtime   = "mtime"
+	FieldUID     = "uid"
+	FieldGID     = "gid"
+	FieldUname   = "uname"
+	FieldGname   = "gname"
+	FieldMode    = "mode"
+	FieldXAttrs  = "xattrs"
+)
+
+// Fields lists all fields that are compared, in the order they are reported.
+var Fields = []string{
+	FieldKind, FieldPayload, FieldSize, FieldMode,
+	FieldUID, FieldGID, FieldUname, FieldGname,
+	FieldMtime, FieldXAttrs,
+}
+
+// Change is a difference between two trees.
+type Change struct {
+	// Type is Added, Removed or Changed.
+	Type string `json:"type"`
+	// Path is the absolute path of the node.
+	Path string `json:"path"`
+	// Old is the node in the old tree. It is nil for added nodes.
+	Old *api.Stat `json:"old,omitempty"`
+	// New is the node in the new tree. It is nil for removed nodes.
+	New *api.Stat `json:"new,omitempty"`
+	// Fields lists the fields that differ for changed nodes.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Options configure the comparison.
+type Options struct {
+	// Ignore lists fields that are not compared.
+	Ignore []string
+}
+
+// Trees compares two trees and returns the changes from the old to the new tree.
+// Changes are sorted by path (depth-first, parents before children).
+// Attributes that are missing in one of the trees (like the ownership of the implicit
+// root directory of a tar file or the xattrs of a source without xattr support)
+// are not reported as changed.
+// If a directory is added or removed, all of its children are reported as well.
+// If a directory is replaced by another kind (or the other way around),
+// the children are reported as removed (or added).
+func Trees(oldTree, newTree api.Tree, opts Options) []Change {
+	d := &differ{ignore: make(map[string]struct{}, len(opts.Ignore))}
+	for _, field := range opts.Ignore {
+		d.ignore[field] = struct{}{}
+	}
+	d.compare("/", oldTree.Root, newTree.Root)
+	return d.changes
+}
+
+type differ struct {
+	ignore  map[string]struct{}
+	changes []Change
+}
+
+func (d *differ) compare(path string, oldNode, newNode *api.Node) {
+	if fields := d.fields(oldNode.Stat, newNode.Stat); len(fields) > 0 {
+		d.changes = append(d.changes, Change{
+			Type:   Changed,
+			Path:   path,
+			Old:    statAt(path, oldNode),
+			New:    statAt(path, newNode),
+			Fields: fields,
+		})
+	}
+
+	// children are sorted by name
+	oldChildren, newChildren := children(oldNode), children(newNode)
+	i, j := 0, 0
+	for i < len(oldChildren) || j < len(newChildren) {
+		switch {
+		case j == len(newChildren) || (i < len(oldChildren) && oldChildren[i].Stat.Name < newChildren[j].Stat.Name):
+			d.subtree(Removed, stdpath.Join(path, oldChildren[i].Stat.Name), oldChildren[i])
+			i++
+		case i == len(oldChildren) || newChildren[j].Stat.Name < oldChildren[i].Stat.Name:
+			d.subtree(Added, stdpath.Join(path, newChildren[j].Stat.Name), newChildren[j])
+			j++
+		default:
+			d.compare(stdpath.Join(path, oldChildren[i].Stat.Name), oldChildren[i], newChildren[j])
+			i++
+			j++
+		}
+	}
+}
+
+// subtree reports a node and all of its children as added or removed.
+func (d *differ) subtree(changeType, path string, node *api.Node) {
+	change := Change{Type: changeType, Path: path}
+	if changeType == Added {
+		change.New = statAt(path, node)
+	} else {
+		change.Old = statAt(path, node)
+	}
+	d.changes = append(d.changes, change)
+	for _, child := range children(node) {
+		d.subtree(changeType, stdpath.Join(path, child.Stat.Name), child)
+	}
+}
+
+// fields returns the fields that differ between two nodes.
+func (d *differ) fields(oldStat, newStat api.Stat) []string {
+	var fields []string
+	for _, field := range Fields {
+		if _, ok := d.ignore[field]; ok {
+			continue
+		}
+		if !equalField(field, oldStat, newStat) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func equalField(field string, oldStat, newStat api.Stat) bool {
+	oldAttrs, newAttrs := oldStat.Attributes, newStat.Attributes
+	switch field {
+	case FieldKind:
+		return oldStat.Kind == newStat.Kind
+	case FieldPayload:
+		return oldStat.Payload == newStat.Payload
+	case FieldSize:
+		// only the size of regular files is meaningful,
+		// sources report different sizes for directories and symlinks
+		if oldStat.Kind != api.KindRegular || newStat.Kind != api.KindRegular {
+			return true
+		}
+		return oldStat.Size == newStat.Size
+	case FieldMtime:
+		// sources without timestamps (like the implicit root of a tar file) report the zero time
+		return oldAttrs.Mtime.IsZero() || newAttrs.Mtime.IsZero() || oldAttrs.Mtime.Equal(newAttrs.Mtime)
+	case FieldUID:
+		return equalAttr(oldAttrs.UserID, newAttrs.UserID)
+	case FieldGID:
+		return equalAttr(oldAttrs.GroupID, newAttrs.GroupID)
+	case FieldUname:
+		return equalAttr(oldAttrs.UserName, newAttrs.UserName)
+	case FieldGname:
+		return equalAttr(oldAttrs.GroupName, newAttrs.GroupName)
+	case FieldMode:
+		return equalAttr(oldAttrs.Mode, newAttrs.Mode)
+	case FieldXAttrs:
+		// sources without xattr support report no xattrs at all
+		if len(oldAttrs.XAttrs) == 0 || len(newAttrs.XAttrs) == 0 {
+			return true
+		}
+		if len(oldAttrs.XAttrs) != len(newAttrs.XAttrs) {
+			return false
+		}
+		for key, value := range oldAttrs.XAttrs {
+			if newValue, ok := newAttrs.XAttrs[key]; !ok || newValue != value {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// equalAttr compares a scalar attribute.
+// An attribute that is missing (empty) on either side is not a difference.
+func equalAttr(oldValue, newValue string) bool {
+	return oldValue == "" || newValue == "" || oldValue == newValue
+}
+
+// children returns the children of directories.
+func children(node *api.Node) []*api.Node {
+	if node.Stat.Kind != api.KindDirectory {
+		return nil
+	}
+	return node.Children
+}
+
+// statAt returns a copy of the stat of the node, named by its absolute path.
+func statAt(path string, node *api.Node) *api.Stat {
+	stat := node.Stat
+	stat.Name = path
+	return &stat
+}
@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+)
+
+var epoch = time.Unix(1700000000, 0).UTC()
+
+func TestTrees(t *testing.T) {
+	testCases := map[string]struct {
+		old, new []api.Stat
+		opts     Options
+		want     []change
+	}{
+		"equal": {
+			old: []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+			new: []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+		},
+		"added and removed subtrees": {
+			old: []api.Stat{dir("/old"), regular("/old/f", "sha256-f", "0o644")},
+			new: []api.Stat{dir("/new"), regular("/new/f", "sha256-f", "0o644")},
+			want: []change{
+				{Type: Added, Path: "/new"},
+				{Type: Added, Path: "/new/f"},
+				{Type: Removed, Path: "/old"},
+				{Type: Removed, Path: "/old/f"},
+			},
+		},
+		"changed contents and mode": {
+			old:  []api.Stat{regular("/f", "sha256-old", "0o644")},
+			new:  []api.Stat{regular("/f", "sha256-new", "0o600")},
+			want: []change{{Type: Changed, Path: "/f", Fields: []string{FieldPayload, FieldMode}}},
+		},
+		"ignored fields": {
+			old:  []api.Stat{regular("/f", "sha256-old", "0o644")},
+			new:  []api.Stat{regular("/f", "sha256-new", "0o600")},
+			opts: Options{Ignore: []string{FieldMode}},
+			want: []change{{Type: Changed, Path: "/f", Fields: []string{FieldPayload}}},
+		},
+		"attributes missing on one side": {
+			old: []api.Stat{regular("/f", "sha256-f", "0o644")},
+			new: []api.Stat{{Name: "/f", Kind: api.KindRegular, Payload: "sha256-f"}},
+		},
+		"xattrs missing on one side": {
+			old: []api.Stat{withXAttrs(regular("/f", "sha256-f", "0o644"), map[string]string{"user.a": "1"})},
+			new: []api.Stat{regular("/f", "sha256-f", "0o644")},
+		},
+		"changed xattr value": {
+			old:  []api.Stat{withXAttrs(regular("/f", "sha256-f", "0o644"), map[string]string{"user.a": "1"})},
+			new:  []api.Stat{withXAttrs(regular("/f", "sha256-f", "0o644"), map[string]string{"user.a": "2"})},
+			want: []change{{Type: Changed, Path: "/f", Fields: []string{FieldXAttrs}}},
+		},
+		"directory replaced by a file": {
+			old: []api.Stat{dir("/d"), regular("/d/f", "sha256-f", "0o644")},
+			new: []api.Stat{regular("/d", "sha256-d", "0o644")},
+			want: []change{
+				{Type: Changed, Path: "/d", Fields: []string{FieldKind, FieldPayload, FieldMode}},
+				{Type: Removed, Path: "/d/f"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			oldTree := coretree.Unflatten(api.Flat{Files: tc.old})
+			newTree := coretree.Unflatten(api.Flat{Files: tc.new})
+			got := summarize(Trees(oldTree, newTree, tc.opts))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Trees() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// change is the part of a Change that is compared by the tests.
+type change struct {
+	Type   string
+	Path   string
+	Fields []string
+}
+
+func summarize(changes []Change) []change {
+	var summary []change
+	for _, c := range changes {
+		summary = append(summary, change{Type: c.Type, Path: c.Path, Fields: c.Fields})
+	}
+	return summary
+}
+
+func dir(name string) api.Stat {
+	return api.Stat{Name: name, Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755", Mtime: epoch}}
+}
+
+func regular(name, payload, mode string) api.Stat {
+	return api.Stat{
+		Name:       name,
+		Kind:       api.KindRegular,
+		Payload:    payload,
+		Attributes: api.NodeAttributes{Mode: mode, Mtime: epoch, UserID: "0", GroupID: "0"},
+	}
+}
+
+func withXAttrs(stat api.Stat, xattrs map[string]string) api.Stat {
+	stat.Attributes.XAttrs = xattrs
+	return stat
+}
@@ -0,0 +1,119 @@
+package diff
+
+import (
+	stdpath "path"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/kind"
+)
+
+// Layer returns the tree of a minimal OCI layer that turns the old tree into the new tree
+// when it is applied on top of the old tree.
+// It contains all added and changed nodes of the new tree (and their parent directories,
+// so that their metadata is preserved), whiteout nodes (kind.Whiteout) for removed nodes
+// and opaque directories (see kind.IsOpaque) for directories whose children were all removed.
+// Hardlinks of the new tree that point to added or changed regular files are part of the layer,
+// so that they keep sharing the new contents.
+// File contents of the layer are the ones of the new tree.
+func Layer(oldTree, newTree api.Tree) api.Tree {
+	changes := Trees(oldTree, newTree, Options{})
+	l := &layerBuilder{
+		newTree: newTree,
+		layer:   api.Tree{Root: &api.Node{Stat: newTree.Root.Stat}},
+	}
+	l.layer.Root.Stat.Name = ""
+
+	// directories that replace (or are replaced by) another kind do not need
+	// whiteouts for their old children: the new node hides the old one entirely
+	hidden := make(map[string]struct{})
+	removedChildren := make(map[string]int)
+	for _, change := range changes {
+		switch {
+		case change.Type == Removed:
+			hidden[change.Path] = struct{}{}
+			removedChildren[stdpath.Dir(change.Path)]++
+		case change.Type == Changed && change.Old.Kind != change.New.Kind:
+			hidden[change.Path] = struct{}{}
+		}
+	}
+	opaque := make(map[string]struct{})
+	for dir, removed := range removedChildren {
+		if _, ok := hidden[dir]; ok {
+			continue
+		}
+		oldDir := coretree.Get(oldTree, dir)
+		// one whiteout is as small as an opaque marker
+		if removed >= 2 && oldDir != nil && len(children(oldDir)) == removed {
+			opaque[dir] = struct{}{}
+		}
+	}
+
+	links := hardlinks(newTree.Root, "/", make(map[string][]string))
+	for _, change := range changes {
+		switch change.Type {
+		case Added, Changed:
+			l.add(change.Path)
+			switch change.New.Kind {
+			case kind.Hardlink:
+				// the link target must be part of the layer
+				l.add(change.New.Payload)
+			case api.KindRegular:
+				// links to the old file would keep the old contents
+				for _, link := range links[change.Path] {
+					l.add(link)
+				}
+			}
+		case Removed:
+			parent := stdpath.Dir(change.Path)
+			if _, ok := hidden[parent]; ok {
+				continue
+			}
+			if _, ok := opaque[parent]; ok {
+				continue
+			}
+			l.add(parent)
+			coretree.Insert(l.layer, parent, api.Stat{Name: stdpath.Base(change.Path), Kind: kind.Whiteout})
+		}
+	}
+	for dir := range opaque {
+		l.add(dir)
+		coretree.Get(l.layer, dir).Stat.Payload = kind.OpaquePayload
+	}
+	return l.layer
+}
+
+type layerBuilder struct {
+	newTree api.Tree
+	layer   api.Tree
+}
+
+// add adds the node at the given path of the new tree and all of its parents to the layer.
+func (l *layerBuilder) add(path string) {
+	path = stdpath.Clean("/" + path)
+	if path == "/" || coretree.Get(l.layer, path) != nil {
+		return
+	}
+	node := coretree.Get(l.newTree, path)
+	if node == nil {
+		return
+	}
+	parent := stdpath.Dir(path)
+	l.add(parent)
+	stat := node.Stat
+	stat.Name = stdpath.Base(path)
+	coretree.Insert(l.layer, parent, stat)
+}
+
+// hardlinks collects the paths of the hardlinks below the node by the path of their target.
+func hardlinks(node *api.Node, path string, links map[string][]string) map[string][]string {
+	for _, child := range children(node) {
+		childPath := stdpath.Join(path, child.Stat.Name)
+		if child.Stat.Kind == kind.Hardlink {
+			target := stdpath.Clean("/" + child.Stat.Payload)
+			links[target] = append(links[target], childPath)
+		}
+		hardlinks(child, childPath, links)
+	}
+	return links
+}
@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/kind"
+)
+
+func TestLayer(t *testing.T) {
+	testCases := map[string]struct {
+		old, new []api.Stat
+		// want lists the nodes of the layer as "<path> <kind>", opaque directories as "<path> opaque".
+		want []string
+	}{
+		"unchanged": {
+			old: []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+			new: []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+		},
+		"added file keeps its parents": {
+			old:  []api.Stat{dir("/a"), dir("/a/b")},
+			new:  []api.Stat{dir("/a"), dir("/a/b"), regular("/a/b/f", "sha256-f", "0o644")},
+			want: []string{"/a directory", "/a/b directory", "/a/b/f regular"},
+		},
+		"changed file": {
+			old:  []api.Stat{dir("/a"), regular("/a/f", "sha256-old", "0o644"), regular("/a/g", "sha256-g", "0o644")},
+			new:  []api.Stat{dir("/a"), regular("/a/f", "sha256-new", "0o644"), regular("/a/g", "sha256-g", "0o644")},
+			want: []string{"/a directory", "/a/f regular"},
+		},
+		"removed file": {
+			old:  []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644"), regular("/a/g", "sha256-g", "0o644")},
+			new:  []api.Stat{dir("/a"), regular("/a/g", "sha256-g", "0o644")},
+			want: []string{"/a directory", "/a/f whiteout"},
+		},
+		"all children removed": {
+			old:  []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644"), regular("/a/g", "sha256-g", "0o644")},
+			new:  []api.Stat{dir("/a")},
+			want: []string{"/a opaque"},
+		},
+		"only child removed": {
+			old:  []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+			new:  []api.Stat{dir("/a")},
+			want: []string{"/a directory", "/a/f whiteout"},
+		},
+		"removed directory": {
+			old:  []api.Stat{dir("/a"), dir("/a/d"), regular("/a/d/f", "sha256-f", "0o644"), regular("/a/g", "sha256-g", "0o644")},
+			new:  []api.Stat{dir("/a"), regular("/a/g", "sha256-g", "0o644")},
+			want: []string{"/a directory", "/a/d whiteout"},
+		},
+		"directory replaced by a file": {
+			old:  []api.Stat{dir("/d"), regular("/d/f", "sha256-f", "0o644"), regular("/d/g", "sha256-g", "0o644")},
+			new:  []api.Stat{regular("/d", "sha256-d", "0o644")},
+			want: []string{"/d regular"},
+		},
+		"hardlink to a changed file": {
+			old: []api.Stat{regular("/f", "sha256-old", "0o644"), hardlink("/l", "/f")},
+			new: []api.Stat{regular("/f", "sha256-new", "0o644"), hardlink("/l", "/f")},
+			// the unchanged link would keep the old contents
+			want: []string{"/f regular", "/l hardlink"},
+		},
+		"added hardlink": {
+			old:  []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644")},
+			new:  []api.Stat{dir("/a"), regular("/a/f", "sha256-f", "0o644"), hardlink("/l", "/a/f")},
+			want: []string{"/a directory", "/a/f regular", "/l hardlink"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			oldTree := coretree.Unflatten(api.Flat{Files: tc.old})
+			newTree := coretree.Unflatten(api.Flat{Files: tc.new})
+			got := describeLayer(Layer(oldTree, newTree))
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Layer() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLayerContents(t *testing.T) {
+	oldTree := coretree.Unflatten(api.Flat{Files: []api.Stat{regular("/f", "sha256-old", "0o644")}})
+	newTree := coretree.Unflatten(api.Flat{Files: []api.Stat{regular("/f", "sha256-new", "0o600")}})
+	node := coretree.Get(Layer(oldTree, newTree), "/f")
+	if node == nil {
+		t.Fatal("/f is missing from the layer")
+	}
+	if node.Stat.Payload != "sha256-new" || node.Stat.Attributes.Mode != "0o600" {
+		t.Errorf("got %s with mode %s, want the new payload and mode", node.Stat.Payload, node.Stat.Attributes.Mode)
+	}
+}
+
+// describeLayer returns the sorted nodes of the layer without the root.
+func describeLayer(layer api.Tree) []string {
+	var nodes []string
+	for _, stat := range coretree.Flatten(layer).Files {
+		if stat.Name == "/" {
+			continue
+		}
+		description := stat.Name + " " + stat.Kind
+		if kind.IsOpaque(stat) {
+			description = stat.Name + " opaque"
+		}
+		nodes = append(nodes, description)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func hardlink(name, target string) api.Stat {
+	return api.Stat{Name: name, Kind: kind.Hardlink, Payload: target}
+}
@@ -0,0 +1,228 @@
+// Package filter selects subsets of a file system by path.
+//
+// Patterns follow the rules of .gitignore and .dockerignore files:
+//
+//   - "*", "?" and "[...]" match within a path segment (see path.Match)
+//   - "**" matches any number of path segments
+//   - patterns without a slash (like "*.pyc") match at any depth,
+//     all other patterns are relative to the root of the file system
+//   - a trailing slash only matches directories
+//   - a leading "!" negates the pattern, the last matching pattern wins
+//
+// Excluding a directory excludes everything below it.
+// If include patterns are given, only nodes that match an include pattern
+// (or are below an included directory) and their parent directories are kept.
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	stdpath "path"
+	"strings"
+)
+
+// Filter decides which nodes of a file system are kept.
+type Filter struct {
+	includes []pattern
+	excludes []pattern
+}
+
+// New returns a filter with the given include and exclude patterns.
+// Without include patterns, all nodes that are not excluded are kept.
+func New(includes, excludes []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.includes, err = parsePatterns(includes); err != nil {
+		return nil, err
+	}
+	if f.excludes, err = parsePatterns(excludes); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Keep returns true if the node at the given absolute path is kept.
+// The root directory is always kept.
+func (f *Filter) Keep(path string, isDir bool) bool {
+	segments := split(path)
+	if len(segments) == 0 {
+		return true
+	}
+	if f.excluded(segments, isDir) {
+		return false
+	}
+	return len(f.includes) == 0 || f.included(segments, isDir)
+}
+
+// Prune returns true if no node below the directory at the given absolute path can be kept.
+// Readers can skip pruned directories entirely.
+func (f *Filter) Prune(dir string) bool {
+	segments := split(dir)
+	if len(segments) == 0 {
+		return false
+	}
+	if f.excluded(segments, true) {
+		return true
+	}
+	if len(f.includes) == 0 || f.included(segments, true) {
+		return false
+	}
+	for _, include := range f.includes {
+		if !include.negate && include.matchBelow(segments) {
+			return false
+		}
+	}
+	return true
+}
+
+// excluded returns true if the node or one of its parent directories is excluded.
+func (f *Filter) excluded(segments []string, isDir bool) bool {
+	for i := 1; i < len(segments); i++ {
+		if matchLast(f.excludes, segments[:i], true) {
+			return true
+		}
+	}
+	return matchLast(f.excludes, segments, isDir)
+}
+
+// included returns true if the node or one of its parent directories is included.
+func (f *Filter) included(segments []string, isDir bool) bool {
+	for i := 1; i < len(segments); i++ {
+		if matchLast(f.includes, segments[:i], true) {
+			return true
+		}
+	}
+	return matchLast(f.includes, segments, isDir)
+}
+
+// ReadPatterns reads patterns from an ignore file (one pattern per line).
+// Empty lines and lines starting with "#" are skipped.
+func ReadPatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+type pattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+func parsePatterns(raw []string) ([]pattern, error) {
+	patterns := make([]pattern, 0, len(raw))
+	for _, r := range raw {
+		p, err := parsePattern(r)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func parsePattern(raw string) (pattern, error) {
+	var p pattern
+	value := raw
+	if strings.HasPrefix(value, "!") {
+		p.negate = true
+		value = value[1:]
+	}
+	if strings.HasSuffix(value, "/") {
+		p.dirOnly = true
+		value = strings.TrimRight(value, "/")
+	}
+	anchored := strings.Contains(value, "/")
+	value = strings.Trim(stdpath.Clean("/"+value), "/")
+	if value == "" {
+		return pattern{}, fmt.Errorf("invalid pattern %q", raw)
+	}
+	p.segments = strings.Split(value, "/")
+	if !anchored {
+		p.segments = append([]string{"**"}, p.segments...)
+	}
+	for _, segment := range p.segments {
+		if _, err := stdpath.Match(segment, ""); err != nil {
+			return pattern{}, fmt.Errorf("invalid pattern %q: %w", raw, err)
+		}
+	}
+	return p, nil
+}
+
+// match returns true if the pattern matches the path.
+func (p pattern) match(segments []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(p.segments, segments)
+}
+
+// matchBelow returns true if the pattern may match a path below the directory.
+func (p pattern) matchBelow(dir []string) bool {
+	patternSegments := p.segments
+	for _, segment := range dir {
+		if len(patternSegments) == 0 {
+			return false
+		}
+		if patternSegments[0] == "**" {
+			return true
+		}
+		if ok, _ := stdpath.Match(patternSegments[0], segment); !ok {
+			return false
+		}
+		patternSegments = patternSegments[1:]
+	}
+	return len(patternSegments) > 0
+}
+
+func matchSegments(patternSegments, segments []string) bool {
+	if len(patternSegments) == 0 {
+		return len(segments) == 0
+	}
+	if patternSegments[0] == "**" {
+		// a trailing "**" matches everything inside, but not the directory itself
+		if len(patternSegments) == 1 {
+			return len(segments) > 0
+		}
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patternSegments[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := stdpath.Match(patternSegments[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(patternSegments[1:], segments[1:])
+}
+
+// matchLast returns true if the last pattern that matches the path is not negated.
+func matchLast(patterns []pattern, segments []string, isDir bool) bool {
+	matched := false
+	for _, p := range patterns {
+		if p.match(segments, isDir) {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+func split(path string) []string {
+	path = strings.Trim(stdpath.Clean("/"+path), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,106 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeep(t *testing.T) {
+	testCases := map[string]struct {
+		includes, excludes []string
+		path               string
+		isDir              bool
+		want               bool
+	}{
+		"no patterns":                       {path: "/a/b", want: true},
+		"root is always kept":               {excludes: []string{"**"}, path: "/", isDir: true, want: true},
+		"unanchored matches at any depth":   {excludes: []string{"*.pyc"}, path: "/a/b/c.pyc", want: false},
+		"unanchored does not match others":  {excludes: []string{"*.pyc"}, path: "/a/b/c.py", want: true},
+		"anchored matches at the root":      {excludes: []string{"/build"}, path: "/build", isDir: true, want: false},
+		"anchored does not match below":     {excludes: []string{"/build"}, path: "/src/build", isDir: true, want: true},
+		"pattern with slash is anchored":    {excludes: []string{"a/b"}, path: "/x/a/b", want: true},
+		"excluded parent excludes children": {excludes: []string{"/build"}, path: "/build/out/a.o", want: false},
+		"dir pattern matches dirs":          {excludes: []string{"cache/"}, path: "/a/cache", isDir: true, want: false},
+		"dir pattern skips files":           {excludes: []string{"cache/"}, path: "/a/cache", want: true},
+		"dir pattern excludes children":     {excludes: []string{"cache/"}, path: "/a/cache/f", want: false},
+		"double star in the middle":         {excludes: []string{"/a/**/z"}, path: "/a/b/c/z", want: false},
+		"double star matches no segment":    {excludes: []string{"/a/**/z"}, path: "/a/z", want: false},
+		"trailing double star":              {excludes: []string{"/a/**"}, path: "/a/b", want: false},
+		"trailing double star keeps dir":    {excludes: []string{"/a/**"}, path: "/a", isDir: true, want: true},
+		"negation":                          {excludes: []string{"*.log", "!keep.log"}, path: "/keep.log", want: true},
+		"last match wins":                   {excludes: []string{"!keep.log", "*.log"}, path: "/keep.log", want: false},
+		"negation below excluded dir":       {excludes: []string{"/build", "!/build/keep"}, path: "/build/keep", want: false},
+		"include":                           {includes: []string{"/etc"}, path: "/etc/hosts", want: true},
+		"not included":                      {includes: []string{"/etc"}, path: "/usr/bin", want: false},
+		"parent of an include":              {includes: []string{"/etc/ssl"}, path: "/etc", isDir: true, want: false},
+		"exclude inside include":            {includes: []string{"/etc"}, excludes: []string{"*.bak"}, path: "/etc/hosts.bak", want: false},
+		"path is cleaned":                   {excludes: []string{"/build"}, path: "build/../build/", isDir: true, want: false},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			f, err := New(tc.includes, tc.excludes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Keep(tc.path, tc.isDir); got != tc.want {
+				t.Errorf("Keep(%q, %v) = %v, want %v", tc.path, tc.isDir, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	testCases := map[string]struct {
+		includes, excludes []string
+		dir                string
+		want               bool
+	}{
+		"no patterns":                       {dir: "/a"},
+		"root is never pruned":              {excludes: []string{"**"}, dir: "/"},
+		"excluded":                          {excludes: []string{"/build"}, dir: "/build", want: true},
+		"below excluded":                    {excludes: []string{"/build"}, dir: "/build/out", want: true},
+		"excluded children only":            {excludes: []string{"/build/*"}, dir: "/build"},
+		"included":                          {includes: []string{"/etc"}, dir: "/etc"},
+		"parent of an include":              {includes: []string{"/etc/ssl/certs"}, dir: "/etc/ssl"},
+		"unrelated to includes":             {includes: []string{"/etc/ssl"}, dir: "/usr", want: true},
+		"unanchored include":                {includes: []string{"*.conf"}, dir: "/usr/share"},
+		"wildcard include":                  {includes: []string{"/home/*/.ssh"}, dir: "/home/user"},
+		"wildcard include mismatch":         {includes: []string{"/home/*/.ssh"}, dir: "/root/user", want: true},
+		"include is the dir itself":         {includes: []string{"/etc/ssl"}, dir: "/etc/ssl/certs"},
+		"negated includes do not keep dirs": {includes: []string{"/etc", "!/usr/lib"}, dir: "/usr", want: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			f, err := New(tc.includes, tc.excludes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Prune(tc.dir); got != tc.want {
+				t.Errorf("Prune(%q) = %v, want %v", tc.dir, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"/", "!", "[", "a/[b"} {
+		if _, err := New(nil, []string{pattern}); err == nil {
+			t.Errorf("New() succeeded with exclude pattern %q", pattern)
+		}
+		if _, err := New([]string{pattern}, nil); err == nil {
+			t.Errorf("New() succeeded with include pattern %q", pattern)
+		}
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	const ignoreFile = "# build output\n/build\n\n  *.pyc  \n!keep.pyc\n"
+	patterns, err := ReadPatterns(strings.NewReader(ignoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/build", "*.pyc", "!keep.pyc"}; !reflect.DeepEqual(patterns, want) {
+		t.Errorf("ReadPatterns() = %q, want %q", patterns, want)
+	}
+}
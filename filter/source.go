@@ -0,0 +1,145 @@
+package filter
+
+import (
+	stdpath "path"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// Builder is implemented by source builders that apply a filter while reading.
+// Such sources skip pruned directories instead of reading them.
+// The source returned by Wrap is still needed to drop parent directories without kept nodes.
+type Builder interface {
+	SetFilter(f *Filter)
+}
+
+// Source filters the nodes of another source.
+type Source struct {
+	source api.Source
+	filter *Filter
+	// queue holds nodes that are returned before reading the next node of the source.
+	queue []api.SourceNode
+	// pending holds directories that are only kept if a node below them is kept.
+	pending map[string]api.SourceNode
+	// parents holds the directories that have kept nodes below them.
+	parents map[string]struct{}
+	// dropped holds regular files that were dropped.
+	// Hardlinks to them are turned into regular files.
+	dropped map[string]api.SourceNode
+	// relinked maps dropped hardlink targets to the path of the node that replaces them.
+	relinked map[string]string
+}
+
+// Wrap returns a source that only returns the nodes of the source that are kept by the filter.
+// If the source is an api.CASReader, so is the returned source.
+func Wrap(source api.Source, f *Filter) api.Source {
+	s := &Source{
+		source:   source,
+		filter:   f,
+		pending:  make(map[string]api.SourceNode),
+		parents:  make(map[string]struct{}),
+		dropped:  make(map[string]api.SourceNode),
+		relinked: make(map[string]string),
+	}
+	if casReader, ok := source.(api.CASReader); ok {
+		return &casSource{Source: s, CASReader: casReader}
+	}
+	return s
+}
+
+// Next returns the next kept node.
+func (s *Source) Next() (api.SourceNode, error) {
+	for len(s.queue) == 0 {
+		node, err := s.source.Next()
+		// directories that are still pending at the end have no kept nodes below them
+		if err != nil {
+			return api.SourceNode{}, err
+		}
+		s.filterNode(node)
+	}
+	node := s.queue[0]
+	s.queue = s.queue[1:]
+	return node, nil
+}
+
+func (s *Source) filterNode(node api.SourceNode) {
+	path := stdpath.Clean("/" + node.Stat.Name)
+	isDir := node.Stat.Kind == api.KindDirectory
+	if s.filter.Keep(path, isDir) {
+		s.keep(path, s.resolveHardlink(node))
+		return
+	}
+	switch {
+	case isDir && !s.filter.Prune(path):
+		if _, ok := s.parents[path]; ok {
+			s.queue = append(s.queue, node)
+			return
+		}
+		s.pending[path] = node
+	case node.Stat.Kind == api.KindRegular:
+		s.dropped[path] = node
+	}
+}
+
+// keep queues the node after all pending parent directories.
+func (s *Source) keep(path string, node api.SourceNode) {
+	var parents []string
+	for dir := stdpath.Dir(path); dir != "/"; dir = stdpath.Dir(dir) {
+		if _, ok := s.parents[dir]; ok {
+			break
+		}
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		s.parents[parents[i]] = struct{}{}
+		if parent, ok := s.pending[parents[i]]; ok {
+			s.queue = append(s.queue, parent)
+			delete(s.pending, parents[i])
+		}
+	}
+	s.queue = append(s.queue, node)
+}
+
+// resolveHardlink turns a hardlink to a dropped file into a regular file.
+// Later hardlinks to the same file link to the first one.
+func (s *Source) resolveHardlink(node api.SourceNode) api.SourceNode {
+	if node.Stat.Kind != kind.Hardlink {
+		return node
+	}
+	target := stdpath.Clean("/" + node.Stat.Payload)
+	if relinked, ok := s.relinked[target]; ok {
+		node.Stat.Payload = relinked
+		return node
+	}
+	dropped, ok := s.dropped[target]
+	if !ok {
+		return node
+	}
+	s.relinked[target] = stdpath.Clean("/" + node.Stat.Name)
+	node.Stat.Kind = api.KindRegular
+	node.Stat.Payload = dropped.Stat.Payload
+	node.Stat.Size = dropped.Stat.Size
+	// hardlinks share the attributes of the inode
+	node.Stat.Attributes = dropped.Stat.Attributes
+	node.Open = dropped.Open
+	return node
+}
+
+type casSource struct {
+	*Source
+	api.CASReader
+}
+
+// Holes returns the holes of the blob if the wrapped source knows them.
+func (c *casSource) Holes(sri string) sparse.HoleMap {
+	return sparse.HolesOf(c.CASReader, sri)
+}
+
+var (
+	_ api.Source        = (*Source)(nil)
+	_ api.Source        = (*casSource)(nil)
+	_ api.CASReader     = (*casSource)(nil)
+	_ sparse.HoleReader = (*casSource)(nil)
+)
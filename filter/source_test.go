@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/kind"
+)
+
+func TestWrap(t *testing.T) {
+	nodes := []api.Stat{
+		{Name: "/", Kind: api.KindDirectory},
+		{Name: "/etc", Kind: api.KindDirectory},
+		{Name: "/etc/ssl", Kind: api.KindDirectory},
+		{Name: "/etc/ssl/cert.pem", Kind: api.KindRegular, Payload: "sha256-cert", Attributes: api.NodeAttributes{Mode: "0o600"}},
+		{Name: "/etc/ssl/cert.bak", Kind: api.KindRegular, Payload: "sha256-bak"},
+		{Name: "/etc/empty", Kind: api.KindDirectory},
+		{Name: "/usr", Kind: api.KindDirectory},
+		{Name: "/usr/ca.pem", Kind: kind.Hardlink, Payload: "/etc/ssl/cert.bak"},
+		{Name: "/usr/ca2.pem", Kind: kind.Hardlink, Payload: "/etc/ssl/cert.bak"},
+		{Name: "/usr/link.pem", Kind: kind.Hardlink, Payload: "/etc/ssl/cert.pem"},
+		{Name: "/var", Kind: api.KindDirectory},
+		{Name: "/var/log", Kind: api.KindDirectory},
+	}
+	testCases := map[string]struct {
+		includes, excludes []string
+		want               []string
+	}{
+		"no patterns": {
+			want: []string{
+				"/ directory", "/etc directory", "/etc/ssl directory", "/etc/ssl/cert.pem regular sha256-cert", "/etc/ssl/cert.bak regular sha256-bak",
+				"/etc/empty directory", "/usr directory", "/usr/ca.pem hardlink /etc/ssl/cert.bak", "/usr/ca2.pem hardlink /etc/ssl/cert.bak",
+				"/usr/link.pem hardlink /etc/ssl/cert.pem", "/var directory", "/var/log directory",
+			},
+		},
+		"parents of included nodes are kept": {
+			includes: []string{"*.pem"},
+			want: []string{
+				"/ directory", "/etc directory", "/etc/ssl directory", "/etc/ssl/cert.pem regular sha256-cert",
+				// the first hardlink to a dropped file replaces it, later ones link to it
+				"/usr directory", "/usr/ca.pem regular sha256-bak", "/usr/ca2.pem hardlink /usr/ca.pem",
+				"/usr/link.pem hardlink /etc/ssl/cert.pem",
+			},
+		},
+		"excluded dirs drop their children": {
+			excludes: []string{"/etc"},
+			want: []string{
+				"/ directory", "/usr directory", "/usr/ca.pem regular sha256-bak", "/usr/ca2.pem hardlink /usr/ca.pem",
+				"/usr/link.pem regular sha256-cert", "/var directory", "/var/log directory",
+			},
+		},
+		"included dirs keep their children": {
+			includes: []string{"/var"},
+			want:     []string{"/ directory", "/var directory", "/var/log directory"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			f, err := New(tc.includes, tc.excludes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := Wrap(&sliceSource{nodes: nodes}, f)
+			var got []string
+			for {
+				node, err := source.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, strings.TrimSpace(node.Stat.Name+" "+node.Stat.Kind+" "+node.Stat.Payload))
+				if node.Stat.Name == "/usr/ca.pem" && node.Stat.Kind == api.KindRegular && node.Stat.Size != 3 {
+					t.Errorf("replaced hardlink has size %d, want the size of its target", node.Stat.Size)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got nodes\n%q\nwant\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWrapCASReader(t *testing.T) {
+	f, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Wrap(&sliceSource{}, f).(api.CASReader); ok {
+		t.Error("wrapped source without CAS is a CASReader")
+	}
+	if _, ok := Wrap(&casSliceSource{}, f).(api.CASReader); !ok {
+		t.Error("wrapped source with CAS is no CASReader")
+	}
+}
+
+// sliceSource returns the stats in order.
+type sliceSource struct {
+	nodes []api.Stat
+}
+
+func (s *sliceSource) Next() (api.SourceNode, error) {
+	if len(s.nodes) == 0 {
+		return api.SourceNode{}, io.EOF
+	}
+	stat := s.nodes[0]
+	if stat.Kind == api.KindRegular {
+		stat.Size = int64(len(strings.TrimPrefix(stat.Payload, "sha256-")))
+	}
+	s.nodes = s.nodes[1:]
+	return api.SourceNode{Stat: stat}, nil
+}
+
+type casSliceSource struct {
+	sliceSource
+}
+
+func (s *casSliceSource) Open(string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
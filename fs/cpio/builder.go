@@ -0,0 +1,201 @@
+package cpio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs-core/sri"
+)
+
+type SourceBuilder struct {
+	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
+	// AliasAlgorithms are the algorithms that file contents are additionally hashed with,
+	// so the CAS of the source can open them by their sris in these algorithms, too.
+	AliasAlgorithms []sri.Algorithm `abstractfs:"cas-aliases"`
+	Path            string
+	IOReader        io.Reader
+	invalidOptions  []string
+}
+
+// WithSourceRef sets the source reference.
+// For the cpio provider, the source reference is the path to the cpio file.
+func (b *SourceBuilder) WithSourceRef(ref string) provider.SourceBuilder {
+	b.Path = ref
+	return b
+}
+
+func (b *SourceBuilder) WithSRIAlgorithm(alg sri.Algorithm) *SourceBuilder {
+	b.SRIAlgorithm = alg
+	return b
+}
+
+// WithAliasAlgorithms sets the algorithms that file contents are additionally hashed with.
+func (b *SourceBuilder) WithAliasAlgorithms(algorithms ...sri.Algorithm) *SourceBuilder {
+	b.AliasAlgorithms = algorithms
+	return b
+}
+
+func (b *SourceBuilder) WithIOReader(r io.Reader) *SourceBuilder {
+	b.IOReader = r
+	return b
+}
+
+// Build builds the options.
+func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	var fileCloser func() error
+	if b.IOReader == nil {
+		file, err := os.Open(b.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileCloser = file.Close
+		b.IOReader = file
+	}
+	source := &Source{
+		casStore:     NewCAS(b.IOReader, b.AliasAlgorithms...),
+		reader:       NewReader(b.IOReader),
+		sriAlgorithm: b.SRIAlgorithm,
+		links:        make(map[linkKey]string),
+		pending:      make(map[linkKey][]api.SourceNode),
+	}
+	return source, func() error {
+		if fileCloser != nil {
+			return fileCloser()
+		}
+		return nil
+	}, nil
+}
+
+func (b *SourceBuilder) applyDefaults() {
+	if b.SRIAlgorithm == "" {
+		b.SRIAlgorithm = sri.SHA256
+	}
+}
+
+func (b *SourceBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path != "" && b.IOReader != nil {
+		return errors.New("cannot set both path and io.Reader")
+	}
+	if b.Path == "" && b.IOReader == nil {
+		return errors.New("must set either path or io.Reader")
+	}
+	return nil
+}
+
+type SinkBuilder struct {
+	// Root is the root directory of the cpio archive.
+	// Common values are "" (skip over root directory) or "." (write a root directory).
+	Root string `abstractfs:"root"`
+	// SkipUnsupported skips nodes that cpio cannot represent (whiteouts).
+	// By default, such nodes are an error (see kind.ErrUnsupported).
+	SkipUnsupported bool `abstractfs:"skip-unsupported"`
+	// Path is the path to write the cpio archive to.
+	// If Path is set, the archive is written to the file.
+	// Otherwise, the archive is written to the io.Writer.
+	Path string
+	// IOWriter is the io.Writer to write the cpio archive to.
+	// If IOWriter is set, the archive is written to the io.Writer.
+	// Otherwise, the archive is written to the file specified by Path.
+	IOWriter       io.Writer
+	invalidOptions []string
+}
+
+// WithSinkRef sets the sink reference.
+// For the cpio provider, the sink reference is the path to the cpio file.
+func (b *SinkBuilder) WithSinkRef(ref string) provider.SinkBuilder {
+	b.Path = ref
+	return b
+}
+
+// Set sets a option.
+func (b *SinkBuilder) Set(key string, value any) provider.SinkBuilder {
+	switch key {
+	case "root":
+		root, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Root = root
+	case "skip-unsupported":
+		skipUnsupported, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.SkipUnsupported = skipUnsupported
+	default:
+		b.invalidOptions = append(b.invalidOptions, key)
+	}
+	return b
+}
+
+func (b *SinkBuilder) WithRoot(root string) *SinkBuilder {
+	b.Root = root
+	return b
+}
+
+// WithSkipUnsupported skips nodes that cpio cannot represent instead of failing.
+func (b *SinkBuilder) WithSkipUnsupported(skipUnsupported bool) *SinkBuilder {
+	b.SkipUnsupported = skipUnsupported
+	return b
+}
+
+func (b *SinkBuilder) WithIOWriter(w io.Writer) *SinkBuilder {
+	b.IOWriter = w
+	return b
+}
+
+// Build builds the options.
+func (b *SinkBuilder) Build() (api.Sink, api.CloseWaitFunc, error) {
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	var fileCloser func() error
+	if b.IOWriter == nil {
+		file, err := os.Create(b.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileCloser = file.Close
+		b.IOWriter = file
+	}
+	sink := &Sink{
+		writer:          NewWriter(b.IOWriter),
+		root:            b.Root,
+		skipUnsupported: b.SkipUnsupported,
+		links:           make(map[string]*linkGroup),
+	}
+	return sink, func() error {
+		closeErr := sink.writer.Close()
+		if fileCloser != nil {
+			return errors.Join(closeErr, fileCloser())
+		}
+		return closeErr
+	}, nil
+}
+
+func (b *SinkBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path != "" && b.IOWriter != nil {
+		return errors.New("cannot set both path and io.Writer")
+	}
+	if b.Path == "" && b.IOWriter == nil {
+		return errors.New("must set either path or io.Writer")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package cpio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+// NewCAS returns a cas store for the given archive reader.
+// If the reader supports random access, file contents are served directly from the archive.
+// Otherwise, file contents are copied into an in-memory CAS while reading.
+// File contents are additionally hashed with the alias algorithms,
+// so they can be opened by their sris in these algorithms, too.
+func NewCAS(r io.Reader, aliasAlgorithms ...sri.Algorithm) casStore {
+	readerAt, ok := r.(io.ReaderAt)
+	if !ok || !seekable(r) {
+		return &fallbackCASStore{cas: memory.NewCAS(false).WithAliases(aliasAlgorithms...)}
+	}
+	return &CASSectionStore{
+		reader:          readerAt,
+		aliasAlgorithms: aliasAlgorithms,
+		inner:           make(map[string]struct{ offset, size int64 }),
+	}
+}
+
+// CASSectionStore is a store for cpio sections.
+// It records the offset and size of file data while reading the cpio archive.
+// Later, the sections can be opened by their sri.
+type CASSectionStore struct {
+	reader          io.ReaderAt
+	aliasAlgorithms []sri.Algorithm
+	mux             sync.RWMutex
+	// inner is the lookup table for sri (or alias) -> section of cpio file (offset + size).
+	inner map[string]struct{ offset, size int64 }
+}
+
+// Record records the given file and returns the sri.
+// The offset is the position of the file data in the archive.
+func (c *CASSectionStore) Record(fileReader io.Reader, offset, size int64, sriAlgorithm sri.Algorithm) (string, error) {
+	counter := &countingReader{r: fileReader}
+	sris, err := cas.Hash(counter, append([]sri.Algorithm{sriAlgorithm}, c.aliasAlgorithms...)...)
+	if err != nil {
+		return "", fmt.Errorf("recording: failed to calculate sri: %w", err)
+	}
+	if counter.n != size {
+		return "", fmt.Errorf("recording: header size does not match real size")
+	}
+	c.Set(sris[0], offset, size, sris[1:]...)
+	return sris[0], nil
+}
+
+// Open returns a reader for the given sri.
+// The sri may be an alias and may contain several space separated digests.
+func (c *CASSectionStore) Open(sri string) (io.ReadCloser, error) {
+	offset, size, ok := c.getSection(sri)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return io.NopCloser(io.NewSectionReader(c.reader, offset, size)), nil
+}
+
+// Set sets the offset and size for the given sri and its aliases.
+// If the sri or an alias already exists, the old location will be kept for it.
+func (c *CASSectionStore) Set(sri string, offset, size int64, aliases ...string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for _, key := range append([]string{sri}, aliases...) {
+		if _, ok := c.inner[key]; !ok {
+			c.inner[key] = struct{ offset, size int64 }{offset, size}
+		}
+	}
+}
+
+// getSection returns the cpio section (offset, size) for the given sri.
+func (c *CASSectionStore) getSection(sri string) (int64, int64, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if section, ok := c.inner[sri]; ok {
+		return section.offset, section.size, true
+	}
+	for _, digest := range cas.Digests(sri) {
+		if section, ok := c.inner[digest]; ok {
+			return section.offset, section.size, true
+		}
+	}
+	return 0, 0, false
+}
+
+type fallbackCASStore struct {
+	cas api.CAS
+}
+
+func (f *fallbackCASStore) Open(sri string) (io.ReadCloser, error) {
+	return f.cas.Open(sri)
+}
+
+func (f *fallbackCASStore) Record(fileReader io.Reader, _, size int64, sriAlgorithm sri.Algorithm) (string, error) {
+	// the reader cannot be rewound, so the file is buffered in order to read it twice.
+	// first read: calculate sri
+	// second read: write to cas
+	buf := new(bytes.Buffer)
+	buf.Grow(int(size))
+	observedSize, err := io.Copy(buf, fileReader)
+	if err != nil {
+		return "", fmt.Errorf("recording: failed to copy file: %w", err)
+	}
+	if observedSize != size {
+		return "", fmt.Errorf("recording: header size does not match real size")
+	}
+	integrity, err := sri.FromReader(sriAlgorithm, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return "", fmt.Errorf("recording: failed to calculate sri: %w", err)
+	}
+	sri := integrity.String()
+	if err := f.cas.Write(sri, buf); err != nil {
+		return "", fmt.Errorf("recording: failed to write to cas: %w", err)
+	}
+	return sri, nil
+}
+
+// seekable returns false if the reader is known to not support random access.
+// This is the case for pipes and sockets wrapped in an *os.File.
+func seekable(r io.Reader) bool {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return true
+	}
+	_, err := seeker.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type casStore interface {
+	Record(fileReader io.Reader, offset, size int64, sriAlgorithm sri.Algorithm) (string, error)
+	Open(sri string) (io.ReadCloser, error)
+}
+
+var _ casStore = (*CASSectionStore)(nil)
+var _ casStore = (*fallbackCASStore)(nil)
@@ -0,0 +1,86 @@
+package cpio
+
+import (
+	"errors"
+	"time"
+)
+
+// Header represents a single header in a cpio archive.
+type Header struct {
+	// Name is the name of the entry.
+	Name string
+	// Mode is the unix mode of the entry, including the file type bits.
+	Mode int64
+	Uid  int
+	Gid  int
+	// Nlink is the number of links to the entry.
+	Nlink   int
+	ModTime time.Time
+	// Size is the size of the data following the header.
+	Size int64
+	// Ino is the inode number of the entry.
+	// Together with DevMajor and DevMinor, it identifies hardlinks.
+	Ino       int64
+	DevMajor  int64
+	DevMinor  int64
+	RDevMajor int64
+	RDevMinor int64
+	// Check is the checksum of the data for FormatCRC.
+	Check  uint32
+	Format Format
+}
+
+// Format is the header format of a cpio archive.
+type Format int
+
+const (
+	FormatUnknown Format = iota
+	// FormatNewc is the "new" portable format (SVR4 without checksum).
+	FormatNewc
+	// FormatCRC is the "new" portable format with checksum.
+	FormatCRC
+	// FormatODC is the old portable (POSIX.1) format.
+	FormatODC
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatNewc:
+		return "newc"
+	case FormatCRC:
+		return "crc"
+	case FormatODC:
+		return "odc"
+	}
+	return "unknown"
+}
+
+// File type bits of the unix mode.
+const (
+	TypeMask    = 0o170000
+	TypeSocket  = 0o140000
+	TypeSymlink = 0o120000
+	TypeReg     = 0o100000
+	TypeBlock   = 0o060000
+	TypeDir     = 0o040000
+	TypeChar    = 0o020000
+	TypeFifo    = 0o010000
+)
+
+const (
+	magicNewc   = "070701"
+	magicCRC    = "070702"
+	magicODC    = "070707"
+	trailerName = "TRAILER!!!"
+
+	newcHeaderSize = 110
+	odcHeaderSize  = 76
+	blockSize      = 512
+)
+
+var (
+	ErrHeader       = errors.New("cpio: invalid header")
+	ErrWriteTooLong = errors.New("cpio: write too long")
+	ErrFieldTooLong = errors.New("cpio: header field too long")
+	ErrChecksum     = errors.New("cpio: checksum mismatch")
+)
@@ -0,0 +1,281 @@
+package cpio
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader provides sequential access to the contents of a cpio archive.
+// It supports the newc, crc and odc formats.
+// Concatenated archives (like the ones of an initramfs) are read one after another.
+type Reader struct {
+	r io.Reader
+	// offset is the number of bytes consumed from r.
+	offset int64
+	// remaining is the number of unread data bytes of the current entry.
+	remaining int64
+	// pad is the padding following the data of the current entry.
+	pad int64
+	// verify is true while the data of a FormatCRC entry is read.
+	// sum adds up the data bytes and is compared to check at the end of the data.
+	verify     bool
+	check, sum uint32
+	// archive is the index of the current archive within concatenated archives.
+	archive int
+	// trailer is true if the last header was a trailer.
+	// It may be followed by padding and the next archive.
+	trailer bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next advances to the next entry.
+// Trailers and the padding after them are skipped, so entries of concatenated archives
+// are returned one after another (see Archive).
+// It returns io.EOF at the end of the input.
+func (r *Reader) Next() (*Header, error) {
+	for {
+		if r.verify {
+			// unread data of the crc format is read anyway to verify the checksum
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.skip(r.remaining + r.pad); err != nil {
+			return nil, err
+		}
+		r.remaining, r.pad = 0, 0
+
+		magic, err := r.readMagic()
+		if err != nil {
+			return nil, err
+		}
+		var hdr *Header
+		switch string(magic[:]) {
+		case magicNewc:
+			hdr, err = r.readNewc(FormatNewc)
+		case magicCRC:
+			hdr, err = r.readNewc(FormatCRC)
+		case magicODC:
+			hdr, err = r.readODC()
+		default:
+			return nil, ErrHeader
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Name == trailerName {
+			r.trailer = true
+			continue
+		}
+		if r.trailer {
+			r.trailer = false
+			r.archive++
+		}
+		return hdr, nil
+	}
+}
+
+// Archive returns the index of the archive of the current entry within concatenated archives.
+// Inode numbers are only unique within an archive.
+func (r *Reader) Archive() int {
+	return r.archive
+}
+
+// readMagic reads the magic of the next header.
+// After a trailer, NUL bytes that pad the archive are skipped.
+// It returns io.EOF if the input ends before the next header.
+func (r *Reader) readMagic() ([6]byte, error) {
+	var magic [6]byte
+	filled := 0
+	for {
+		n, err := io.ReadFull(r.r, magic[filled:])
+		r.offset += int64(n)
+		filled += n
+		if r.trailer {
+			// drop the padding before the next archive
+			start := 0
+			for start < filled && magic[start] == 0 {
+				start++
+			}
+			copy(magic[:], magic[start:filled])
+			filled -= start
+		}
+		switch {
+		case filled == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF):
+			// end of input (archives without trailer are accepted as well)
+			return magic, io.EOF
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			return magic, io.ErrUnexpectedEOF
+		case err != nil:
+			return magic, err
+		case filled == len(magic):
+			return magic, nil
+		}
+	}
+}
+
+// Read reads from the data of the current entry.
+// For FormatCRC, it returns ErrChecksum at the end of the data if the checksum does not match.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if r.verify {
+		for _, b := range p[:n] {
+			r.sum += uint32(b)
+		}
+		if r.remaining == 0 {
+			r.verify = false
+			if r.sum != r.check {
+				return n, fmt.Errorf("%w: got %08x, want %08x", ErrChecksum, r.sum, r.check)
+			}
+		}
+	}
+	return n, err
+}
+
+// Offset returns the number of bytes consumed from the underlying reader.
+// Directly after Next, this is the offset of the entry data.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+func (r *Reader) readNewc(format Format) (*Header, error) {
+	var raw [newcHeaderSize - len(magicNewc)]byte
+	if err := r.readFull(raw[:]); err != nil {
+		return nil, err
+	}
+	var fields [13]int64
+	for i := range fields {
+		v, err := strconv.ParseUint(string(raw[i*8:(i+1)*8]), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHeader, err)
+		}
+		fields[i] = int64(v)
+	}
+	namesize := fields[11]
+	name, err := r.readName(namesize)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.skip(pad4(newcHeaderSize + namesize)); err != nil {
+		return nil, err
+	}
+	hdr := &Header{
+		Name:      name,
+		Ino:       fields[0],
+		Mode:      fields[1],
+		Uid:       int(fields[2]),
+		Gid:       int(fields[3]),
+		Nlink:     int(fields[4]),
+		ModTime:   time.Unix(fields[5], 0),
+		Size:      fields[6],
+		DevMajor:  fields[7],
+		DevMinor:  fields[8],
+		RDevMajor: fields[9],
+		RDevMinor: fields[10],
+		Check:     uint32(fields[12]),
+		Format:    format,
+	}
+	r.remaining = hdr.Size
+	r.pad = pad4(hdr.Size)
+	r.check, r.sum = hdr.Check, 0
+	r.verify = format == FormatCRC && hdr.Size > 0
+	if format == FormatCRC && hdr.Size == 0 && hdr.Check != 0 {
+		return nil, fmt.Errorf("%w: got 00000000, want %08x", ErrChecksum, hdr.Check)
+	}
+	return hdr, nil
+}
+
+func (r *Reader) readODC() (*Header, error) {
+	var raw [odcHeaderSize - len(magicODC)]byte
+	if err := r.readFull(raw[:]); err != nil {
+		return nil, err
+	}
+	widths := [10]int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+	var fields [10]int64
+	pos := 0
+	for i, width := range widths {
+		v, err := strconv.ParseUint(string(raw[pos:pos+width]), 8, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrHeader, err)
+		}
+		fields[i] = int64(v)
+		pos += width
+	}
+	name, err := r.readName(fields[8])
+	if err != nil {
+		return nil, err
+	}
+	hdr := &Header{
+		Name:      name,
+		DevMajor:  fields[0] >> 8,
+		DevMinor:  fields[0] & 0xff,
+		Ino:       fields[1],
+		Mode:      fields[2],
+		Uid:       int(fields[3]),
+		Gid:       int(fields[4]),
+		Nlink:     int(fields[5]),
+		RDevMajor: fields[6] >> 8,
+		RDevMinor: fields[6] & 0xff,
+		ModTime:   time.Unix(fields[7], 0),
+		Size:      fields[9],
+		Format:    FormatODC,
+	}
+	r.remaining = hdr.Size
+	return hdr, nil
+}
+
+func (r *Reader) readName(namesize int64) (string, error) {
+	if namesize <= 0 || namesize > maxNameSize {
+		return "", fmt.Errorf("%w: invalid name size %d", ErrHeader, namesize)
+	}
+	name := make([]byte, namesize)
+	if err := r.readFull(name); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(name), "\x00"), nil
+}
+
+func (r *Reader) readFull(p []byte) error {
+	n, err := io.ReadFull(r.r, p)
+	r.offset += int64(n)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *Reader) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	skipped, err := io.CopyN(io.Discard, r.r, n)
+	r.offset += skipped
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// pad4 returns the number of bytes needed to align n to 4 bytes.
+func pad4(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+const maxNameSize = 64 * 1024
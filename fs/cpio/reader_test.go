@@ -0,0 +1,146 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	entries := []testEntry{
+		{name: "dir", mode: TypeDir | 0o755, ino: 1, nlink: 2},
+		{name: "dir/file", mode: TypeReg | 0o644, ino: 2, nlink: 1, data: "contents"},
+		{name: "dir/empty", mode: TypeReg | 0o600, ino: 3, nlink: 1},
+		{name: "link", mode: TypeSymlink | 0o777, ino: 4, nlink: 1, data: "dir/file"},
+	}
+	for _, format := range []Format{FormatNewc, FormatCRC, FormatODC} {
+		t.Run(format.String(), func(t *testing.T) {
+			archive := writeArchive(format, entries...)
+			reader := NewReader(bytes.NewReader(archive))
+			for _, want := range entries {
+				header, err := reader.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Name != want.name || header.Mode != want.mode || header.Ino != want.ino || header.Format != format {
+					t.Errorf("got header %q (mode %o, ino %d, format %s), want %q (mode %o, ino %d, format %s)",
+						header.Name, header.Mode, header.Ino, header.Format, want.name, want.mode, want.ino, format)
+				}
+				data, err := io.ReadAll(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want.data {
+					t.Errorf("%s: got data %q, want %q", want.name, data, want.data)
+				}
+			}
+			if _, err := reader.Next(); err != io.EOF {
+				t.Errorf("got %v after the last entry, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReaderConcatenated(t *testing.T) {
+	var archive []byte
+	archive = append(archive, writeArchive(FormatNewc, testEntry{name: "a", mode: TypeReg | 0o644, ino: 1, nlink: 1, data: "a"})...)
+	archive = append(archive, writeArchive(FormatCRC, testEntry{name: "b", mode: TypeReg | 0o644, ino: 1, nlink: 1, data: "bb"})...)
+	archive = append(archive, writeArchive(FormatODC, testEntry{name: "c", mode: TypeReg | 0o644, ino: 1, nlink: 1, data: "ccc"})...)
+
+	reader := NewReader(bytes.NewReader(archive))
+	var got []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the data is not read, so it is skipped by Next
+		got = append(got, fmt.Sprintf("%s:%d", header.Name, reader.Archive()))
+	}
+	if want := []string{"a:0", "b:1", "c:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestReaderChecksum(t *testing.T) {
+	testCases := map[string]struct {
+		check uint32
+		read  bool
+	}{
+		"data read":    {check: 1, read: true},
+		"data skipped": {check: 1},
+		"empty file":   {check: 1},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			entry := testEntry{name: "file", mode: TypeReg | 0o644, ino: 1, nlink: 1, data: "contents", check: &tc.check}
+			if name == "empty file" {
+				entry.data = ""
+			}
+			reader := NewReader(bytes.NewReader(writeArchive(FormatCRC, entry)))
+			_, err := reader.Next()
+			if err == nil && tc.read {
+				_, err = io.ReadAll(reader)
+			}
+			if err == nil {
+				_, err = reader.Next()
+			}
+			if !errors.Is(err, ErrChecksum) {
+				t.Errorf("got error %v, want %v", err, ErrChecksum)
+			}
+		})
+	}
+}
+
+// testEntry is an entry of a test archive.
+type testEntry struct {
+	name  string
+	mode  int64
+	ino   int64
+	nlink int
+	data  string
+	// check overrides the checksum written for FormatCRC.
+	check *uint32
+}
+
+// writeArchive returns an archive in the format with the entries and a trailer.
+func writeArchive(format Format, entries ...testEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range append(entries, testEntry{name: trailerName, nlink: 1}) {
+		namesize := len(entry.name) + 1
+		switch format {
+		case FormatNewc, FormatCRC:
+			magic, check := magicNewc, uint32(0)
+			if format == FormatCRC {
+				magic = magicCRC
+				for _, b := range []byte(entry.data) {
+					check += uint32(b)
+				}
+				if entry.check != nil {
+					check = *entry.check
+				}
+			}
+			fmt.Fprintf(&buf, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%s\x00",
+				magic, entry.ino, entry.mode, 0, 0, entry.nlink, 1700000000, len(entry.data),
+				0, 0, 0, 0, namesize, check, entry.name)
+			buf.Write(make([]byte, pad4(int64(newcHeaderSize+namesize))))
+			buf.WriteString(entry.data)
+			buf.Write(make([]byte, pad4(int64(len(entry.data)))))
+		case FormatODC:
+			fmt.Fprintf(&buf, "%s%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o%s\x00",
+				magicODC, 0, entry.ino, entry.mode, 0, 0, entry.nlink, 0, 1700000000,
+				namesize, len(entry.data), entry.name)
+			buf.WriteString(entry.data)
+		}
+	}
+	if rest := buf.Len() % blockSize; rest != 0 {
+		buf.Write(make([]byte, blockSize-rest))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,301 @@
+package cpio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	stdpath "path"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/fs/internal/fsutil"
+	"github.com/malt3/abstractfs/kind"
+)
+
+type Sink struct {
+	writer *Writer
+	root   string
+	// skipUnsupported skips nodes that cpio cannot represent (whiteouts) instead of failing.
+	skipUnsupported bool
+	// ino is the last inode number that was assigned.
+	ino int64
+	// links maps the path of regular files with more than one name to their link group.
+	links map[string]*linkGroup
+}
+
+// linkGroup is a set of names sharing one inode.
+// Following GNU cpio, the file contents are only stored with the last member.
+type linkGroup struct {
+	ino       int64
+	nlink     int
+	remaining int
+}
+
+func (s *Sink) Consume(in fs.FS) error {
+	if err := s.collectLinks(in); err != nil {
+		return err
+	}
+	return fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		isRoot := path == "." || path == "/"
+		if isRoot && s.root == "" {
+			// skip root
+			return nil
+		}
+		stat, err := s.stat(in, path, d)
+		if err != nil {
+			return err
+		}
+		contentPath := path
+		if stat.Kind == kind.Hardlink {
+			contentPath = linkTarget(stat)
+			if stat, err = fsutil.HardlinkTarget(in, contentPath); err != nil {
+				return fmt.Errorf("resolving hardlink %q: %w", path, err)
+			}
+		}
+		header, err := s.prepareHeader(in, path, stat)
+		if errors.Is(err, kind.ErrUnsupported) && s.skipUnsupported {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if group, ok := s.links[contentPath]; ok {
+			s.applyLinkGroup(header, group)
+		}
+		if err := s.writer.WriteHeader(header); err != nil {
+			return err
+		}
+		switch stat.Kind {
+		case api.KindSymlink:
+			_, err := io.WriteString(s.writer, stat.Payload)
+			return err
+		case api.KindRegular:
+			if header.Size == 0 {
+				return nil
+			}
+			file, err := in.Open(contentPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(s.writer, file)
+			return err
+		}
+		return nil
+	})
+}
+
+// collectLinks finds all regular files that are the target of at least one hardlink.
+func (s *Sink) collectLinks(in fs.FS) error {
+	counts := make(map[string]int)
+	err := fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stat, hasStat := info.Sys().(api.Stat)
+		if !hasStat {
+			return nil
+		}
+		switch stat.Kind {
+		case api.KindRegular:
+			counts[path]++
+		case kind.Hardlink:
+			counts[linkTarget(stat)]++
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return err
+	}
+	for path, count := range counts {
+		if count > 1 {
+			s.links[path] = &linkGroup{nlink: count, remaining: count}
+		}
+	}
+	return nil
+}
+
+// applyLinkGroup gives every member of a link group the same inode and link count.
+// All but the last member are written without contents.
+func (s *Sink) applyLinkGroup(header *Header, group *linkGroup) {
+	if group.ino == 0 {
+		group.ino = header.Ino
+	}
+	group.remaining--
+	header.Ino = group.ino
+	header.Nlink = group.nlink
+	if group.remaining > 0 {
+		header.Size = 0
+	}
+}
+
+// stat returns the api.Stat of the node.
+// if the dirEntry comes from a api.Tree, the api.Stat is used directly.
+// otherwise, only the subset that is available in fs.FileInfo is used.
+func (s *Sink) stat(in fs.FS, path string, d fs.DirEntry) (api.Stat, error) {
+	info, err := d.Info()
+	if err != nil {
+		return api.Stat{}, err
+	}
+	if stat, hasStat := info.Sys().(api.Stat); hasStat {
+		return stat, nil
+	}
+	stat := api.Stat{
+		Name: info.Name(),
+		Size: info.Size(),
+		Attributes: api.NodeAttributes{
+			Mtime: info.ModTime().UTC(),
+			Mode:  "0o" + strconv.FormatInt(int64(info.Mode().Perm()), 8),
+		},
+	}
+	switch {
+	case info.IsDir():
+		stat.Kind = api.KindDirectory
+	case info.Mode()&fs.ModeSymlink != 0:
+		readLinkFS, ok := in.(fsutil.ReadLinkFS)
+		if !ok {
+			return api.Stat{}, errors.New("symlink given but fs does not implement readLinkFS")
+		}
+		stat.Kind = api.KindSymlink
+		stat.Payload, err = readLinkFS.Readlink(path)
+		if err != nil {
+			return api.Stat{}, err
+		}
+	case info.Mode().IsRegular():
+		stat.Kind = api.KindRegular
+	}
+	return stat, nil
+}
+
+func (s *Sink) prepareHeader(in fs.FS, path string, stat api.Stat) (*Header, error) {
+	typeBits, ok := typeFromKind(stat.Kind)
+	if !ok {
+		return nil, kind.Unsupported(path, stat.Kind)
+	}
+
+	var mode int64
+	var err error
+	if len(stat.Attributes.Mode) > 0 {
+		mode, err = strconv.ParseInt(stat.Attributes.Mode, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var uid int
+	if len(stat.Attributes.UserID) > 0 {
+		uid64, err := strconv.ParseInt(stat.Attributes.UserID, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		uid = int(uid64)
+	}
+
+	var gid int
+	if len(stat.Attributes.GroupID) > 0 {
+		gid64, err := strconv.ParseInt(stat.Attributes.GroupID, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		gid = int(gid64)
+	}
+
+	nlink := 1
+	var size int64
+	var rdevMajor, rdevMinor uint32
+	switch stat.Kind {
+	case kind.CharDevice, kind.BlockDevice:
+		rdevMajor, rdevMinor, err = kind.ParseDevice(stat.Payload)
+		if err != nil {
+			return nil, err
+		}
+	case api.KindDirectory:
+		nlink, err = dirLinks(in, path)
+		if err != nil {
+			return nil, err
+		}
+	case api.KindSymlink:
+		size = int64(len(stat.Payload))
+	case api.KindRegular:
+		size = stat.Size
+	}
+
+	s.ino++
+	return &Header{
+		Name:      s.name(path),
+		Mode:      typeBits | mode&0o7777,
+		Uid:       uid,
+		Gid:       gid,
+		Nlink:     nlink,
+		ModTime:   stat.Attributes.Mtime,
+		Size:      size,
+		Ino:       s.ino,
+		RDevMajor: int64(rdevMajor),
+		RDevMinor: int64(rdevMinor),
+	}, nil
+}
+
+func (s *Sink) name(path string) string {
+	name := path
+	if len(s.root) > 0 {
+		name = stdpath.Join(s.root, name)
+	}
+	return strings.TrimSuffix(name, "/")
+}
+
+// dirLinks returns the link count of a directory.
+// This is 2 (the entry in the parent and ".") plus one for every subdirectory ("..").
+func dirLinks(in fs.FS, path string) (int, error) {
+	entries, err := fs.ReadDir(in, path)
+	if err != nil {
+		return 0, err
+	}
+	nlink := 2
+	for _, entry := range entries {
+		if entry.IsDir() {
+			nlink++
+		}
+	}
+	return nlink, nil
+}
+
+// linkTarget returns the path of the hardlink target relative to the root of the tree.
+func linkTarget(stat api.Stat) string {
+	return strings.TrimPrefix(stdpath.Clean(stat.Payload), "/")
+}
+
+// typeFromKind returns the type bits of the mode of a node.
+// It returns false for kinds that cpio cannot represent.
+func typeFromKind(nodeKind string) (int64, bool) {
+	switch nodeKind {
+	case api.KindDirectory:
+		return TypeDir, true
+	case api.KindSymlink:
+		return TypeSymlink, true
+	case api.KindRegular:
+		return TypeReg, true
+	case kind.CharDevice:
+		return TypeChar, true
+	case kind.BlockDevice:
+		return TypeBlock, true
+	case kind.FIFO:
+		return TypeFifo, true
+	case kind.Socket:
+		return TypeSocket, true
+	}
+	return 0, false
+}
+
+var _ api.Sink = (*Sink)(nil)
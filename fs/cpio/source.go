@@ -0,0 +1,211 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/kind"
+)
+
+type Source struct {
+	casStore
+	reader       *Reader
+	sriAlgorithm sri.Algorithm
+	// links maps the inode of hardlinked regular files to the name of the first entry.
+	links map[linkKey]string
+	// pending holds hardlinked nodes that are waiting for the entry carrying the data.
+	// In the newc format, only the last entry of a hardlink set stores the data.
+	pending map[linkKey][]api.SourceNode
+	// archive is the index of the archive the links and pending nodes belong to.
+	archive int
+	// queue holds nodes that are ready to be returned by Next.
+	queue []api.SourceNode
+	done  bool
+}
+
+func (s *Source) Next() (api.SourceNode, error) {
+	for len(s.queue) == 0 {
+		if s.done {
+			return api.SourceNode{}, io.EOF
+		}
+		header, err := s.reader.Next()
+		if err == io.EOF {
+			s.done = true
+			if err := s.flushPending(); err != nil {
+				return api.SourceNode{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return api.SourceNode{}, err
+		}
+		if s.reader.Archive() != s.archive {
+			// inode numbers of concatenated archives are unrelated
+			if err := s.flushPending(); err != nil {
+				return api.SourceNode{}, err
+			}
+			s.links = make(map[linkKey]string)
+			s.archive = s.reader.Archive()
+		}
+		if err := s.prepareNext(header); err != nil {
+			return api.SourceNode{}, err
+		}
+	}
+	node := s.queue[0]
+	s.queue = s.queue[1:]
+	return node, nil
+}
+
+func (s *Source) prepareNext(header *Header) error {
+	nodeKind := kindFromMode(header.Mode)
+	node := api.SourceNode{
+		Stat: api.Stat{
+			Name:       path.Clean("/" + header.Name),
+			Kind:       nodeKind,
+			Attributes: s.nodeAttributes(header),
+		},
+	}
+
+	switch nodeKind {
+	case api.KindSymlink:
+		target, err := io.ReadAll(io.LimitReader(s.reader, maxSymlinkSize))
+		if err != nil {
+			return err
+		}
+		node.Stat.Payload = string(target)
+	case kind.CharDevice, kind.BlockDevice:
+		node.Stat.Payload = kind.DevicePayload(uint32(header.RDevMajor), uint32(header.RDevMinor))
+	case api.KindRegular:
+		return s.prepareRegular(header, node)
+	}
+	node.Open = s.openFunc(nodeKind, node.Stat.Payload)
+	s.queue = append(s.queue, node)
+	return nil
+}
+
+// prepareRegular records the contents of a regular file.
+// The first entry of a hardlink set is emitted as regular file,
+// all other entries are emitted as hardlinks to the first one.
+// Since newc archives only store the data in the last entry of a hardlink set,
+// entries without data are deferred until the data is found.
+func (s *Source) prepareRegular(header *Header, node api.SourceNode) error {
+	key := linkKey{devMajor: header.DevMajor, devMinor: header.DevMinor, ino: header.Ino}
+	isLink := header.Nlink > 1
+	if isLink {
+		if target, ok := s.links[key]; ok {
+			s.enqueueHardlink(node, target)
+			return nil
+		}
+		if header.Size == 0 {
+			s.pending[key] = append(s.pending[key], node)
+			return nil
+		}
+	}
+
+	payload, err := s.Record(s.reader, s.reader.Offset(), header.Size, s.sriAlgorithm)
+	if err != nil {
+		return err
+	}
+	first := s.enqueueGroup(append(s.pending[key], node), payload, header.Size)
+	delete(s.pending, key)
+	if isLink {
+		s.links[key] = first
+	}
+	return nil
+}
+
+// flushPending emits hardlinked nodes for which no data was found.
+// These are empty files.
+func (s *Source) flushPending() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	payload, err := s.Record(bytes.NewReader(nil), s.reader.Offset(), 0, s.sriAlgorithm)
+	if err != nil {
+		return err
+	}
+	for key, nodes := range s.pending {
+		s.enqueueGroup(nodes, payload, 0)
+		delete(s.pending, key)
+	}
+	return nil
+}
+
+// enqueueGroup emits the first node of a hardlink set as regular file
+// and all other nodes as hardlinks to the first one.
+// It returns the name of the first node.
+func (s *Source) enqueueGroup(nodes []api.SourceNode, payload string, size int64) string {
+	first := nodes[0]
+	first.Stat.Payload = payload
+	first.Stat.Size = size
+	first.Open = s.openFunc(api.KindRegular, payload)
+	s.queue = append(s.queue, first)
+	for _, node := range nodes[1:] {
+		s.enqueueHardlink(node, first.Stat.Name)
+	}
+	return first.Stat.Name
+}
+
+func (s *Source) enqueueHardlink(node api.SourceNode, target string) {
+	node.Stat.Kind = kind.Hardlink
+	node.Stat.Payload = target
+	node.Stat.Size = 0
+	node.Open = s.openFunc(kind.Hardlink, target)
+	s.queue = append(s.queue, node)
+}
+
+func (s *Source) nodeAttributes(header *Header) api.NodeAttributes {
+	return api.NodeAttributes{
+		Mtime:   header.ModTime.UTC(),
+		UserID:  strconv.Itoa(header.Uid),
+		GroupID: strconv.Itoa(header.Gid),
+		Mode:    "0o" + strconv.FormatInt(header.Mode&0o7777, 8),
+	}
+}
+
+func (s *Source) openFunc(kind, payload string) func() (io.ReadCloser, error) {
+	if kind != api.KindRegular {
+		return func() (io.ReadCloser, error) {
+			return nil, fs.ErrNotExist
+		}
+	}
+	return func() (io.ReadCloser, error) {
+		return s.casStore.Open(payload)
+	}
+}
+
+func kindFromMode(mode int64) string {
+	switch mode & TypeMask {
+	case TypeDir:
+		return api.KindDirectory
+	case TypeReg:
+		return api.KindRegular
+	case TypeSymlink:
+		return api.KindSymlink
+	case TypeChar:
+		return kind.CharDevice
+	case TypeBlock:
+		return kind.BlockDevice
+	case TypeFifo:
+		return kind.FIFO
+	case TypeSocket:
+		return kind.Socket
+	}
+	return ""
+}
+
+type linkKey struct {
+	devMajor, devMinor, ino int64
+}
+
+const maxSymlinkSize = 64 * 1024
+
+var (
+	_ api.Source    = (*Source)(nil)
+	_ api.CASReader = (*Source)(nil)
+)
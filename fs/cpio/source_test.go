@@ -0,0 +1,162 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/kind"
+)
+
+func TestSinkSourceRoundTrip(t *testing.T) {
+	blobs := memory.NewCAS(false)
+	payload := writeBlob(t, blobs, "hello\n")
+	tree := coretree.Unflatten(api.Flat{Files: []api.Stat{
+		{Name: "/etc", Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}},
+		{Name: "/etc/hostname", Kind: api.KindRegular, Payload: payload, Size: 6, Attributes: api.NodeAttributes{Mode: "0o644"}},
+		{Name: "/etc/hostname.bak", Kind: kind.Hardlink, Payload: "/etc/hostname"},
+		{Name: "/etc/link", Kind: api.KindSymlink, Payload: "hostname", Attributes: api.NodeAttributes{Mode: "0o777"}},
+	}})
+
+	var archive bytes.Buffer
+	sink, closeSink, err := (&SinkBuilder{}).WithIOWriter(&archive).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Consume(&coretree.TreeFS{Tree: tree, CASReader: blobs}); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeSink(); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := readSource(t, bytes.NewReader(archive.Bytes()))
+	want := []string{
+		"/etc directory",
+		"/etc/hostname regular hello\n",
+		"/etc/hostname.bak hardlink /etc/hostname",
+		"/etc/link symlink hostname",
+	}
+	if got := describe(t, nodes); !equalStrings(got, want) {
+		t.Errorf("got nodes %q, want %q", got, want)
+	}
+}
+
+func TestSourceFormats(t *testing.T) {
+	// the data of a hardlink set is stored with the last entry,
+	// inode numbers are reused by the second archive
+	first := []testEntry{
+		{name: "dir", mode: TypeDir | 0o755, ino: 1, nlink: 2},
+		{name: "dir/a", mode: TypeReg | 0o644, ino: 2, nlink: 2},
+		{name: "dir/b", mode: TypeReg | 0o644, ino: 2, nlink: 2, data: "shared"},
+	}
+	second := []testEntry{
+		{name: "dir/c", mode: TypeReg | 0o644, ino: 2, nlink: 1, data: "other"},
+	}
+	want := []string{
+		"/dir directory",
+		"/dir/a regular shared",
+		"/dir/b hardlink /dir/a",
+		"/dir/c regular other",
+	}
+	inputs := map[string]func([]byte) io.Reader{
+		"seekable":     func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"not seekable": func(b []byte) io.Reader { return struct{ io.Reader }{bytes.NewReader(b)} },
+	}
+	for _, format := range []Format{FormatNewc, FormatCRC, FormatODC} {
+		for inputName, newInput := range inputs {
+			t.Run(format.String()+"/"+inputName, func(t *testing.T) {
+				archive := append(writeArchive(format, first...), writeArchive(format, second...)...)
+				nodes := readSource(t, newInput(archive))
+				if got := describe(t, nodes); !equalStrings(got, want) {
+					t.Errorf("got nodes %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSourceChecksumMismatch(t *testing.T) {
+	check := uint32(0)
+	archive := writeArchive(FormatCRC, testEntry{name: "file", mode: TypeReg | 0o644, ino: 1, nlink: 1, data: "contents", check: &check})
+	source, closeSource, err := (&SourceBuilder{}).WithIOReader(bytes.NewReader(archive)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	if _, err := source.Next(); !errors.Is(err, ErrChecksum) {
+		t.Errorf("got error %v, want %v", err, ErrChecksum)
+	}
+}
+
+func writeBlob(t *testing.T, blobs api.CAS, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Write(sris[0], strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+// readSource reads all nodes of a cpio source.
+func readSource(t *testing.T, r io.Reader) []api.SourceNode {
+	t.Helper()
+	source, closeSource, err := (&SourceBuilder{}).WithIOReader(r).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	var nodes []api.SourceNode
+	for {
+		node, err := source.Next()
+		if err == io.EOF {
+			return nodes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// describe returns the sorted name, kind and contents or payload of the nodes.
+func describe(t *testing.T, nodes []api.SourceNode) []string {
+	t.Helper()
+	var descriptions []string
+	for _, node := range nodes {
+		description := node.Stat.Name + " " + node.Stat.Kind
+		switch node.Stat.Kind {
+		case api.KindRegular:
+			file, err := node.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			description += " " + string(contents)
+		case api.KindSymlink, kind.Hardlink:
+			description += " " + node.Stat.Payload
+		}
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+	return descriptions
+}
+
+func equalStrings(a, b []string) bool {
+	return strings.Join(a, "\x00") == strings.Join(b, "\x00")
+}
@@ -0,0 +1,119 @@
+package cpio
+
+import (
+	"fmt"
+	"io"
+)
+
+// Writer provides sequential writing of a cpio archive in the newc format.
+type Writer struct {
+	w io.Writer
+	// written is the number of bytes written to w.
+	written int64
+	// remaining is the number of data bytes that still need to be written for the current entry.
+	remaining int64
+	// pad is the padding following the data of the current entry.
+	pad    int64
+	closed bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteHeader writes hdr and prepares to accept the entry's data.
+// The Format and Check fields of the header are ignored.
+func (w *Writer) WriteHeader(hdr *Header) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := w.writeHeader(hdr); err != nil {
+		return err
+	}
+	w.remaining = hdr.Size
+	w.pad = pad4(hdr.Size)
+	return nil
+}
+
+// Write writes to the data of the current entry.
+func (w *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.remaining {
+		n, err := w.write(p[:w.remaining])
+		w.remaining -= int64(n)
+		if err != nil {
+			return n, err
+		}
+		return n, ErrWriteTooLong
+	}
+	n, err := w.write(p)
+	w.remaining -= int64(n)
+	return n, err
+}
+
+// Flush finishes writing the current entry.
+// It returns an error if the entry data is incomplete.
+func (w *Writer) Flush() error {
+	if w.remaining > 0 {
+		return fmt.Errorf("cpio: missed writing %d bytes", w.remaining)
+	}
+	if _, err := w.write(make([]byte, w.pad)); err != nil {
+		return err
+	}
+	w.pad = 0
+	return nil
+}
+
+// Close writes the trailer and pads the archive to a multiple of the block size.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := w.writeHeader(&Header{Name: trailerName, Nlink: 1}); err != nil {
+		return err
+	}
+	if rest := w.written % blockSize; rest != 0 {
+		if _, err := w.write(make([]byte, blockSize-rest)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeHeader(hdr *Header) error {
+	if w.closed && hdr.Name != trailerName {
+		return fmt.Errorf("cpio: write after close")
+	}
+	namesize := int64(len(hdr.Name) + 1)
+	var mtime int64
+	if !hdr.ModTime.IsZero() {
+		mtime = hdr.ModTime.Unix()
+	}
+	fields := []int64{
+		hdr.Ino, hdr.Mode, int64(hdr.Uid), int64(hdr.Gid), int64(hdr.Nlink), mtime, hdr.Size,
+		hdr.DevMajor, hdr.DevMinor, hdr.RDevMajor, hdr.RDevMinor, namesize, 0,
+	}
+	buf := make([]byte, 0, newcHeaderSize+namesize+3)
+	buf = append(buf, magicNewc...)
+	for _, field := range fields {
+		if field < 0 || field > 0xffffffff {
+			return fmt.Errorf("%w: %q", ErrFieldTooLong, hdr.Name)
+		}
+		buf = append(buf, fmt.Sprintf("%08x", field)...)
+	}
+	buf = append(buf, hdr.Name...)
+	buf = append(buf, 0)
+	buf = append(buf, make([]byte, pad4(newcHeaderSize+namesize))...)
+	_, err := w.write(buf)
+	return err
+}
+
+func (w *Writer) write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	return n, err
+}
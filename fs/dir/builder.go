@@ -1,18 +1,24 @@
 package dir
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strings"
 
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/provider"
 	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/filter"
 	"github.com/malt3/abstractfs/fs/generic"
 )
 
 type SourceBuilder struct {
 	Dir          string
 	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
+	// AliasAlgorithms are the algorithms that file contents are additionally hashed with,
+	// so the CAS of the source can open them by their sris in these algorithms, too.
+	AliasAlgorithms []sri.Algorithm `abstractfs:"cas-aliases"`
 	// KeepPrefix will keep the prefix of the dir.
 	// If set, the dir path prefix will be removed from the node path.
 	// If not set, the node path will be the real path of the node.
@@ -20,6 +26,9 @@ type SourceBuilder struct {
 	// the node path will be /foo/bar if KeepPrefix is set and /bar if not.
 	KeepPrefix     bool `abstractfs:"keep-prefix"`
 	PreserveXAttrs bool `abstractfs:"preserve-xattrs"`
+	// Filter skips nodes while walking the directory.
+	// Pruned directories are not read at all.
+	Filter         *filter.Filter
 	invalidOptions []string
 }
 
@@ -34,6 +43,12 @@ func (b *SourceBuilder) WithSRIAlgorithm(alg sri.Algorithm) *SourceBuilder {
 	return b
 }
 
+// WithAliasAlgorithms sets the algorithms that file contents are additionally hashed with.
+func (b *SourceBuilder) WithAliasAlgorithms(algorithms ...sri.Algorithm) *SourceBuilder {
+	b.AliasAlgorithms = algorithms
+	return b
+}
+
 func (b *SourceBuilder) WithKeepPrefix(keepPrefix bool) *SourceBuilder {
 	b.KeepPrefix = keepPrefix
 	return b
@@ -44,6 +59,17 @@ func (b *SourceBuilder) WithPreserveXAttrs(preserveXAttrs bool) *SourceBuilder {
 	return b
 }
 
+// WithFilter sets the filter that is applied while walking the directory.
+func (b *SourceBuilder) WithFilter(f *filter.Filter) *SourceBuilder {
+	b.Filter = f
+	return b
+}
+
+// SetFilter sets the filter that is applied while walking the directory.
+func (b *SourceBuilder) SetFilter(f *filter.Filter) {
+	b.Filter = f
+}
+
 // Build builds the options.
 func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
 	b.applyDefaults()
@@ -55,13 +81,16 @@ func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
 		return nil, nil, err
 	}
 	source := &Source{
-		dir:            dir,
-		casStore:       generic.NewCASStore(),
-		sriAlgorithm:   b.SRIAlgorithm,
-		keepPrefix:     b.KeepPrefix,
-		preserveXAttrs: b.PreserveXAttrs,
-		nodes:          make(chan next),
-		stop:           make(chan struct{}, 1),
+		dir:             dir,
+		casStore:        generic.NewCASStore(),
+		sriAlgorithm:    b.SRIAlgorithm,
+		aliasAlgorithms: b.AliasAlgorithms,
+		keepPrefix:      b.KeepPrefix,
+		preserveXAttrs:  b.PreserveXAttrs,
+		filter:          b.Filter,
+		nodes:           make(chan next),
+		stop:            make(chan struct{}, 1),
+		inodes:          make(map[inodeKey]string),
 	}
 	source.wg.Add(1)
 	go source.walk()
@@ -84,3 +113,149 @@ func (b *SourceBuilder) check() error {
 	}
 	return nil
 }
+
+type SinkBuilder struct {
+	// Dir is the target directory.
+	// It is created if it does not exist.
+	Dir string
+	// Existing decides what happens if the target directory already exists.
+	// Valid values are ExistingError, ExistingMerge and ExistingReplace.
+	// By default, ExistingError is used, which only allows writing into an empty directory.
+	Existing string `abstractfs:"existing"`
+	// IgnoreXAttrs skips writing xattrs.
+	IgnoreXAttrs bool `abstractfs:"ignore-xattrs"`
+	// IgnoreOwnership skips changing the owner of written nodes.
+	// Ownership is only applied if the process is privileged.
+	IgnoreOwnership bool `abstractfs:"ignore-ownership"`
+	// IgnoreDevices skips character and block devices.
+	// Device nodes can only be created if the process is privileged.
+	// Without this option, writing a device node as unprivileged user fails.
+	IgnoreDevices bool `abstractfs:"ignore-devices"`
+	// SkipUnsupported skips nodes that cannot be written to a directory (whiteouts).
+	// By default, such nodes are an error (see kind.ErrUnsupported).
+	SkipUnsupported bool `abstractfs:"skip-unsupported"`
+	invalidOptions  []string
+}
+
+// WithSinkRef sets the sink reference.
+// For the dir provider, the sink reference is the path to the target directory.
+func (b *SinkBuilder) WithSinkRef(ref string) provider.SinkBuilder {
+	b.Dir = ref
+	return b
+}
+
+// Set sets a option.
+func (b *SinkBuilder) Set(key string, value any) provider.SinkBuilder {
+	switch key {
+	case "existing":
+		existing, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Existing = existing
+	case "ignore-xattrs":
+		ignoreXAttrs, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.IgnoreXAttrs = ignoreXAttrs
+	case "ignore-ownership":
+		ignoreOwnership, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.IgnoreOwnership = ignoreOwnership
+	case "ignore-devices":
+		ignoreDevices, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.IgnoreDevices = ignoreDevices
+	case "skip-unsupported":
+		skipUnsupported, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.SkipUnsupported = skipUnsupported
+	default:
+		b.invalidOptions = append(b.invalidOptions, key)
+	}
+	return b
+}
+
+func (b *SinkBuilder) WithExisting(existing string) *SinkBuilder {
+	b.Existing = existing
+	return b
+}
+
+func (b *SinkBuilder) WithIgnoreXAttrs(ignoreXAttrs bool) *SinkBuilder {
+	b.IgnoreXAttrs = ignoreXAttrs
+	return b
+}
+
+func (b *SinkBuilder) WithIgnoreOwnership(ignoreOwnership bool) *SinkBuilder {
+	b.IgnoreOwnership = ignoreOwnership
+	return b
+}
+
+func (b *SinkBuilder) WithIgnoreDevices(ignoreDevices bool) *SinkBuilder {
+	b.IgnoreDevices = ignoreDevices
+	return b
+}
+
+// WithSkipUnsupported skips nodes that cannot be written to a directory instead of failing.
+func (b *SinkBuilder) WithSkipUnsupported(skipUnsupported bool) *SinkBuilder {
+	b.SkipUnsupported = skipUnsupported
+	return b
+}
+
+// Build builds the options.
+func (b *SinkBuilder) Build() (api.Sink, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	sink := &Sink{
+		dir:             strings.TrimSuffix(b.Dir, "/"),
+		existing:        b.Existing,
+		ignoreXAttrs:    b.IgnoreXAttrs,
+		ignoreDevices:   b.IgnoreDevices,
+		chown:           !b.IgnoreOwnership && privileged(),
+		mknodDevices:    privileged(),
+		skipUnsupported: b.SkipUnsupported,
+	}
+	if sink.dir == "" {
+		sink.dir = "/"
+	}
+	return sink, func() error { return nil }, nil
+}
+
+func (b *SinkBuilder) applyDefaults() {
+	if b.Existing == "" {
+		b.Existing = ExistingError
+	}
+}
+
+func (b *SinkBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Dir == "" {
+		return errors.New("must set target directory")
+	}
+	switch b.Existing {
+	case ExistingError, ExistingMerge:
+	case ExistingReplace:
+		if filepath.Clean(b.Dir) == string(filepath.Separator) {
+			return errors.New("refusing to replace the root directory")
+		}
+	default:
+		return fmt.Errorf("invalid value for existing: %q", b.Existing)
+	}
+	return nil
+}
@@ -16,7 +16,7 @@ func (p Provider) SourceBuilder() provider.SourceBuilder {
 }
 
 func (p Provider) SinkBuilder() provider.SinkBuilder {
-	return &provider.UnsupportedSinkBuilder{}
+	return &SinkBuilder{}
 }
 
 func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
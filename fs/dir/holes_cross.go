@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package dir
+
+import (
+	"io/fs"
+
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// holes is not supported on this platform.
+// Sparse files are read as regular files.
+func holes(path string, info fs.FileInfo) (sparse.HoleMap, error) {
+	return nil, nil
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || freebsd
+
+package dir
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"github.com/malt3/abstractfs/sparse"
+	"golang.org/x/sys/unix"
+)
+
+// holes returns the hole map of a regular file using SEEK_DATA and SEEK_HOLE.
+// Files that occupy at least their size on disk are never sparse and are not inspected.
+func holes(path string, info fs.FileInfo) (sparse.HoleMap, error) {
+	size := info.Size()
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || size == 0 || stat.Blocks*512 >= size {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fd := int(file.Fd())
+
+	var data []sparse.Region
+	var offset int64
+	for offset < size {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// no data after offset
+			break
+		}
+		if errors.Is(err, unix.EINVAL) {
+			// the file system does not support SEEK_DATA
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sparse.Region{Offset: start, Length: end - start})
+		offset = end
+	}
+	return sparse.FromData(data, size), nil
+}
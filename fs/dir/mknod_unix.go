@@ -0,0 +1,9 @@
+//go:build unix && !freebsd
+
+package dir
+
+import "golang.org/x/sys/unix"
+
+func mknodDev(path string, mode uint32, dev uint64) error {
+	return unix.Mknod(path, mode, int(dev))
+}
@@ -0,0 +1,422 @@
+package dir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	stdpath "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/fs/internal/fsutil"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+type Sink struct {
+	dir           string
+	existing      string
+	ignoreXAttrs  bool
+	ignoreDevices bool
+	chown         bool
+	// mknodDevices is true if the process is allowed to create device nodes.
+	mknodDevices bool
+	// skipUnsupported skips nodes that cannot be written to a directory (whiteouts) instead of failing.
+	skipUnsupported bool
+}
+
+// Consume materializes the given fs.FS in the target directory.
+// Directory metadata is applied after all children have been written,
+// so that restrictive modes and mtimes are not disturbed by the write itself.
+func (s *Sink) Consume(in fs.FS) error {
+	if err := s.prepareTarget(); err != nil {
+		return err
+	}
+	var dirs []pendingDir
+	// links maps the fs path of regular files to the path they were written to.
+	// Later occurrences of the same file are written as hardlinks.
+	links := make(map[string]string)
+	err := fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stat, err := s.stat(in, path, d)
+		if err != nil {
+			return err
+		}
+		target, err := s.targetPath(path)
+		if err != nil {
+			return err
+		}
+		switch stat.Kind {
+		case api.KindDirectory:
+			if err := s.mkdir(target); err != nil {
+				return err
+			}
+			dirs = append(dirs, pendingDir{path: target, stat: stat})
+			return nil
+		case api.KindRegular:
+			return s.writeOrLink(in, path, target, stat, links)
+		case kind.Hardlink:
+			contentPath := strings.TrimPrefix(stdpath.Clean(stat.Payload), "/")
+			targetStat, err := fsutil.HardlinkTarget(in, contentPath)
+			if err != nil {
+				return fmt.Errorf("resolving hardlink %q: %w", path, err)
+			}
+			return s.writeOrLink(in, contentPath, target, targetStat, links)
+		case api.KindSymlink:
+			return s.symlink(target, stat)
+		case kind.CharDevice, kind.BlockDevice:
+			if s.ignoreDevices {
+				return nil
+			}
+			if !s.mknodDevices {
+				return fmt.Errorf("writing %q: creating device nodes requires privileges", path)
+			}
+			return s.special(target, stat)
+		case kind.FIFO, kind.Socket:
+			return s.special(target, stat)
+		}
+		if s.skipUnsupported {
+			return nil
+		}
+		return kind.Unsupported(path, stat.Kind)
+	})
+	if err != nil {
+		return err
+	}
+	// apply directory metadata bottom-up
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := s.applyDirMetadata(dirs[i].path, dirs[i].stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareTarget ensures that the target directory exists and
+// handles a preexisting target according to the configured policy.
+func (s *Sink) prepareTarget() error {
+	info, err := os.Lstat(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.MkdirAll(s.dir, 0o755)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("target %q exists and is not a directory", s.dir)
+	}
+	switch s.existing {
+	case ExistingMerge:
+		return nil
+	case ExistingReplace:
+		if err := os.RemoveAll(s.dir); err != nil {
+			return err
+		}
+		return os.Mkdir(s.dir, 0o755)
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("target directory %q already exists and is not empty", s.dir)
+	}
+	return nil
+}
+
+// stat returns the api.Stat of the node.
+// if the dirEntry comes from a api.Tree, the api.Stat is used directly.
+// otherwise, only the subset that is available in fs.FileInfo is used.
+func (s *Sink) stat(in fs.FS, path string, d fs.DirEntry) (api.Stat, error) {
+	info, err := d.Info()
+	if err != nil {
+		return api.Stat{}, err
+	}
+	if stat, hasStat := info.Sys().(api.Stat); hasStat {
+		return stat, nil
+	}
+	stat := api.Stat{
+		Name: info.Name(),
+		Size: info.Size(),
+		Attributes: api.NodeAttributes{
+			Mtime: info.ModTime().UTC(),
+			Mode:  "0o" + strconv.FormatInt(int64(info.Mode().Perm()), 8),
+		},
+	}
+	switch {
+	case info.IsDir():
+		stat.Kind = api.KindDirectory
+	case info.Mode()&fs.ModeSymlink != 0:
+		readLinkFS, ok := in.(fsutil.ReadLinkFS)
+		if !ok {
+			return api.Stat{}, errors.New("symlink given but fs does not implement readLinkFS")
+		}
+		stat.Kind = api.KindSymlink
+		stat.Payload, err = readLinkFS.Readlink(path)
+		if err != nil {
+			return api.Stat{}, err
+		}
+	case info.Mode().IsRegular():
+		stat.Kind = api.KindRegular
+	}
+	return stat, nil
+}
+
+// targetPath returns the path on disk for the given fs path.
+// Paths that would escape the target directory are rejected.
+func (s *Sink) targetPath(path string) (string, error) {
+	if path == "." {
+		return s.dir, nil
+	}
+	local := filepath.FromSlash(path)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("refusing to write %q outside of target directory", path)
+	}
+	return filepath.Join(s.dir, local), nil
+}
+
+func (s *Sink) mkdir(target string) error {
+	info, err := os.Lstat(target)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if err := s.clearTarget(target); err != nil {
+		return err
+	}
+	// use restrictive permissions until the final mode is applied
+	return os.Mkdir(target, 0o700)
+}
+
+// writeOrLink writes the regular file at contentPath to target.
+// If the file was already written, a hardlink to the earlier copy is created instead.
+func (s *Sink) writeOrLink(in fs.FS, contentPath, target string, stat api.Stat, links map[string]string) error {
+	if earlier, ok := links[contentPath]; ok {
+		if err := s.clearTarget(target); err != nil {
+			return err
+		}
+		return os.Link(earlier, target)
+	}
+	if err := s.writeFile(in, contentPath, target, stat); err != nil {
+		return err
+	}
+	links[contentPath] = target
+	return nil
+}
+
+func (s *Sink) writeFile(in fs.FS, path, target string, stat api.Stat) error {
+	if err := s.clearTarget(target); err != nil {
+		return err
+	}
+	src, err := in.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	holes, err := sparse.FromFS(in, stat)
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", path, err)
+	}
+	if len(holes) > 0 {
+		err = sparse.Copy(dst, src, holes, stat.Size)
+	} else {
+		_, err = io.Copy(dst, src)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", path, err)
+	}
+	if err := s.applyFileMetadata(dst, stat); err != nil {
+		return fmt.Errorf("writing %q: %w", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return s.applyTimes(target, stat)
+}
+
+func (s *Sink) symlink(target string, stat api.Stat) error {
+	if err := s.clearTarget(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(stat.Payload, target); err != nil {
+		return err
+	}
+	if s.chown {
+		uid, gid, err := ownership(stat.Attributes)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(target, uid, gid); err != nil {
+			return err
+		}
+	}
+	return s.applyTimes(target, stat)
+}
+
+// special creates a device node, FIFO or socket.
+// Xattrs are not applied, since this would require opening the node.
+func (s *Sink) special(target string, stat api.Stat) error {
+	if err := s.clearTarget(target); err != nil {
+		return err
+	}
+	if err := mknod(target, stat); err != nil {
+		return fmt.Errorf("creating %q: %w", target, err)
+	}
+	if s.chown {
+		uid, gid, err := ownership(stat.Attributes)
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(target, uid, gid); err != nil {
+			return err
+		}
+	}
+	if len(stat.Attributes.Mode) > 0 {
+		mode, err := fileMode(stat.Attributes.Mode)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	}
+	return s.applyTimes(target, stat)
+}
+
+func (s *Sink) applyDirMetadata(target string, stat api.Stat) error {
+	dir, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	if err := s.applyFileMetadata(dir, stat); err != nil {
+		return fmt.Errorf("writing %q: %w", target, err)
+	}
+	if err := dir.Close(); err != nil {
+		return err
+	}
+	return s.applyTimes(target, stat)
+}
+
+// applyFileMetadata applies ownership, mode and xattrs to an open file.
+// Ownership is applied first, since chown may clear setuid and setgid bits.
+func (s *Sink) applyFileMetadata(file *os.File, stat api.Stat) error {
+	if s.chown {
+		uid, gid, err := ownership(stat.Attributes)
+		if err != nil {
+			return err
+		}
+		if err := file.Chown(uid, gid); err != nil {
+			return err
+		}
+	}
+	if len(stat.Attributes.Mode) > 0 {
+		mode, err := fileMode(stat.Attributes.Mode)
+		if err != nil {
+			return err
+		}
+		if err := file.Chmod(mode); err != nil {
+			return err
+		}
+	}
+	if s.ignoreXAttrs {
+		return nil
+	}
+	for key, value := range stat.Attributes.XAttrs {
+		if err := Fsetxattr(file, key, []byte(value)); err != nil {
+			return fmt.Errorf("setting xattr %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *Sink) applyTimes(target string, stat api.Stat) error {
+	if stat.Attributes.Mtime.IsZero() {
+		return nil
+	}
+	return lchtimes(target, stat.Attributes.Mtime, stat.Attributes.Mtime)
+}
+
+// clearTarget removes an existing node at the target path.
+// This only happens when merging into an existing directory.
+func (s *Sink) clearTarget(target string) error {
+	if _, err := os.Lstat(target); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if s.existing != ExistingMerge {
+		return fmt.Errorf("target %q already exists", target)
+	}
+	return os.RemoveAll(target)
+}
+
+// ownership returns the uid and gid of the node.
+// Missing values are returned as -1, which leaves them unchanged.
+func ownership(attributes api.NodeAttributes) (int, int, error) {
+	uid, gid := -1, -1
+	if len(attributes.UserID) > 0 {
+		uid64, err := strconv.ParseInt(attributes.UserID, 0, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid = int(uid64)
+	}
+	if len(attributes.GroupID) > 0 {
+		gid64, err := strconv.ParseInt(attributes.GroupID, 0, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid = int(gid64)
+	}
+	return uid, gid, nil
+}
+
+// fileMode converts a unix mode string (like "0o4755") into a fs.FileMode.
+func fileMode(raw string) (fs.FileMode, error) {
+	mode, err := strconv.ParseInt(raw, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+	fileMode := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		fileMode |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		fileMode |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		fileMode |= fs.ModeSticky
+	}
+	return fileMode, nil
+}
+
+type pendingDir struct {
+	path string
+	stat api.Stat
+}
+
+const (
+	// ExistingError refuses to write into a non-empty target directory.
+	ExistingError = "error"
+	// ExistingMerge writes into an existing target directory.
+	// Conflicting nodes are replaced.
+	ExistingMerge = "merge"
+	// ExistingReplace removes an existing target directory before writing.
+	ExistingReplace = "replace"
+)
+
+var _ api.Sink = (*Sink)(nil)
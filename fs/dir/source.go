@@ -9,20 +9,28 @@ import (
 	"sync"
 
 	"github.com/malt3/abstractfs-core/api"
-	"github.com/malt3/abstractfs-core/kind"
 	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/filter"
 	"github.com/malt3/abstractfs/fs/generic"
+	abstractfskind "github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
 )
 
 type Source struct {
-	wg             sync.WaitGroup
-	dir            string
-	casStore       *generic.CASStore
-	sriAlgorithm   sri.Algorithm
-	keepPrefix     bool
-	preserveXAttrs bool
-	nodes          chan next
-	stop           chan struct{}
+	wg              sync.WaitGroup
+	dir             string
+	casStore        *generic.CASStore
+	sriAlgorithm    sri.Algorithm
+	aliasAlgorithms []sri.Algorithm
+	keepPrefix      bool
+	preserveXAttrs  bool
+	nodes           chan next
+	stop            chan struct{}
+	// inodes maps hardlinked inodes to the name of the first node that was found for them.
+	inodes map[inodeKey]string
+	// filter skips nodes while walking, it may be nil.
+	filter *filter.Filter
 }
 
 func (s *Source) Next() (api.SourceNode, error) {
@@ -41,6 +49,25 @@ func (s *Source) Open(sri string) (io.ReadCloser, error) {
 	return os.Open(path)
 }
 
+// Holes returns the holes of the file with the given sri.
+// Holes are detected when they are requested, so sinks that do not write sparse files
+// do not pay for the detection.
+func (s *Source) Holes(sri string) sparse.HoleMap {
+	path, ok := s.casStore.Get(sri)
+	if !ok {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	holes, err := holes(path, info)
+	if err != nil {
+		return nil
+	}
+	return holes
+}
+
 func (s *Source) walk() {
 	defer s.wg.Done()
 	defer close(s.nodes)
@@ -52,6 +79,16 @@ func (s *Source) walk() {
 		root = "/"
 	}
 	err := fs.WalkDir(os.DirFS(root), relativeDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && s.filter != nil {
+			name := normalizePath(s.absPath(path), s.dir, s.keepPrefix)
+			if d.IsDir() && s.filter.Prune(name) {
+				return fs.SkipDir
+			}
+			// directories that are not kept may still have kept nodes below them
+			if !d.IsDir() && !s.filter.Keep(name, false) {
+				return nil
+			}
+		}
 		next := s.prepareNext(path, err)
 
 		select {
@@ -69,9 +106,7 @@ func (s *Source) walk() {
 }
 
 func (s *Source) prepareNext(path string, err error) next {
-	if strings.HasPrefix(s.dir, "/") && !strings.HasPrefix(path, "/") {
-		path = "/" + path
-	}
+	path = s.absPath(path)
 	if err != nil {
 		return next{Err: err}
 	}
@@ -81,25 +116,32 @@ func (s *Source) prepareNext(path string, err error) next {
 		return next{Err: err}
 	}
 
-	kind := kind.FromMode(stat.Mode())
+	name := normalizePath(path, s.dir, s.keepPrefix)
+	kind := abstractfskind.FromMode(stat.Mode())
+	var linkTarget string
+	if kind == api.KindRegular {
+		kind, linkTarget = s.detectHardlink(name, stat)
+	}
 
 	payload, err := s.payload(path, kind)
 	if err != nil {
 		return next{Err: err}
 	}
 
-	if kind == api.KindRegular {
-		s.addToCAS(payload, path)
+	switch kind {
+	case abstractfskind.Hardlink:
+		payload = linkTarget
+	case abstractfskind.CharDevice, abstractfskind.BlockDevice:
+		payload = abstractfskind.DevicePayload(device(stat))
 	}
 
-	attributes, err := s.nodeAttributes(path, stat)
+	attributes, err := s.nodeAttributes(path, kind, stat)
 	if err != nil {
 		return next{Err: err}
 	}
-
 	node := api.SourceNode{
 		Stat: api.Stat{
-			Name:       normalizePath(path, s.dir, s.keepPrefix),
+			Name:       name,
 			Kind:       kind,
 			Attributes: attributes,
 			Payload:    payload,
@@ -113,6 +155,15 @@ func (s *Source) prepareNext(path string, err error) next {
 	return next{Node: node}
 }
 
+// absPath returns the walked path relative to the working directory or absolute,
+// like the dir of the source.
+func (s *Source) absPath(path string) string {
+	if strings.HasPrefix(s.dir, "/") && !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
 func (s *Source) payload(path, kind string) (string, error) {
 	switch kind {
 	case api.KindSymlink:
@@ -122,21 +173,12 @@ func (s *Source) payload(path, kind string) (string, error) {
 		}
 		return normalizeSymlinkTarget(target, s.dir, s.keepPrefix), nil
 	case api.KindRegular:
-		f, err := os.Open(path)
-		if err != nil {
-			return "", err
-		}
-		defer f.Close()
-		integrity, err := sri.FromReader(s.sriAlgorithm, f)
-		if err != nil {
-			return "", err
-		}
-		return integrity.String(), nil
+		return s.record(path)
 	}
 	return "", nil
 }
 
-func (s *Source) nodeAttributes(path string, stat fs.FileInfo) (api.NodeAttributes, error) {
+func (s *Source) nodeAttributes(path, kind string, stat fs.FileInfo) (api.NodeAttributes, error) {
 	mtime := stat.ModTime().UTC()
 	userID := userID(stat)
 	groupID := groupID(stat)
@@ -150,7 +192,9 @@ func (s *Source) nodeAttributes(path string, stat fs.FileInfo) (api.NodeAttribut
 	}
 	mode := "0o" + strconv.FormatInt(int64(stat.Mode().Perm()), 8)
 	var xattrs map[string]string
-	if s.preserveXAttrs {
+	// special files are not opened to read xattrs:
+	// opening a FIFO blocks and opening a device may have side effects
+	if s.preserveXAttrs && !isSpecial(kind) {
 		xattrs, err = xattrMap(path)
 		if err != nil {
 			return api.NodeAttributes{}, err
@@ -167,8 +211,42 @@ func (s *Source) nodeAttributes(path string, stat fs.FileInfo) (api.NodeAttribut
 	}, nil
 }
 
-func (s *Source) addToCAS(sri, path string) {
-	s.casStore.Set(sri, path)
+// detectHardlink returns kind.Hardlink and the link target
+// if the regular file shares its inode with a previously visited file.
+func (s *Source) detectHardlink(name string, stat fs.FileInfo) (string, string) {
+	key, ok := inode(stat)
+	if !ok {
+		return api.KindRegular, ""
+	}
+	if target, ok := s.inodes[key]; ok {
+		return abstractfskind.Hardlink, target
+	}
+	s.inodes[key] = name
+	return api.KindRegular, ""
+}
+
+// isSpecial returns true for device nodes, FIFOs and sockets.
+func isSpecial(kind string) bool {
+	switch kind {
+	case abstractfskind.CharDevice, abstractfskind.BlockDevice, abstractfskind.FIFO, abstractfskind.Socket:
+		return true
+	}
+	return false
+}
+
+// record hashes the file and adds it to the CAS.
+func (s *Source) record(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sris, err := cas.Hash(f, append([]sri.Algorithm{s.sriAlgorithm}, s.aliasAlgorithms...)...)
+	if err != nil {
+		return "", err
+	}
+	s.casStore.Set(sris[0], path, sris[1:]...)
+	return sris[0], nil
 }
 
 type next struct {
@@ -178,6 +256,11 @@ type next struct {
 
 type closeWaitFunc func()
 
+// inodeKey identifies an inode.
+type inodeKey struct {
+	dev, ino uint64
+}
+
 func normalizePath(path, dir string, keepPrefix bool) string {
 	if path == "." {
 		return "/"
@@ -231,6 +314,7 @@ func xattrMap(path string) (map[string]string, error) {
 }
 
 var (
-	_ api.Source    = (*Source)(nil)
-	_ api.CASReader = (*Source)(nil)
+	_ api.Source        = (*Source)(nil)
+	_ api.CASReader     = (*Source)(nil)
+	_ sparse.HoleReader = (*Source)(nil)
 )
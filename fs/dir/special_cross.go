@@ -0,0 +1,14 @@
+//go:build !unix
+
+package dir
+
+import (
+	"errors"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// mknod is not supported on non-unix systems.
+func mknod(_ string, _ api.Stat) error {
+	return errors.New("device nodes, FIFOs and sockets are not supported")
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package dir
+
+import (
+	"fmt"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/kind"
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a device node, FIFO or socket.
+// The permissions are applied separately, since mknod is subject to the umask.
+func mknod(target string, stat api.Stat) error {
+	var mode uint32
+	var dev uint64
+	switch stat.Kind {
+	case kind.CharDevice, kind.BlockDevice:
+		major, minor, err := kind.ParseDevice(stat.Payload)
+		if err != nil {
+			return err
+		}
+		mode = unix.S_IFCHR
+		if stat.Kind == kind.BlockDevice {
+			mode = unix.S_IFBLK
+		}
+		dev = unix.Mkdev(major, minor)
+	case kind.FIFO:
+		mode = unix.S_IFIFO
+	case kind.Socket:
+		mode = unix.S_IFSOCK
+	default:
+		return fmt.Errorf("mknod: unsupported kind %q", stat.Kind)
+	}
+	return mknodDev(target, mode|0o600, dev)
+}
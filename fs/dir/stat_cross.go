@@ -21,3 +21,14 @@ func userName(info fs.FileInfo) (string, error) {
 func groupName(info fs.FileInfo) (string, error) {
 	return "", nil
 }
+
+// inode is not supported on non-unix systems.
+// Hardlinks are not detected.
+func inode(info fs.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
+
+// device is not supported on non-unix systems.
+func device(info fs.FileInfo) (uint32, uint32) {
+	return 0, 0
+}
@@ -7,6 +7,8 @@ import (
 	osuser "os/user"
 	"strconv"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 func userID(info fs.FileInfo) string {
@@ -40,3 +42,19 @@ func groupName(info fs.FileInfo) (string, error) {
 	}
 	return group.Name, nil
 }
+
+// inode returns the identity of the inode (device and inode number) of the file.
+// It returns false if the file has no other links.
+func inode(info fs.FileInfo) (inodeKey, bool) {
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Nlink <= 1 {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// device returns the major and minor number of a device node.
+func device(info fs.FileInfo) (uint32, uint32) {
+	rdev := uint64(info.Sys().(*syscall.Stat_t).Rdev)
+	return unix.Major(rdev), unix.Minor(rdev)
+}
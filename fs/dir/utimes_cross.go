@@ -0,0 +1,20 @@
+//go:build !unix
+
+package dir
+
+import (
+	"os"
+	"time"
+)
+
+// lchtimes changes the access and modification times of the named file.
+// Symlinks are followed on non-unix systems.
+func lchtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+// privileged returns false on non-unix systems.
+// Ownership is never changed.
+func privileged() bool {
+	return false
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package dir
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// lchtimes changes the access and modification times of the named file.
+// Unlike os.Chtimes, it does not follow symlinks.
+func lchtimes(path string, atime, mtime time.Time) error {
+	ts := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// privileged returns true if the process is allowed to change ownership of files.
+func privileged() bool {
+	return unix.Geteuid() == 0
+}
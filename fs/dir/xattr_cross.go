@@ -19,3 +19,9 @@ func Flistxattr(_ any) ([]string, error) {
 func Fgetxattr(_ any, _ string) ([]byte, error) {
 	return nil, errors.New("xattr not supported")
 }
+
+// Fsetxattr would set the extended attribute value for a given name.
+// This is not supported on non-unix systems.
+func Fsetxattr(_ any, _ string, _ []byte) error {
+	return errors.New("xattr not supported")
+}
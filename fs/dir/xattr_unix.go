@@ -60,6 +60,14 @@ func Fgetxattr(file Fd, attr string) ([]byte, error) {
 	return dest[:sizeRead], nil
 }
 
+func Fsetxattr(file Fd, attr string, value []byte) error {
+	fd := int(file.Fd())
+	if len(value) > maxBufSize {
+		return errors.New("xattr value is too large")
+	}
+	return unix.Fsetxattr(fd, attr, value, 0)
+}
+
 func stringsFromByteSlice(buf []byte) []string {
 	var result []string
 	off := 0
@@ -11,11 +11,14 @@ import (
 )
 
 type SourceBuilder struct {
-	SRIAlgorithm   sri.Algorithm `abstractfs:"cas-algorithm"`
-	NodeAttributes func(iofs.FileInfo) api.NodeAttributes
-	StripPrefix    string `abstractfs:"strip-prefix"`
-	FS             iofs.FS
-	invalidOptions []string
+	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
+	// AliasAlgorithms are the algorithms that file contents are additionally hashed with,
+	// so the CAS of the source can open them by their sris in these algorithms, too.
+	AliasAlgorithms []sri.Algorithm `abstractfs:"cas-aliases"`
+	NodeAttributes  func(iofs.FileInfo) api.NodeAttributes
+	StripPrefix     string `abstractfs:"strip-prefix"`
+	FS              iofs.FS
+	invalidOptions  []string
 }
 
 // WithSourceRef sets the source reference.
@@ -42,13 +45,14 @@ func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
 		return nil, nil, err
 	}
 	source := &Source{
-		inner:          b.FS,
-		casStore:       NewCASStore(),
-		sriAlgorithm:   b.SRIAlgorithm,
-		nodeAttributes: b.NodeAttributes,
-		stripPrefix:    b.StripPrefix,
-		nodes:          make(chan next),
-		stop:           make(chan struct{}, 1),
+		inner:           b.FS,
+		casStore:        NewCASStore(),
+		sriAlgorithm:    b.SRIAlgorithm,
+		aliasAlgorithms: b.AliasAlgorithms,
+		nodeAttributes:  b.NodeAttributes,
+		stripPrefix:     b.StripPrefix,
+		nodes:           make(chan next),
+		stop:            make(chan struct{}, 1),
 	}
 	source.wg.Add(1)
 	go source.walk()
@@ -4,6 +4,8 @@ import (
 	"io"
 	"io/fs"
 	"sync"
+
+	"github.com/malt3/abstractfs/cas"
 )
 
 type CAS struct {
@@ -28,7 +30,7 @@ func (c *CAS) Open(sri string) (io.ReadCloser, error) {
 
 type CASStore struct {
 	mux sync.RWMutex
-	// inner is the lookup table for sri -> file path.
+	// inner is the lookup table for sri (or alias) -> file path.
 	inner map[string]string
 }
 
@@ -39,21 +41,30 @@ func NewCASStore() *CASStore {
 }
 
 // Get returns the file path for the given sri.
+// The sri may be an alias and may contain several space separated digests.
 func (c *CASStore) Get(sri string) (string, bool) {
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	path, ok := c.inner[sri]
-	return path, ok
+	if path, ok := c.inner[sri]; ok {
+		return path, true
+	}
+	for _, digest := range cas.Digests(sri) {
+		if path, ok := c.inner[digest]; ok {
+			return path, true
+		}
+	}
+	return "", false
 }
 
-// Set sets the file path for the given sri.
-// If the sri already exists, the old file path will be kept.
-func (c *CASStore) Set(sri, path string) {
+// Set sets the file path for the given sri and its aliases
+// (the sris of the same contents in other algorithms).
+// If the sri or an alias already exists, the old file path will be kept for it.
+func (c *CASStore) Set(sri, path string, aliases ...string) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	_, ok := c.inner[sri]
-	if ok {
-		return
+	for _, key := range append([]string{sri}, aliases...) {
+		if _, ok := c.inner[key]; !ok {
+			c.inner[key] = path
+		}
 	}
-	c.inner[sri] = path
 }
@@ -11,17 +11,19 @@ import (
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/kind"
 	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
 )
 
 type Source struct {
-	wg             sync.WaitGroup
-	inner          iofs.FS
-	casStore       *CASStore
-	sriAlgorithm   sri.Algorithm
-	nodeAttributes func(iofs.FileInfo) api.NodeAttributes
-	stripPrefix    string
-	nodes          chan next
-	stop           chan struct{}
+	wg              sync.WaitGroup
+	inner           iofs.FS
+	casStore        *CASStore
+	sriAlgorithm    sri.Algorithm
+	aliasAlgorithms []sri.Algorithm
+	nodeAttributes  func(iofs.FileInfo) api.NodeAttributes
+	stripPrefix     string
+	nodes           chan next
+	stop            chan struct{}
 }
 
 func (s *Source) Next() (api.SourceNode, error) {
@@ -85,10 +87,6 @@ func (s *Source) prepareNext(path string, d iofs.DirEntry, err error) next {
 		return next{Err: err}
 	}
 
-	if kind == api.KindRegular {
-		s.addToCAS(payload, path)
-	}
-
 	node := api.SourceNode{
 		Stat: api.Stat{
 			Name:       normalizePath(path, s.stripPrefix),
@@ -118,22 +116,24 @@ func (s *Source) payload(path, kind string) (string, error) {
 		}
 		return normalizeSymlinkTarget(target, s.stripPrefix), nil
 	case api.KindRegular:
-		f, err := s.inner.Open(path)
-		if err != nil {
-			return "", err
-		}
-		defer f.Close()
-		integrity, err := sri.FromReader(s.sriAlgorithm, f)
-		if err != nil {
-			return "", err
-		}
-		return integrity.String(), nil
+		return s.record(path)
 	}
 	return "", nil
 }
 
-func (s *Source) addToCAS(sri, path string) {
-	s.casStore.Set(sri, path)
+// record hashes the file and adds it to the CAS.
+func (s *Source) record(path string) (string, error) {
+	f, err := s.inner.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sris, err := cas.Hash(f, append([]sri.Algorithm{s.sriAlgorithm}, s.aliasAlgorithms...)...)
+	if err != nil {
+		return "", err
+	}
+	s.casStore.Set(sris[0], path, sris[1:]...)
+	return sris[0], nil
 }
 
 type next struct {
@@ -0,0 +1,34 @@
+// Package fsutil contains helpers shared by the sinks.
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// ReadLinkFS is a file system that can read the targets of symlinks.
+type ReadLinkFS interface {
+	fs.FS
+	Readlink(string) (string, error)
+}
+
+// HardlinkTarget returns the api.Stat of the regular file at the given path,
+// which is the target of a hardlink.
+// The file system must provide api.Stat (like the TreeFS of the core module).
+func HardlinkTarget(in fs.FS, path string) (api.Stat, error) {
+	info, err := fs.Stat(in, path)
+	if err != nil {
+		return api.Stat{}, err
+	}
+	stat, ok := info.Sys().(api.Stat)
+	if !ok {
+		return api.Stat{}, errors.New("fs does not provide api.Stat")
+	}
+	if stat.Kind != api.KindRegular {
+		return api.Stat{}, fmt.Errorf("hardlink target has kind %q", stat.Kind)
+	}
+	return stat, nil
+}
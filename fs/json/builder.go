@@ -0,0 +1,133 @@
+package json
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/cas/recorder"
+	"github.com/malt3/abstractfs-core/provider"
+	casdir "github.com/malt3/abstractfs/cas/dir"
+	cashttp "github.com/malt3/abstractfs/cas/http"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+type SourceBuilder struct {
+	// CASDir is the root of a dir CAS that holds the file contents.
+	CASDir string `abstractfs:"cas-dir"`
+	// CASURL is the base url of a CAS served over http (see the cas command) that holds the file contents.
+	CASURL string `abstractfs:"cas-url"`
+	// CASTimeout limits connecting to the CAS of CASURL and waiting for responses (e.g. "30s").
+	CASTimeout string `abstractfs:"cas-timeout"`
+	// CASRetries is the number of retries of failed requests to the CAS of CASURL.
+	// A negative number disables retries.
+	CASRetries int `abstractfs:"cas-retries"`
+	// CASRecord is the path to a recording of the file contents (see the --record-to flag of the json command).
+	// The recording is read into memory.
+	CASRecord string `abstractfs:"cas-record"`
+	// CAS is a custom CAS that holds the file contents.
+	// Exactly one of CASDir, CASURL, CASRecord and CAS must be set.
+	CAS api.CASReader
+	// Path is the path to the JSON file.
+	// Use "-" to read from stdin.
+	Path           string
+	IOReader       io.Reader
+	invalidOptions []string
+}
+
+// WithSourceRef sets the source reference.
+// For the json provider, the source reference is the path to the JSON file.
+func (b *SourceBuilder) WithSourceRef(ref string) provider.SourceBuilder {
+	b.Path = ref
+	return b
+}
+
+// WithCAS sets the CAS that holds the file contents.
+func (b *SourceBuilder) WithCAS(cas api.CASReader) *SourceBuilder {
+	b.CAS = cas
+	return b
+}
+
+func (b *SourceBuilder) WithIOReader(r io.Reader) *SourceBuilder {
+	b.IOReader = r
+	return b
+}
+
+// Build builds the options.
+func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	cas, casCloser, err := b.cas()
+	if err != nil {
+		return nil, nil, err
+	}
+	fileCloser := func() error { return nil }
+	switch {
+	case b.IOReader != nil:
+	case b.Path == "-":
+		b.IOReader = os.Stdin
+	default:
+		file, err := os.Open(b.Path)
+		if err != nil {
+			casCloser()
+			return nil, nil, err
+		}
+		fileCloser = file.Close
+		b.IOReader = file
+	}
+	return NewSource(bufio.NewReader(b.IOReader), cas), func() error {
+		return errors.Join(fileCloser(), casCloser())
+	}, nil
+}
+
+// cas returns the CAS that holds the file contents and a function that closes it.
+func (b *SourceBuilder) cas() (api.CASReader, api.CloseWaitFunc, error) {
+	noop := func() error { return nil }
+	switch {
+	case b.CAS != nil:
+		return b.CAS, noop, nil
+	case b.CASDir != "":
+		cas, err := casdir.NewCAS(b.CASDir, true)
+		return cas, noop, err
+	case b.CASURL != "":
+		backend := &cashttp.Provider{URL: b.CASURL, Timeout: b.CASTimeout, Retries: b.CASRetries}
+		return backend.CASReader()
+	}
+	file, err := os.Open(b.CASRecord)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening cas recording: %w", err)
+	}
+	defer file.Close()
+	cas := memory.NewCAS(false)
+	if err := recorder.New(cas, bufio.NewReader(file)).Consume(); err != nil {
+		return nil, nil, fmt.Errorf("reading cas recording: %w", err)
+	}
+	return cas, noop, nil
+}
+
+func (b *SourceBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path == "" && b.IOReader == nil {
+		return errors.New("must set either path or io.Reader")
+	}
+	var casOptions int
+	for _, set := range []bool{b.CAS != nil, b.CASDir != "", b.CASURL != "", b.CASRecord != ""} {
+		if set {
+			casOptions++
+		}
+	}
+	if casOptions != 1 {
+		return errors.New("must set exactly one of cas-dir, cas-url and cas-record")
+	}
+	if b.CASURL == "" && (b.CASTimeout != "" || b.CASRetries != 0) {
+		return errors.New("cas-timeout and cas-retries require cas-url")
+	}
+	return nil
+}
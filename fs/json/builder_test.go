@@ -0,0 +1,80 @@
+package json
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	coreprovider "github.com/malt3/abstractfs-core/provider"
+)
+
+func TestSourceBuilderCASURLOptions(t *testing.T) {
+	testCases := map[string]struct {
+		retries      string
+		wantRequests int64
+	}{
+		"retries disabled": {retries: "-1", wantRequests: 1},
+		"one retry":        {retries: "1", wantRequests: 2},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			builder := &SourceBuilder{}
+			if err := coreprovider.SetOptions(builder, map[string]string{
+				"cas-url":     server.URL,
+				"cas-retries": tc.retries,
+				"cas-timeout": "5s",
+			}); err != nil {
+				t.Fatal(err)
+			}
+			_, closeSource, err := builder.WithIOReader(strings.NewReader("")).Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeSource()
+			cas, closeCAS, err := builder.cas()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeCAS()
+			if _, err := cas.Open("sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="); err == nil {
+				t.Fatal("Open() succeeded, want error")
+			}
+			if got := requests.Load(); got != tc.wantRequests {
+				t.Errorf("got %d requests, want %d", got, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSourceBuilderCheck(t *testing.T) {
+	testCases := map[string]struct {
+		builder SourceBuilder
+		wantErr bool
+	}{
+		"cas-url with options": {builder: SourceBuilder{Path: "-", CASURL: "http://localhost", CASTimeout: "1s", CASRetries: 1}},
+		"no cas":               {builder: SourceBuilder{Path: "-"}, wantErr: true},
+		"two cas":              {builder: SourceBuilder{Path: "-", CASDir: "/cas", CASURL: "http://localhost"}, wantErr: true},
+		"timeout without url":  {builder: SourceBuilder{Path: "-", CASDir: "/cas", CASTimeout: "1s"}, wantErr: true},
+		"retries without url":  {builder: SourceBuilder{Path: "-", CASDir: "/cas", CASRetries: 1}, wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.builder.check()
+			if tc.wantErr && err == nil {
+				t.Error("check() succeeded, want error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("check() = %v, want success", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package json
+
+import (
+	encodingjson "encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// stat is the JSON representation of an api.Stat.
+// It matches the encoding of the core module, with two differences:
+// mtimes keep sub-second precision (so archives can be rebuilt exactly)
+// and nodes other than regular files can be decoded (they have no size).
+type stat struct {
+	Name       string     `json:"name"`
+	Kind       string     `json:"kind"`
+	Attributes attributes `json:"attributes"`
+	Payload    string     `json:"payload,omitempty"`
+	Size       string     `json:"size,omitempty"`
+}
+
+type attributes struct {
+	Mtime     string            `json:"mtime,omitempty"`
+	UserID    string            `json:"uid,omitempty"`
+	GroupID   string            `json:"gid,omitempty"`
+	UserName  string            `json:"uname,omitempty"`
+	GroupName string            `json:"gname,omitempty"`
+	Mode      string            `json:"mode,omitempty"`
+	XAttrs    map[string]string `json:"xattrs,omitempty"`
+}
+
+// Encode writes the nodes of a flattened tree as JSON array.
+func Encode(w io.Writer, files []api.Stat) error {
+	stats := make([]stat, len(files))
+	for i, file := range files {
+		stats[i] = fromStat(file)
+	}
+	return encodingjson.NewEncoder(w).Encode(stats)
+}
+
+func fromStat(s api.Stat) stat {
+	var mtime, size string
+	if !s.Attributes.Mtime.IsZero() {
+		mtime = s.Attributes.Mtime.UTC().Format(time.RFC3339Nano)
+	}
+	if s.Kind == api.KindRegular {
+		size = strconv.FormatInt(s.Size, 10)
+	}
+	return stat{
+		Name: s.Name,
+		Kind: s.Kind,
+		Attributes: attributes{
+			Mtime:     mtime,
+			UserID:    s.Attributes.UserID,
+			GroupID:   s.Attributes.GroupID,
+			UserName:  s.Attributes.UserName,
+			GroupName: s.Attributes.GroupName,
+			Mode:      s.Attributes.Mode,
+			XAttrs:    s.Attributes.XAttrs,
+		},
+		Payload: s.Payload,
+		Size:    size,
+	}
+}
+
+func (s stat) toStat() (api.Stat, error) {
+	var mtime time.Time
+	if s.Attributes.Mtime != "" {
+		var err error
+		// RFC3339Nano also accepts timestamps without fractional seconds
+		if mtime, err = time.Parse(time.RFC3339Nano, s.Attributes.Mtime); err != nil {
+			return api.Stat{}, fmt.Errorf("parsing mtime of %q: %w", s.Name, err)
+		}
+	}
+	var size int64
+	if s.Size != "" {
+		var err error
+		if size, err = strconv.ParseInt(s.Size, 10, 64); err != nil {
+			return api.Stat{}, fmt.Errorf("parsing size of %q: %w", s.Name, err)
+		}
+	}
+	return api.Stat{
+		Name: s.Name,
+		Kind: s.Kind,
+		Attributes: api.NodeAttributes{
+			Mtime:     mtime,
+			UserID:    s.Attributes.UserID,
+			GroupID:   s.Attributes.GroupID,
+			UserName:  s.Attributes.UserName,
+			GroupName: s.Attributes.GroupName,
+			Mode:      s.Attributes.Mode,
+			XAttrs:    s.Attributes.XAttrs,
+		},
+		Payload: s.Payload,
+		Size:    size,
+	}, nil
+}
@@ -0,0 +1,36 @@
+package json
+
+import (
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+)
+
+// Provider reads file systems from the JSON representation written by the json command.
+// File contents are read from a CAS.
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "json"
+}
+
+func (p Provider) SourceBuilder() provider.SourceBuilder {
+	return &SourceBuilder{}
+}
+
+func (p Provider) SinkBuilder() provider.SinkBuilder {
+	return &provider.UnsupportedSinkBuilder{}
+}
+
+func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+var _ provider.Provider = (*Provider)(nil)
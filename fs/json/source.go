@@ -0,0 +1,80 @@
+package json
+
+import (
+	encodingjson "encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// Source reads the nodes of a flattened tree (a JSON array of api.Stat).
+// The JSON is decoded while the nodes are read.
+type Source struct {
+	decoder *encodingjson.Decoder
+	cas     api.CASReader
+	started bool
+	done    bool
+}
+
+// NewSource returns a source that decodes a flattened tree from the reader
+// and opens file contents from the CAS.
+// The CAS may be nil if file contents are not read.
+func NewSource(r io.Reader, cas api.CASReader) *Source {
+	return &Source{decoder: encodingjson.NewDecoder(r), cas: cas}
+}
+
+func (s *Source) Next() (api.SourceNode, error) {
+	if s.done {
+		return api.SourceNode{}, io.EOF
+	}
+	if !s.started {
+		if err := s.expectDelim('['); err != nil {
+			return api.SourceNode{}, err
+		}
+		s.started = true
+	}
+	if !s.decoder.More() {
+		if err := s.expectDelim(']'); err != nil {
+			return api.SourceNode{}, err
+		}
+		s.done = true
+		return api.SourceNode{}, io.EOF
+	}
+	var raw stat
+	if err := s.decoder.Decode(&raw); err != nil {
+		return api.SourceNode{}, fmt.Errorf("decoding node: %w", err)
+	}
+	stat, err := raw.toStat()
+	if err != nil {
+		return api.SourceNode{}, err
+	}
+	node := api.SourceNode{Stat: stat}
+	if stat.Kind == api.KindRegular {
+		node.Open = func() (io.ReadCloser, error) {
+			return s.Open(stat.Payload)
+		}
+	}
+	return node, nil
+}
+
+// Open opens a blob from the CAS.
+func (s *Source) Open(sri string) (io.ReadCloser, error) {
+	return s.cas.Open(sri)
+}
+
+func (s *Source) expectDelim(delim encodingjson.Delim) error {
+	token, err := s.decoder.Token()
+	if err != nil {
+		return fmt.Errorf("decoding json: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("decoding json: expected %q, got %v", delim, token)
+	}
+	return nil
+}
+
+var (
+	_ api.Source    = (*Source)(nil)
+	_ api.CASReader = (*Source)(nil)
+)
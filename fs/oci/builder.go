@@ -0,0 +1,310 @@
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	casdir "github.com/malt3/abstractfs/cas/dir"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/fs/tar"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+type SourceBuilder struct {
+	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
+	// AliasAlgorithms are the algorithms that file contents are additionally hashed with,
+	// so the CAS of the source can open them by their sris in these algorithms, too.
+	AliasAlgorithms []sri.Algorithm `abstractfs:"cas-aliases"`
+	// Platform selects the image of a multi-platform image by its platform ("os/arch[/variant]").
+	// By default, the only image of the layout is used regardless of its platform.
+	// If the layout contains multiple images, the image for linux and the current architecture is used.
+	Platform string `abstractfs:"platform"`
+	// RefName selects an image by name.
+	// It matches the "org.opencontainers.image.ref.name" and "io.containerd.image.name"
+	// annotations of the image index or the repo tags of a docker save tarball.
+	RefName string `abstractfs:"ref-name"`
+	// Spill selects where file contents of the layers are kept.
+	// Valid values are tar.SpillMemory and tar.SpillTempDir.
+	// By default, tar.SpillTempDir is used, since the layers of an image may be larger than the memory.
+	// Spill is ignored if SpillCAS is set.
+	Spill string `abstractfs:"spill"`
+	// SpillCAS is a custom CAS that file contents of the layers are copied to.
+	// It cannot be combined with AliasAlgorithms, since the blobs may outlive the source
+	// but the alias index does not (see cas.AliasCAS).
+	// A custom spill CAS can index aliases itself instead, like memory.CAS.WithAliases.
+	SpillCAS api.CAS
+	// Path is the path to the image layout directory or tarball.
+	Path           string
+	invalidOptions []string
+}
+
+// WithSourceRef sets the source reference.
+// For the oci provider, the source reference is the path to an OCI image layout
+// (directory or tarball) or a docker save tarball.
+func (b *SourceBuilder) WithSourceRef(ref string) provider.SourceBuilder {
+	b.Path = ref
+	return b
+}
+
+func (b *SourceBuilder) WithSRIAlgorithm(alg sri.Algorithm) *SourceBuilder {
+	b.SRIAlgorithm = alg
+	return b
+}
+
+// WithAliasAlgorithms sets the algorithms that file contents are additionally hashed with.
+func (b *SourceBuilder) WithAliasAlgorithms(algorithms ...sri.Algorithm) *SourceBuilder {
+	b.AliasAlgorithms = algorithms
+	return b
+}
+
+// WithPlatform sets the platform of the image ("os/arch[/variant]").
+func (b *SourceBuilder) WithPlatform(platform string) *SourceBuilder {
+	b.Platform = platform
+	return b
+}
+
+// WithRefName selects an image by name.
+func (b *SourceBuilder) WithRefName(refName string) *SourceBuilder {
+	b.RefName = refName
+	return b
+}
+
+// WithSpillCAS sets the CAS that file contents of the layers are copied to.
+func (b *SourceBuilder) WithSpillCAS(cas api.CAS) *SourceBuilder {
+	b.SpillCAS = cas
+	return b
+}
+
+// Build builds the options.
+func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	selector := imageSelector{platformSet: b.Platform != "", refName: b.RefName}
+	platform := b.Platform
+	if platform == "" {
+		platform = defaultPlatform()
+	}
+	var err error
+	if selector.platform, err = parsePlatform(platform); err != nil {
+		return nil, nil, err
+	}
+
+	layout, closeLayout, err := openLayout(b.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := selectImage(layout, selector)
+	if err != nil {
+		closeLayout()
+		return nil, nil, err
+	}
+	spill, spillCloser, err := b.spillCAS()
+	if err != nil {
+		closeLayout()
+		return nil, nil, err
+	}
+	if len(b.AliasAlgorithms) > 0 {
+		spill = cas.NewAliasCAS(spill, b.AliasAlgorithms...)
+	}
+	source := &Source{
+		layout:       layout,
+		image:        img,
+		spill:        spill,
+		holes:        make(map[string]sparse.HoleMap),
+		sriAlgorithm: b.SRIAlgorithm,
+	}
+	return source, func() error {
+		return errors.Join(closeLayout(), spillCloser())
+	}, nil
+}
+
+// spillCAS returns the CAS that file contents of the layers are kept in.
+func (b *SourceBuilder) spillCAS() (api.CAS, api.CloseWaitFunc, error) {
+	nopCloser := func() error { return nil }
+	if b.SpillCAS != nil {
+		return b.SpillCAS, nopCloser, nil
+	}
+	switch b.Spill {
+	case tar.SpillTempDir:
+		tempDir, err := os.MkdirTemp("", "abstractfs-oci-*")
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating spill directory: %w", err)
+		}
+		cas, err := casdir.NewCAS(tempDir, false)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return nil, nil, err
+		}
+		return cas, func() error { return os.RemoveAll(tempDir) }, nil
+	}
+	return memory.NewCAS(false), nopCloser, nil
+}
+
+func (b *SourceBuilder) applyDefaults() {
+	if b.SRIAlgorithm == "" {
+		b.SRIAlgorithm = sri.SHA256
+	}
+	if b.Spill == "" {
+		b.Spill = tar.SpillTempDir
+	}
+}
+
+func (b *SourceBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path == "" {
+		return errors.New("must set path")
+	}
+	if b.Spill != tar.SpillMemory && b.Spill != tar.SpillTempDir {
+		return fmt.Errorf("unsupported spill %q", b.Spill)
+	}
+	if b.SpillCAS != nil && len(b.AliasAlgorithms) > 0 {
+		return errors.New("cas-aliases cannot be combined with a custom spill CAS")
+	}
+	return nil
+}
+
+type SinkBuilder struct {
+	// Platform is the platform of the image ("os/arch[/variant]").
+	// By default, linux and the current architecture is used.
+	Platform string `abstractfs:"platform"`
+	// Compression is the compression algorithm of the layer.
+	// Valid values are tar.CompressionNone, tar.CompressionGzip and tar.CompressionZstd.
+	// By default, the layer is compressed with gzip.
+	Compression string `abstractfs:"compression"`
+	// RefName is the name of the image.
+	// It is stored as "org.opencontainers.image.ref.name" annotation in the image index.
+	RefName string `abstractfs:"ref-name"`
+	// SkipUnsupported skips nodes that the layer tar cannot represent (sockets).
+	// By default, such nodes are an error (see kind.ErrUnsupported).
+	SkipUnsupported bool `abstractfs:"skip-unsupported"`
+	// Path is the path of the image layout directory.
+	// The directory is created if it does not exist.
+	// An existing index.json is replaced.
+	Path           string
+	invalidOptions []string
+}
+
+// WithSinkRef sets the sink reference.
+// For the oci provider, the sink reference is the path to the image layout directory.
+func (b *SinkBuilder) WithSinkRef(ref string) provider.SinkBuilder {
+	b.Path = ref
+	return b
+}
+
+// Set sets a option.
+func (b *SinkBuilder) Set(key string, value any) provider.SinkBuilder {
+	switch key {
+	case "platform":
+		platform, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Platform = platform
+	case "compression":
+		compression, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Compression = compression
+	case "ref-name":
+		refName, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.RefName = refName
+	case "skip-unsupported":
+		skipUnsupported, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.SkipUnsupported = skipUnsupported
+	default:
+		b.invalidOptions = append(b.invalidOptions, key)
+	}
+	return b
+}
+
+// WithPlatform sets the platform of the image ("os/arch[/variant]").
+func (b *SinkBuilder) WithPlatform(platform string) *SinkBuilder {
+	b.Platform = platform
+	return b
+}
+
+// WithCompression sets the compression algorithm of the layer.
+func (b *SinkBuilder) WithCompression(compression string) *SinkBuilder {
+	b.Compression = compression
+	return b
+}
+
+// WithRefName sets the name of the image.
+func (b *SinkBuilder) WithRefName(refName string) *SinkBuilder {
+	b.RefName = refName
+	return b
+}
+
+// WithSkipUnsupported skips nodes that the layer tar cannot represent instead of failing.
+func (b *SinkBuilder) WithSkipUnsupported(skipUnsupported bool) *SinkBuilder {
+	b.SkipUnsupported = skipUnsupported
+	return b
+}
+
+// Build builds the options.
+func (b *SinkBuilder) Build() (api.Sink, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	platform, err := parsePlatform(b.Platform)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(b.Path, 0o755); err != nil {
+		return nil, nil, err
+	}
+	sink := &Sink{
+		path:            b.Path,
+		platform:        platform,
+		compression:     b.Compression,
+		refName:         b.RefName,
+		skipUnsupported: b.SkipUnsupported,
+	}
+	return sink, func() error { return nil }, nil
+}
+
+func (b *SinkBuilder) applyDefaults() {
+	if b.Platform == "" {
+		b.Platform = defaultPlatform()
+	}
+	if b.Compression == "" {
+		b.Compression = tar.CompressionGzip
+	}
+}
+
+func (b *SinkBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path == "" {
+		return errors.New("must set path")
+	}
+	switch b.Compression {
+	case tar.CompressionNone, tar.CompressionGzip, tar.CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported layer compression %q", b.Compression)
+	}
+	return nil
+}
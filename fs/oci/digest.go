@@ -0,0 +1,118 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// newDigester returns the hash for the algorithm of an OCI digest ("<algorithm>:<hex>").
+func newDigester(digest string) (hash.Hash, string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid digest %q", digest)
+	}
+	var digester hash.Hash
+	switch algorithm {
+	case "sha256":
+		digester = sha256.New()
+	case "sha512":
+		digester = sha512.New()
+	default:
+		return nil, "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	if len(encoded) != 2*digester.Size() || strings.ToLower(encoded) != encoded {
+		return nil, "", fmt.Errorf("invalid digest %q", digest)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return nil, "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return digester, encoded, nil
+}
+
+// blobPath returns the path of a blob in the image layout.
+func blobPath(digest string) (string, error) {
+	if _, _, err := newDigester(digest); err != nil {
+		return "", err
+	}
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return "blobs/" + algorithm + "/" + encoded, nil
+}
+
+// verifyingReader verifies the digest and size of a blob while it is read.
+type verifyingReader struct {
+	r        io.Reader
+	digester hash.Hash
+	digest   string
+	encoded  string
+	// size is the expected size of the blob or -1 if it is unknown.
+	size int64
+	read int64
+}
+
+func newVerifyingReader(r io.Reader, digest string, size int64) (*verifyingReader, error) {
+	digester, encoded, err := newDigester(digest)
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{r: r, digester: digester, digest: digest, encoded: encoded, size: size}, nil
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.digester.Write(p[:n])
+	v.read += int64(n)
+	if v.size >= 0 && v.read > v.size {
+		return n, fmt.Errorf("blob %s is larger than %d bytes", v.digest, v.size)
+	}
+	return n, err
+}
+
+// verify reads the rest of the blob and checks its digest and size.
+func (v *verifyingReader) verify() error {
+	if _, err := io.Copy(io.Discard, v); err != nil {
+		return err
+	}
+	if v.size >= 0 && v.read != v.size {
+		return fmt.Errorf("blob %s has size %d, expected %d", v.digest, v.read, v.size)
+	}
+	if hex.EncodeToString(v.digester.Sum(nil)) != v.encoded {
+		return fmt.Errorf("blob %s: digest mismatch", v.digest)
+	}
+	return nil
+}
+
+// readBlob reads a small blob (like a manifest or config) into memory and verifies it.
+func readBlob(l layout, desc descriptor) ([]byte, error) {
+	path, err := blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if desc.Size > maxMetadataSize {
+		return nil, fmt.Errorf("blob %s is too large (%d bytes)", desc.Digest, desc.Size)
+	}
+	blob, err := l.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	verifier, err := newVerifyingReader(blob, desc.Digest, desc.Size)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(verifier)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifier.verify(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// maxMetadataSize limits the size of indexes, manifests and configs.
+const maxMetadataSize = 16 * 1024 * 1024
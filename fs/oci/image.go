@@ -0,0 +1,320 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// image is an image selected from a layout.
+type image struct {
+	config imageConfig
+	layers []layer
+}
+
+// layer is a layer blob of an image.
+type layer struct {
+	// path is the path of the blob in the layout.
+	path string
+	// digest is the expected digest of the blob.
+	digest string
+	// size is the expected size of the blob or -1 if it is unknown.
+	size int64
+}
+
+// imageSelector selects one image of a layout.
+type imageSelector struct {
+	// platform is the platform of the image.
+	platform platform
+	// platformSet is false if the platform was not set explicitly.
+	// In that case, the only image of a layout is selected regardless of its platform.
+	platformSet bool
+	// refName selects images by name. If empty, all images are candidates.
+	refName string
+}
+
+// candidate is an image that may be selected.
+type candidate struct {
+	// name identifies the image in error messages.
+	name string
+	// platform returns the platform of the image.
+	platform func() (platform, error)
+	// load loads the image.
+	load func() (image, error)
+}
+
+// selectImage selects an image of an OCI image layout or docker save tarball.
+// If both index.json and manifest.json exist (docker save since Docker 25),
+// the OCI index is used.
+func selectImage(l layout, selector imageSelector) (image, error) {
+	candidates, err := ociCandidates(l, selector)
+	if errors.Is(err, fs.ErrNotExist) {
+		candidates, err = dockerCandidates(l, selector)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return image{}, fmt.Errorf("neither %s nor %s found: not an image layout or docker save tarball", indexFile, dockerIndexFile)
+	}
+	if err != nil {
+		return image{}, err
+	}
+	if len(candidates) == 0 {
+		if selector.refName != "" {
+			return image{}, fmt.Errorf("no image named %q", selector.refName)
+		}
+		return image{}, errors.New("no images found")
+	}
+	if len(candidates) == 1 && !selector.platformSet {
+		return candidates[0].load()
+	}
+
+	var matches []candidate
+	var available []string
+	for _, c := range candidates {
+		p, err := c.platform()
+		if err != nil {
+			return image{}, err
+		}
+		available = append(available, p.String())
+		if p.matches(selector.platform) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		sort.Strings(available)
+		return image{}, fmt.Errorf("no image for platform %s (available: %s)", selector.platform, strings.Join(available, ", "))
+	case 1:
+		return matches[0].load()
+	}
+	names := make([]string, len(matches))
+	for i, c := range matches {
+		names[i] = c.name
+	}
+	return image{}, fmt.Errorf("multiple images for platform %s (%s): select one by name", selector.platform, strings.Join(names, ", "))
+}
+
+// ociCandidates returns the image manifests of index.json, including manifests of nested indexes.
+func ociCandidates(l layout, selector imageSelector) ([]candidate, error) {
+	file, err := l.open(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	var root index
+	err = decodeJSON(file, &root)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", indexFile, err)
+	}
+
+	var descriptors []descriptor
+	for _, desc := range root.Manifests {
+		if selector.refName == "" || hasRefName(desc, selector.refName) {
+			descriptors = append(descriptors, desc)
+		}
+	}
+	manifests, err := expandIndexes(l, descriptors, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []candidate
+	seen := make(map[string]struct{})
+	for _, desc := range manifests {
+		desc := desc
+		// the same manifest may be referenced by multiple names
+		if _, ok := seen[desc.Digest]; ok {
+			continue
+		}
+		seen[desc.Digest] = struct{}{}
+		var loaded *image
+		load := func() (image, error) {
+			if loaded != nil {
+				return *loaded, nil
+			}
+			img, err := loadOCIImage(l, desc)
+			if err != nil {
+				return image{}, err
+			}
+			loaded = &img
+			return img, nil
+		}
+		candidates = append(candidates, candidate{
+			name: desc.Digest,
+			platform: func() (platform, error) {
+				if desc.Platform != nil {
+					return *desc.Platform, nil
+				}
+				img, err := load()
+				if err != nil {
+					return platform{}, err
+				}
+				return img.config.platform(), nil
+			},
+			load: load,
+		})
+	}
+	return candidates, nil
+}
+
+// expandIndexes replaces nested indexes by the manifests they reference.
+func expandIndexes(l layout, descriptors []descriptor, depth int) ([]descriptor, error) {
+	if depth > maxIndexDepth {
+		return nil, errors.New("image indexes are nested too deeply")
+	}
+	var manifests []descriptor
+	for _, desc := range descriptors {
+		switch desc.MediaType {
+		case MediaTypeImageManifest, MediaTypeDockerManifest:
+			manifests = append(manifests, desc)
+		case MediaTypeImageIndex, MediaTypeDockerManifestList:
+			data, err := readBlob(l, desc)
+			if err != nil {
+				return nil, err
+			}
+			var nested index
+			if err := json.Unmarshal(data, &nested); err != nil {
+				return nil, fmt.Errorf("parsing index %s: %w", desc.Digest, err)
+			}
+			expanded, err := expandIndexes(l, nested.Manifests, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, expanded...)
+		}
+		// other artifacts (like attestations) are skipped
+	}
+	return manifests, nil
+}
+
+// loadOCIImage reads the manifest and config of an image.
+func loadOCIImage(l layout, desc descriptor) (image, error) {
+	data, err := readBlob(l, desc)
+	if err != nil {
+		return image{}, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return image{}, fmt.Errorf("parsing manifest %s: %w", desc.Digest, err)
+	}
+	data, err = readBlob(l, m.Config)
+	if err != nil {
+		return image{}, err
+	}
+	var config imageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return image{}, fmt.Errorf("parsing config %s: %w", m.Config.Digest, err)
+	}
+	img := image{config: config}
+	for _, layerDesc := range m.Layers {
+		path, err := blobPath(layerDesc.Digest)
+		if err != nil {
+			return image{}, err
+		}
+		img.layers = append(img.layers, layer{path: path, digest: layerDesc.Digest, size: layerDesc.Size})
+	}
+	return img, nil
+}
+
+// dockerCandidates returns the images of the manifest.json of a docker save tarball.
+func dockerCandidates(l layout, selector imageSelector) ([]candidate, error) {
+	file, err := l.open(dockerIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	var entries []dockerManifest
+	err = decodeJSON(file, &entries)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", dockerIndexFile, err)
+	}
+
+	var candidates []candidate
+	for _, entry := range entries {
+		if selector.refName != "" && !containsString(entry.RepoTags, selector.refName) {
+			continue
+		}
+		entry := entry
+		var loaded *image
+		load := func() (image, error) {
+			if loaded != nil {
+				return *loaded, nil
+			}
+			img, err := loadDockerImage(l, entry)
+			if err != nil {
+				return image{}, err
+			}
+			loaded = &img
+			return img, nil
+		}
+		name := entry.Config
+		if len(entry.RepoTags) > 0 {
+			name = entry.RepoTags[0]
+		}
+		candidates = append(candidates, candidate{
+			name: name,
+			platform: func() (platform, error) {
+				img, err := load()
+				if err != nil {
+					return platform{}, err
+				}
+				return img.config.platform(), nil
+			},
+			load: load,
+		})
+	}
+	return candidates, nil
+}
+
+// loadDockerImage reads the config of an image of a docker save tarball.
+// Layers of docker save tarballs are uncompressed, so they are verified using the diff IDs.
+func loadDockerImage(l layout, entry dockerManifest) (image, error) {
+	file, err := l.open(entry.Config)
+	if err != nil {
+		return image{}, err
+	}
+	var config imageConfig
+	err = decodeJSON(file, &config)
+	file.Close()
+	if err != nil {
+		return image{}, fmt.Errorf("parsing config %s: %w", entry.Config, err)
+	}
+	if len(config.RootFS.DiffIDs) != len(entry.Layers) {
+		return image{}, fmt.Errorf("config %s has %d diff IDs, but the image has %d layers", entry.Config, len(config.RootFS.DiffIDs), len(entry.Layers))
+	}
+	img := image{config: config}
+	for i, path := range entry.Layers {
+		img.layers = append(img.layers, layer{path: path, digest: config.RootFS.DiffIDs[i], size: -1})
+	}
+	return img, nil
+}
+
+func (c imageConfig) platform() platform {
+	return platform{OS: c.OS, Architecture: c.Architecture, Variant: c.Variant}
+}
+
+func hasRefName(desc descriptor, refName string) bool {
+	return desc.Annotations[AnnotationRefName] == refName || desc.Annotations[annotationContainerdImageName] == refName
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeJSON(r io.Reader, v any) error {
+	return json.NewDecoder(io.LimitReader(r, maxMetadataSize)).Decode(v)
+}
+
+const (
+	// annotationContainerdImageName is the full image name that is set by docker save.
+	annotationContainerdImageName = "io.containerd.image.name"
+	maxIndexDepth                 = 8
+)
@@ -0,0 +1,121 @@
+package oci
+
+import (
+	archivetar "archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	stdpath "path"
+	"path/filepath"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// layout gives access to the files of an image layout or a docker save tarball.
+type layout interface {
+	// open opens a file by its slash separated path relative to the root of the layout.
+	open(name string) (io.ReadCloser, error)
+}
+
+// openLayout opens an image layout directory or a tarball.
+// Tarballs are indexed once and files are read directly from the tarball,
+// so the tarball must be a regular (uncompressed) file.
+func openLayout(path string) (layout, api.CloseWaitFunc, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return dirLayout{root: path}, func() error { return nil }, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	tarball, err := newTarLayout(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("reading image tarball %s: %w", path, err)
+	}
+	return tarball, file.Close, nil
+}
+
+// dirLayout is an image layout directory.
+type dirLayout struct {
+	root string
+}
+
+func (l dirLayout) open(name string) (io.ReadCloser, error) {
+	// names are cleaned as absolute paths, so they cannot escape the root
+	return os.Open(filepath.Join(l.root, filepath.FromSlash(stdpath.Clean("/"+name))))
+}
+
+// tarLayout is an image layout or docker save tarball.
+type tarLayout struct {
+	file io.ReaderAt
+	// files maps the name of every regular file to its section of the tarball.
+	files map[string]tarSection
+	// links maps the name of every symlink and hardlink to its target.
+	// docker save uses symlinks for layers that are shared between images.
+	links map[string]string
+}
+
+type tarSection struct {
+	offset, size int64
+}
+
+// newTarLayout indexes the files of a tarball.
+func newTarLayout(file io.ReadSeeker) (*tarLayout, error) {
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("tarball does not support random access")
+	}
+	l := &tarLayout{
+		file:  readerAt,
+		files: make(map[string]tarSection),
+		links: make(map[string]string),
+	}
+	reader := archivetar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := stdpath.Clean("/" + header.Name)
+		switch header.Typeflag {
+		case archivetar.TypeReg:
+			// archive/tar stops reading at the start of the file contents
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			l.files[name] = tarSection{offset: offset, size: header.Size}
+		case archivetar.TypeSymlink:
+			l.links[name] = stdpath.Join(stdpath.Dir(name), header.Linkname)
+		case archivetar.TypeLink:
+			l.links[name] = stdpath.Clean("/" + header.Linkname)
+		}
+	}
+	return l, nil
+}
+
+func (l *tarLayout) open(name string) (io.ReadCloser, error) {
+	name = stdpath.Clean("/" + name)
+	// follow a bounded number of links to prevent loops
+	for i := 0; i < 16; i++ {
+		if section, ok := l.files[name]; ok {
+			return io.NopCloser(io.NewSectionReader(l.file, section.offset, section.size)), nil
+		}
+		target, ok := l.links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
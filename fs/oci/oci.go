@@ -0,0 +1,108 @@
+// Package oci implements a source and sink for container images in the OCI image layout.
+//
+// The source reads an OCI image layout (directory or tarball) or a docker save tarball,
+// applies the layers of the selected image in order and yields the flattened root filesystem.
+// The sink writes the file system as single-layer image in the OCI image layout.
+package oci
+
+import (
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+)
+
+type Provider struct{}
+
+func (p Provider) Name() string {
+	return "oci"
+}
+
+func (p Provider) SourceBuilder() provider.SourceBuilder {
+	return &SourceBuilder{}
+}
+
+func (p Provider) SinkBuilder() provider.SinkBuilder {
+	return &SinkBuilder{}
+}
+
+func (p Provider) CAS() (api.CAS, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+func (p Provider) CASReader() (api.CASReader, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+func (p Provider) CASWriter() (api.CASWriter, api.CloseWaitFunc, error) {
+	return nil, nil, provider.ErrUnsupported
+}
+
+var _ provider.Provider = (*Provider)(nil)
+
+// Media types of the OCI image spec and the docker image manifest v2.
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer         = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip     = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd     = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// AnnotationRefName is the annotation of the image index that holds the name of an image.
+const AnnotationRefName = "org.opencontainers.image.ref.name"
+
+// descriptor describes a blob.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// index is an image index (index.json of the image layout or a nested index).
+type index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+// manifest is an image manifest.
+type manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        descriptor   `json:"config"`
+	Layers        []descriptor `json:"layers"`
+}
+
+// imageConfig is the subset of the image config that is used by the source and sink.
+type imageConfig struct {
+	Architecture string         `json:"architecture"`
+	OS           string         `json:"os"`
+	Variant      string         `json:"variant,omitempty"`
+	Config       map[string]any `json:"config"`
+	RootFS       rootFS         `json:"rootfs"`
+}
+
+type rootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// dockerManifest is an entry of the manifest.json of a docker save tarball.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// Well-known files of the image layout and of docker save tarballs.
+const (
+	layoutFile      = "oci-layout"
+	layoutVersion   = "1.0.0"
+	indexFile       = "index.json"
+	dockerIndexFile = "manifest.json"
+)
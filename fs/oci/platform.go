@@ -0,0 +1,48 @@
+package oci
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// platform describes the platform an image runs on.
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// parsePlatform parses a platform selector of the form "os/arch[/variant]".
+func parsePlatform(s string) (platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return platform{}, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", s)
+	}
+	p := platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// defaultPlatform returns the platform of linux images for the current architecture.
+func defaultPlatform() string {
+	return "linux/" + runtime.GOARCH
+}
+
+// matches returns true if the platform of an image matches the selector.
+// An empty variant of the selector matches every variant.
+func (p platform) matches(selector platform) bool {
+	if p.OS != selector.OS || p.Architecture != selector.Architecture {
+		return false
+	}
+	return selector.Variant == "" || p.Variant == selector.Variant
+}
+
+func (p platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
@@ -0,0 +1,176 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/fs/tar"
+)
+
+// Sink writes a file system as single-layer image in the OCI image layout.
+type Sink struct {
+	path        string
+	platform    platform
+	compression string
+	refName     string
+	// skipUnsupported skips nodes that the layer tar cannot represent.
+	skipUnsupported bool
+}
+
+func (s *Sink) Consume(in fs.FS) error {
+	blobs := filepath.Join(s.path, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0o755); err != nil {
+		return err
+	}
+	layerDesc, diffID, err := s.writeLayer(blobs, in)
+	if err != nil {
+		return err
+	}
+	config := imageConfig{
+		Architecture: s.platform.Architecture,
+		OS:           s.platform.OS,
+		Variant:      s.platform.Variant,
+		Config:       map[string]any{},
+		RootFS:       rootFS{Type: "layers", DiffIDs: []string{diffID}},
+	}
+	configDesc, err := writeJSONBlob(blobs, MediaTypeImageConfig, config)
+	if err != nil {
+		return err
+	}
+	manifestDesc, err := writeJSONBlob(blobs, MediaTypeImageManifest, manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Config:        configDesc,
+		Layers:        []descriptor{layerDesc},
+	})
+	if err != nil {
+		return err
+	}
+	manifestDesc.Platform = &s.platform
+	if s.refName != "" {
+		manifestDesc.Annotations = map[string]string{AnnotationRefName: s.refName}
+	}
+
+	indexData, err := json.Marshal(index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageIndex,
+		Manifests:     []descriptor{manifestDesc},
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.path, indexFile), indexData, 0o644); err != nil {
+		return err
+	}
+	layoutData, err := json.Marshal(struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}{layoutVersion})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.path, layoutFile), layoutData, 0o644)
+}
+
+// writeLayer writes the file system as (compressed) layer tar.
+// It returns the descriptor of the layer blob and the diff ID
+// (the digest of the uncompressed tar).
+func (s *Sink) writeLayer(blobs string, in fs.FS) (descriptor, string, error) {
+	temp, err := os.CreateTemp(blobs, ".layer-*")
+	if err != nil {
+		return descriptor{}, "", err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	blob := newDigestWriter(temp)
+	compressor, err := tar.NewCompressor(s.compression, 0, blob)
+	if err != nil {
+		return descriptor{}, "", err
+	}
+	diff := newDigestWriter(compressor)
+	builder := &tar.SinkBuilder{}
+	layerSink, closeLayerSink, err := builder.WithIOWriter(diff).WithSkipUnsupported(s.skipUnsupported).Build()
+	if err != nil {
+		return descriptor{}, "", err
+	}
+	if err := layerSink.Consume(in); err != nil {
+		closeLayerSink()
+		return descriptor{}, "", err
+	}
+	if err := errors.Join(closeLayerSink(), compressor.Close(), temp.Close()); err != nil {
+		return descriptor{}, "", err
+	}
+	digest := blob.digest()
+	if err := os.Rename(temp.Name(), filepath.Join(blobs, blob.encoded())); err != nil {
+		return descriptor{}, "", err
+	}
+	return descriptor{
+		MediaType: layerMediaType(s.compression),
+		Digest:    digest,
+		Size:      blob.size,
+	}, diff.digest(), nil
+}
+
+// writeJSONBlob writes a JSON document as blob.
+func writeJSONBlob(blobs, mediaType string, v any) (descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return descriptor{}, err
+	}
+	sum := sha256.Sum256(data)
+	encoded := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(blobs, encoded), data, 0o644); err != nil {
+		return descriptor{}, err
+	}
+	return descriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + encoded,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+func layerMediaType(compression string) string {
+	switch compression {
+	case tar.CompressionGzip:
+		return MediaTypeLayerGzip
+	case tar.CompressionZstd:
+		return MediaTypeLayerZstd
+	}
+	return MediaTypeLayer
+}
+
+// digestWriter calculates the sha256 digest and size of everything written to w.
+type digestWriter struct {
+	w      io.Writer
+	hasher hash.Hash
+	size   int64
+}
+
+func newDigestWriter(w io.Writer) *digestWriter {
+	return &digestWriter{w: w, hasher: sha256.New()}
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.hasher.Write(p[:n])
+	d.size += int64(n)
+	return n, err
+}
+
+func (d *digestWriter) encoded() string {
+	return hex.EncodeToString(d.hasher.Sum(nil))
+}
+
+func (d *digestWriter) digest() string {
+	return "sha256:" + d.encoded()
+}
+
+var _ api.Sink = (*Sink)(nil)
@@ -0,0 +1,292 @@
+package oci
+
+import (
+	"fmt"
+	"io"
+	stdpath "path"
+	"sort"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/fs/tar"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// Source yields the flattened root filesystem of an image.
+// The layers are applied in order when the first node is requested.
+// File contents of all layers are kept in the spill CAS, which serves them by their SRI.
+type Source struct {
+	layout       layout
+	image        image
+	spill        api.CAS
+	sriAlgorithm sri.Algorithm
+	// holes are the hole maps of sparse files of all layers by sri.
+	holes map[string]sparse.HoleMap
+	// nodes are the remaining nodes of the flattened file system.
+	nodes     []api.SourceNode
+	flattened bool
+}
+
+func (s *Source) Next() (api.SourceNode, error) {
+	if !s.flattened {
+		if err := s.flatten(); err != nil {
+			return api.SourceNode{}, err
+		}
+		s.flattened = true
+	}
+	if len(s.nodes) == 0 {
+		return api.SourceNode{}, io.EOF
+	}
+	node := s.nodes[0]
+	s.nodes = s.nodes[1:]
+	return node, nil
+}
+
+// Open returns the contents of a regular file by its SRI.
+func (s *Source) Open(sri string) (io.ReadCloser, error) {
+	return s.spill.Open(sri)
+}
+
+// Holes returns the holes of a sparse file by its SRI.
+func (s *Source) Holes(sri string) sparse.HoleMap {
+	return s.holes[sri]
+}
+
+// flatten applies all layers and prepares the nodes of the resulting file system.
+func (s *Source) flatten() error {
+	root := &treeNode{children: make(map[string]*treeNode)}
+	for i, layer := range s.image.layers {
+		if err := s.applyLayer(root, layer); err != nil {
+			return fmt.Errorf("applying layer %d (%s): %w", i, layer.digest, err)
+		}
+	}
+	s.nodes = root.collect(root, "/")
+	return nil
+}
+
+// applyLayer applies a layer to the file system of the lower layers.
+// Whiteouts only hide files of lower layers, so they are applied before
+// the files of the layer are added.
+func (s *Source) applyLayer(root *treeNode, layer layer) error {
+	blob, err := s.layout.open(layer.path)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	verifier, err := newVerifyingReader(blob, layer.digest, layer.size)
+	if err != nil {
+		return err
+	}
+	nodes, err := s.readLayer(verifier)
+	if err != nil {
+		return err
+	}
+	if err := verifier.verify(); err != nil {
+		return err
+	}
+
+	// hardlinks to files of lower layers are resolved before the whiteouts of the layer apply
+	for i := range nodes {
+		if err := root.resolveLowerLink(&nodes[i]); err != nil {
+			return err
+		}
+	}
+
+	var files []layerFile
+	for _, file := range nodes {
+		dir, base := stdpath.Split(file.node.Stat.Name)
+		switch {
+		case base == tar.WhiteoutOpaque:
+			if parent := root.lookup(dir); parent != nil && parent.children != nil {
+				parent.children = make(map[string]*treeNode)
+			}
+		case strings.HasPrefix(base, tar.WhiteoutPrefix):
+			if parent := root.lookup(dir); parent != nil && parent.children != nil {
+				delete(parent.children, strings.TrimPrefix(base, tar.WhiteoutPrefix))
+			}
+		default:
+			files = append(files, file)
+		}
+	}
+	for _, file := range files {
+		root.insert(file)
+	}
+	return nil
+}
+
+// readLayer reads all nodes of a (possibly compressed) layer tar.
+func (s *Source) readLayer(r io.Reader) ([]layerFile, error) {
+	builder := &tar.SourceBuilder{}
+	source, closeSource, err := builder.
+		WithIOReader(r).
+		WithSRIAlgorithm(s.sriAlgorithm).
+		WithSpillCAS(s.spill).
+		WithExternalLinks(true).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	var files []layerFile
+	// regular files of the layer by name, used to resolve hardlinks
+	regular := make(map[string]api.SourceNode)
+	for {
+		node, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		file := layerFile{node: node}
+		switch node.Stat.Kind {
+		case api.KindRegular:
+			regular[node.Stat.Name] = node
+			if holes := sparse.HolesOf(source.(api.CASReader), node.Stat.Payload); len(holes) > 0 {
+				s.holes[node.Stat.Payload] = holes
+			}
+		case kind.Hardlink:
+			// the tar source resolves hardlinks to the name of the regular file.
+			// Targets in lower layers are resolved in applyLayer.
+			file.linkTarget = regular[node.Stat.Payload]
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// layerFile is a node of a layer.
+type layerFile struct {
+	node api.SourceNode
+	// linkTarget is the regular file a hardlink points to.
+	linkTarget api.SourceNode
+}
+
+// treeNode is a node of the flattened file system.
+type treeNode struct {
+	layerFile
+	// children of directories by name. It is nil for other kinds.
+	children map[string]*treeNode
+}
+
+// lookup returns the node at the given path or nil.
+func (n *treeNode) lookup(path string) *treeNode {
+	node := n
+	for _, part := range splitPath(path) {
+		if node.children == nil {
+			return nil
+		}
+		node = node.children[part]
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// insert adds a file, replacing an existing file at the same path.
+// Missing parents are created. Directories replacing directories keep their children.
+func (n *treeNode) insert(file layerFile) {
+	parts := splitPath(file.node.Stat.Name)
+	parent := n
+	if len(parts) == 0 {
+		n.layerFile = file
+		return
+	}
+	for _, part := range parts[:len(parts)-1] {
+		child := parent.children[part]
+		if child == nil || child.children == nil {
+			// files of lower layers are replaced by the parent directory
+			child = &treeNode{children: make(map[string]*treeNode)}
+			parent.children[part] = child
+		}
+		parent = child
+	}
+	base := parts[len(parts)-1]
+	existing := parent.children[base]
+	if file.node.Stat.Kind == api.KindDirectory && existing != nil && existing.children != nil {
+		existing.layerFile = file
+		return
+	}
+	node := &treeNode{layerFile: file}
+	if file.node.Stat.Kind == api.KindDirectory {
+		node.children = make(map[string]*treeNode)
+	}
+	parent.children[base] = node
+}
+
+// collect returns the nodes of the subtree in depth-first order, sorted by name.
+// Directories that were only created as parents are not returned.
+// Hardlinks whose target was removed or replaced by a later layer become regular files.
+func (n *treeNode) collect(root *treeNode, path string) []api.SourceNode {
+	var nodes []api.SourceNode
+	if n.node.Stat.Kind != "" {
+		node := n.node
+		node.Stat.Name = path
+		if node.Stat.Kind == kind.Hardlink {
+			node = root.resolveLink(node, n.linkTarget)
+		}
+		nodes = append(nodes, node)
+	}
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nodes = append(nodes, n.children[name].collect(root, stdpath.Join(path, name))...)
+	}
+	return nodes
+}
+
+// resolveLowerLink sets the link target of a hardlink that points to a file of a lower layer.
+// Links to links of lower layers are redirected to the regular file they point to.
+func (n *treeNode) resolveLowerLink(file *layerFile) error {
+	if file.node.Stat.Kind != kind.Hardlink || file.linkTarget.Stat.Kind != "" {
+		return nil
+	}
+	target := n.lookup(file.node.Stat.Payload)
+	switch {
+	case target == nil:
+		return fmt.Errorf("hardlink %q points to unknown file %q", file.node.Stat.Name, file.node.Stat.Payload)
+	case target.node.Stat.Kind == api.KindRegular:
+		file.linkTarget = target.node
+	case target.node.Stat.Kind == kind.Hardlink:
+		file.node.Stat.Payload = target.node.Stat.Payload
+		file.linkTarget = target.linkTarget
+	default:
+		return fmt.Errorf("hardlink %q points to %q of kind %q", file.node.Stat.Name, file.node.Stat.Payload, target.node.Stat.Kind)
+	}
+	return nil
+}
+
+// resolveLink returns the hardlink or a regular file with the contents of the link target
+// if the target no longer exists in the flattened file system.
+func (n *treeNode) resolveLink(link, target api.SourceNode) api.SourceNode {
+	current := n.lookup(link.Stat.Payload)
+	if current != nil && current.node.Stat.Kind == api.KindRegular && current.node.Stat.Payload == target.Stat.Payload {
+		return link
+	}
+	link.Stat.Kind = api.KindRegular
+	link.Stat.Payload = target.Stat.Payload
+	link.Stat.Size = target.Stat.Size
+	link.Open = target.Open
+	return link
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(stdpath.Clean("/"+path), "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+var (
+	_ api.Source        = (*Source)(nil)
+	_ api.CASReader     = (*Source)(nil)
+	_ sparse.HoleReader = (*Source)(nil)
+)
@@ -0,0 +1,313 @@
+package oci
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/fs/tar"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+func TestFlattenWhiteouts(t *testing.T) {
+	lower := layerTar(t,
+		dir("a"), file("a/x", "x"), file("a/y", "y"),
+		dir("b"), file("b/z", "z"),
+		file("c", "c"),
+	)
+	upper := layerTar(t,
+		file("a/.wh.x", ""),
+		file("b/.wh..wh..opq", ""), file("b/new", "new"),
+		file(".wh.c", ""),
+	)
+	nodes := flattenLayers(t, lower, upper)
+
+	want := []string{"/a", "/a/y", "/b", "/b/new"}
+	if got := names(nodes); !equalStrings(got, want) {
+		t.Errorf("flattened nodes = %v, want %v", got, want)
+	}
+}
+
+func TestFlattenWhiteoutOnlyHidesLowerLayers(t *testing.T) {
+	lower := layerTar(t, dir("a"), file("a/old", "old"))
+	// the opaque marker of a layer does not hide files of the same layer
+	upper := layerTar(t, file("a/new", "new"), file("a/.wh..wh..opq", ""))
+	nodes := flattenLayers(t, lower, upper)
+
+	want := []string{"/a", "/a/new"}
+	if got := names(nodes); !equalStrings(got, want) {
+		t.Errorf("flattened nodes = %v, want %v", got, want)
+	}
+}
+
+func TestFlattenHardlinks(t *testing.T) {
+	testCases := map[string]struct {
+		layers [][]tarEntry
+		// path of the link and the payload of the hardlink.
+		// If contents is set, the link must have become a regular file with these contents.
+		link     string
+		payload  string
+		contents string
+		wantErr  bool
+	}{
+		"target in the same layer": {
+			layers:  [][]tarEntry{{file("f", "data"), link("l", "f")}},
+			link:    "/l",
+			payload: "/f",
+		},
+		"target in a lower layer": {
+			layers:  [][]tarEntry{{file("f", "data")}, {link("l", "f")}},
+			link:    "/l",
+			payload: "/f",
+		},
+		"link to a link of a lower layer": {
+			layers:  [][]tarEntry{{file("f", "data"), link("l1", "f")}, {link("l2", "l1")}},
+			link:    "/l2",
+			payload: "/f",
+		},
+		"target replaced by an upper layer": {
+			layers:   [][]tarEntry{{file("f", "old"), link("l", "f")}, {file("f", "new")}},
+			link:     "/l",
+			contents: "old",
+		},
+		"target removed by an upper layer": {
+			layers:   [][]tarEntry{{file("f", "old"), link("l", "f")}, {file(".wh.f", "")}},
+			link:     "/l",
+			contents: "old",
+		},
+		"lower target removed by the layer of the link": {
+			layers:   [][]tarEntry{{file("f", "old")}, {link("l", "f"), file(".wh.f", "")}},
+			link:     "/l",
+			contents: "old",
+		},
+		"unknown target": {
+			layers:  [][]tarEntry{{file("f", "data")}, {link("l", "missing")}},
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			layers := make([][]byte, len(tc.layers))
+			for i, entries := range tc.layers {
+				layers[i] = layerTar(t, entries...)
+			}
+			source := newTestSource(layers...)
+			nodes, err := readNodes(source)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("flattening succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			node, ok := nodes[tc.link]
+			if !ok {
+				t.Fatalf("%s is missing, got %v", tc.link, names(nodes))
+			}
+			if tc.contents == "" {
+				if node.Stat.Kind != kind.Hardlink || node.Stat.Payload != tc.payload {
+					t.Errorf("%s: got %s to %q, want hardlink to %q", tc.link, node.Stat.Kind, node.Stat.Payload, tc.payload)
+				}
+				return
+			}
+			if node.Stat.Kind != api.KindRegular {
+				t.Fatalf("%s: got kind %s, want regular file", tc.link, node.Stat.Kind)
+			}
+			if node.Stat.Size != int64(len(tc.contents)) {
+				t.Errorf("%s: got size %d, want %d", tc.link, node.Stat.Size, len(tc.contents))
+			}
+			if got := readContents(t, node); got != tc.contents {
+				t.Errorf("%s: got contents %q, want %q", tc.link, got, tc.contents)
+			}
+		})
+	}
+}
+
+func TestSinkSourceRoundTrip(t *testing.T) {
+	in := fstest.MapFS{
+		"etc":          &fstest.MapFile{Mode: fs.ModeDir | 0o755},
+		"etc/hostname": &fstest.MapFile{Data: []byte("host\n"), Mode: 0o644},
+		"bin":          &fstest.MapFile{Mode: fs.ModeDir | 0o755},
+		"bin/sh":       &fstest.MapFile{Data: []byte("#!"), Mode: 0o755},
+	}
+	path := t.TempDir()
+	sinkBuilder := &SinkBuilder{}
+	sink, closeSink, err := sinkBuilder.WithRefName("test").WithSinkRef(path).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Consume(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeSink(); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceBuilder := &SourceBuilder{Spill: tar.SpillMemory}
+	source, closeSource, err := sourceBuilder.WithRefName("test").WithSourceRef(path).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	nodes, err := readNodes(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/bin", "/bin/sh", "/etc", "/etc/hostname"}
+	if got := names(nodes); !equalStrings(got, want) {
+		t.Fatalf("nodes = %v, want %v", got, want)
+	}
+	if got := readContents(t, nodes["/etc/hostname"]); got != "host\n" {
+		t.Errorf("/etc/hostname = %q, want %q", got, "host\n")
+	}
+	if mode := nodes["/bin/sh"].Stat.Attributes.Mode; mode != "0o755" {
+		t.Errorf("/bin/sh has mode %s, want 0o755", mode)
+	}
+}
+
+// tarEntry is an entry of a test layer.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	contents string
+}
+
+func dir(name string) tarEntry {
+	return tarEntry{name: name + "/", typeflag: archivetar.TypeDir}
+}
+
+func file(name, contents string) tarEntry {
+	return tarEntry{name: name, typeflag: archivetar.TypeReg, contents: contents}
+}
+
+func link(name, target string) tarEntry {
+	return tarEntry{name: name, typeflag: archivetar.TypeLink, linkname: target}
+}
+
+// layerTar returns an uncompressed layer tar with the entries.
+func layerTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := archivetar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &archivetar.Header{
+			Name:     entry.name,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+			Size:     int64(len(entry.contents)),
+			Mode:     0o644,
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(writer, entry.contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// memLayout is an image layout in memory.
+type memLayout map[string][]byte
+
+func (l memLayout) open(name string) (io.ReadCloser, error) {
+	data, ok := l[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// newTestSource returns a source for an image with the layers, from bottom to top.
+func newTestSource(layers ...[]byte) *Source {
+	l := memLayout{}
+	var img image
+	for _, data := range layers {
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		path, _ := blobPath(digest)
+		l[path] = data
+		img.layers = append(img.layers, layer{path: path, digest: digest, size: int64(len(data))})
+	}
+	return &Source{
+		layout:       l,
+		image:        img,
+		spill:        memory.NewCAS(false),
+		holes:        make(map[string]sparse.HoleMap),
+		sriAlgorithm: "sha256",
+	}
+}
+
+func flattenLayers(t *testing.T, layers ...[]byte) map[string]api.SourceNode {
+	t.Helper()
+	nodes, err := readNodes(newTestSource(layers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nodes
+}
+
+// readNodes reads all nodes of a source by name.
+func readNodes(source api.Source) (map[string]api.SourceNode, error) {
+	nodes := make(map[string]api.SourceNode)
+	for {
+		node, err := source.Next()
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		nodes[node.Stat.Name] = node
+	}
+}
+
+func readContents(t *testing.T, node api.SourceNode) string {
+	t.Helper()
+	if node.Open == nil {
+		t.Fatalf("%s cannot be opened", node.Stat.Name)
+	}
+	file, err := node.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+// names returns the sorted names of the nodes, without the root directory.
+func names(nodes map[string]api.SourceNode) []string {
+	var names []string
+	for name := range nodes {
+		if name != "/" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
@@ -11,17 +11,51 @@ import (
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/provider"
 	"github.com/malt3/abstractfs-core/sri"
+	casdir "github.com/malt3/abstractfs/cas/dir"
+	"github.com/malt3/abstractfs/cas/memory"
 )
 
 type SourceBuilder struct {
 	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
-	NewReader    func(io.Reader) Reader
-	// XAttrPaxPrefixes is a list of prefixes that are used to identify xattrs
-	// later prefixes override earlier ones if the same xattr is set multiple times.
+	// AliasAlgorithms are the algorithms that file contents are additionally hashed with,
+	// so the CAS of the source can open them by their sris in these algorithms, too.
+	AliasAlgorithms []sri.Algorithm `abstractfs:"cas-aliases"`
+	NewReader       func(io.Reader) Reader
+	// XAttrPaxPrefixes is a list of prefixes that are used to identify xattrs,
+	// ordered by precedence. Xattrs of an entry are read from the records of the
+	// first prefix that has any records, all other records are ignored.
+	// Records with the XAttrPrefixLibarchive prefix are decoded.
+	// By default, LIBARCHIVE.xattr. records take precedence over SCHILY.xattr. records,
+	// since they can store arbitrary keys and values.
 	XAttrPaxPrefixes []string `abstractfs:"xattr-prefixes"`
-	Path             string
-	IOReader         io.Reader
-	invalidOptions   []string
+	// Compression is the compression algorithm of the tar archive.
+	// Valid values are CompressionAuto, CompressionNone, CompressionGzip,
+	// CompressionZstd, CompressionXZ and CompressionBzip2.
+	// By default, the compression is detected using magic bytes.
+	// Compressed archives cannot be read randomly, so file contents are
+	// decompressed once and kept in a CAS while reading.
+	Compression string `abstractfs:"compression"`
+	// Spill selects where file contents are kept if the input does not support random access
+	// (compressed archives, pipes or sockets).
+	// Valid values are SpillMemory and SpillTempDir.
+	// By default, SpillTempDir is used, so the memory use does not grow with the archive.
+	// The directory is only created if needed. Spill is ignored if SpillCAS is set.
+	Spill string `abstractfs:"spill"`
+	// SpillCAS is a custom CAS that file contents are copied to
+	// if the input does not support random access.
+	// It cannot be combined with AliasAlgorithms, since the blobs may outlive the source
+	// but the alias index does not (see cas.AliasCAS).
+	// A custom spill CAS can index aliases itself instead, like memory.CAS.WithAliases.
+	SpillCAS api.CAS
+	// ExternalLinks allows hardlinks to files that are not in the archive.
+	// Their payload is the (normalized) link name.
+	// This is used for OCI layers, whose hardlinks may point to files of lower layers.
+	ExternalLinks bool
+	// Path is the path to the tar file.
+	// Use "-" to read from stdin.
+	Path           string
+	IOReader       io.Reader
+	invalidOptions []string
 }
 
 // WithSourceRef sets the source reference.
@@ -36,20 +70,44 @@ func (b *SourceBuilder) WithSRIAlgorithm(alg sri.Algorithm) *SourceBuilder {
 	return b
 }
 
+// WithAliasAlgorithms sets the algorithms that file contents are additionally hashed with.
+func (b *SourceBuilder) WithAliasAlgorithms(algorithms ...sri.Algorithm) *SourceBuilder {
+	b.AliasAlgorithms = algorithms
+	return b
+}
+
 func (b *SourceBuilder) WithNewReader(f func(io.Reader) Reader) *SourceBuilder {
 	b.NewReader = f
 	return b
 }
 
-// WithXAttrPaxPrefixes sets the xattr prefixes that are used to identify xattrs.
-// Later prefixes override earlier ones if the same xattr is set multiple times.
-// By default, the "SHILY.xattr." prefix is used.
+// WithXAttrPaxPrefixes sets the xattr prefixes that are used to identify xattrs, ordered by precedence.
+// By default, the "LIBARCHIVE.xattr." and "SCHILY.xattr." prefixes are used.
 // If set to []string{}, no xattrs are read.
 func (b *SourceBuilder) WithXAttrPaxPrefixes(prefixes []string) *SourceBuilder {
 	b.XAttrPaxPrefixes = prefixes
 	return b
 }
 
+// WithSpillCAS sets the CAS that file contents are copied to
+// if the input does not support random access.
+func (b *SourceBuilder) WithSpillCAS(cas api.CAS) *SourceBuilder {
+	b.SpillCAS = cas
+	return b
+}
+
+// WithExternalLinks allows hardlinks to files that are not in the archive.
+func (b *SourceBuilder) WithExternalLinks(externalLinks bool) *SourceBuilder {
+	b.ExternalLinks = externalLinks
+	return b
+}
+
+// WithCompression sets the compression algorithm of the tar archive.
+func (b *SourceBuilder) WithCompression(compression string) *SourceBuilder {
+	b.Compression = compression
+	return b
+}
+
 func (b *SourceBuilder) WithIOReader(r io.Reader) *SourceBuilder {
 	b.IOReader = r
 	return b
@@ -62,7 +120,11 @@ func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
 		return nil, nil, err
 	}
 	var fileCloser func() error
-	if b.IOReader == nil {
+	switch {
+	case b.IOReader != nil:
+	case b.Path == "-":
+		b.IOReader = os.Stdin
+	default:
 		file, err := os.Open(b.Path)
 		if err != nil {
 			return nil, nil, err
@@ -70,20 +132,76 @@ func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
 		fileCloser = file.Close
 		b.IOReader = file
 	}
+	closeFile := func() error {
+		if fileCloser != nil {
+			return fileCloser()
+		}
+		return nil
+	}
+	reader := b.IOReader
+	compression := b.Compression
+	var err error
+	if compression == CompressionAuto {
+		compression, reader, err = detectCompression(reader)
+		if err != nil {
+			closeFile()
+			return nil, nil, err
+		}
+	}
+	decompressor, err := newDecompressor(compression, reader)
+	if err != nil {
+		closeFile()
+		return nil, nil, fmt.Errorf("opening %s compressed tar: %w", compression, err)
+	}
+	if compression != CompressionNone {
+		reader = decompressor
+	}
+	// file contents of archives with random access are read from the archive itself
+	var spill api.CAS
+	spillCloser := func() error { return nil }
+	if !randomAccess(reader) {
+		spill, spillCloser, err = b.spillCAS()
+		if err != nil {
+			decompressor.Close()
+			closeFile()
+			return nil, nil, err
+		}
+	}
 	source := &Source{
-		reader:           b.NewReader(b.IOReader),
-		casStore:         NewCAS(b.IOReader),
+		reader:           b.NewReader(reader),
+		casStore:         NewCAS(reader, spill, b.AliasAlgorithms...),
 		sriAlgorithm:     b.SRIAlgorithm,
 		xattrPaxPrefixes: b.XAttrPaxPrefixes,
+		externalLinks:    b.ExternalLinks,
+		files:            make(map[string]string),
 	}
 	return source, func() error {
-		if fileCloser != nil {
-			return fileCloser()
-		}
-		return nil
+		return errors.Join(decompressor.Close(), closeFile(), spillCloser())
 	}, nil
 }
 
+// spillCAS returns the CAS that is used if the input does not support random access.
+func (b *SourceBuilder) spillCAS() (api.CAS, api.CloseWaitFunc, error) {
+	nopCloser := func() error { return nil }
+	if b.SpillCAS != nil {
+		return b.SpillCAS, nopCloser, nil
+	}
+	switch b.Spill {
+	case SpillTempDir:
+		tempDir, err := os.MkdirTemp("", "abstractfs-tar-*")
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating spill directory: %w", err)
+		}
+		cas, err := casdir.NewCAS(tempDir, false)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			return nil, nil, err
+		}
+		return cas, func() error { return os.RemoveAll(tempDir) }, nil
+	}
+	return memory.NewCAS(false), nopCloser, nil
+}
+
 func (o *SourceBuilder) applyDefaults() {
 	if o.SRIAlgorithm == "" {
 		o.SRIAlgorithm = sri.SHA256
@@ -91,11 +209,14 @@ func (o *SourceBuilder) applyDefaults() {
 	if o.NewReader == nil {
 		o.NewReader = newDefaultReader
 	}
+	if o.Compression == "" {
+		o.Compression = CompressionAuto
+	}
+	if o.Spill == "" {
+		o.Spill = SpillTempDir
+	}
 	if o.XAttrPaxPrefixes == nil {
-		o.XAttrPaxPrefixes = []string{"SCHILY.xattr."}
-		// TODO: support libarchive xattrs
-		// They are encoded as urlencode(key), base64_encode(value)
-		// o.XAttrPaxPrefixes = []string{"LIBARCHIVE.xattr.", "SCHILY.xattr."}
+		o.XAttrPaxPrefixes = []string{XAttrPrefixLibarchive, XAttrPrefixSchily}
 	}
 }
 
@@ -109,9 +230,25 @@ func (b *SourceBuilder) check() error {
 	if b.Path == "" && b.IOReader == nil {
 		return errors.New("must set either path or io.Reader")
 	}
+	switch b.Spill {
+	case SpillMemory, SpillTempDir:
+	default:
+		return fmt.Errorf("invalid spill: %q", b.Spill)
+	}
+	if b.SpillCAS != nil && len(b.AliasAlgorithms) > 0 {
+		return errors.New("cas-aliases cannot be combined with a custom spill CAS")
+	}
 	return nil
 }
 
+const (
+	// SpillMemory keeps file contents of non-seekable inputs in memory.
+	SpillMemory = "memory"
+	// SpillTempDir keeps file contents of non-seekable inputs in a temporary directory.
+	// The directory is removed when the source is closed.
+	SpillTempDir = "tempdir"
+)
+
 type SinkBuilder struct {
 	NewWriter func(io.Writer) Writer
 	// Format is the tar format to use.
@@ -124,7 +261,34 @@ type SinkBuilder struct {
 	Root string `abstractfs:"root"`
 	// XattrPaxPrefix is the prefix to use for xattrs.
 	// By default, the "SHILY.xattr." prefix is used.
+	// It is ignored if XAttrEncoding is XAttrEncodingLibarchive.
 	XAttrPaxPrefix string `abstractfs:"xattr-prefix"`
+	// XAttrEncoding selects the PAX records that xattrs are written as.
+	// Valid values are XAttrEncodingSchily (records with the XAttrPaxPrefix),
+	// XAttrEncodingLibarchive (LIBARCHIVE.xattr. records) and XAttrEncodingBoth (both, like bsdtar).
+	// By default, XAttrEncodingSchily is used.
+	XAttrEncoding string `abstractfs:"xattr-encoding"`
+	// Compression is the compression algorithm to use.
+	// Valid values are CompressionNone, CompressionGzip, CompressionZstd and CompressionXZ.
+	// By default, the tar is not compressed.
+	Compression string `abstractfs:"compression"`
+	// Level is the compression level.
+	// By default (0), the default level of the compression algorithm is used.
+	Level int `abstractfs:"level"`
+	// Sparse writes files with holes as GNU PAX 1.0 sparse entries.
+	// Like GNU tar's --sparse, this is disabled by default,
+	// since readers without sparse support extract the entries with a different name.
+	// Sparse entries are written directly to the underlying stream,
+	// so a custom Writer must not buffer.
+	Sparse bool `abstractfs:"sparse"`
+	// Whiteouts writes whiteout nodes (kind.Whiteout) and opaque directories (see kind.IsOpaque)
+	// as OCI whiteout files (".wh.<name>" and ".wh..wh..opq").
+	// This is used to write layers generated by diff.Layer.
+	// If disabled, whiteout nodes are an error.
+	Whiteouts bool `abstractfs:"whiteouts"`
+	// SkipUnsupported skips nodes that tar cannot represent (sockets).
+	// By default, such nodes are an error (see kind.ErrUnsupported).
+	SkipUnsupported bool `abstractfs:"skip-unsupported"`
 	// Path is the path to write the tar to.
 	// If Path is set, the tar is written to the file.
 	// Otherwise, the tar is written to the io.Writer.
@@ -176,6 +340,48 @@ func (b *SinkBuilder) Set(key string, value any) provider.SinkBuilder {
 			return b
 		}
 		b.XAttrPaxPrefix = xattrPrefix
+	case "xattr-encoding":
+		encoding, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.XAttrEncoding = encoding
+	case "compression":
+		compression, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Compression = compression
+	case "level":
+		level, ok := value.(int)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Level = level
+	case "sparse":
+		sparse, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Sparse = sparse
+	case "whiteouts":
+		whiteouts, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Whiteouts = whiteouts
+	case "skip-unsupported":
+		skipUnsupported, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.SkipUnsupported = skipUnsupported
 	default:
 		b.invalidOptions = append(b.invalidOptions, key)
 	}
@@ -202,6 +408,37 @@ func (b *SinkBuilder) WithXAttrPaxPrefix(prefix string) *SinkBuilder {
 	return b
 }
 
+// WithXAttrEncoding sets the PAX records that xattrs are written as.
+func (b *SinkBuilder) WithXAttrEncoding(encoding string) *SinkBuilder {
+	b.XAttrEncoding = encoding
+	return b
+}
+
+// WithCompression sets the compression algorithm and level.
+func (b *SinkBuilder) WithCompression(compression string, level int) *SinkBuilder {
+	b.Compression = compression
+	b.Level = level
+	return b
+}
+
+// WithSparse enables writing files with holes as sparse entries.
+func (b *SinkBuilder) WithSparse(sparse bool) *SinkBuilder {
+	b.Sparse = sparse
+	return b
+}
+
+// WithWhiteouts enables writing whiteout nodes and opaque directories as OCI whiteout files.
+func (b *SinkBuilder) WithWhiteouts(whiteouts bool) *SinkBuilder {
+	b.Whiteouts = whiteouts
+	return b
+}
+
+// WithSkipUnsupported skips nodes that tar cannot represent instead of failing.
+func (b *SinkBuilder) WithSkipUnsupported(skipUnsupported bool) *SinkBuilder {
+	b.SkipUnsupported = skipUnsupported
+	return b
+}
+
 func (b *SinkBuilder) WithIOWriter(w io.Writer) *SinkBuilder {
 	b.IOWriter = w
 	return b
@@ -222,18 +459,31 @@ func (b *SinkBuilder) Build() (api.Sink, api.CloseWaitFunc, error) {
 		fileCloser = file.Close
 		b.IOWriter = file
 	}
+	compressor, err := NewCompressor(b.Compression, b.Level, b.IOWriter)
+	if err != nil {
+		if fileCloser != nil {
+			fileCloser()
+		}
+		return nil, nil, err
+	}
 	sink := &Sink{
-		writer:         b.NewWriter(b.IOWriter),
-		format:         b.Format,
-		root:           b.Root,
-		xattrPaxPrefix: b.XAttrPaxPrefix,
+		writer:          b.NewWriter(compressor),
+		out:             compressor,
+		sparse:          b.Sparse,
+		whiteouts:       b.Whiteouts,
+		skipUnsupported: b.SkipUnsupported,
+		format:          b.Format,
+		root:            b.Root,
+		xattrPaxPrefix:  b.XAttrPaxPrefix,
+		xattrEncoding:   b.XAttrEncoding,
+		links:           make(map[string]string),
 	}
 	return sink, func() error {
-		sink.writer.Close()
+		closeErr := errors.Join(sink.writer.Close(), compressor.Close())
 		if fileCloser != nil {
-			return fileCloser()
+			return errors.Join(closeErr, fileCloser())
 		}
-		return nil
+		return closeErr
 	}, nil
 }
 
@@ -245,9 +495,14 @@ func (o *SinkBuilder) applyDefaults() {
 		o.Format = archivetar.FormatPAX
 	}
 	if o.XAttrPaxPrefix == "" {
-		o.XAttrPaxPrefix = "SCHILY.xattr."
+		o.XAttrPaxPrefix = XAttrPrefixSchily
+	}
+	if o.XAttrEncoding == "" {
+		o.XAttrEncoding = XAttrEncodingSchily
+	}
+	if o.Compression == "" {
+		o.Compression = CompressionNone
 	}
-
 }
 
 func (b *SinkBuilder) check() error {
@@ -260,5 +515,16 @@ func (b *SinkBuilder) check() error {
 	if b.Path == "" && b.IOWriter == nil {
 		return errors.New("must set either path or io.Writer")
 	}
+	switch b.XAttrEncoding {
+	case XAttrEncodingSchily, XAttrEncodingLibarchive, XAttrEncodingBoth:
+	default:
+		return fmt.Errorf("unsupported xattr encoding %q", b.XAttrEncoding)
+	}
+	if b.Sparse && b.Format != archivetar.FormatPAX {
+		return errors.New("sparse entries require the PAX format")
+	}
+	if err := checkCompression(b.Compression, b.Level); err != nil {
+		return err
+	}
 	return nil
 }
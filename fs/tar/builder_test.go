@@ -0,0 +1,88 @@
+package tar
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+func TestSinkBuilderCompression(t *testing.T) {
+	testCases := map[string]struct {
+		compression string
+		level       int
+		wantErr     bool
+	}{
+		"none":                 {compression: CompressionNone},
+		"gzip":                 {compression: CompressionGzip, level: 9},
+		"gzip invalid level":   {compression: CompressionGzip, level: 10, wantErr: true},
+		"zstd":                 {compression: CompressionZstd, level: 3},
+		"xz":                   {compression: CompressionXZ},
+		"xz with level":        {compression: CompressionXZ, level: 6, wantErr: true},
+		"bzip2 is read only":   {compression: CompressionBzip2, wantErr: true},
+		"auto is only readers": {compression: CompressionAuto, wantErr: true},
+		"unknown":              {compression: "lz4", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.tar")
+			_, closeSink, err := (&SinkBuilder{}).WithCompression(tc.compression, tc.level).WithSinkRef(path).(*SinkBuilder).Build()
+			if !tc.wantErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := closeSink(); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				closeSink()
+				t.Fatal("Build() succeeded, want error")
+			}
+			// invalid options are rejected before the output is created
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("output exists after failed Build() (stat: %v)", err)
+			}
+		})
+	}
+}
+
+func TestSinkCloseError(t *testing.T) {
+	closeErr := errors.New("close failed")
+	newWriter := func(w io.Writer) Writer {
+		return failingCloseWriter{Writer: archivetar.NewWriter(w), err: closeErr}
+	}
+	_, closeSink, err := (&SinkBuilder{}).WithNewWriter(newWriter).WithIOWriter(&bytes.Buffer{}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closeSink(); !errors.Is(err, closeErr) {
+		t.Errorf("closing the sink returned %v, want %v", err, closeErr)
+	}
+}
+
+type failingCloseWriter struct {
+	*archivetar.Writer
+	err error
+}
+
+func (w failingCloseWriter) Close() error {
+	return w.err
+}
+
+func TestSourceBuilderSpillCASAliases(t *testing.T) {
+	builder := (&SourceBuilder{}).
+		WithIOReader(&bytes.Buffer{}).
+		WithSpillCAS(memory.NewCAS(false)).
+		WithAliasAlgorithms(sri.SHA512)
+	if _, _, err := builder.Build(); err == nil {
+		t.Error("Build() succeeded, want error for aliases with a custom spill CAS")
+	}
+}
@@ -2,25 +2,41 @@ package tar
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"sync"
 
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/sparse"
 )
 
-func NewCAS(r io.Reader) casStore {
-	randomReader, ok := r.(randomAccessReader)
-	if !ok {
-		panic("should use fallback cas store with in-memory cas backend once implemented")
+// NewCAS returns a cas store for the given tar reader.
+// If the reader supports random access, file contents are served directly from the tar file.
+// Otherwise (for example for compressed archives, pipes or sockets), file contents are
+// copied into the spill CAS while reading. If spill is nil, an in-memory CAS is used.
+// File contents are additionally hashed with the alias algorithms,
+// so they can be opened by their sris in these algorithms, too.
+func NewCAS(r io.Reader, spill api.CAS, aliasAlgorithms ...sri.Algorithm) casStore {
+	if !randomAccess(r) {
+		if spill == nil {
+			spill = memory.NewCAS(false)
+		}
+		if len(aliasAlgorithms) > 0 {
+			spill = cas.NewAliasCAS(spill, aliasAlgorithms...)
+		}
+		return &fallbackCASStore{cas: spill, holes: make(map[string]sparse.HoleMap)}
 	}
 	return &CASSectionStore{
-		reader: randomReader,
-		inner:  make(map[string]struct{ offset, size int64 }),
+		reader:          r.(randomAccessReader),
+		aliasAlgorithms: aliasAlgorithms,
+		inner:           make(map[string]section),
 	}
-
 }
 
 // CASSectionStore is a store for tar sections.
@@ -28,111 +44,228 @@ func NewCAS(r io.Reader) casStore {
 // While reading the tar file.
 // Later, the sections can be opened by their sri.
 type CASSectionStore struct {
-	reader randomAccessReader
-	mux    sync.RWMutex
-	// inner is the lookup table for sri -> section of tar file (offset + size).
-	inner map[string]struct{ offset, size int64 }
+	reader          randomAccessReader
+	aliasAlgorithms []sri.Algorithm
+	mux             sync.RWMutex
+	// inner is the lookup table for sri (or alias) -> section of tar file.
+	inner map[string]section
 }
 
 // Record records the given file and returns the sri.
 // The headerSize is the file size reported by the tar header.
-func (c *CASSectionStore) Record(fileReader io.Reader, headerSize int64, sriAlgorithm sri.Algorithm) (string, error) {
+// For sparse files, the holes are not stored in the tar file and
+// the section only contains the data regions.
+func (c *CASSectionStore) Record(fileReader io.Reader, headerSize int64, holes sparse.HoleMap, sriAlgorithm sri.Algorithm) (string, error) {
 	// spy on the current offset of the reader
 	offsetBefore, err := c.reader.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return "", fmt.Errorf("recording: failed to get file offset of reader: %w", err)
 	}
-	integrity, err := sri.FromReader(sriAlgorithm, fileReader)
+	sris, err := cas.Hash(fileReader, append([]sri.Algorithm{sriAlgorithm}, c.aliasAlgorithms...)...)
 	if err != nil {
 		return "", fmt.Errorf("recording: failed to calculate sri: %w", err)
 	}
-	sri := integrity.String()
 	offsetAfter, err := c.reader.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return "", fmt.Errorf("recording: failed to get file offset of reader: %w", err)
 	}
 	observedSize := offsetAfter - offsetBefore
-	if offsetAfter < offsetBefore || observedSize != headerSize {
-		return "", fmt.Errorf("recording: reader is not a random access reader, header size does not match real size or reading sparse file")
+	if offsetAfter < offsetBefore || observedSize != headerSize-holes.HoleBytes() {
+		return "", fmt.Errorf("recording: reader is not a random access reader, header size does not match real size or sparse map is unknown")
 	}
-	c.Set(sri, offsetBefore, observedSize)
-	return sri, nil
+	c.Set(sris[0], section{offset: offsetBefore, size: observedSize, holes: holes, logicalSize: headerSize}, sris[1:]...)
+	return sris[0], nil
 }
 
 // Open returns a reader for the given sri.
+// The sri may be an alias and may contain several space separated digests.
 func (c *CASSectionStore) Open(sri string) (io.ReadCloser, error) {
-	offset, size, ok := c.getSection(sri)
+	section, ok := c.getSection(sri)
 	if !ok {
 		return nil, fs.ErrNotExist
 	}
-	return &nopCloser{io.NewSectionReader(c.reader, offset, size)}, nil
+	reader := io.NewSectionReader(c.reader, section.offset, section.size)
+	if len(section.holes) > 0 {
+		return io.NopCloser(sparse.NewReader(reader, section.holes, section.logicalSize)), nil
+	}
+	return &nopCloser{reader}, nil
 }
 
-// Set sets the offset and size for the given sri.
-// If the sri already exists, the old location will be kept.
-func (c *CASSectionStore) Set(sri string, offset, size int64) {
+// Holes returns the holes of the file with the given sri.
+func (c *CASSectionStore) Holes(sri string) sparse.HoleMap {
+	section, _ := c.getSection(sri)
+	return section.holes
+}
+
+// Set sets the section for the given sri and its aliases.
+// If the sri or an alias already exists, the old location will be kept for it.
+func (c *CASSectionStore) Set(sri string, s section, aliases ...string) {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	_, ok := c.inner[sri]
-	if ok {
-		return
+	for _, key := range append([]string{sri}, aliases...) {
+		if _, ok := c.inner[key]; !ok {
+			c.inner[key] = s
+		}
 	}
-	c.inner[sri] = struct{ offset, size int64 }{offset, size}
 }
 
-// getSection returns the tar section (offset, size) for the given sri.
-func (c *CASSectionStore) getSection(sri string) (int64, int64, bool) {
+// getSection returns the tar section for the given sri.
+func (c *CASSectionStore) getSection(sri string) (section, bool) {
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	section, ok := c.inner[sri]
-	return section.offset, section.size, ok
+	if section, ok := c.inner[sri]; ok {
+		return section, true
+	}
+	for _, digest := range cas.Digests(sri) {
+		if section, ok := c.inner[digest]; ok {
+			return section, true
+		}
+	}
+	return section{}, false
+}
+
+// section is the location of a file in the tar file.
+type section struct {
+	offset, size int64
+	// holes is the hole map of sparse files.
+	// Only the data regions are stored (back to back) in the section.
+	holes       sparse.HoleMap
+	logicalSize int64
 }
 
 type fallbackCASStore struct {
 	cas api.CAS
+	mux sync.RWMutex
+	// holes holds the hole maps of sparse files by sri.
+	// The spill CAS only holds the full file contents.
+	holes map[string]sparse.HoleMap
 }
 
 func (f *fallbackCASStore) Open(sri string) (io.ReadCloser, error) {
 	return f.cas.Open(sri)
 }
 
-func (f *fallbackCASStore) Record(fileReader io.Reader, headerSize int64, sriAlgorithm sri.Algorithm) (string, error) {
+// Holes returns the holes of the file with the given sri.
+func (f *fallbackCASStore) Holes(sri string) sparse.HoleMap {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return f.holes[sri]
+}
+
+func (f *fallbackCASStore) Record(fileReader io.Reader, headerSize int64, holes sparse.HoleMap, sriAlgorithm sri.Algorithm) (string, error) {
 	// since it is not guaranteed that we can rewind the reader, we need to copy the file
 	// into a buffer in order to read it twice.
 	// first read: calculate sri
 	// second read: write to cas
-	buf := new(bytes.Buffer)
-	buf.Grow(int(headerSize))
-	observedSize, err := io.Copy(buf, fileReader)
+	buf, err := newSpillBuffer(headerSize)
+	if err != nil {
+		return "", fmt.Errorf("recording: %w", err)
+	}
+	defer buf.Close()
+	observedSize, err := buf.fill(fileReader, holes, headerSize)
 	if err != nil {
 		return "", fmt.Errorf("recording: failed to copy file: %w", err)
 	}
 	if observedSize != headerSize {
-		return "", fmt.Errorf("recording: header size does not match real size or reading sparse file")
+		return "", fmt.Errorf("recording: header size does not match real size")
 	}
-	integrity, err := sri.FromReader(sriAlgorithm, buf)
+	content, err := buf.Reader()
+	if err != nil {
+		return "", fmt.Errorf("recording: %w", err)
+	}
+	integrity, err := sri.FromReader(sriAlgorithm, content)
 	if err != nil {
 		return "", fmt.Errorf("recording: failed to calculate sri: %w", err)
 	}
 	sri := integrity.String()
-	buf.Reset()
-	if err := f.cas.Write(sri, buf); err != nil {
+	content, err = buf.Reader()
+	if err != nil {
+		return "", fmt.Errorf("recording: %w", err)
+	}
+	if err := f.cas.Write(sri, content); err != nil {
 		return "", fmt.Errorf("recording: failed to write to cas: %w", err)
 	}
+	if len(holes) > 0 {
+		f.mux.Lock()
+		f.holes[sri] = holes
+		f.mux.Unlock()
+	}
 	return sri, nil
 }
 
-type randomAccessReader interface {
-	io.Reader
-	io.ReaderAt
-	io.Seeker
+// spillBuffer buffers a single file.
+// Small files are kept in memory, large files are buffered in a temporary file.
+type spillBuffer struct {
+	mem  *bytes.Buffer
+	file *os.File
 }
 
-type randomAccessReadCloser interface {
+func newSpillBuffer(size int64) (*spillBuffer, error) {
+	if size <= maxInMemorySpill {
+		mem := new(bytes.Buffer)
+		mem.Grow(int(size))
+		return &spillBuffer{mem: mem}, nil
+	}
+	file, err := os.CreateTemp("", "abstractfs-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill file: %w", err)
+	}
+	return &spillBuffer{file: file}, nil
+}
+
+// fill buffers the file contents.
+// Holes of sparse files are not written to the temporary file.
+func (b *spillBuffer) fill(r io.Reader, holes sparse.HoleMap, size int64) (int64, error) {
+	if b.file == nil {
+		return io.Copy(b.mem, r)
+	}
+	if len(holes) == 0 {
+		return io.Copy(b.file, r)
+	}
+	if err := sparse.Copy(b.file, r, holes, size); err != nil {
+		return 0, err
+	}
+	// there must not be any data after the logical end of the file
+	n, err := io.Copy(io.Discard, r)
+	return size + n, err
+}
+
+// Reader returns a reader for the full buffered content.
+// It can be called multiple times.
+func (b *spillBuffer) Reader() (io.Reader, error) {
+	if b.file == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return b.file, nil
+}
+
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	return errors.Join(b.file.Close(), os.Remove(b.file.Name()))
+}
+
+// randomAccess returns true if file contents can be read directly from the reader.
+func randomAccess(r io.Reader) bool {
+	randomReader, ok := r.(randomAccessReader)
+	return ok && seekable(randomReader)
+}
+
+// seekable returns false if the reader is known to not support random access.
+// This is the case for pipes and sockets wrapped in an *os.File.
+func seekable(r io.Seeker) bool {
+	_, err := r.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+type randomAccessReader interface {
 	io.Reader
 	io.ReaderAt
 	io.Seeker
-	io.Closer
 }
 
 type nopCloser struct {
@@ -142,9 +275,13 @@ type nopCloser struct {
 func (n *nopCloser) Close() error { return nil }
 
 type casStore interface {
-	Record(fileReader io.Reader, headerSize int64, sriAlgorithm sri.Algorithm) (string, error)
+	Record(fileReader io.Reader, headerSize int64, holes sparse.HoleMap, sriAlgorithm sri.Algorithm) (string, error)
 	Open(sri string) (io.ReadCloser, error)
+	Holes(sri string) sparse.HoleMap
 }
 
+// maxInMemorySpill is the maximum file size that is buffered in memory while spilling.
+const maxInMemorySpill = 32 * 1024 * 1024 // 32 MiB
+
 var _ casStore = (*CASSectionStore)(nil)
 var _ casStore = (*fallbackCASStore)(nil)
@@ -0,0 +1,138 @@
+package tar
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression algorithms for tar archives.
+const (
+	// CompressionAuto detects the compression algorithm using magic bytes.
+	// It is only valid for sources.
+	CompressionAuto  = "auto"
+	CompressionNone  = "none"
+	CompressionGzip  = "gzip"
+	CompressionZstd  = "zstd"
+	CompressionXZ    = "xz"
+	CompressionBzip2 = "bzip2"
+)
+
+var compressionMagic = []struct {
+	compression string
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{CompressionBzip2, []byte{'B', 'Z', 'h'}},
+}
+
+// detectCompression detects the compression algorithm of r using magic bytes.
+// It returns a reader that yields the full stream, including the magic bytes.
+// If r is seekable, the returned reader is r itself.
+func detectCompression(r io.Reader) (string, io.Reader, error) {
+	magic := make([]byte, 6)
+	n, err := io.ReadFull(r, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, fmt.Errorf("detecting compression: %w", err)
+	}
+	magic = magic[:n]
+
+	compression := CompressionNone
+	for _, candidate := range compressionMagic {
+		if bytes.HasPrefix(magic, candidate.magic) {
+			compression = candidate.compression
+			break
+		}
+	}
+
+	if seeker, ok := r.(io.Seeker); ok {
+		if _, err := seeker.Seek(-int64(n), io.SeekCurrent); err == nil {
+			return compression, r, nil
+		}
+	}
+	return compression, io.MultiReader(bytes.NewReader(magic), r), nil
+}
+
+// newDecompressor returns a reader that decompresses r.
+func newDecompressor(compression string, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case CompressionXZ:
+		reader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(reader), nil
+	case CompressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unsupported compression %q", compression)
+}
+
+// NewCompressor returns a writer that compresses to w.
+// A level of 0 selects the default level of the algorithm.
+func NewCompressor(compression string, level int, w io.Writer) (io.WriteCloser, error) {
+	if err := checkCompression(compression, level); err != nil {
+		return nil, err
+	}
+	switch compression {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		opts := []zstd.EOption{}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case CompressionXZ:
+		return xz.NewWriter(w)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// checkCompression returns an error if the compression algorithm and level cannot be written.
+func checkCompression(compression string, level int) error {
+	switch compression {
+	case CompressionNone, CompressionZstd:
+		return nil
+	case CompressionGzip:
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("invalid gzip compression level %d", level)
+		}
+		return nil
+	case CompressionXZ:
+		if level != 0 {
+			return errors.New("xz compression does not support setting a level")
+		}
+		return nil
+	case CompressionBzip2:
+		return errors.New("bzip2 compression is only supported for reading")
+	}
+	return fmt.Errorf("unsupported compression %q", compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
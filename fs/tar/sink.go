@@ -3,13 +3,26 @@ package tar
 import (
 	archivetar "archive/tar"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	stdpath "path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/fs/internal/fsutil"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// Whiteout files of the OCI image spec.
+const (
+	// WhiteoutPrefix marks a file that hides the file without the prefix in lower layers.
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaque hides all children of its directory in lower layers.
+	WhiteoutOpaque = ".wh..wh..opq"
 )
 
 type Sink struct {
@@ -17,6 +30,21 @@ type Sink struct {
 	format         archivetar.Format
 	root           string
 	xattrPaxPrefix string
+	// xattrEncoding selects the PAX records that xattrs are written as.
+	xattrEncoding string
+	// out is the stream below the writer.
+	// Sparse entries are written to it directly.
+	out io.Writer
+	// sparse enables writing files with holes as sparse entries.
+	sparse bool
+	// whiteouts enables writing whiteout nodes and opaque directories
+	// as OCI whiteout files.
+	whiteouts bool
+	// skipUnsupported skips nodes that tar cannot represent (sockets) instead of failing.
+	skipUnsupported bool
+	// links maps the path of regular files to the name they were written as.
+	// It is used to emit hardlinks for the second and later occurrences of a file.
+	links map[string]string
 }
 
 func (s *Sink) Consume(in fs.FS) error {
@@ -30,23 +58,47 @@ func (s *Sink) Consume(in fs.FS) error {
 		isRoot := path == "." || path == "/"
 		if isRoot && s.root == "" {
 			// skip root
+			return s.writeOpaque(in, path)
+		}
+		header, contentPath, err := s.prepareHeader(in, path, d)
+		if errors.Is(err, kind.ErrUnsupported) && s.skipUnsupported {
 			return nil
 		}
-		header, err := s.prepareHeader(path, d)
 		if err != nil {
 			return err
 		}
-		if err := s.writer.WriteHeader(header); err != nil {
-			return err
+		if header.Typeflag == archivetar.TypeDir {
+			if err := s.writer.WriteHeader(header); err != nil {
+				return err
+			}
+			return s.writeOpaque(in, path)
+		}
+		if header.Typeflag == archivetar.TypeReg && contentPath == "" {
+			// whiteout files are empty
+			return s.writer.WriteHeader(header)
+		}
+		var holes sparse.HoleMap
+		if s.sparse && header.Typeflag == archivetar.TypeReg {
+			if holes, err = holesFromTree(in, contentPath); err != nil {
+				return err
+			}
+		}
+		if len(holes) == 0 {
+			if err := s.writer.WriteHeader(header); err != nil {
+				return err
+			}
 		}
-		if !d.Type().IsRegular() {
+		if header.Typeflag != archivetar.TypeReg {
 			return nil
 		}
-		file, err := in.Open(path)
+		file, err := in.Open(contentPath)
 		if err != nil {
 			return err
 		}
 		defer file.Close()
+		if len(holes) > 0 {
+			return s.writeSparse(header, holes, file)
+		}
 		if _, err := io.Copy(s.writer, file); err != nil {
 			return err
 		}
@@ -54,17 +106,68 @@ func (s *Sink) Consume(in fs.FS) error {
 	})
 }
 
-func (s *Sink) prepareHeader(path string, d fs.DirEntry) (*archivetar.Header, error) {
+// prepareHeader returns the header for the node and the path of the node
+// that provides the file contents (for regular files).
+// Nodes that tar cannot represent (sockets) return a kind.ErrUnsupported.
+func (s *Sink) prepareHeader(in fs.FS, path string, d fs.DirEntry) (*archivetar.Header, string, error) {
 	// if the dirEntry comes from a api.Tree, get the api.Stat from it
 	// otherwise, use only the subset that is available in fs.FileInfo
 	info, err := d.Info()
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	if stat, hasStat := info.Sys().(api.Stat); hasStat {
-		return s.prepareHeaderFromStat(path, stat)
+		return s.prepareHeaderFromTreeStat(in, path, stat)
+	}
+	header, err := s.prepareHeaderFromFileInfo(path, d, info)
+	return header, path, err
+}
+
+// prepareHeaderFromTreeStat resolves hardlinks.
+// The first occurrence of a file (in walk order) is written as regular file,
+// all later occurrences are written as hardlinks to the first one.
+func (s *Sink) prepareHeaderFromTreeStat(in fs.FS, path string, stat api.Stat) (*archivetar.Header, string, error) {
+	contentPath := path
+	switch stat.Kind {
+	case api.KindRegular:
+	case kind.Socket:
+		return nil, "", kind.Unsupported(path, stat.Kind)
+	case kind.Whiteout:
+		if !s.whiteouts {
+			return nil, "", fmt.Errorf("whiteout %q requires the whiteouts option", path)
+		}
+		return s.whiteoutHeader(stdpath.Dir(path), WhiteoutPrefix+stdpath.Base(path)), "", nil
+	case kind.Hardlink:
+		contentPath = strings.TrimPrefix(stdpath.Clean(stat.Payload), "/")
+		targetStat, err := fsutil.HardlinkTarget(in, contentPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolving hardlink %q: %w", path, err)
+		}
+		stat = targetStat
+	default:
+		if tarTypeFromKind(stat.Kind) == 0 {
+			return nil, "", kind.Unsupported(path, stat.Kind)
+		}
+		header, err := s.prepareHeaderFromStat(path, stat)
+		return header, path, err
 	}
-	return s.prepareHeaderFromFileInfo(path, d, info)
+
+	if linkname, ok := s.links[contentPath]; ok {
+		header, err := s.prepareHeaderFromStat(path, stat)
+		if err != nil {
+			return nil, "", err
+		}
+		header.Typeflag = archivetar.TypeLink
+		header.Linkname = linkname
+		header.Size = 0
+		return header, "", nil
+	}
+	header, err := s.prepareHeaderFromStat(path, stat)
+	if err != nil {
+		return nil, "", err
+	}
+	s.links[contentPath] = header.Name
+	return header, contentPath, nil
 }
 
 func (s *Sink) prepareHeaderFromStat(path string, stat api.Stat) (*archivetar.Header, error) {
@@ -103,35 +206,90 @@ func (s *Sink) prepareHeaderFromStat(path string, stat api.Stat) (*archivetar.He
 		gid = int(gid64)
 	}
 
+	var devmajor, devminor uint32
+	if kind.IsDevice(stat.Kind) {
+		var err error
+		devmajor, devminor, err = kind.ParseDevice(stat.Payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var paxRecords map[string]string
 	if len(stat.Attributes.XAttrs) > 0 {
 		paxRecords = make(map[string]string, len(stat.Attributes.XAttrs))
 		for key, value := range stat.Attributes.XAttrs {
-			paxRecords[s.xattrPaxPrefix+key] = value
+			// PAX keys cannot contain '=', such xattrs can only be stored
+			// as libarchive records (with an url encoded key).
+			writeSchily := s.xattrEncoding == XAttrEncodingSchily ||
+				(s.xattrEncoding == XAttrEncodingBoth && !strings.Contains(key, "="))
+			if writeSchily {
+				paxRecords[s.xattrPaxPrefix+key] = value
+			}
+			if s.xattrEncoding != XAttrEncodingSchily {
+				encodedKey, encodedValue := encodeLibarchiveXAttr(key, value)
+				paxRecords[XAttrPrefixLibarchive+encodedKey] = encodedValue
+			}
 		}
 	}
 
+	// only regular files have contents in the archive
+	// (sources like dir report the size of directories and symlinks)
+	var size int64
+	if stat.Kind == api.KindRegular {
+		size = stat.Size
+	}
+
 	return &archivetar.Header{
 		Typeflag:   tarTypeFromKind(stat.Kind),
 		Name:       name,
 		Linkname:   linkname,
-		Size:       stat.Size,
+		Size:       size,
 		Mode:       mode,
 		Uid:        uid,
 		Gid:        gid,
 		Uname:      stat.Attributes.UserName,
 		Gname:      stat.Attributes.GroupName,
 		ModTime:    stat.Attributes.Mtime,
+		Devmajor:   int64(devmajor),
+		Devminor:   int64(devminor),
 		PAXRecords: paxRecords,
 		Format:     s.format,
 	}, nil
 }
 
+// writeOpaque writes the opaque marker of the directory at the given path
+// if whiteouts are enabled and the directory is opaque.
+func (s *Sink) writeOpaque(in fs.FS, path string) error {
+	if !s.whiteouts {
+		return nil
+	}
+	info, err := fs.Stat(in, path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(api.Stat)
+	if !ok || !kind.IsOpaque(stat) {
+		return nil
+	}
+	return s.writer.WriteHeader(s.whiteoutHeader(path, WhiteoutOpaque))
+}
+
+// whiteoutHeader returns the header of an empty whiteout file in the given directory.
+func (s *Sink) whiteoutHeader(dir, base string) *archivetar.Header {
+	return &archivetar.Header{
+		Typeflag: archivetar.TypeReg,
+		Name:     s.name(stdpath.Join(dir, base), false),
+		ModTime:  time.Unix(0, 0),
+		Format:   s.format,
+	}
+}
+
 func (s *Sink) prepareHeaderFromFileInfo(path string, d fs.DirEntry, info fs.FileInfo) (*archivetar.Header, error) {
 	name := s.name(path, info.IsDir())
 	var link string
 	if d.Type()&fs.ModeSymlink != 0 {
-		readLinkFS, ok := d.(readLinkFS)
+		readLinkFS, ok := d.(fsutil.ReadLinkFS)
 		if !ok {
 			return nil, errors.New("symlink given but fs does not implement readLinkFS")
 		}
@@ -162,24 +320,38 @@ func (s *Sink) name(path string, isDir bool) string {
 	return name
 }
 
+// holesFromTree returns the hole map of the regular file at the given path.
+// It returns nil if the fs does not provide an api.Stat or the holes are unknown.
+func holesFromTree(in fs.FS, path string) (sparse.HoleMap, error) {
+	info, err := fs.Stat(in, path)
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(api.Stat)
+	if !ok {
+		return nil, nil
+	}
+	return sparse.FromFS(in, stat)
+}
+
 func newDefaultWriter(w io.Writer) Writer {
 	return archivetar.NewWriter(w)
 }
 
-func tarTypeFromKind(kind string) byte {
-	// TODO: handle other kinds
-	switch kind {
+func tarTypeFromKind(nodeKind string) byte {
+	switch nodeKind {
 	case api.KindDirectory:
 		return archivetar.TypeDir
 	case api.KindSymlink:
 		return archivetar.TypeSymlink
 	case api.KindRegular:
 		return archivetar.TypeReg
+	case kind.CharDevice:
+		return archivetar.TypeChar
+	case kind.BlockDevice:
+		return archivetar.TypeBlock
+	case kind.FIFO:
+		return archivetar.TypeFifo
 	}
 	return 0
 }
-
-type readLinkFS interface {
-	fs.FS
-	Readlink(string) (string, error)
-}
@@ -1,8 +1,10 @@
 package tar
 
 import (
+	"fmt"
 	"io"
 	"io/fs"
+	"path"
 	"strconv"
 	"strings"
 
@@ -10,6 +12,8 @@ import (
 
 	"github.com/malt3/abstractfs-core/api"
 	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/sparse"
 )
 
 type Source struct {
@@ -17,6 +21,11 @@ type Source struct {
 	reader           Reader
 	sriAlgorithm     sri.Algorithm
 	xattrPaxPrefixes []string
+	// externalLinks allows hardlinks to files that are not in the archive.
+	externalLinks bool
+	// files maps the name of every regular file and hardlink seen so far
+	// to the name of the regular file holding the contents.
+	files map[string]string
 }
 
 func (s *Source) Next() (api.SourceNode, error) {
@@ -28,18 +37,15 @@ func (s *Source) Next() (api.SourceNode, error) {
 }
 
 func (s *Source) prepareNext(header *archivetar.Header) (api.SourceNode, error) {
-	name := header.Name
-	if !strings.HasPrefix(name, "/") {
-		name = "/" + name
-	}
+	name := normalizeName(header.Name)
 	kind := kindFromTarType(header.Typeflag)
 
-	payload, err := s.payload(header, kind)
+	payload, err := s.payload(name, header, kind)
 	if err != nil {
 		return api.SourceNode{}, err
 	}
 
-	attributes := s.nodeAttributes(header)
+	attributes, err := s.nodeAttributes(header)
 	if err != nil {
 		return api.SourceNode{}, err
 	}
@@ -58,43 +64,55 @@ func (s *Source) prepareNext(header *archivetar.Header) (api.SourceNode, error)
 	return node, nil
 }
 
-func (s *Source) payload(header *archivetar.Header, kind string) (string, error) {
-	switch kind {
+func (s *Source) payload(name string, header *archivetar.Header, nodeKind string) (string, error) {
+	switch nodeKind {
 	case api.KindSymlink:
 		return header.Linkname, nil
+	case kind.Hardlink:
+		// hardlinks always point to an earlier entry
+		target, ok := s.files[normalizeName(header.Linkname)]
+		if !ok && s.externalLinks {
+			target, ok = normalizeName(header.Linkname), true
+		}
+		if !ok {
+			return "", fmt.Errorf("hardlink %q points to unknown file %q", header.Name, header.Linkname)
+		}
+		s.files[name] = target
+		return target, nil
+	case kind.CharDevice, kind.BlockDevice:
+		return kind.DevicePayload(uint32(header.Devmajor), uint32(header.Devminor)), nil
 	case api.KindRegular:
 		// handled below
 	default:
 		return "", nil
 	}
-	integrity, err := s.Record(s.reader, header.Size, s.sriAlgorithm)
+	integrity, err := s.Record(s.reader, header.Size, s.holes(), s.sriAlgorithm)
 	if err != nil {
 		return "", err
 	}
+	s.files[name] = name
 	return integrity, nil
 }
 
-func (s *Source) nodeAttributes(header *archivetar.Header) api.NodeAttributes {
+// holes returns the hole map of the current entry.
+func (s *Source) holes() sparse.HoleMap {
+	sparseReader, ok := s.reader.(SparseReader)
+	if !ok {
+		return nil
+	}
+	return sparseReader.Holes()
+}
+
+func (s *Source) nodeAttributes(header *archivetar.Header) (api.NodeAttributes, error) {
 	mtime := header.ModTime.UTC()
 	userID := strconv.Itoa(header.Uid)
 	groupID := strconv.Itoa(header.Gid)
 	userName := header.Uname
 	groupName := header.Gname
 	mode := "0o" + strconv.FormatInt(int64(header.Mode), 8)
-	xattrs := make(map[string]string)
-	// TODO: support different encodings
-	// including libarchive xattrs
-	for _, xattrPaxPrefix := range s.xattrPaxPrefixes {
-		for key, value := range header.PAXRecords {
-			// TODO: find out if null bytes should be preserved
-			// if strings.HasSuffix(value, "\x00") {
-			// 	value = value[:len(value)-1]
-			// }
-			if strings.HasPrefix(key, xattrPaxPrefix) {
-				key = strings.TrimPrefix(key, xattrPaxPrefix)
-				xattrs[key] = value
-			}
-		}
+	xattrs, err := s.xattrs(header)
+	if err != nil {
+		return api.NodeAttributes{}, err
 	}
 	return api.NodeAttributes{
 		Mtime:     mtime,
@@ -104,7 +122,36 @@ func (s *Source) nodeAttributes(header *archivetar.Header) api.NodeAttributes {
 		GroupName: groupName,
 		Mode:      mode,
 		XAttrs:    xattrs,
+	}, nil
+}
+
+// xattrs returns the xattrs of the entry.
+// The xattr prefixes are ordered by precedence: xattrs are only read from the records
+// of the first prefix that has any records in the header.
+// Tools like bsdtar write the same xattrs with multiple encodings,
+// so merging them would duplicate xattrs with encoded keys.
+func (s *Source) xattrs(header *archivetar.Header) (map[string]string, error) {
+	xattrs := make(map[string]string)
+	for _, xattrPaxPrefix := range s.xattrPaxPrefixes {
+		for key, value := range header.PAXRecords {
+			// TODO: find out if null bytes should be preserved
+			// if strings.HasSuffix(value, "\x00") {
+			// 	value = value[:len(value)-1]
+			// }
+			if !strings.HasPrefix(key, xattrPaxPrefix) {
+				continue
+			}
+			key, value, err := decodeXAttr(xattrPaxPrefix, strings.TrimPrefix(key, xattrPaxPrefix), value)
+			if err != nil {
+				return nil, fmt.Errorf("reading xattrs of %q: %w", header.Name, err)
+			}
+			xattrs[key] = value
+		}
+		if len(xattrs) > 0 {
+			break
+		}
 	}
+	return xattrs, nil
 }
 
 func (s *Source) openFunc(kind, payload string) func() (io.ReadCloser, error) {
@@ -119,24 +166,34 @@ func (s *Source) openFunc(kind, payload string) func() (io.ReadCloser, error) {
 	}
 }
 
-func newDefaultReader(r io.Reader) Reader {
-	return archivetar.NewReader(r)
+// normalizeName returns the absolute, cleaned path of a tar entry.
+// Entries like "./etc/" and "etc" both map to "/etc".
+func normalizeName(name string) string {
+	return path.Clean("/" + name)
 }
 
 func kindFromTarType(tarType byte) string {
-	// TODO: support other types
 	switch tarType {
+	case archivetar.TypeLink:
+		return kind.Hardlink
 	case archivetar.TypeDir:
 		return api.KindDirectory
-	case archivetar.TypeReg:
+	case archivetar.TypeReg, archivetar.TypeGNUSparse:
 		return api.KindRegular
 	case archivetar.TypeSymlink:
 		return api.KindSymlink
+	case archivetar.TypeChar:
+		return kind.CharDevice
+	case archivetar.TypeBlock:
+		return kind.BlockDevice
+	case archivetar.TypeFifo:
+		return kind.FIFO
 	}
 	return ""
 }
 
 var (
-	_ api.Source    = (*Source)(nil)
-	_ api.CASReader = (*Source)(nil)
+	_ api.Source        = (*Source)(nil)
+	_ api.CASReader     = (*Source)(nil)
+	_ sparse.HoleReader = (*Source)(nil)
 )
@@ -0,0 +1,362 @@
+package tar
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// SparseReader is implemented by readers that expose the hole map of sparse entries.
+type SparseReader interface {
+	// Holes returns the hole map of the current entry.
+	// It returns nil if the entry is not sparse.
+	Holes() sparse.HoleMap
+}
+
+// defaultReader wraps archive/tar.Reader.
+// archive/tar expands sparse entries into their full contents, but does not
+// expose the sparse map. The raw headers of every entry are recorded,
+// so that the sparse map can be parsed again.
+type defaultReader struct {
+	*archivetar.Reader
+	raw   *headerRecorder
+	holes sparse.HoleMap
+	// next is the offset of the header of the next entry.
+	next int64
+}
+
+func newDefaultReader(r io.Reader) Reader {
+	raw := &headerRecorder{r: r}
+	return &defaultReader{
+		Reader: archivetar.NewReader(raw),
+		raw:    raw,
+	}
+}
+
+func (r *defaultReader) Next() (*archivetar.Header, error) {
+	r.holes = nil
+	// archive/tar first skips the unread contents of the current entry,
+	// only the headers of the next entry are recorded
+	r.raw.start(r.next)
+	header, err := r.Reader.Next()
+	blocks, overflow := r.raw.stop()
+	if err != nil {
+		return header, err
+	}
+	if !isSparse(header) {
+		r.next = r.raw.offset + physicalSize(header)
+		return header, nil
+	}
+	if overflow {
+		return nil, fmt.Errorf("reading sparse map of %q: headers too large", header.Name)
+	}
+	data, err := sparseData(header, blocks)
+	if err != nil {
+		return nil, fmt.Errorf("reading sparse map of %q: %w", header.Name, err)
+	}
+	r.holes = sparse.FromData(data, header.Size)
+	// the contents of sparse entries are their data regions
+	var dataSize int64
+	for _, region := range data {
+		dataSize += region.Length
+	}
+	r.next = r.raw.offset + dataSize + blockPadding(dataSize)
+	return header, nil
+}
+
+func (r *defaultReader) Holes() sparse.HoleMap {
+	return r.holes
+}
+
+// physicalSize returns the size of the contents of a (not sparse) entry in the archive,
+// including the padding.
+func physicalSize(header *archivetar.Header) int64 {
+	if isHeaderOnly(header.Typeflag) {
+		return 0
+	}
+	return header.Size + blockPadding(header.Size)
+}
+
+// isHeaderOnly returns true for entries whose contents archive/tar ignores.
+func isHeaderOnly(typeflag byte) bool {
+	switch typeflag {
+	case archivetar.TypeLink, archivetar.TypeSymlink, archivetar.TypeChar,
+		archivetar.TypeBlock, archivetar.TypeDir, archivetar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+// headerRecorder records the bytes read by the tar reader while it reads headers.
+// It is an io.Seeker if the underlying reader is one,
+// so archive/tar can seek over the contents of entries.
+type headerRecorder struct {
+	r io.Reader
+	// offset is the number of bytes read or skipped so far.
+	offset    int64
+	recording bool
+	// from is the offset of the first recorded byte.
+	from     int64
+	overflow bool
+	buf      []byte
+}
+
+func (h *headerRecorder) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if end := h.offset + int64(n); h.recording && end > h.from {
+		recorded := p[:n]
+		if h.offset < h.from {
+			recorded = p[h.from-h.offset : n]
+		}
+		if len(h.buf)+len(recorded) > maxRecordedHeader {
+			h.overflow = true
+		} else {
+			h.buf = append(h.buf, recorded...)
+		}
+	}
+	h.offset += int64(n)
+	return n, err
+}
+
+// Seek supports the relative seeks that archive/tar uses to skip contents.
+func (h *headerRecorder) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := h.r.(io.Seeker)
+	if !ok || whence != io.SeekCurrent {
+		return 0, errors.New("seek not supported")
+	}
+	before, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	after, err := seeker.Seek(offset, io.SeekCurrent)
+	if err != nil {
+		return after, err
+	}
+	h.offset += after - before
+	return after, nil
+}
+
+// start starts recording at the given offset.
+// Bytes before the offset are not recorded.
+func (h *headerRecorder) start(from int64) {
+	h.recording = true
+	h.overflow = false
+	h.buf = h.buf[:0]
+	h.from = from
+}
+
+// stop ends recording and returns the recorded blocks.
+func (h *headerRecorder) stop() ([]byte, bool) {
+	h.recording = false
+	return h.buf, h.overflow
+}
+
+// isSparse returns true if the header describes a sparse entry in any of the supported formats
+// (old GNU, PAX 0.0, PAX 0.1 and PAX 1.0).
+func isSparse(header *archivetar.Header) bool {
+	if header.Typeflag == archivetar.TypeGNUSparse {
+		return true
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, paxGNUSparse) {
+			return true
+		}
+	}
+	return false
+}
+
+// sparseData returns the data regions of a sparse entry.
+// The blocks are the raw tar blocks read for the header.
+func sparseData(header *archivetar.Header, blocks []byte) ([]sparse.Region, error) {
+	if header.Typeflag == archivetar.TypeGNUSparse {
+		return oldGNUSparseData(blocks)
+	}
+	major, minor := header.PAXRecords[paxGNUSparseMajor], header.PAXRecords[paxGNUSparseMinor]
+	switch {
+	case major == "1" && minor == "0":
+		// the sparse map is stored in the blocks following the last header block
+		last := lastHeaderBlock(blocks, 0)
+		if last < 0 {
+			return nil, errors.New("header not found")
+		}
+		return parseSparseMap1x0(blocks[last+blockSize:])
+	case header.PAXRecords[paxGNUSparseMap] != "":
+		// archive/tar converts the 0.0 format into the 0.1 format
+		return parseSparseMap0x1(header.PAXRecords[paxGNUSparseMap])
+	}
+	return nil, fmt.Errorf("unsupported sparse format %s.%s", major, minor)
+}
+
+// oldGNUSparseData parses the sparse map of the old GNU format.
+// Up to four entries are stored in the header, more entries follow in extension blocks.
+func oldGNUSparseData(blocks []byte) ([]sparse.Region, error) {
+	start := lastHeaderBlock(blocks, archivetar.TypeGNUSparse)
+	if start < 0 {
+		return nil, errors.New("header not found")
+	}
+	block := blocks[start : start+blockSize]
+	data, err := appendSparseEntries(nil, block[386:482])
+	if err != nil {
+		return nil, err
+	}
+	isExtended := block[482] != 0
+	for offset := start + blockSize; isExtended; offset += blockSize {
+		if offset+blockSize > len(blocks) {
+			return nil, errors.New("truncated sparse header")
+		}
+		block := blocks[offset : offset+blockSize]
+		if data, err = appendSparseEntries(data, block[:504]); err != nil {
+			return nil, err
+		}
+		isExtended = block[504] != 0
+	}
+	return data, nil
+}
+
+// appendSparseEntries parses 24 byte entries (12 byte offset, 12 byte length).
+func appendSparseEntries(data []sparse.Region, raw []byte) ([]sparse.Region, error) {
+	for ; len(raw) >= 24; raw = raw[24:] {
+		if raw[0] == 0 {
+			break
+		}
+		offset, err := parseNumeric(raw[:12])
+		if err != nil {
+			return nil, err
+		}
+		length, err := parseNumeric(raw[12:24])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sparse.Region{Offset: offset, Length: length})
+	}
+	return data, nil
+}
+
+// parseSparseMap1x0 parses the sparse map of the PAX 1.0 format.
+// It is a newline separated list of decimal numbers:
+// the number of entries, followed by offset and length of every entry.
+func parseSparseMap1x0(raw []byte) ([]sparse.Region, error) {
+	fields := bytes.Split(raw, []byte{'\n'})
+	next := func() (int64, error) {
+		if len(fields) == 0 {
+			return 0, errors.New("truncated sparse map")
+		}
+		field := fields[0]
+		fields = fields[1:]
+		return strconv.ParseInt(string(field), 10, 64)
+	}
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || 2*count > int64(len(fields)) {
+		return nil, errors.New("invalid number of sparse entries")
+	}
+	data := make([]sparse.Region, 0, count)
+	for i := int64(0); i < count; i++ {
+		offset, err := next()
+		if err != nil {
+			return nil, err
+		}
+		length, err := next()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sparse.Region{Offset: offset, Length: length})
+	}
+	return data, nil
+}
+
+// parseSparseMap0x1 parses the comma separated sparse map of the PAX 0.1 format.
+func parseSparseMap0x1(raw string) ([]sparse.Region, error) {
+	fields := strings.Split(raw, ",")
+	if len(fields)%2 != 0 {
+		return nil, errors.New("odd number of sparse map fields")
+	}
+	data := make([]sparse.Region, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		offset, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sparse.Region{Offset: offset, Length: length})
+	}
+	return data, nil
+}
+
+// lastHeaderBlock returns the offset of the last block with a valid header checksum.
+// If typeflag is not 0, only headers of that type are considered.
+func lastHeaderBlock(blocks []byte, typeflag byte) int {
+	for offset := len(blocks)/blockSize*blockSize - blockSize; offset >= 0; offset -= blockSize {
+		block := blocks[offset : offset+blockSize]
+		if typeflag != 0 && block[156] != typeflag {
+			continue
+		}
+		if validChecksum(block) {
+			return offset
+		}
+	}
+	return -1
+}
+
+func validChecksum(block []byte) bool {
+	want, err := parseNumeric(block[148:156])
+	if err != nil {
+		return false
+	}
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return want == unsigned || want == signed
+}
+
+// parseNumeric parses an octal or (GNU) base-256 encoded number.
+func parseNumeric(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		value := int64(field[0] & 0x7f)
+		for _, b := range field[1:] {
+			if value > math.MaxInt64>>8 {
+				return 0, errors.New("numeric field overflows")
+			}
+			value = value<<8 | int64(b)
+		}
+		return value, nil
+	}
+	trimmed := strings.Trim(string(field), " \x00")
+	if trimmed == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(trimmed, 8, 64)
+}
+
+const (
+	blockSize = 512
+	// maxRecordedHeader limits the memory used for recording headers.
+	maxRecordedHeader = 16 * 1024 * 1024
+
+	paxGNUSparse      = "GNU.sparse."
+	paxGNUSparseMap   = "GNU.sparse.map"
+	paxGNUSparseMajor = "GNU.sparse.major"
+	paxGNUSparseMinor = "GNU.sparse.minor"
+)
+
+var (
+	_ SparseReader = (*defaultReader)(nil)
+	_ io.Seeker    = (*headerRecorder)(nil)
+)
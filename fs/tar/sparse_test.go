@@ -0,0 +1,381 @@
+package tar
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// testSparseFile has more data regions than fit into an old GNU header.
+var testSparseFile = sparseFile{
+	size: 10*4096 + 100,
+	data: []sparse.Region{
+		{Offset: 0, Length: 100},
+		{Offset: 4096, Length: 10},
+		{Offset: 2 * 4096, Length: 600},
+		{Offset: 4 * 4096, Length: 1},
+		{Offset: 6 * 4096, Length: 4096},
+		{Offset: 10 * 4096, Length: 100},
+	},
+}
+
+func TestReadSparse(t *testing.T) {
+	formats := map[string]func(*bytes.Buffer, string, sparseFile){
+		"old GNU": writeOldGNUSparse,
+		"PAX 0.1": writePAX01Sparse,
+		"PAX 1.0": writePAX10Sparse,
+	}
+	inputs := map[string]func([]byte) io.Reader{
+		"seekable":     func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"not seekable": func(b []byte) io.Reader { return struct{ io.Reader }{bytes.NewReader(b)} },
+	}
+	for formatName, writeSparse := range formats {
+		for inputName, newInput := range inputs {
+			t.Run(formatName+"/"+inputName, func(t *testing.T) {
+				var archive bytes.Buffer
+				writeRegular(&archive, "before", bytes.Repeat([]byte{'x'}, 1000))
+				writeSparse(&archive, "sparse", testSparseFile)
+				// a large entry that is not read must not be recorded as header
+				writeRegular(&archive, "large", make([]byte, maxRecordedHeader+1))
+				writeSparse(&archive, "sparse-after-large", testSparseFile)
+				writeRegular(&archive, "after", []byte("after"))
+				archive.Write(make([]byte, 2*blockSize))
+
+				reader := newDefaultReader(newInput(archive.Bytes()))
+				var names []string
+				for {
+					header, err := reader.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					names = append(names, header.Name)
+					holes := reader.(SparseReader).Holes()
+					if !strings.HasPrefix(header.Name, "sparse") {
+						if holes != nil {
+							t.Errorf("%s: got holes %v for a regular entry", header.Name, holes)
+						}
+						continue
+					}
+					if want := testSparseFile.holes(); !reflect.DeepEqual(holes, want) {
+						t.Errorf("%s: got holes %v, want %v", header.Name, holes, want)
+					}
+					contents, err := io.ReadAll(reader)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(contents, testSparseFile.contents()) {
+						t.Errorf("%s: contents differ", header.Name)
+					}
+				}
+				want := []string{"before", "sparse", "large", "sparse-after-large", "after"}
+				if !reflect.DeepEqual(names, want) {
+					t.Errorf("got entries %v, want %v", names, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSparseRoundTrip(t *testing.T) {
+	contents := testSparseFile.contents()
+	sris, err := cas.Hash(bytes.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := holeCAS{CAS: memory.NewCAS(false), holes: map[string]sparse.HoleMap{sris[0]: testSparseFile.holes()}}
+	if err := blobs.Write(sris[0], bytes.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	tree := api.Tree{Root: &api.Node{Stat: api.Stat{Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}}}}
+	if err := coretree.Insert(tree, "/", api.Stat{
+		Name:       "sparse",
+		Kind:       api.KindRegular,
+		Size:       testSparseFile.size,
+		Payload:    sris[0],
+		Attributes: api.NodeAttributes{Mode: "0o644"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	sink, closeSink, err := (&SinkBuilder{}).WithIOWriter(&archive).WithSparse(true).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Consume(&coretree.TreeFS{Tree: tree, CASReader: blobs}); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeSink(); err != nil {
+		t.Fatal(err)
+	}
+
+	// archive/tar understands the written entry
+	reader := archivetar.NewReader(bytes.NewReader(archive.Bytes()))
+	header, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Name != "sparse" || header.Size != testSparseFile.size {
+		t.Errorf("archive/tar read %q with size %d, want %q with size %d", header.Name, header.Size, "sparse", testSparseFile.size)
+	}
+	if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, contents) {
+		t.Errorf("archive/tar read different contents (err %v)", err)
+	}
+
+	// the source restores the holes
+	source, closeSource, err := (&SourceBuilder{}).WithIOReader(bytes.NewReader(archive.Bytes())).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	node, err := source.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Stat.Name != "/sparse" || node.Stat.Payload != sris[0] {
+		t.Fatalf("got %s with payload %s, want /sparse with payload %s", node.Stat.Name, node.Stat.Payload, sris[0])
+	}
+	if holes := sparse.HolesOf(source.(api.CASReader), sris[0]); !reflect.DeepEqual(holes, testSparseFile.holes()) {
+		t.Errorf("got holes %v, want %v", holes, testSparseFile.holes())
+	}
+}
+
+func TestParseSparseMap1x0(t *testing.T) {
+	testCases := map[string]struct {
+		raw     string
+		want    []sparse.Region
+		wantErr bool
+	}{
+		"entries": {
+			raw:  "2\n0\n10\n4096\n0\n\x00\x00",
+			want: []sparse.Region{{Offset: 0, Length: 10}, {Offset: 4096, Length: 0}},
+		},
+		"no entries": {
+			raw:  "0\n",
+			want: []sparse.Region{},
+		},
+		"truncated": {
+			raw:     "2\n0\n10\n",
+			wantErr: true,
+		},
+		"negative count": {
+			raw:     "-1\n",
+			wantErr: true,
+		},
+		"not a number": {
+			raw:     "1\nx\n10\n",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseSparseMap1x0([]byte(tc.raw))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseSparseMap1x0() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseSparseMap1x0() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLastHeaderBlock(t *testing.T) {
+	regular := formatUSTARHeader(&archivetar.Header{Name: "regular"}, archivetar.TypeReg)
+	gnuSparse := formatUSTARHeader(&archivetar.Header{Name: "sparse"}, archivetar.TypeGNUSparse)
+	data := bytes.Repeat([]byte{'1'}, blockSize)
+	join := func(blocks ...[]byte) []byte { return bytes.Join(blocks, nil) }
+
+	testCases := map[string]struct {
+		blocks   []byte
+		typeflag byte
+		want     int
+	}{
+		"last valid header":   {blocks: join(regular, regular, data), want: blockSize},
+		"filtered by type":    {blocks: join(gnuSparse, data, regular), typeflag: archivetar.TypeGNUSparse, want: 0},
+		"no header":           {blocks: join(data, data), want: -1},
+		"partial block":       {blocks: append(join(regular), 'x'), want: 0},
+		"not of the type":     {blocks: join(regular), typeflag: archivetar.TypeGNUSparse, want: -1},
+		"corrupted checksum":  {blocks: join(corrupt(regular)), want: -1},
+		"empty":               {want: -1},
+		"header after header": {blocks: join(gnuSparse, regular), want: blockSize},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := lastHeaderBlock(tc.blocks, tc.typeflag); got != tc.want {
+				t.Errorf("lastHeaderBlock() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// sparseFile describes the contents of a sparse test file.
+type sparseFile struct {
+	size int64
+	data []sparse.Region
+}
+
+func (f sparseFile) holes() sparse.HoleMap {
+	return sparse.FromData(f.data, f.size)
+}
+
+// contents returns the full contents. Every data region is filled with a different letter.
+func (f sparseFile) contents() []byte {
+	contents := make([]byte, f.size)
+	for i, region := range f.data {
+		copy(contents[region.Offset:], bytes.Repeat([]byte{byte('a' + i)}, int(region.Length)))
+	}
+	return contents
+}
+
+// dataBytes returns the data regions back to back, as stored in sparse entries.
+func (f sparseFile) dataBytes() []byte {
+	contents := f.contents()
+	var data []byte
+	for _, region := range f.data {
+		data = append(data, contents[region.Offset:region.Offset+region.Length]...)
+	}
+	return data
+}
+
+func writeRegular(archive *bytes.Buffer, name string, contents []byte) {
+	archive.Write(formatUSTARHeader(&archivetar.Header{Name: name, Mode: 0o644, Size: int64(len(contents))}, archivetar.TypeReg))
+	writePadded(archive, contents)
+}
+
+// writeOldGNUSparse writes an old GNU sparse entry ('S').
+// The header holds 4 entries of the sparse map, extension blocks 21 entries each.
+func writeOldGNUSparse(archive *bytes.Buffer, name string, f sparseFile) {
+	data := f.dataBytes()
+	block := formatUSTARHeader(&archivetar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}, archivetar.TypeGNUSparse)
+	copy(block[257:265], "ustar  \x00")
+	formatOctal(block[483:495], f.size)
+	entries := f.data
+	for i := 0; i < 4 && len(entries) > 0; i++ {
+		formatSparseEntry(block[386+24*i:], entries[0])
+		entries = entries[1:]
+	}
+	var extensions [][]byte
+	for len(entries) > 0 {
+		extension := make([]byte, blockSize)
+		for i := 0; i < 21 && len(entries) > 0; i++ {
+			formatSparseEntry(extension[24*i:], entries[0])
+			entries = entries[1:]
+		}
+		extensions = append(extensions, extension)
+	}
+	if len(extensions) > 0 {
+		block[482] = 1
+	}
+	for i := 0; i < len(extensions)-1; i++ {
+		extensions[i][504] = 1
+	}
+	setChecksum(block)
+	archive.Write(block)
+	for _, extension := range extensions {
+		archive.Write(extension)
+	}
+	writePadded(archive, data)
+}
+
+// writePAX01Sparse writes a GNU PAX 0.1 sparse entry, with the sparse map in a PAX record.
+func writePAX01Sparse(archive *bytes.Buffer, name string, f sparseFile) {
+	var sparseMap []string
+	for _, region := range f.data {
+		sparseMap = append(sparseMap, strconv.FormatInt(region.Offset, 10), strconv.FormatInt(region.Length, 10))
+	}
+	records := formatPAXRecords(map[string]string{
+		paxGNUSparseMajor:       "0",
+		paxGNUSparseMinor:       "1",
+		paxGNUSparseName:        name,
+		"GNU.sparse.size":       strconv.FormatInt(f.size, 10),
+		"GNU.sparse.numblocks":  strconv.Itoa(len(f.data)),
+		paxGNUSparseMap:         strings.Join(sparseMap, ","),
+		paxGNUSparse + "unused": "",
+	})
+	archive.Write(formatUSTARHeader(&archivetar.Header{Name: "PaxHeaders/" + name, Size: int64(len(records))}, archivetar.TypeXHeader))
+	writePadded(archive, records)
+	data := f.dataBytes()
+	archive.Write(formatUSTARHeader(&archivetar.Header{Name: "GNUSparseFile.0/" + name, Mode: 0o644, Size: int64(len(data))}, archivetar.TypeReg))
+	writePadded(archive, data)
+}
+
+// writePAX10Sparse writes a GNU PAX 1.0 sparse entry, with the sparse map in front of the data.
+func writePAX10Sparse(archive *bytes.Buffer, name string, f sparseFile) {
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(f.data))
+	for _, region := range f.data {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", region.Offset, region.Length)
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+	records := formatPAXRecords(map[string]string{
+		paxGNUSparseMajor:    "1",
+		paxGNUSparseMinor:    "0",
+		paxGNUSparseName:     name,
+		paxGNUSparseRealSize: strconv.FormatInt(f.size, 10),
+	})
+	archive.Write(formatUSTARHeader(&archivetar.Header{Name: "PaxHeaders/" + name, Size: int64(len(records))}, archivetar.TypeXHeader))
+	writePadded(archive, records)
+	data := f.dataBytes()
+	archive.Write(formatUSTARHeader(&archivetar.Header{Name: "GNUSparseFile.0/" + name, Mode: 0o644, Size: int64(sparseMap.Len() + len(data))}, archivetar.TypeReg))
+	archive.Write(sparseMap.Bytes())
+	writePadded(archive, data)
+}
+
+func formatSparseEntry(field []byte, region sparse.Region) {
+	formatOctal(field[:12], region.Offset)
+	formatOctal(field[12:24], region.Length)
+}
+
+func writePadded(archive *bytes.Buffer, data []byte) {
+	archive.Write(data)
+	archive.Write(make([]byte, blockPadding(int64(len(data)))))
+}
+
+// setChecksum updates the checksum of a modified header block.
+func setChecksum(block []byte) {
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+}
+
+func corrupt(block []byte) []byte {
+	corrupted := bytes.Clone(block)
+	corrupted[0]++
+	return corrupted
+}
+
+// holeCAS is a CAS that knows the holes of its blobs.
+type holeCAS struct {
+	*memory.CAS
+	holes map[string]sparse.HoleMap
+}
+
+func (c holeCAS) Holes(sri string) sparse.HoleMap {
+	return c.holes[sri]
+}
@@ -0,0 +1,238 @@
+package tar
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	stdpath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// writeSparse writes a regular file as GNU PAX 1.0 sparse entry.
+// archive/tar cannot write sparse entries (and drops GNU.sparse.* PAX records),
+// so the PAX header, the header and the data are written directly to the underlying stream.
+// The entry consists of:
+//   - a PAX extended header with the GNU.sparse.* records and all other records of the header
+//   - a USTAR header named "<dir>/GNUSparseFile.0/<name>"
+//   - the sparse map (newline separated decimal numbers, padded to the block size)
+//   - the data regions of the file, back to back
+func (s *Sink) writeSparse(header *archivetar.Header, holes sparse.HoleMap, contents io.Reader) error {
+	// write padding of the previous entry
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	data := holes.Data(header.Size)
+	// like GNU tar, terminate the map with an empty region at the end of the file
+	data = append(data, sparse.Region{Offset: header.Size, Length: 0})
+	var sparseMap bytes.Buffer
+	fmt.Fprintf(&sparseMap, "%d\n", len(data))
+	var dataSize int64
+	for _, region := range data {
+		fmt.Fprintf(&sparseMap, "%d\n%d\n", region.Offset, region.Length)
+		dataSize += region.Length
+	}
+	sparseMap.Write(make([]byte, blockPadding(int64(sparseMap.Len()))))
+
+	dir, file := stdpath.Split(strings.TrimSuffix(header.Name, "/"))
+	entry := *header
+	entry.Name = stdpath.Join(dir, "GNUSparseFile.0", file)
+	entry.Size = int64(sparseMap.Len()) + dataSize
+
+	records := make(map[string]string, len(header.PAXRecords)+8)
+	for key, value := range header.PAXRecords {
+		records[key] = value
+	}
+	records[paxGNUSparseMajor] = "1"
+	records[paxGNUSparseMinor] = "0"
+	records[paxGNUSparseName] = header.Name
+	records[paxGNUSparseRealSize] = strconv.FormatInt(header.Size, 10)
+	addOverflowRecords(records, &entry)
+
+	paxData := formatPAXRecords(records)
+	paxHeader := &archivetar.Header{
+		Name: stdpath.Join(dir, "PaxHeaders.0", file),
+		Size: int64(len(paxData)),
+	}
+	if _, err := s.out.Write(formatUSTARHeader(paxHeader, archivetar.TypeXHeader)); err != nil {
+		return err
+	}
+	if _, err := s.out.Write(paxData); err != nil {
+		return err
+	}
+	if _, err := s.out.Write(make([]byte, blockPadding(int64(len(paxData))))); err != nil {
+		return err
+	}
+	if _, err := s.out.Write(formatUSTARHeader(&entry, archivetar.TypeReg)); err != nil {
+		return err
+	}
+	if _, err := s.out.Write(sparseMap.Bytes()); err != nil {
+		return err
+	}
+	if err := sparse.CopyData(s.out, contents, holes, header.Size); err != nil {
+		return err
+	}
+	_, err := s.out.Write(make([]byte, blockPadding(dataSize)))
+	return err
+}
+
+// addOverflowRecords adds PAX records for all header fields that do not fit into a USTAR header.
+func addOverflowRecords(records map[string]string, header *archivetar.Header) {
+	if header.Size > maxOctal(12) {
+		records["size"] = strconv.FormatInt(header.Size, 10)
+	}
+	if int64(header.Uid) > maxOctal(8) || header.Uid < 0 {
+		records["uid"] = strconv.Itoa(header.Uid)
+	}
+	if int64(header.Gid) > maxOctal(8) || header.Gid < 0 {
+		records["gid"] = strconv.Itoa(header.Gid)
+	}
+	if len(header.Uname) > 31 || !isASCII(header.Uname) {
+		records["uname"] = header.Uname
+	}
+	if len(header.Gname) > 31 || !isASCII(header.Gname) {
+		records["gname"] = header.Gname
+	}
+	if header.ModTime.IsZero() {
+		return
+	}
+	if header.ModTime.Nanosecond() != 0 || header.ModTime.Unix() < 0 || header.ModTime.Unix() > maxOctal(12) {
+		records["mtime"] = formatPAXTime(header.ModTime)
+	}
+}
+
+// formatPAXRecords encodes PAX records, sorted by key.
+// Every record has the form "<length> <key>=<value>\n",
+// where the length includes itself.
+func formatPAXRecords(records map[string]string) []byte {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, key := range keys {
+		record := " " + key + "=" + records[key] + "\n"
+		size := len(record)
+		// the length field is part of the length
+		for digits := len(strconv.Itoa(size)); ; {
+			total := size + digits
+			if len(strconv.Itoa(total)) == digits {
+				size = total
+				break
+			}
+			digits = len(strconv.Itoa(total))
+		}
+		buf.WriteString(strconv.Itoa(size) + record)
+	}
+	return buf.Bytes()
+}
+
+// formatUSTARHeader encodes a USTAR header block.
+// Fields that do not fit are truncated (or set to zero) and
+// must be provided by PAX records.
+func formatUSTARHeader(header *archivetar.Header, typeflag byte) []byte {
+	block := make([]byte, blockSize)
+	name, prefix := splitUSTARName(header.Name)
+	copy(block[0:100], name)
+	formatOctal(block[100:108], header.Mode&0o7777)
+	formatOctal(block[108:116], int64(header.Uid))
+	formatOctal(block[116:124], int64(header.Gid))
+	formatOctal(block[124:136], header.Size)
+	formatOctal(block[136:148], header.ModTime.Unix())
+	block[156] = typeflag
+	copy(block[257:265], "ustar\x0000")
+	copy(block[265:297], truncateASCII(header.Uname, 31))
+	copy(block[297:329], truncateASCII(header.Gname, 31))
+	formatOctal(block[329:337], 0)
+	formatOctal(block[337:345], 0)
+	copy(block[345:500], prefix)
+
+	// the checksum is calculated with the checksum field set to spaces
+	copy(block[148:156], "        ")
+	var checksum int64
+	for _, b := range block {
+		checksum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", checksum))
+	return block
+}
+
+// splitUSTARName splits a name into the name and prefix fields of a USTAR header.
+// Names that do not fit are truncated.
+func splitUSTARName(name string) (string, string) {
+	name = truncateASCII(name, len(name))
+	if len(name) <= 100 {
+		return name, ""
+	}
+	for i := len(name) - 1; i > 0; i-- {
+		if name[i] == '/' && i <= 155 && len(name)-i-1 <= 100 {
+			return name[i+1:], name[:i]
+		}
+	}
+	return name[:100], ""
+}
+
+// formatOctal writes a zero padded, NUL terminated octal number.
+// Values that do not fit are written as zero.
+func formatOctal(field []byte, value int64) {
+	if value < 0 || value > maxOctal(len(field)) {
+		value = 0
+	}
+	copy(field, fmt.Sprintf("%0*o\x00", len(field)-1, value))
+}
+
+// maxOctal returns the maximum value of an octal field of the given size (including the NUL terminator).
+func maxOctal(size int) int64 {
+	return 1<<(3*(size-1)) - 1
+}
+
+func formatPAXTime(t time.Time) string {
+	secs, nsecs := t.Unix(), int64(t.Nanosecond())
+	if nsecs == 0 {
+		return strconv.FormatInt(secs, 10)
+	}
+	sign := ""
+	if secs < 0 {
+		// the fraction is part of the (negative) seconds
+		sign = "-"
+		secs = -(secs + 1)
+		nsecs = 1e9 - nsecs
+	}
+	return sign + strconv.FormatInt(secs, 10) + "." + strings.TrimRight(fmt.Sprintf("%09d", nsecs), "0")
+}
+
+func truncateASCII(s string, size int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= 0x80 || b.Len() >= size {
+			break
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func blockPadding(size int64) int64 {
+	return -size & (blockSize - 1)
+}
+
+const (
+	paxGNUSparseName     = "GNU.sparse.name"
+	paxGNUSparseRealSize = "GNU.sparse.realsize"
+)
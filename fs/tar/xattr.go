@@ -0,0 +1,101 @@
+package tar
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// XAttrPrefixSchily is the PAX record prefix for xattrs used by star and GNU tar.
+	// Keys and values are stored as is.
+	XAttrPrefixSchily = "SCHILY.xattr."
+	// XAttrPrefixLibarchive is the PAX record prefix for xattrs used by libarchive (bsdtar).
+	// Keys are url encoded and values are base64 encoded,
+	// so arbitrary binary keys and values can be stored.
+	XAttrPrefixLibarchive = "LIBARCHIVE.xattr."
+)
+
+const (
+	// XAttrEncodingSchily writes xattrs as SCHILY.xattr. records.
+	XAttrEncodingSchily = "schily"
+	// XAttrEncodingLibarchive writes xattrs as LIBARCHIVE.xattr. records.
+	XAttrEncodingLibarchive = "libarchive"
+	// XAttrEncodingBoth writes xattrs as SCHILY.xattr. and LIBARCHIVE.xattr. records (like bsdtar).
+	XAttrEncodingBoth = "both"
+)
+
+// decodeXAttr decodes a xattr PAX record with the given prefix.
+// Only records with the libarchive prefix are encoded.
+func decodeXAttr(prefix, key, value string) (string, string, error) {
+	if prefix != XAttrPrefixLibarchive {
+		return key, value, nil
+	}
+	decodedKey, err := urlDecode(key)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding xattr key %q: %w", key, err)
+	}
+	// libarchive omits the padding, but accepts padded values
+	decodedValue, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return "", "", fmt.Errorf("decoding value of xattr %q: %w", decodedKey, err)
+	}
+	return decodedKey, string(decodedValue), nil
+}
+
+// encodeLibarchiveXAttr encodes a xattr as libarchive PAX record (without prefix).
+func encodeLibarchiveXAttr(key, value string) (string, string) {
+	return urlEncode(key), base64.RawStdEncoding.EncodeToString([]byte(value))
+}
+
+// urlEncode escapes a xattr key like libarchive:
+// control characters, spaces, non-ASCII bytes, '%' and '=' are percent encoded.
+func urlEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == '%' || c == '=' {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// urlDecode reverses urlEncode.
+func urlDecode(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("truncated escape sequence at %d", i)
+		}
+		high, okHigh := unhex(s[i+1])
+		low, okLow := unhex(s[i+2])
+		if !okHigh || !okLow {
+			return "", fmt.Errorf("invalid escape sequence %q", s[i:i+3])
+		}
+		b.WriteByte(high<<4 | low)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,353 @@
+package tar
+
+import (
+	archivetar "archive/tar"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+)
+
+func TestURLEncode(t *testing.T) {
+	testCases := map[string]struct {
+		in   string
+		want string
+	}{
+		"plain":         {in: "user.comment", want: "user.comment"},
+		"equals":        {in: "user.a=b", want: "user.a%3Db"},
+		"percent":       {in: "user.100%", want: "user.100%25"},
+		"space":         {in: "user.a b", want: "user.a%20b"},
+		"control":       {in: "user.\x00\n", want: "user.%00%0A"},
+		"non-ascii":     {in: "user.ä", want: "user.%C3%A4"},
+		"delete":        {in: "user.\x7f", want: "user.%7F"},
+		"printable end": {in: "user.~", want: "user.~"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := urlEncode(tc.in); got != tc.want {
+				t.Errorf("urlEncode(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+			decoded, err := urlDecode(tc.want)
+			if err != nil {
+				t.Fatalf("urlDecode(%q): %v", tc.want, err)
+			}
+			if decoded != tc.in {
+				t.Errorf("urlDecode(%q) = %q, want %q", tc.want, decoded, tc.in)
+			}
+		})
+	}
+}
+
+func TestURLDecode(t *testing.T) {
+	testCases := map[string]struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		"plain":             {in: "user.comment", want: "user.comment"},
+		"uppercase hex":     {in: "user.a%3Db", want: "user.a=b"},
+		"lowercase hex":     {in: "user.a%3db", want: "user.a=b"},
+		"unescaped equals":  {in: "user.a=b", want: "user.a=b"},
+		"escape at the end": {in: "user.%25", want: "user.%"},
+		"truncated":         {in: "user.%2", wantErr: true},
+		"lone percent":      {in: "user.%", wantErr: true},
+		"invalid hex":       {in: "user.%zz", wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := urlDecode(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("urlDecode(%q) = %q, want error", tc.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("urlDecode(%q): %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Errorf("urlDecode(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeXAttr(t *testing.T) {
+	testCases := map[string]struct {
+		prefix    string
+		key       string
+		value     string
+		wantKey   string
+		wantValue string
+		wantErr   bool
+	}{
+		"schily is not encoded": {
+			prefix: XAttrPrefixSchily, key: "user.a%3Db", value: "YQ",
+			wantKey: "user.a%3Db", wantValue: "YQ",
+		},
+		"unpadded base64": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "YQ",
+			wantKey: "user.a", wantValue: "a",
+		},
+		"padded base64": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "YQ==",
+			wantKey: "user.a", wantValue: "a",
+		},
+		"unpadded base64 of two bytes": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "YWI",
+			wantKey: "user.a", wantValue: "ab",
+		},
+		"binary value": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "AP8",
+			wantKey: "user.a", wantValue: "\x00\xff",
+		},
+		"empty value": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "",
+			wantKey: "user.a", wantValue: "",
+		},
+		"encoded key": {
+			prefix: XAttrPrefixLibarchive, key: "user.a%3Db", value: "YQ",
+			wantKey: "user.a=b", wantValue: "a",
+		},
+		"invalid base64": {
+			prefix: XAttrPrefixLibarchive, key: "user.a", value: "Y!",
+			wantErr: true,
+		},
+		"invalid key": {
+			prefix: XAttrPrefixLibarchive, key: "user.%zz", value: "YQ",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			key, value, err := decodeXAttr(tc.prefix, tc.key, tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("decodeXAttr(%q, %q, %q) succeeded, want error", tc.prefix, tc.key, tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeXAttr(%q, %q, %q): %v", tc.prefix, tc.key, tc.value, err)
+			}
+			if key != tc.wantKey || value != tc.wantValue {
+				t.Errorf("decodeXAttr(%q, %q, %q) = %q, %q, want %q, %q",
+					tc.prefix, tc.key, tc.value, key, value, tc.wantKey, tc.wantValue)
+			}
+		})
+	}
+}
+
+func TestEncodeLibarchiveXAttr(t *testing.T) {
+	key, value := encodeLibarchiveXAttr("user.a=b", "a")
+	if key != "user.a%3Db" {
+		t.Errorf("key = %q, want %q", key, "user.a%3Db")
+	}
+	// libarchive omits the padding
+	if value != "YQ" {
+		t.Errorf("value = %q, want %q", value, "YQ")
+	}
+}
+
+func TestSourceXAttrPrecedence(t *testing.T) {
+	records := map[string]string{
+		XAttrPrefixLibarchive + "user.both":    base64.RawStdEncoding.EncodeToString([]byte("libarchive")),
+		XAttrPrefixLibarchive + "user.a%3Db":   base64.RawStdEncoding.EncodeToString([]byte("encoded")),
+		XAttrPrefixSchily + "user.both":        "schily",
+		XAttrPrefixSchily + "user.schily-only": "schily",
+	}
+	testCases := map[string]struct {
+		prefixes []string
+		want     map[string]string
+	}{
+		"libarchive takes precedence by default": {
+			want: map[string]string{"user.both": "libarchive", "user.a=b": "encoded"},
+		},
+		"schily first": {
+			prefixes: []string{XAttrPrefixSchily, XAttrPrefixLibarchive},
+			want:     map[string]string{"user.both": "schily", "user.schily-only": "schily"},
+		},
+		"libarchive only": {
+			prefixes: []string{XAttrPrefixLibarchive},
+			want:     map[string]string{"user.both": "libarchive", "user.a=b": "encoded"},
+		},
+		"schily only": {
+			prefixes: []string{XAttrPrefixSchily},
+			want:     map[string]string{"user.both": "schily", "user.schily-only": "schily"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			archive := writeTestTar(t, &archivetar.Header{
+				Typeflag:   archivetar.TypeReg,
+				Name:       "file",
+				Mode:       0o644,
+				PAXRecords: records,
+				Format:     archivetar.FormatPAX,
+			})
+			builder := &SourceBuilder{}
+			if tc.prefixes != nil {
+				builder.WithXAttrPaxPrefixes(tc.prefixes)
+			}
+			stats := readTestTar(t, builder.WithIOReader(bytes.NewReader(archive)))
+			stat, ok := stats["/file"]
+			if !ok {
+				t.Fatalf("file not found in %v", stats)
+			}
+			if !reflect.DeepEqual(stat.Attributes.XAttrs, tc.want) {
+				t.Errorf("xattrs = %q, want %q", stat.Attributes.XAttrs, tc.want)
+			}
+		})
+	}
+}
+
+func TestXAttrEncodingRoundTrip(t *testing.T) {
+	plain := map[string]string{
+		"user.comment":        "hello",
+		"security.capability": "\x01\x00\x00\x02\x00\x20\x00\x00",
+	}
+	withEquals := map[string]string{
+		"user.comment": "hello",
+		"user.a=b":     "\x00binary\xff",
+		"user.a b":     "",
+	}
+	testCases := map[string]struct {
+		encoding   string
+		xattrs     map[string]string
+		wantPrefix []string
+	}{
+		"schily": {
+			encoding:   XAttrEncodingSchily,
+			xattrs:     plain,
+			wantPrefix: []string{XAttrPrefixSchily},
+		},
+		"libarchive": {
+			encoding:   XAttrEncodingLibarchive,
+			xattrs:     withEquals,
+			wantPrefix: []string{XAttrPrefixLibarchive},
+		},
+		"both": {
+			encoding:   XAttrEncodingBoth,
+			xattrs:     withEquals,
+			wantPrefix: []string{XAttrPrefixSchily, XAttrPrefixLibarchive},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var archive bytes.Buffer
+			sink, closeSink, err := (&SinkBuilder{}).
+				WithXAttrEncoding(tc.encoding).
+				WithIOWriter(&archive).
+				Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Consume(testTreeFS(t, tc.xattrs)); err != nil {
+				t.Fatal(err)
+			}
+			if err := closeSink(); err != nil {
+				t.Fatal(err)
+			}
+
+			reader := archivetar.NewReader(bytes.NewReader(archive.Bytes()))
+			header, err := reader.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, prefix := range tc.wantPrefix {
+				if !hasRecordWithPrefix(header.PAXRecords, prefix) {
+					t.Errorf("no %s records in %q", prefix, header.PAXRecords)
+				}
+			}
+
+			stats := readTestTar(t, (&SourceBuilder{}).WithIOReader(bytes.NewReader(archive.Bytes())))
+			stat, ok := stats["/file"]
+			if !ok {
+				t.Fatalf("file not found in %v", stats)
+			}
+			if !reflect.DeepEqual(stat.Attributes.XAttrs, tc.xattrs) {
+				t.Errorf("xattrs = %q, want %q", stat.Attributes.XAttrs, tc.xattrs)
+			}
+		})
+	}
+}
+
+// writeTestTar writes a tar with the headers of empty files.
+func writeTestTar(t *testing.T, headers ...*archivetar.Header) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	writer := archivetar.NewWriter(&archive)
+	for _, header := range headers {
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+// readTestTar returns the stats of all nodes of the tar by name.
+func readTestTar(t *testing.T, builder *SourceBuilder) map[string]api.Stat {
+	t.Helper()
+	source, closeSource, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	stats := make(map[string]api.Stat)
+	for {
+		node, err := source.Next()
+		if errors.Is(err, io.EOF) {
+			return stats
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats[node.Stat.Name] = node.Stat
+	}
+}
+
+// testTreeFS returns a tree with a single file with the xattrs.
+func testTreeFS(t *testing.T, xattrs map[string]string) *coretree.TreeFS {
+	t.Helper()
+	const contents = "contents"
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := memory.NewCAS(false)
+	if err := blobs.Write(sris[0], strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	tree := api.Tree{Root: &api.Node{Stat: api.Stat{Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}}}}
+	if err := coretree.Insert(tree, "/", api.Stat{
+		Name:       "file",
+		Kind:       api.KindRegular,
+		Size:       int64(len(contents)),
+		Payload:    sris[0],
+		Attributes: api.NodeAttributes{Mode: "0o644", XAttrs: xattrs},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return &coretree.TreeFS{Tree: tree, CASReader: blobs}
+}
+
+func hasRecordWithPrefix(records map[string]string, prefix string) bool {
+	for key := range records {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package zip
+
+import (
+	archivezip "archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs-core/sri"
+)
+
+type SourceBuilder struct {
+	SRIAlgorithm sri.Algorithm `abstractfs:"cas-algorithm"`
+	Path         string
+	// IOReaderAt is used to read the zip archive if Path is not set.
+	// Size must be set to the size of the archive.
+	IOReaderAt     io.ReaderAt
+	Size           int64
+	invalidOptions []string
+}
+
+// WithSourceRef sets the source reference.
+// For the zip provider, the source reference is the path to the zip file.
+func (b *SourceBuilder) WithSourceRef(ref string) provider.SourceBuilder {
+	b.Path = ref
+	return b
+}
+
+func (b *SourceBuilder) WithSRIAlgorithm(alg sri.Algorithm) *SourceBuilder {
+	b.SRIAlgorithm = alg
+	return b
+}
+
+// WithIOReaderAt sets the reader and size of the zip archive.
+func (b *SourceBuilder) WithIOReaderAt(r io.ReaderAt, size int64) *SourceBuilder {
+	b.IOReaderAt = r
+	b.Size = size
+	return b
+}
+
+// Build builds the options.
+func (b *SourceBuilder) Build() (api.Source, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	var fileCloser func() error
+	if b.IOReaderAt == nil {
+		file, err := os.Open(b.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		fileCloser = file.Close
+		b.IOReaderAt = file
+		b.Size = info.Size()
+	}
+	reader, err := archivezip.NewReader(b.IOReaderAt, b.Size)
+	if err != nil {
+		if fileCloser != nil {
+			fileCloser()
+		}
+		return nil, nil, err
+	}
+	source := &Source{
+		CASFileStore: NewCASFileStore(),
+		files:        reader.File,
+		sriAlgorithm: b.SRIAlgorithm,
+	}
+	return source, func() error {
+		if fileCloser != nil {
+			return fileCloser()
+		}
+		return nil
+	}, nil
+}
+
+func (b *SourceBuilder) applyDefaults() {
+	if b.SRIAlgorithm == "" {
+		b.SRIAlgorithm = sri.SHA256
+	}
+}
+
+func (b *SourceBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	if b.Path != "" && b.IOReaderAt != nil {
+		return errors.New("cannot set both path and io.ReaderAt")
+	}
+	if b.Path == "" && b.IOReaderAt == nil {
+		return errors.New("must set either path or io.ReaderAt")
+	}
+	return nil
+}
+
+type SinkBuilder struct {
+	// Compression is the compression method to use for regular files.
+	// Valid values are "deflate" and "store".
+	// By default, "deflate" is used.
+	Compression string `abstractfs:"compression"`
+	// CompressionLevel is the deflate compression level:
+	// flate.DefaultCompression (-1), flate.NoCompression (0) or 1-9.
+	// NewSinkBuilder sets it to flate.DefaultCompression,
+	// so 0 only requests no compression if it is set explicitly.
+	CompressionLevel int `abstractfs:"compression-level"`
+	// SkipUnsupported skips nodes that zip cannot represent (devices).
+	// By default, such nodes are an error (see kind.ErrUnsupported).
+	SkipUnsupported bool `abstractfs:"skip-unsupported"`
+	// Path is the path to write the zip to.
+	// If Path is set, the zip is written to the file.
+	// Otherwise, the zip is written to the io.Writer.
+	Path string
+	// IOWriter is the io.Writer to write the zip to.
+	// If IOWriter is set, the zip is written to the io.Writer.
+	// Otherwise, the zip is written to the file specified by Path.
+	IOWriter       io.Writer
+	invalidOptions []string
+}
+
+// NewSinkBuilder returns a SinkBuilder that uses the default compression level.
+func NewSinkBuilder() *SinkBuilder {
+	return &SinkBuilder{CompressionLevel: flate.DefaultCompression}
+}
+
+// WithSinkRef sets the sink reference.
+// For the zip provider, the sink reference is the path to the zip file.
+func (b *SinkBuilder) WithSinkRef(ref string) provider.SinkBuilder {
+	b.Path = ref
+	return b
+}
+
+// Set sets a option.
+func (b *SinkBuilder) Set(key string, value any) provider.SinkBuilder {
+	switch key {
+	case "compression":
+		compression, ok := value.(string)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.Compression = compression
+	case "compression-level":
+		level, ok := value.(int)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.CompressionLevel = level
+	case "skip-unsupported":
+		skipUnsupported, ok := value.(bool)
+		if !ok {
+			b.invalidOptions = append(b.invalidOptions, key)
+			return b
+		}
+		b.SkipUnsupported = skipUnsupported
+	default:
+		b.invalidOptions = append(b.invalidOptions, key)
+	}
+	return b
+}
+
+func (b *SinkBuilder) WithCompression(compression string) *SinkBuilder {
+	b.Compression = compression
+	return b
+}
+
+func (b *SinkBuilder) WithCompressionLevel(level int) *SinkBuilder {
+	b.CompressionLevel = level
+	return b
+}
+
+// WithSkipUnsupported skips nodes that zip cannot represent instead of failing.
+func (b *SinkBuilder) WithSkipUnsupported(skipUnsupported bool) *SinkBuilder {
+	b.SkipUnsupported = skipUnsupported
+	return b
+}
+
+func (b *SinkBuilder) WithIOWriter(w io.Writer) *SinkBuilder {
+	b.IOWriter = w
+	return b
+}
+
+// Build builds the options.
+func (b *SinkBuilder) Build() (api.Sink, api.CloseWaitFunc, error) {
+	b.applyDefaults()
+	if err := b.check(); err != nil {
+		return nil, nil, err
+	}
+	var fileCloser func() error
+	if b.IOWriter == nil {
+		file, err := os.Create(b.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileCloser = file.Close
+		b.IOWriter = file
+	}
+	writer := archivezip.NewWriter(b.IOWriter)
+	level := b.CompressionLevel
+	writer.RegisterCompressor(archivezip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	method := archivezip.Deflate
+	if b.Compression == "store" {
+		method = archivezip.Store
+	}
+	sink := &Sink{
+		writer:          writer,
+		method:          method,
+		skipUnsupported: b.SkipUnsupported,
+	}
+	return sink, func() error {
+		closeErr := sink.writer.Close()
+		if fileCloser != nil {
+			return errors.Join(closeErr, fileCloser())
+		}
+		return closeErr
+	}, nil
+}
+
+func (b *SinkBuilder) applyDefaults() {
+	if b.Compression == "" {
+		b.Compression = "deflate"
+	}
+}
+
+func (b *SinkBuilder) check() error {
+	if len(b.invalidOptions) > 0 {
+		return fmt.Errorf("invalid options: %s", strings.Join(b.invalidOptions, ","))
+	}
+	switch b.Compression {
+	case "deflate", "store":
+	default:
+		return fmt.Errorf("invalid compression: %q", b.Compression)
+	}
+	if b.CompressionLevel < flate.DefaultCompression || b.CompressionLevel > flate.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", b.CompressionLevel)
+	}
+	if b.Path != "" && b.IOWriter != nil {
+		return errors.New("cannot set both path and io.Writer")
+	}
+	if b.Path == "" && b.IOWriter == nil {
+		return errors.New("must set either path or io.Writer")
+	}
+	return nil
+}
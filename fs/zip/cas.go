@@ -0,0 +1,45 @@
+package zip
+
+import (
+	archivezip "archive/zip"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// CASFileStore is a lookup table for sri -> zip file entry.
+// Contents are served directly from the archive.
+type CASFileStore struct {
+	mux sync.RWMutex
+	// inner is the lookup table for sri -> zip file entry.
+	inner map[string]*archivezip.File
+}
+
+func NewCASFileStore() *CASFileStore {
+	return &CASFileStore{
+		inner: make(map[string]*archivezip.File),
+	}
+}
+
+// Open returns a reader for the given sri.
+func (c *CASFileStore) Open(sri string) (io.ReadCloser, error) {
+	c.mux.RLock()
+	file, ok := c.inner[sri]
+	c.mux.RUnlock()
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return file.Open()
+}
+
+// Set sets the zip file entry for the given sri.
+// If the sri already exists, the old entry will be kept.
+func (c *CASFileStore) Set(sri string, file *archivezip.File) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	_, ok := c.inner[sri]
+	if ok {
+		return
+	}
+	c.inner[sri] = file
+}
@@ -0,0 +1,137 @@
+package zip
+
+import (
+	"encoding/binary"
+	"io/fs"
+	"strconv"
+)
+
+// Extra field header IDs.
+// See https://libzip.org/specifications/extrafld.txt
+const (
+	extraPKWAREUnix   = 0x000d
+	extraInfoZIPUnix1 = 0x5855
+	extraInfoZIPUnix2 = 0x7855
+	extraInfoZIPUnix3 = 0x7875
+)
+
+// ownerFromExtra decodes the uid and gid from the unix extra fields of a zip entry.
+// The newest Info-ZIP format (0x7875) takes precedence over older formats.
+func ownerFromExtra(extra []byte) (uid, gid string, ok bool) {
+	var fallbackUID, fallbackGID string
+	var hasFallback bool
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		switch tag {
+		case extraInfoZIPUnix3:
+			if uid, gid, ok := decodeInfoZIPUnix3(field); ok {
+				return uid, gid, true
+			}
+		case extraInfoZIPUnix1:
+			// the local header variant contains atime, mtime, uid and gid
+			if len(field) >= 12 {
+				fallbackUID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[8:10])))
+				fallbackGID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[10:12])))
+				hasFallback = true
+			}
+		case extraInfoZIPUnix2:
+			// the local header variant contains uid and gid
+			if len(field) >= 4 {
+				fallbackUID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[0:2])))
+				fallbackGID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[2:4])))
+				hasFallback = true
+			}
+		case extraPKWAREUnix:
+			if len(field) >= 12 {
+				fallbackUID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[8:10])))
+				fallbackGID = strconv.Itoa(int(binary.LittleEndian.Uint16(field[10:12])))
+				hasFallback = true
+			}
+		}
+	}
+	return fallbackUID, fallbackGID, hasFallback
+}
+
+// decodeInfoZIPUnix3 decodes the "ux" extra field.
+// It contains a version, followed by the size and value of the uid and gid.
+func decodeInfoZIPUnix3(field []byte) (string, string, bool) {
+	if len(field) < 1 || field[0] != 1 {
+		return "", "", false
+	}
+	field = field[1:]
+	uid, field, ok := decodeVarSizeID(field)
+	if !ok {
+		return "", "", false
+	}
+	gid, _, ok := decodeVarSizeID(field)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uid, 10), strconv.FormatUint(gid, 10), true
+}
+
+func decodeVarSizeID(field []byte) (uint64, []byte, bool) {
+	if len(field) < 1 {
+		return 0, nil, false
+	}
+	size := int(field[0])
+	field = field[1:]
+	if size > 8 || size > len(field) {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint64(field[i])
+	}
+	return id, field[size:], true
+}
+
+// infoZIPUnix3Extra encodes uid and gid as a "ux" extra field.
+func infoZIPUnix3Extra(uid, gid uint32) []byte {
+	field := make([]byte, 4+11)
+	binary.LittleEndian.PutUint16(field[0:2], extraInfoZIPUnix3)
+	binary.LittleEndian.PutUint16(field[2:4], 11)
+	field[4] = 1 // version
+	field[5] = 4 // uid size
+	binary.LittleEndian.PutUint32(field[6:10], uid)
+	field[10] = 4 // gid size
+	binary.LittleEndian.PutUint32(field[11:15], gid)
+	return field
+}
+
+// unixMode converts a fs.FileMode into a unix permission mode (including setuid, setgid and sticky bits).
+func unixMode(mode fs.FileMode) int64 {
+	unix := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 0o1000
+	}
+	return unix
+}
+
+// fileMode converts a unix permission mode into a fs.FileMode.
+func fileMode(mode int64) fs.FileMode {
+	fileMode := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		fileMode |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		fileMode |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		fileMode |= fs.ModeSticky
+	}
+	return fileMode
+}
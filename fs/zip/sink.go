@@ -0,0 +1,180 @@
+package zip
+
+import (
+	archivezip "archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	stdpath "path"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/fs/internal/fsutil"
+	"github.com/malt3/abstractfs/kind"
+)
+
+type Sink struct {
+	writer *archivezip.Writer
+	method uint16
+	// skipUnsupported skips nodes that zip cannot represent (devices) instead of failing.
+	skipUnsupported bool
+}
+
+// Consume writes the given fs.FS as zip archive.
+// Entries are written in lexical order (as visited by fs.WalkDir),
+// with mtimes in UTC, so the output is reproducible.
+func (s *Sink) Consume(in fs.FS) error {
+	return fs.WalkDir(in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if path == "." || path == "/" {
+			// zip archives have no root entry
+			return nil
+		}
+		stat, err := s.stat(in, path, d)
+		if err != nil {
+			return err
+		}
+		contentPath := path
+		if stat.Kind == kind.Hardlink {
+			// zip has no hardlinks: every name gets its own copy of the contents
+			contentPath = strings.TrimPrefix(stdpath.Clean(stat.Payload), "/")
+			if stat, err = fsutil.HardlinkTarget(in, contentPath); err != nil {
+				return fmt.Errorf("resolving hardlink %q: %w", path, err)
+			}
+		}
+		header, err := s.prepareHeader(path, stat)
+		if errors.Is(err, kind.ErrUnsupported) && s.skipUnsupported {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		writer, err := s.writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		switch stat.Kind {
+		case api.KindSymlink:
+			_, err := io.WriteString(writer, stat.Payload)
+			return err
+		case api.KindRegular:
+			file, err := in.Open(contentPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = io.Copy(writer, file)
+			return err
+		}
+		return nil
+	})
+}
+
+// stat returns the api.Stat of the node.
+// if the dirEntry comes from a api.Tree, the api.Stat is used directly.
+// otherwise, only the subset that is available in fs.FileInfo is used.
+func (s *Sink) stat(in fs.FS, path string, d fs.DirEntry) (api.Stat, error) {
+	info, err := d.Info()
+	if err != nil {
+		return api.Stat{}, err
+	}
+	if stat, hasStat := info.Sys().(api.Stat); hasStat {
+		return stat, nil
+	}
+	stat := api.Stat{
+		Name: info.Name(),
+		Size: info.Size(),
+		Attributes: api.NodeAttributes{
+			Mtime: info.ModTime().UTC(),
+			Mode:  "0o" + strconv.FormatInt(unixMode(info.Mode()), 8),
+		},
+	}
+	switch {
+	case info.IsDir():
+		stat.Kind = api.KindDirectory
+	case info.Mode()&fs.ModeSymlink != 0:
+		readLinkFS, ok := in.(fsutil.ReadLinkFS)
+		if !ok {
+			return api.Stat{}, errors.New("symlink given but fs does not implement readLinkFS")
+		}
+		stat.Kind = api.KindSymlink
+		stat.Payload, err = readLinkFS.Readlink(path)
+		if err != nil {
+			return api.Stat{}, err
+		}
+	case info.Mode().IsRegular():
+		stat.Kind = api.KindRegular
+	}
+	return stat, nil
+}
+
+func (s *Sink) prepareHeader(path string, stat api.Stat) (*archivezip.FileHeader, error) {
+	var mode int64
+	if len(stat.Attributes.Mode) > 0 {
+		var err error
+		mode, err = strconv.ParseInt(stat.Attributes.Mode, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	header := &archivezip.FileHeader{
+		Name:   strings.TrimPrefix(path, "/"),
+		Method: s.method,
+	}
+	if !stat.Attributes.Mtime.IsZero() {
+		// setting Modified makes the writer emit an extended timestamp field
+		header.Modified = stat.Attributes.Mtime.UTC()
+	}
+
+	switch stat.Kind {
+	case api.KindDirectory:
+		header.Name += "/"
+		header.Method = archivezip.Store
+		header.SetMode(fs.ModeDir | fileMode(mode))
+	case api.KindSymlink:
+		header.SetMode(fs.ModeSymlink | fileMode(mode))
+	case api.KindRegular:
+		header.SetMode(fileMode(mode))
+	case kind.FIFO:
+		header.SetMode(fs.ModeNamedPipe | fileMode(mode))
+	case kind.Socket:
+		header.SetMode(fs.ModeSocket | fileMode(mode))
+	default:
+		// zip archives have no device numbers
+		return nil, kind.Unsupported(path, stat.Kind)
+	}
+
+	if len(stat.Attributes.UserID) > 0 || len(stat.Attributes.GroupID) > 0 {
+		uid, err := parseID(stat.Attributes.UserID)
+		if err != nil {
+			return nil, err
+		}
+		gid, err := parseID(stat.Attributes.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		header.Extra = append(header.Extra, infoZIPUnix3Extra(uid, gid)...)
+	}
+	return header, nil
+}
+
+func parseID(id string) (uint32, error) {
+	if len(id) == 0 {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseUint(id, 0, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(parsed), nil
+}
+
+var _ api.Sink = (*Sink)(nil)
@@ -0,0 +1,133 @@
+package zip
+
+import (
+	archivezip "archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	abstractfskind "github.com/malt3/abstractfs/kind"
+)
+
+type Source struct {
+	*CASFileStore
+	files        []*archivezip.File
+	pos          int
+	sriAlgorithm sri.Algorithm
+}
+
+func (s *Source) Next() (api.SourceNode, error) {
+	if s.pos >= len(s.files) {
+		return api.SourceNode{}, io.EOF
+	}
+	file := s.files[s.pos]
+	s.pos++
+	return s.prepareNext(file)
+}
+
+func (s *Source) prepareNext(file *archivezip.File) (api.SourceNode, error) {
+	mode := file.Mode()
+	if mode&fs.ModeDevice != 0 {
+		return api.SourceNode{}, fmt.Errorf("zip: %q is a device node, but zip archives cannot store device numbers", file.Name)
+	}
+	kind := kindFromMode(mode)
+
+	payload, err := s.payload(file, kind)
+	if err != nil {
+		return api.SourceNode{}, err
+	}
+
+	var size int64
+	if kind == api.KindRegular {
+		size = int64(file.UncompressedSize64)
+	}
+
+	node := api.SourceNode{
+		Stat: api.Stat{
+			Name:       path.Clean("/" + file.Name),
+			Kind:       kind,
+			Attributes: s.nodeAttributes(file, mode),
+			Payload:    payload,
+			Size:       size,
+		},
+		Open: s.openFunc(kind, payload),
+	}
+	return node, nil
+}
+
+func (s *Source) payload(file *archivezip.File, kind string) (string, error) {
+	switch kind {
+	case api.KindSymlink:
+		// the symlink target is stored as file content
+		reader, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		target, err := io.ReadAll(io.LimitReader(reader, maxSymlinkSize))
+		if err != nil {
+			return "", err
+		}
+		return string(target), nil
+	case api.KindRegular:
+		reader, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		integrity, err := sri.FromReader(s.sriAlgorithm, reader)
+		if err != nil {
+			return "", err
+		}
+		payload := integrity.String()
+		s.Set(payload, file)
+		return payload, nil
+	}
+	return "", nil
+}
+
+func (s *Source) nodeAttributes(file *archivezip.File, mode fs.FileMode) api.NodeAttributes {
+	uid, gid, _ := ownerFromExtra(file.Extra)
+	return api.NodeAttributes{
+		Mtime:   file.Modified.UTC(),
+		UserID:  uid,
+		GroupID: gid,
+		Mode:    "0o" + strconv.FormatInt(unixMode(mode), 8),
+	}
+}
+
+func (s *Source) openFunc(kind, payload string) func() (io.ReadCloser, error) {
+	if kind != api.KindRegular {
+		return func() (io.ReadCloser, error) {
+			return nil, fs.ErrNotExist
+		}
+	}
+	return func() (io.ReadCloser, error) {
+		return s.CASFileStore.Open(payload)
+	}
+}
+
+func kindFromMode(mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return api.KindDirectory
+	case mode&fs.ModeSymlink != 0:
+		return api.KindSymlink
+	case mode&fs.ModeNamedPipe != 0:
+		return abstractfskind.FIFO
+	case mode&fs.ModeSocket != 0:
+		return abstractfskind.Socket
+	}
+	return api.KindRegular
+}
+
+const maxSymlinkSize = 64 * 1024
+
+var (
+	_ api.Source    = (*Source)(nil)
+	_ api.CASReader = (*Source)(nil)
+)
@@ -0,0 +1,157 @@
+package zip
+
+import (
+	archivezip "archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/sri"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/cas"
+	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/kind"
+)
+
+func TestSinkSourceRoundTrip(t *testing.T) {
+	blobs := memory.NewCAS(false)
+	payload := writeBlob(t, blobs, "hello\n")
+	tree := coretree.Unflatten(api.Flat{Files: []api.Stat{
+		{Name: "/etc", Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}},
+		{Name: "/etc/hostname", Kind: api.KindRegular, Payload: payload, Size: 6, Attributes: api.NodeAttributes{Mode: "0o640", UserID: "1000", GroupID: "100"}},
+		{Name: "/etc/hostname.bak", Kind: kind.Hardlink, Payload: "/etc/hostname"},
+		{Name: "/etc/link", Kind: api.KindSymlink, Payload: "hostname", Attributes: api.NodeAttributes{Mode: "0o777"}},
+		{Name: "/dev", Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}},
+		{Name: "/dev/null", Kind: kind.CharDevice, Payload: kind.DevicePayload(1, 3), Attributes: api.NodeAttributes{Mode: "0o666"}},
+	}})
+	in := &coretree.TreeFS{Tree: tree, CASReader: blobs}
+
+	var archive bytes.Buffer
+	if err := writeZip(in, NewSinkBuilder().WithIOWriter(&archive)); !errors.Is(err, kind.ErrUnsupported) {
+		t.Fatalf("writing a device returned %v, want %v", err, kind.ErrUnsupported)
+	}
+	archive.Reset()
+	if err := writeZip(in, NewSinkBuilder().WithIOWriter(&archive).WithSkipUnsupported(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	source, closeSource, err := (&SourceBuilder{}).WithIOReaderAt(bytes.NewReader(archive.Bytes()), int64(archive.Len())).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeSource()
+	var got []string
+	for {
+		node, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		description := node.Stat.Name + " " + node.Stat.Kind + " " + node.Stat.Attributes.Mode
+		switch node.Stat.Kind {
+		case api.KindRegular:
+			description += " " + node.Stat.Attributes.UserID + ":" + node.Stat.Attributes.GroupID + " " + readNode(t, node)
+		case api.KindSymlink:
+			description += " " + node.Stat.Payload
+		}
+		got = append(got, description)
+	}
+	sort.Strings(got)
+	// zip has no hardlinks, so every name gets its own copy
+	want := []string{
+		"/dev directory 0o755",
+		"/etc directory 0o755",
+		"/etc/hostname regular 0o640 1000:100 hello\n",
+		"/etc/hostname.bak regular 0o640 1000:100 hello\n",
+		"/etc/link symlink 0o777 hostname",
+	}
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("got nodes %q, want %q", got, want)
+	}
+}
+
+func TestSinkCompressionLevel(t *testing.T) {
+	contents := strings.Repeat("compressible ", 4096)
+	testCases := map[string]struct {
+		builder *SinkBuilder
+		// stored is true if the deflate stream must not be smaller than the contents.
+		stored  bool
+		wantErr bool
+	}{
+		"default":        {builder: NewSinkBuilder()},
+		"no compression": {builder: NewSinkBuilder().WithCompressionLevel(flate.NoCompression), stored: true},
+		"zero value":     {builder: &SinkBuilder{}, stored: true},
+		"best":           {builder: NewSinkBuilder().WithCompressionLevel(flate.BestCompression)},
+		"store method":   {builder: NewSinkBuilder().WithCompression("store"), stored: true},
+		"too high":       {builder: NewSinkBuilder().WithCompressionLevel(10), wantErr: true},
+		"too low":        {builder: NewSinkBuilder().WithCompressionLevel(-2), wantErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			blobs := memory.NewCAS(false)
+			tree := coretree.Unflatten(api.Flat{Files: []api.Stat{
+				{Name: "/file", Kind: api.KindRegular, Payload: writeBlob(t, blobs, contents), Size: int64(len(contents)), Attributes: api.NodeAttributes{Mode: "0o644"}},
+			}})
+			var archive bytes.Buffer
+			err := writeZip(&coretree.TreeFS{Tree: tree, CASReader: blobs}, tc.builder.WithIOWriter(&archive))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("writing succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := archivezip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := reader.File[0]
+			if stored := file.CompressedSize64 >= file.UncompressedSize64; stored != tc.stored {
+				t.Errorf("got %d compressed bytes for %d bytes, want stored: %v", file.CompressedSize64, file.UncompressedSize64, tc.stored)
+			}
+		})
+	}
+}
+
+func writeZip(in *coretree.TreeFS, builder *SinkBuilder) error {
+	sink, closeSink, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	return errors.Join(sink.Consume(in), closeSink())
+}
+
+func writeBlob(t *testing.T, blobs api.CAS, contents string) string {
+	t.Helper()
+	sris, err := cas.Hash(strings.NewReader(contents), sri.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Write(sris[0], strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	return sris[0]
+}
+
+func readNode(t *testing.T, node api.SourceNode) string {
+	t.Helper()
+	file, err := node.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
@@ -3,8 +3,10 @@ module github.com/malt3/abstractfs
 go 1.20
 
 require (
+	github.com/klauspost/compress v1.17.9
 	github.com/malt3/abstractfs-core v0.0.1-rc4
 	github.com/spf13/cobra v1.7.0
+	github.com/ulikunitz/xz v0.5.15
 )
 
 require (
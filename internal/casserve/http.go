@@ -2,11 +2,20 @@ package casserve
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/malt3/abstractfs-core/api"
 	corehttp "github.com/malt3/abstractfs-core/cas/http"
+	"github.com/malt3/abstractfs-core/sri"
+	"github.com/malt3/abstractfs/cas"
 )
 
 type httpServer struct {
@@ -14,15 +23,142 @@ type httpServer struct {
 	listener net.Listener
 }
 
-func newHTTPServer(cas api.CAS, listener net.Listener) runnable {
+func newHTTPServer(backend api.CAS, listener net.Listener) runnable {
 	return &httpServer{
 		Server: http.Server{
-			Handler: corehttp.NewHandler(cas),
+			Handler: NewHandler(backend),
 		},
 		listener: listener,
 	}
 }
 
+// NewHandler returns the http handler that serves the backend.
+func NewHandler(backend api.CAS) http.Handler {
+	return &handler{Handler: corehttp.NewHandler(backend), cas: backend}
+}
+
 func (s *httpServer) Serve(_ context.Context) error {
 	return s.Server.Serve(s.listener)
 }
+
+// handler extends the CAS http protocol of the core with optional operations:
+//
+//   - HEAD /cas/<algorithm>/<hex>: size (Content-Length) and modification time (Last-Modified) of a blob
+//   - DELETE /cas/<algorithm>/<hex>: deletes a blob
+//   - GET /cas?prefix=<sri prefix>: lists blobs as json lines (see cas.BlobInfo)
+//
+// All other requests are handled by the core handler.
+type handler struct {
+	http.Handler
+	cas api.CAS
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodGet && (req.URL.Path == "/cas" || req.URL.Path == "/cas/"):
+		h.list(w, req)
+	case req.Method == http.MethodHead:
+		h.stat(w, req)
+	case req.Method == http.MethodDelete:
+		h.delete(w, req)
+	default:
+		h.Handler.ServeHTTP(w, req)
+	}
+}
+
+func (h *handler) stat(w http.ResponseWriter, req *http.Request) {
+	integrity, ok := parseBlobPath(req.URL.Path)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	info, err := cas.Stat(h.cas, integrity.String())
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) delete(w http.ResponseWriter, req *http.Request) {
+	integrity, ok := parseBlobPath(req.URL.Path)
+	if !ok {
+		http.Error(w, "invalid path: must have format /cas/<hash-function>/<hash-value-hex>", http.StatusBadRequest)
+		return
+	}
+	deleter, ok := h.cas.(cas.Deleter)
+	if !ok {
+		http.Error(w, "backend does not support deleting blobs", http.StatusMethodNotAllowed)
+		return
+	}
+	err := deleter.Delete(integrity.String())
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) list(w http.ResponseWriter, req *http.Request) {
+	lister, ok := h.cas.(cas.Lister)
+	if !ok {
+		http.Error(w, "backend does not support listing blobs", http.StatusNotImplemented)
+		return
+	}
+	iter, err := lister.List(req.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for written := false; ; written = true {
+		info, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil && !written {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			// the status was already sent, abort the response so the client sees an incomplete body
+			panic(http.ErrAbortHandler)
+		}
+		if err := encoder.Encode(info); err != nil {
+			return
+		}
+	}
+}
+
+// parseBlobPath parses paths of the form /cas/<algorithm>/<hex digest>.
+func parseBlobPath(path string) (sri.Integrity, bool) {
+	if !strings.HasPrefix(path, "/cas/") {
+		return sri.Integrity{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/cas/"), "/")
+	if len(parts) != 2 {
+		return sri.Integrity{}, false
+	}
+	algorithm, err := sri.AlgorithmFromString(parts[0])
+	if err != nil {
+		return sri.Integrity{}, false
+	}
+	hash, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return sri.Integrity{}, false
+	}
+	return sri.Integrity{Algorithm: algorithm, Hash: hash}, true
+}
@@ -9,6 +9,9 @@ import (
 	"path"
 	"strings"
 
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/cas/chunked"
+	"github.com/malt3/abstractfs/cas/tiered"
 	"github.com/malt3/abstractfs/internal/casserve"
 	"github.com/spf13/cobra"
 )
@@ -25,7 +28,7 @@ func NewCASCmd() *cobra.Command {
 
 	cmd.SetOut(os.Stdout)
 
-	cmd.Flags().String("backend-type", "", "Type of the CAS backend.")
+	cmd.Flags().String("backend-type", "", "Type of the CAS backend (memory, dir, http, s3, tiered or chunked).")
 	cmd.Flags().StringToString("backend-option", nil, "Optional CAS backend specific options.")
 	cmd.Flags().StringSlice("http-listen", nil, "Optional address (tcp or unix domain socket) to listen on for HTTP requests.")
 	cmd.Flags().StringSlice("record-listen", nil, "Optional address (tcp or unix domain socket) to listen on for recording requests.")
@@ -33,6 +36,8 @@ func NewCASCmd() *cobra.Command {
 	cmd.Flags().Bool("verbose", false, "Enable verbose output")
 	must(cmd.MarkFlagRequired("backend-type"))
 
+	cmd.AddCommand(NewCASGCCmd(), NewCASLsCmd(), NewCASStatCmd(), NewCASRmCmd())
+
 	return cmd
 }
 
@@ -84,7 +89,23 @@ func runCAS(cmd *cobra.Command, args []string) error {
 		server.AddRecorder(reader)
 	}
 
-	return server.Serve(cmd.Context())
+	err = server.Serve(cmd.Context())
+	if flags.Verbose {
+		printCASStats(cmd, backend)
+	}
+	return err
+}
+
+// printCASStats prints the statistics of backends that collect them.
+func printCASStats(cmd *cobra.Command, backend api.CAS) {
+	switch backend := backend.(type) {
+	case *tiered.CAS:
+		for _, stats := range backend.Stats() {
+			cmd.PrintErrln(stats)
+		}
+	case *chunked.CAS:
+		cmd.PrintErrln(backend.Stats())
+	}
 }
 
 type casFlags struct {
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/malt3/abstractfs/cas"
+	cashttp "github.com/malt3/abstractfs/cas/http"
+	"github.com/spf13/cobra"
+)
+
+// NewCASLsCmd creates a new cas ls command.
+func NewCASLsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ls",
+		Short: "Lists the blobs of a running CAS server",
+		Long: "Lists the blobs of a running CAS server (see cas --http-listen).\n" +
+			"Prints one line per blob with its sri, size and modification time.",
+		Args: cobra.ExactArgs(0),
+		RunE: runCASLs,
+	}
+	cmd.SetOut(os.Stdout)
+	addCASClientFlags(cmd)
+	cmd.Flags().String("prefix", "", "Only list blobs whose sri starts with the prefix (e.g. sha256-).")
+	cmd.Flags().Bool("total", false, "Print the number and total size of the blobs at the end.")
+	return cmd
+}
+
+// NewCASStatCmd creates a new cas stat command.
+func NewCASStatCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stat SRI...",
+		Short: "Shows the size of blobs of a running CAS server",
+		Long: "Shows the size and modification time of blobs of a running CAS server.\n" +
+			"Exits with a non-zero exit code if a blob does not exist.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: runCASStat,
+	}
+	cmd.SetOut(os.Stdout)
+	addCASClientFlags(cmd)
+	return cmd
+}
+
+// NewCASRmCmd creates a new cas rm command.
+func NewCASRmCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rm SRI...",
+		Short: "Deletes blobs from a running CAS server",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runCASRm,
+	}
+	cmd.SetOut(os.Stdout)
+	addCASClientFlags(cmd)
+	return cmd
+}
+
+func addCASClientFlags(cmd *cobra.Command) {
+	cmd.Flags().String("url", "", "Base url of the CAS server.")
+	must(cmd.MarkFlagRequired("url"))
+}
+
+func newCASClient(cmd *cobra.Command) (*cashttp.CAS, error) {
+	baseURL, err := cmd.Flags().GetString("url")
+	if err != nil {
+		return nil, err
+	}
+	return cashttp.NewCAS(baseURL, cashttp.Options{}, false)
+}
+
+func runCASLs(cmd *cobra.Command, args []string) error {
+	client, err := newCASClient(cmd)
+	if err != nil {
+		return err
+	}
+	prefix, err := cmd.Flags().GetString("prefix")
+	if err != nil {
+		return err
+	}
+	total, err := cmd.Flags().GetBool("total")
+	if err != nil {
+		return err
+	}
+
+	iter, err := client.List(prefix)
+	if err != nil {
+		return err
+	}
+	var count, size int64
+	for {
+		info, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		printBlobInfo(cmd, info)
+		count++
+		size += info.Size
+	}
+	if total {
+		cmd.Printf("total %d blobs, %d bytes\n", count, size)
+	}
+	return nil
+}
+
+func runCASStat(cmd *cobra.Command, args []string) error {
+	client, err := newCASClient(cmd)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sri := range args {
+		info, err := client.Stat(sri)
+		if errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("%s: not found", sri))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		printBlobInfo(cmd, info)
+	}
+	return errors.Join(errs...)
+}
+
+func runCASRm(cmd *cobra.Command, args []string) error {
+	client, err := newCASClient(cmd)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, sri := range args {
+		err := client.Delete(sri)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%s: not found", sri)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func printBlobInfo(cmd *cobra.Command, info cas.BlobInfo) {
+	modTime := "-"
+	if !info.ModTime.IsZero() {
+		modTime = info.ModTime.Format(time.RFC3339)
+	}
+	cmd.Printf("%s\t%d\t%s\n", info.SRI, info.Size, modTime)
+}
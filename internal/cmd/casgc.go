@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/malt3/abstractfs/cas/gc"
+	"github.com/spf13/cobra"
+)
+
+// NewCASGCCmd creates a new cas gc command.
+func NewCASGCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Deletes unreferenced blobs from a CAS",
+		Long: "Deletes all blobs from a CAS backend that are not referenced by any root.\n" +
+			"Roots are flattened trees (the output of the json command) or recordings.\n" +
+			"The backend must support listing and deleting blobs.\n" +
+			"For a chunked backend, unreferenced chunks are deleted as well.\n" +
+			"Do not run gc on the chunk store of a chunked backend directly: chunks are never referenced by roots.",
+		Args: cobra.ExactArgs(0),
+		RunE: runCASGC,
+	}
+
+	cmd.SetOut(os.Stdout)
+
+	cmd.Flags().String("backend-type", "", "Type of the CAS backend.")
+	cmd.Flags().StringToString("backend-option", nil, "Optional CAS backend specific options.")
+	cmd.Flags().StringArray("root-json", nil, "Flattened tree (json) whose contents are kept. Use \"-\" for stdin. Can be repeated.")
+	cmd.Flags().StringArray("root-record", nil, "Recording whose contents are kept. Use \"-\" for stdin. Can be repeated.")
+	cmd.Flags().Bool("dry-run", false, "Only report the blobs that would be deleted.")
+	cmd.Flags().Duration("grace-period", time.Hour, "Keep unreferenced blobs written within this period.")
+	cmd.Flags().Bool("force", false, "Delete unreferenced blobs even if no blob of the backend is referenced by the roots.")
+	cmd.Flags().Bool("verbose", false, "Print every deleted blob")
+	must(cmd.MarkFlagRequired("backend-type"))
+
+	return cmd
+}
+
+func runCASGC(cmd *cobra.Command, args []string) error {
+	flags, err := parseCASGCFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	live := make(gc.Set)
+	for _, root := range flags.RootJSON {
+		if err := markRoot(live, root, gc.MarkJSON); err != nil {
+			return err
+		}
+	}
+	for _, root := range flags.RootRecord {
+		if err := markRoot(live, root, gc.MarkRecording); err != nil {
+			return err
+		}
+	}
+
+	backend, closeBackend, err := getCASBackend(flags.BackendType, flags.BackendOpts)
+	if err != nil {
+		return err
+	}
+	defer closeBackend()
+	store, ok := backend.(gc.Store)
+	if !ok {
+		return fmt.Errorf("backend %q does not support listing and deleting blobs", flags.BackendType)
+	}
+
+	report, err := gc.Sweep(store, live, gc.Options{
+		DryRun:      flags.DryRun,
+		GracePeriod: flags.GracePeriod,
+		Force:       flags.Force,
+	})
+	if flags.Verbose {
+		for _, blob := range report.Deleted {
+			cmd.Printf("%s %d\n", blob.SRI, blob.Size)
+		}
+		if report.Parts != nil {
+			for _, blob := range report.Parts.Deleted {
+				cmd.Printf("%s %d (part)\n", blob.SRI, blob.Size)
+			}
+		}
+	}
+	verb, reclaimed := "deleted", "reclaimed"
+	if flags.DryRun {
+		verb, reclaimed = "would delete", "reclaimable"
+	}
+	cmd.Printf("scanned %d blobs (%d referenced, %d within grace period), %s %d blobs",
+		report.Scanned, report.Live, report.Recent, verb, len(report.Deleted))
+	if parts := report.Parts; parts != nil {
+		cmd.Printf("; scanned %d parts (%d referenced, %d within grace period), %s %d parts",
+			parts.Scanned, parts.Live, parts.Recent, verb, len(parts.Deleted))
+	}
+	cmd.Printf(", %d bytes %s\n", report.ReclaimedBytes, reclaimed)
+	return err
+}
+
+// markRoot marks the blobs referenced by the root file.
+func markRoot(live gc.Set, root string, mark func(gc.Set, io.Reader) error) error {
+	var r io.Reader = os.Stdin
+	if root != "-" {
+		f, err := os.Open(root)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := mark(live, r); err != nil {
+		return fmt.Errorf("marking root %s: %w", root, err)
+	}
+	return nil
+}
+
+type casGCFlags struct {
+	BackendType string
+	BackendOpts map[string]string
+	RootJSON    []string
+	RootRecord  []string
+	DryRun      bool
+	GracePeriod time.Duration
+	Force       bool
+	Verbose     bool
+}
+
+func parseCASGCFlags(cmd *cobra.Command) (casGCFlags, error) {
+	backendType, err := cmd.Flags().GetString("backend-type")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	backendOptions, err := cmd.Flags().GetStringToString("backend-option")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	rootJSON, err := cmd.Flags().GetStringArray("root-json")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	rootRecord, err := cmd.Flags().GetStringArray("root-record")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	if len(rootJSON)+len(rootRecord) == 0 {
+		// without roots, every blob would be deleted
+		return casGCFlags{}, errors.New("at least one --root-json or --root-record is required")
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	gracePeriod, err := cmd.Flags().GetDuration("grace-period")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return casGCFlags{}, err
+	}
+
+	return casGCFlags{
+		BackendType: backendType,
+		BackendOpts: backendOptions,
+		RootJSON:    rootJSON,
+		RootRecord:  rootRecord,
+		DryRun:      dryRun,
+		GracePeriod: gracePeriod,
+		Force:       force,
+		Verbose:     verbose,
+	}, nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/cas/chunked"
+)
+
+const chunkedBackendType = "chunked"
+
+// getChunkedBackend builds a chunking CAS from backend options.
+//
+//   - chunks: backend type of the chunk store
+//   - chunks.<option>: option of the chunk store (a memory chunk store must not have a budget)
+//   - manifests: directory of the manifests (manifests are kept in memory if not set)
+//   - min-size, avg-size, max-size: chunk sizes in bytes
+func getChunkedBackend(opts map[string]string) (api.CAS, api.CloseWaitFunc, error) {
+	var chunksType, manifestDir string
+	var chunkedOpts chunked.Options
+	chunksOpts := make(map[string]string)
+	for key, value := range opts {
+		var size *int
+		switch key {
+		case "chunks":
+			chunksType = value
+			continue
+		case "manifests":
+			manifestDir = value
+			continue
+		case "min-size":
+			size = &chunkedOpts.MinSize
+		case "avg-size":
+			size = &chunkedOpts.AvgSize
+		case "max-size":
+			size = &chunkedOpts.MaxSize
+		default:
+			option, ok := strings.CutPrefix(key, "chunks.")
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown option %q (valid options are chunks, chunks.<option>, manifests, min-size, avg-size and max-size)", key)
+			}
+			chunksOpts[option] = value
+			continue
+		}
+		var err error
+		if *size, err = strconv.Atoi(value); err != nil {
+			return nil, nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+	}
+	if chunksType == "" {
+		return nil, nil, errors.New("chunked cas: missing chunks option")
+	}
+	if chunksType == chunkedBackendType {
+		return nil, nil, errors.New("chunked cas: chunks cannot be chunked")
+	}
+
+	var manifests chunked.ManifestStore = chunked.NewMemoryManifests()
+	if manifestDir != "" {
+		dirManifests, err := chunked.NewDirManifests(manifestDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifests = dirManifests
+	}
+	chunks, closeChunks, err := getCASBackend(chunksType, chunksOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("chunk store: %w", err)
+	}
+	cas, err := chunked.New(chunks, manifests, chunkedOpts)
+	if err != nil {
+		closeChunks()
+		return nil, nil, err
+	}
+	return cas, closeChunks, nil
+}
@@ -3,9 +3,13 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/malt3/abstractfs-core/api"
 	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/filter"
+	"github.com/malt3/abstractfs/overlay"
+	"github.com/malt3/abstractfs/transform"
 	"github.com/spf13/cobra"
 )
 
@@ -14,20 +18,25 @@ func NewConvertCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "convert",
 		Short: "Converts a file system to a different format",
-		Long:  "Converts a file system to a different format.",
-		Args:  cobra.ExactArgs(0),
-		RunE:  runConvert,
+		Long: "Converts a file system to a different format.\n" +
+			"Multiple sources are merged in order, later sources override earlier ones by path.",
+		Args: cobra.ExactArgs(0),
+		RunE: runConvert,
 	}
 
 	cmd.SetOut(os.Stdout)
 
-	cmd.Flags().String("source", "", "Path or reference to the source.")
-	cmd.Flags().String("source-type", "", "Type of the source.")
-	cmd.Flags().StringToString("source-option", nil, "Optional provider specific source options.")
+	cmd.Flags().StringArray("source", nil, "Path or reference to the source. Can be repeated to merge multiple sources.")
+	cmd.Flags().StringArray("source-type", nil, "Type of the source. Must be given once per source, in the order of the sources.")
+	cmd.Flags().StringArray("source-option", nil, "Optional provider specific source options (comma separated key=value pairs). Can be repeated. With several sources, applies to all sources if given once, otherwise must be given once per source, in the order of the sources.")
+	cmd.Flags().StringArray("mount", nil, "Optional absolute path to mount the source at. If set, must be given once per source.")
+	cmd.Flags().String("conflict", overlay.PolicyMergeDirs, "Policy for paths that exist in multiple sources (merge-dirs, last-wins, keep-first or error).")
 	cmd.Flags().String("sink", "", "Path or reference to the sink.")
 	cmd.Flags().String("sink-type", "", "Type of the sink.")
 	cmd.Flags().StringToString("sink-option", nil, "Optional provider specific sink options.")
+	cmd.Flags().StringArray("transform", nil, "Optional metadata transformation (e.g. mtime-clamp=source-date-epoch, uid=0, xattrs-strip, umask=022). Can be repeated.")
 	cmd.Flags().Bool("verbose", false, "Enable verbose output")
+	addFilterFlags(cmd)
 	must(cmd.MarkFlagRequired("source"))
 	must(cmd.MarkFlagRequired("source-type"))
 	must(cmd.MarkFlagRequired("sink"))
@@ -42,19 +51,36 @@ func runConvert(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	source, closeSource, err := getSource(flags.Source, flags.SourceType, flags.SourceOpts)
-	if err != nil {
-		return err
-	}
-	defer closeSource()
+	layers := make([]overlay.Layer, len(flags.Sources))
+	casReaders := make(overlay.CASReader, len(flags.Sources))
+	for i := range flags.Sources {
+		source, closeSource, err := getSource(flags.Sources[i], flags.SourceTypes[i], flags.SourceOpts[i], flags.Filter)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
 
-	casReader, ok := source.(api.CASReader)
-	if !ok {
-		return fmt.Errorf("source does not support reading as CAS")
+		casReader, ok := source.(api.CASReader)
+		if !ok {
+			return fmt.Errorf("source %q does not support reading as CAS", flags.Sources[i])
+		}
+		casReaders[i] = casReader
+		if layers[i].Tree, err = coretree.FromSource(source); err != nil {
+			return err
+		}
+		if len(flags.Mounts) > 0 {
+			layers[i].Mount = flags.Mounts[i]
+		}
 	}
 
-	tree, err := coretree.FromSource(source)
-	if err != nil {
+	tree, casReader := layers[0].Tree, api.CASReader(casReaders[0])
+	if len(layers) > 1 || len(flags.Mounts) > 0 {
+		if tree, err = overlay.Merge(flags.Conflict, layers...); err != nil {
+			return err
+		}
+		casReader = casReaders
+	}
+	if err := transform.Apply(tree, flags.Transforms...); err != nil {
 		return err
 	}
 
@@ -69,25 +95,53 @@ func runConvert(cmd *cobra.Command, args []string) error {
 }
 
 type convertFlags struct {
-	Source     string
-	SourceType string
-	SourceOpts map[string]string
-	Sink       string
-	SinkType   string
-	SinkOpts   map[string]string
-	Verbose    bool
+	Sources     []string
+	SourceTypes []string
+	SourceOpts  []map[string]string
+	Mounts      []string
+	Conflict    string
+	Transforms  []transform.Transform
+	Sink        string
+	SinkType    string
+	SinkOpts    map[string]string
+	Verbose     bool
+	Filter      *filter.Filter
 }
 
 func parseConvertFlags(cmd *cobra.Command) (convertFlags, error) {
-	source, err := cmd.Flags().GetString("source")
+	sources, err := cmd.Flags().GetStringArray("source")
 	if err != nil {
 		return convertFlags{}, err
 	}
-	sourceType, err := cmd.Flags().GetString("source-type")
+	sourceTypes, err := cmd.Flags().GetStringArray("source-type")
 	if err != nil {
 		return convertFlags{}, err
 	}
-	sourceOptions, err := cmd.Flags().GetStringToString("source-option")
+	if len(sources) != len(sourceTypes) {
+		return convertFlags{}, fmt.Errorf("got %d sources and %d source types", len(sources), len(sourceTypes))
+	}
+	mounts, err := cmd.Flags().GetStringArray("mount")
+	if err != nil {
+		return convertFlags{}, err
+	}
+	if len(mounts) > 0 && len(mounts) != len(sources) {
+		return convertFlags{}, fmt.Errorf("got %d mount points for %d sources", len(mounts), len(sources))
+	}
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount, "/") {
+			return convertFlags{}, fmt.Errorf("mount point %q is not absolute", mount)
+		}
+	}
+	conflict, err := cmd.Flags().GetString("conflict")
+	if err != nil {
+		return convertFlags{}, err
+	}
+	switch conflict {
+	case overlay.PolicyMergeDirs, overlay.PolicyLastWins, overlay.PolicyKeepFirst, overlay.PolicyError:
+	default:
+		return convertFlags{}, fmt.Errorf("unsupported conflict policy %q", conflict)
+	}
+	sourceOptions, err := getSourceOptions(cmd, len(sources))
 	if err != nil {
 		return convertFlags{}, err
 	}
@@ -103,18 +157,35 @@ func parseConvertFlags(cmd *cobra.Command) (convertFlags, error) {
 	if err != nil {
 		return convertFlags{}, err
 	}
+	transformSpecs, err := cmd.Flags().GetStringArray("transform")
+	if err != nil {
+		return convertFlags{}, err
+	}
+	transforms, err := transform.ParseAll(transformSpecs)
+	if err != nil {
+		return convertFlags{}, err
+	}
 	verbose, err := cmd.Flags().GetBool("verbose")
 	if err != nil {
 		return convertFlags{}, err
 	}
 
+	pathFilter, err := parseFilterFlags(cmd)
+	if err != nil {
+		return convertFlags{}, err
+	}
+
 	return convertFlags{
-		Source:     source,
-		SourceType: sourceType,
-		SourceOpts: sourceOptions,
-		Sink:       sink,
-		SinkType:   sinkType,
-		SinkOpts:   sinkOptions,
-		Verbose:    verbose,
+		Sources:     sources,
+		SourceTypes: sourceTypes,
+		SourceOpts:  sourceOptions,
+		Mounts:      mounts,
+		Conflict:    conflict,
+		Transforms:  transforms,
+		Sink:        sink,
+		SinkType:    sinkType,
+		SinkOpts:    sinkOptions,
+		Verbose:     verbose,
+		Filter:      pathFilter,
 	}, nil
 }
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/diff"
+	"github.com/malt3/abstractfs/filter"
+	"github.com/spf13/cobra"
+)
+
+// Output modes of the diff command.
+const (
+	diffOutputHuman    = "human"
+	diffOutputJSON     = "json"
+	diffOutputExitCode = "exit-code"
+)
+
+// maxPrintedXAttrLen is the length of the longest xattr value that is printed as is.
+const maxPrintedXAttrLen = 64
+
+// errDifferences is returned if the file systems differ.
+// It is silenced, the command only exits with a non-zero exit code.
+var errDifferences = errors.New("file systems differ")
+
+// NewDiffCmd creates a new diff command.
+func NewDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compares two file systems",
+		Long: "Compares two file systems node by node.\n" +
+			"Reports added, removed and changed nodes and the differences in kind, payload, mode, ownership, mtime and xattrs.\n" +
+			"The first source is the old, the second source the new file system.\n" +
+			"Exits with a non-zero exit code if the file systems differ.",
+		Args: cobra.ExactArgs(0),
+		RunE: runDiff,
+	}
+
+	cmd.SetOut(os.Stdout)
+
+	cmd.Flags().StringArray("source", nil, "Path or reference to a source. Must be given twice.")
+	cmd.Flags().StringArray("source-type", nil, "Type of a source. Must be given twice, in the order of the sources.")
+	cmd.Flags().StringArray("source-option", nil, "Optional provider specific source options (comma separated key=value pairs). Applies to both sources if given once, otherwise must be given twice, in the order of the sources.")
+	cmd.Flags().String("output", diffOutputHuman, "Output mode (human, json or exit-code).")
+	cmd.Flags().StringSlice("ignore", nil, fmt.Sprintf("Fields to ignore (%s).", strings.Join(diff.Fields, ",")))
+	addFilterFlags(cmd)
+	must(cmd.MarkFlagRequired("source"))
+	must(cmd.MarkFlagRequired("source-type"))
+
+	return cmd
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	flags, err := parseDiffFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	var trees [2]api.Tree
+	for i := range trees {
+		source, closeSource, err := getSource(flags.Sources[i], flags.SourceTypes[i], flags.SourceOpts[i], flags.Filter)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if trees[i], err = coretree.FromSource(source); err != nil {
+			return err
+		}
+	}
+
+	changes := diff.Trees(trees[0], trees[1], diff.Options{Ignore: flags.Ignore})
+	switch flags.Output {
+	case diffOutputJSON:
+		if changes == nil {
+			changes = []diff.Change{}
+		}
+		if err := json.NewEncoder(cmd.OutOrStdout()).Encode(changes); err != nil {
+			return err
+		}
+	case diffOutputHuman:
+		if err := printChanges(cmd.OutOrStdout(), changes); err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		cmd.SilenceErrors = true
+		return errDifferences
+	}
+	return nil
+}
+
+// printChanges prints one line per change.
+// For changed nodes, the old and new value of every differing field is printed.
+func printChanges(w io.Writer, changes []diff.Change) error {
+	for _, change := range changes {
+		var details []string
+		for _, field := range change.Fields {
+			details = append(details, fmt.Sprintf("%s: %s -> %s", field, fieldValue(field, *change.Old), fieldValue(field, *change.New)))
+		}
+		line := fmt.Sprintf("%-8s %s", change.Type, change.Path)
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldValue returns the human readable value of a field.
+func fieldValue(field string, stat api.Stat) string {
+	attrs := stat.Attributes
+	var value string
+	switch field {
+	case diff.FieldKind:
+		value = stat.Kind
+	case diff.FieldPayload:
+		value = stat.Payload
+	case diff.FieldSize:
+		value = fmt.Sprint(stat.Size)
+	case diff.FieldMtime:
+		if !attrs.Mtime.IsZero() {
+			value = attrs.Mtime.Format(time.RFC3339Nano)
+		}
+	case diff.FieldUID:
+		value = attrs.UserID
+	case diff.FieldGID:
+		value = attrs.GroupID
+	case diff.FieldUname:
+		value = attrs.UserName
+	case diff.FieldGname:
+		value = attrs.GroupName
+	case diff.FieldMode:
+		value = attrs.Mode
+	case diff.FieldXAttrs:
+		keys := make([]string, 0, len(attrs.XAttrs))
+		for key := range attrs.XAttrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			pairs[i] = key + "=" + xattrValue(attrs.XAttrs[key])
+		}
+		value = "[" + strings.Join(pairs, " ") + "]"
+	}
+	if value == "" {
+		return `""`
+	}
+	return value
+}
+
+// xattrValue returns the printable value of an xattr.
+// Values may be binary, so they are quoted. Long values are shortened to a digest.
+func xattrValue(value string) string {
+	if len(value) <= maxPrintedXAttrLen {
+		return strconv.Quote(value)
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])[:16]
+}
+
+type diffFlags struct {
+	Sources     []string
+	SourceTypes []string
+	SourceOpts  []map[string]string
+	Output      string
+	Ignore      []string
+	Filter      *filter.Filter
+}
+
+func parseDiffFlags(cmd *cobra.Command) (diffFlags, error) {
+	sources, err := cmd.Flags().GetStringArray("source")
+	if err != nil {
+		return diffFlags{}, err
+	}
+	sourceTypes, err := cmd.Flags().GetStringArray("source-type")
+	if err != nil {
+		return diffFlags{}, err
+	}
+	if len(sources) != 2 || len(sourceTypes) != 2 {
+		return diffFlags{}, errors.New("--source and --source-type must be given exactly twice")
+	}
+	sourceOptions, err := getSourceOptions(cmd, len(sources))
+	if err != nil {
+		return diffFlags{}, err
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return diffFlags{}, err
+	}
+	switch output {
+	case diffOutputHuman, diffOutputJSON, diffOutputExitCode:
+	default:
+		return diffFlags{}, fmt.Errorf("unsupported output mode %q", output)
+	}
+	ignore, err := cmd.Flags().GetStringSlice("ignore")
+	if err != nil {
+		return diffFlags{}, err
+	}
+	for _, field := range ignore {
+		if !isDiffField(field) {
+			return diffFlags{}, fmt.Errorf("unknown field %q, valid fields are %s", field, strings.Join(diff.Fields, ","))
+		}
+	}
+
+	pathFilter, err := parseFilterFlags(cmd)
+	if err != nil {
+		return diffFlags{}, err
+	}
+
+	return diffFlags{
+		Sources:     sources,
+		SourceTypes: sourceTypes,
+		SourceOpts:  sourceOptions,
+		Output:      output,
+		Ignore:      ignore,
+		Filter:      pathFilter,
+	}, nil
+}
+
+func isDiffField(field string) bool {
+	for _, known := range diff.Fields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
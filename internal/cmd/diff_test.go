@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/diff"
+)
+
+func TestFieldValueXAttrs(t *testing.T) {
+	testCases := map[string]struct {
+		xattrs map[string]string
+		want   string
+	}{
+		"none": {
+			want: "[]",
+		},
+		"sorted and quoted": {
+			xattrs: map[string]string{"user.b": "\x00\x01", "user.a": "value"},
+			want:   `[user.a="value" user.b="\x00\x01"]`,
+		},
+		"long values are shortened to a digest": {
+			xattrs: map[string]string{"user.a": strings.Repeat("x", maxPrintedXAttrLen+1)},
+			want:   "[user.a=sha256:9537c5fdf120482f]",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			stat := api.Stat{Attributes: api.NodeAttributes{XAttrs: tc.xattrs}}
+			if got := fieldValue(diff.FieldXAttrs, stat); got != tc.want {
+				t.Errorf("fieldValue() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/malt3/abstractfs/filter"
+	"github.com/spf13/cobra"
+)
+
+// addFilterFlags adds the flags to filter sources by path.
+func addFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("include", nil, "Optional pattern of paths to include (gitignore syntax, e.g. /usr/**). Can be repeated.")
+	cmd.Flags().StringArray("exclude", nil, "Optional pattern of paths to exclude (gitignore syntax, e.g. /proc or *.pyc). Can be repeated.")
+	cmd.Flags().StringArray("exclude-from", nil, "Optional file with exclude patterns (one per line, like .dockerignore). Can be repeated.")
+}
+
+// parseFilterFlags returns the filter of the flags or nil if no patterns are given.
+func parseFilterFlags(cmd *cobra.Command) (*filter.Filter, error) {
+	includes, err := cmd.Flags().GetStringArray("include")
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := cmd.Flags().GetStringArray("exclude")
+	if err != nil {
+		return nil, err
+	}
+	excludeFrom, err := cmd.Flags().GetStringArray("exclude-from")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range excludeFrom {
+		patterns, err := readPatterns(path)
+		if err != nil {
+			return nil, err
+		}
+		excludes = append(excludes, patterns...)
+	}
+	if len(includes) == 0 && len(excludes) == 0 {
+		return nil, nil
+	}
+	return filter.New(includes, excludes)
+}
+
+func readPatterns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return filter.ReadPatterns(file)
+}
@@ -1,9 +1,9 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/url"
 	"os"
@@ -11,7 +11,14 @@ import (
 	"strings"
 
 	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs-core/cas/recorder"
+	coreprovider "github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs-core/sri"
 	coretree "github.com/malt3/abstractfs-core/tree"
+	cashttp "github.com/malt3/abstractfs/cas/http"
+	"github.com/malt3/abstractfs/filter"
+	fsjson "github.com/malt3/abstractfs/fs/json"
+	"github.com/malt3/abstractfs/transform"
 	"github.com/spf13/cobra"
 )
 
@@ -30,9 +37,11 @@ func NewJSONCmd() *cobra.Command {
 	cmd.Flags().String("source", "", "Path or reference to the source.")
 	cmd.Flags().String("source-type", "", "Type of the source.")
 	cmd.Flags().String("out", "", "Optional path to write the JSON to. If not set, the result is written to stdout.")
-	cmd.Flags().StringToString("source-option", nil, "Optional provider specific options.")
-	cmd.Flags().StringSlice("record-to", nil, "Optional output url to record CAS contents to.")
+	cmd.Flags().StringArray("source-option", nil, "Optional provider specific options (comma separated key=value pairs). Can be repeated.")
+	cmd.Flags().StringSlice("record-to", nil, "Optional output url to record CAS contents to (tcp, unix, file, or http(s) to upload to a cas server).")
+	cmd.Flags().StringArray("transform", nil, "Optional metadata transformation (e.g. mtime-clamp=source-date-epoch, uid=0, xattrs-strip, umask=022). Can be repeated.")
 	cmd.Flags().Bool("verbose", false, "Enable verbose output")
+	addFilterFlags(cmd)
 	must(cmd.MarkFlagRequired("source"))
 	must(cmd.MarkFlagRequired("source-type"))
 
@@ -45,7 +54,7 @@ func runJSON(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	source, closeSource, err := getSource(flags.Source, flags.SourceType, flags.SourceOpts)
+	source, closeSource, err := getSource(flags.Source, flags.SourceType, flags.SourceOpts, flags.Filter)
 	if err != nil {
 		return err
 	}
@@ -55,6 +64,9 @@ func runJSON(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := transform.Apply(tree, flags.Transforms...); err != nil {
+		return err
+	}
 
 	if err := record(source, tree, flags); err != nil {
 		return err
@@ -71,7 +83,7 @@ func runJSON(cmd *cobra.Command, args []string) error {
 		defer outF.Close()
 		out = outF
 	}
-	return json.NewEncoder(out).Encode(flat.Files)
+	return fsjson.Encode(out, flat.Files)
 }
 
 func record(source api.Source, tree api.Tree, flags jsonFlags) error {
@@ -103,21 +115,92 @@ func record(source api.Source, tree api.Tree, flags jsonFlags) error {
 			}
 			defer f.Close()
 			writers = append(writers, f)
+		case "http", "https":
+			// blobs are uploaded directly to the http cas of a cas server
+			backend := &cashttp.Provider{URL: conf.Location}
+			if err := coreprovider.SetOptions(backend, conf.Options); err != nil {
+				return fmt.Errorf("setting options: %w", err)
+			}
+			cas, _, err := backend.CASWriter()
+			if err != nil {
+				return err
+			}
+			if err := uploadTree(treeFS, cas); err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("invalid protocol: %s", conf.Protocol)
 		}
 	}
+	if len(writers) == 0 {
+		return nil
+	}
 
-	return treeFS.Record(io.MultiWriter(writers...))
+	return recordTree(treeFS, io.MultiWriter(writers...))
+}
+
+// recordTree records the contents of all regular files of the tree to a io.Writer.
+// Unlike coretree.TreeFS.Record, it supports all node kinds and records every payload only once.
+func recordTree(treeFS *coretree.TreeFS, w io.Writer) error {
+	return walkBlobs(treeFS, func(integrity sri.Integrity, size int64, file fs.File) error {
+		return recorder.Encode(w, integrity, size, file)
+	})
+}
+
+// uploadTree writes the contents of all regular files of the tree to a CAS.
+func uploadTree(treeFS *coretree.TreeFS, cas api.CASWriter) error {
+	return walkBlobs(treeFS, func(integrity sri.Integrity, _ int64, file fs.File) error {
+		return cas.Write(integrity.String(), file)
+	})
+}
+
+// walkBlobs calls fn once for every payload of the regular files of the tree.
+func walkBlobs(treeFS *coretree.TreeFS, fn func(integrity sri.Integrity, size int64, file fs.File) error) error {
+	visited := make(map[string]struct{})
+	return fs.WalkDir(treeFS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(api.Stat)
+		if !ok {
+			return &fs.PathError{Op: "record", Path: path, Err: fs.ErrInvalid}
+		}
+		if stat.Kind != api.KindRegular {
+			return nil
+		}
+		if _, ok := visited[stat.Payload]; ok {
+			return nil
+		}
+		integrity, err := sri.FromString(stat.Payload)
+		if err != nil {
+			return err
+		}
+		file, err := treeFS.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := fn(integrity, stat.Size, file); err != nil {
+			return err
+		}
+		visited[stat.Payload] = struct{}{}
+		return nil
+	})
 }
 
 type jsonFlags struct {
 	Source     string
 	SourceType string
 	SourceOpts map[string]string
+	Transforms []transform.Transform
 	RecordTo   []recordToConfig
 	Out        string
 	Verbose    bool
+	Filter     *filter.Filter
 }
 
 func parseJSONFlags(cmd *cobra.Command) (jsonFlags, error) {
@@ -133,7 +216,7 @@ func parseJSONFlags(cmd *cobra.Command) (jsonFlags, error) {
 	if err != nil {
 		return jsonFlags{}, err
 	}
-	sourceOptions, err := cmd.Flags().GetStringToString("source-option")
+	sourceOptions, err := getOptions(cmd, "source-option")
 	if err != nil {
 		return jsonFlags{}, err
 	}
@@ -150,18 +233,33 @@ func parseJSONFlags(cmd *cobra.Command) (jsonFlags, error) {
 		recordToConfigs = append(recordToConfigs, recordToConfig)
 	}
 
+	transformSpecs, err := cmd.Flags().GetStringArray("transform")
+	if err != nil {
+		return jsonFlags{}, err
+	}
+	transforms, err := transform.ParseAll(transformSpecs)
+	if err != nil {
+		return jsonFlags{}, err
+	}
 	verbose, err := cmd.Flags().GetBool("verbose")
 	if err != nil {
 		return jsonFlags{}, err
 	}
 
+	pathFilter, err := parseFilterFlags(cmd)
+	if err != nil {
+		return jsonFlags{}, err
+	}
+
 	return jsonFlags{
 		Source:     source,
 		SourceType: sourceType,
 		SourceOpts: sourceOptions,
+		Transforms: transforms,
 		RecordTo:   recordToConfigs,
 		Out:        out,
 		Verbose:    verbose,
+		Filter:     pathFilter,
 	}, nil
 }
 
@@ -180,7 +278,11 @@ func parseRecordURL(u string) (recordToConfig, error) {
 		} else {
 			location = recordURL.Path
 		}
-
+	case "http", "https":
+		// the query holds the options of the http cas
+		base := *recordURL
+		base.RawQuery = ""
+		location = base.String()
 	default:
 		return recordToConfig{}, fmt.Errorf("invalid scheme: %s", recordURL.Scheme)
 	}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/diff"
+	"github.com/malt3/abstractfs/filter"
+	"github.com/spf13/cobra"
+)
+
+// NewLayerDiffCmd creates a new layer-diff command.
+func NewLayerDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "layer-diff",
+		Short: "Creates an OCI layer with the changes between two file systems",
+		Long: "Creates an OCI layer tarball with the changes between two file systems.\n" +
+			"Applying the layer on top of the base results in the target.\n" +
+			"Removed files are written as \".wh.\" whiteouts and directories whose contents were replaced entirely as opaque directories.",
+		Args: cobra.ExactArgs(0),
+		RunE: runLayerDiff,
+	}
+
+	cmd.SetOut(os.Stdout)
+
+	cmd.Flags().String("base", "", "Path or reference to the base (lower) file system.")
+	cmd.Flags().String("base-type", "", "Type of the base.")
+	cmd.Flags().StringArray("base-option", nil, "Optional provider specific base options (comma separated key=value pairs). Can be repeated.")
+	cmd.Flags().String("target", "", "Path or reference to the target (upper) file system.")
+	cmd.Flags().String("target-type", "", "Type of the target.")
+	cmd.Flags().StringArray("target-option", nil, "Optional provider specific target options (comma separated key=value pairs). Can be repeated.")
+	cmd.Flags().String("out", "", "Path of the layer tarball.")
+	cmd.Flags().StringToString("sink-option", nil, "Optional tar sink options (e.g. compression=gzip).")
+	cmd.Flags().Bool("verbose", false, "Enable verbose output")
+	addFilterFlags(cmd)
+	must(cmd.MarkFlagRequired("base"))
+	must(cmd.MarkFlagRequired("base-type"))
+	must(cmd.MarkFlagRequired("target"))
+	must(cmd.MarkFlagRequired("target-type"))
+	must(cmd.MarkFlagRequired("out"))
+
+	return cmd
+}
+
+func runLayerDiff(cmd *cobra.Command, args []string) error {
+	flags, err := parseLayerDiffFlags(cmd)
+	if err != nil {
+		return err
+	}
+
+	base, closeBase, err := getSource(flags.Base, flags.BaseType, flags.BaseOpts, flags.Filter)
+	if err != nil {
+		return err
+	}
+	defer closeBase()
+	baseTree, err := coretree.FromSource(base)
+	if err != nil {
+		return err
+	}
+
+	target, closeTarget, err := getSource(flags.Target, flags.TargetType, flags.TargetOpts, flags.Filter)
+	if err != nil {
+		return err
+	}
+	defer closeTarget()
+	casReader, ok := target.(api.CASReader)
+	if !ok {
+		return fmt.Errorf("target does not support reading as CAS")
+	}
+	targetTree, err := coretree.FromSource(target)
+	if err != nil {
+		return err
+	}
+
+	if flags.Verbose {
+		for _, change := range diff.Trees(baseTree, targetTree, diff.Options{}) {
+			cmd.PrintErrf("%s %s\n", change.Type, change.Path)
+		}
+	}
+	layer := diff.Layer(baseTree, targetTree)
+
+	sinkOpts := map[string]string{"whiteouts": "true"}
+	for key, value := range flags.SinkOpts {
+		sinkOpts[key] = value
+	}
+	sink, closeSink, err := getSink(flags.Out, "tar", sinkOpts)
+	if err != nil {
+		return err
+	}
+	defer closeSink()
+	return sink.Consume(&coretree.TreeFS{Tree: layer, CASReader: casReader})
+}
+
+type layerDiffFlags struct {
+	Base       string
+	BaseType   string
+	BaseOpts   map[string]string
+	Target     string
+	TargetType string
+	TargetOpts map[string]string
+	Out        string
+	SinkOpts   map[string]string
+	Verbose    bool
+	Filter     *filter.Filter
+}
+
+func parseLayerDiffFlags(cmd *cobra.Command) (layerDiffFlags, error) {
+	base, err := cmd.Flags().GetString("base")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	baseType, err := cmd.Flags().GetString("base-type")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	baseOptions, err := getOptions(cmd, "base-option")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	target, err := cmd.Flags().GetString("target")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	targetType, err := cmd.Flags().GetString("target-type")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	targetOptions, err := getOptions(cmd, "target-option")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	out, err := cmd.Flags().GetString("out")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	sinkOptions, err := cmd.Flags().GetStringToString("sink-option")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+	verbose, err := cmd.Flags().GetBool("verbose")
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+
+	pathFilter, err := parseFilterFlags(cmd)
+	if err != nil {
+		return layerDiffFlags{}, err
+	}
+
+	return layerDiffFlags{
+		Base:       base,
+		BaseType:   baseType,
+		BaseOpts:   baseOptions,
+		Target:     target,
+		TargetType: targetType,
+		TargetOpts: targetOptions,
+		Out:        out,
+		SinkOpts:   sinkOptions,
+		Verbose:    verbose,
+		Filter:     pathFilter,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLayerDiff(t *testing.T) {
+	base, target := t.TempDir(), t.TempDir()
+	writeFiles(t, base, map[string]string{
+		"a/changed": "old",
+		"a/removed": "removed",
+		"b/x":       "x",
+		"b/y":       "y",
+		"unchanged": "unchanged",
+	})
+	writeFiles(t, target, map[string]string{
+		"a/changed": "new",
+		"b/":        "",
+		"unchanged": "unchanged",
+		"added":     "added",
+	})
+	out := filepath.Join(t.TempDir(), "layer.tar")
+
+	cmd := NewLayerDiffCmd()
+	cmd.SetArgs([]string{
+		"--base", base, "--base-type", "dir",
+		"--target", target, "--target-type", "dir",
+		"--out", out,
+	})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := tar.NewReader(file)
+	got := map[string]string{}
+	var names []string
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		got[header.Name] = string(contents)
+	}
+	sort.Strings(names)
+	want := []string{"a/", "a/.wh.removed", "a/changed", "added", "b/", "b/.wh..wh..opq"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("layer has entries %q, want %q", names, want)
+	}
+	if got["a/changed"] != "new" || got["added"] != "added" {
+		t.Errorf("layer has contents %q, want the contents of the target", got)
+	}
+}
+
+// writeFiles creates the files below root with the same modification time.
+// Names ending in a slash are created as empty directories.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	mtime := time.Unix(1700000000, 0)
+	var paths []string
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for ; path != root; path = filepath.Dir(path) {
+			paths = append(paths, path)
+		}
+	}
+	// all entries exist by now, so creating files no longer changes the times of their directories
+	for _, path := range append(paths, root) {
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
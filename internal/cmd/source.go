@@ -2,13 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/malt3/abstractfs-core/api"
 	coreprovider "github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/filter"
 	"github.com/malt3/abstractfs/internal/providers"
+	"github.com/spf13/cobra"
 )
 
-func getSource(sourceRef, sourceType string, opts map[string]string) (api.Source, api.CloseWaitFunc, error) {
+// getSource builds a source. If the filter is not nil, only the nodes kept by the filter are returned.
+func getSource(sourceRef, sourceType string, opts map[string]string, f *filter.Filter) (api.Source, api.CloseWaitFunc, error) {
 	provider, ok := providers.All[sourceType]
 	if !ok {
 		return nil, nil, fmt.Errorf("unknown source type %q", sourceType)
@@ -17,13 +21,83 @@ func getSource(sourceRef, sourceType string, opts map[string]string) (api.Source
 	if err := coreprovider.SetOptions(builder, opts); err != nil {
 		return nil, nil, fmt.Errorf("setting options: %w", err)
 	}
+	if filterBuilder, ok := builder.(filter.Builder); ok && f != nil {
+		filterBuilder.SetFilter(f)
+	}
 	source, closer, err := builder.Build()
 	if err != nil {
 		return nil, nil, fmt.Errorf("building source: %w", err)
 	}
+	if f != nil {
+		source = filter.Wrap(source, f)
+	}
 	return source, closer, nil
 }
 
+// getSourceOptions parses the repeatable source-option flag of commands with several sources.
+// With a single source, all values are merged (see getOptions).
+// Otherwise, a value that is given once applies to all sources
+// and values that are given once per source apply in the order of the sources.
+func getSourceOptions(cmd *cobra.Command, sources int) ([]map[string]string, error) {
+	values, err := cmd.Flags().GetStringArray("source-option")
+	if err != nil {
+		return nil, err
+	}
+	if sources > 1 && len(values) > 1 && len(values) != sources {
+		return nil, fmt.Errorf("got %d source options for %d sources", len(values), sources)
+	}
+	opts := make([]map[string]string, sources)
+	for i := range opts {
+		if sources > 1 && len(values) > 1 {
+			opts[i], err = parseOptions(values[i])
+		} else {
+			opts[i], err = parseOptions(values...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+// getOptions parses a repeatable option flag of a single provider.
+// Like string-to-string flags, the options of repeated flags are merged.
+func getOptions(cmd *cobra.Command, name string) (map[string]string, error) {
+	values, err := cmd.Flags().GetStringArray(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseOptions(values...)
+}
+
+// parseOptions parses and merges comma separated key=value pairs.
+// A comma only separates two options if the text after it contains "=",
+// so values can be lists (e.g. cas-aliases=sha384,sha512).
+func parseOptions(values ...string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		var fields []string
+		for _, part := range strings.Split(value, ",") {
+			if len(fields) > 0 && !strings.Contains(part, "=") {
+				fields[len(fields)-1] += "," + part
+				continue
+			}
+			fields = append(fields, part)
+		}
+		for _, field := range fields {
+			key, val, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("option %q must be formatted as key=value", field)
+			}
+			opts[key] = val
+		}
+	}
+	return opts, nil
+}
+
 func getSink(sinkRef, sinkType string, opts map[string]string) (api.Sink, api.CloseWaitFunc, error) {
 	provider, ok := providers.All[sinkType]
 	if !ok {
@@ -41,10 +115,19 @@ func getSink(sinkRef, sinkType string, opts map[string]string) (api.Sink, api.Cl
 }
 
 func getCASBackend(backendType string, opts map[string]string) (api.CAS, api.CloseWaitFunc, error) {
-	provider, ok := providers.CAS[backendType]
+	switch backendType {
+	case tieredBackendType:
+		return getTieredBackend(opts)
+	case chunkedBackendType:
+		return getChunkedBackend(opts)
+	}
+	provider, ok := providers.NewCAS(backendType)
 	if !ok {
 		return nil, nil, fmt.Errorf("unknown backend type %q", backendType)
 	}
+	if err := coreprovider.SetOptions(provider, opts); err != nil {
+		return nil, nil, fmt.Errorf("setting options: %w", err)
+	}
 	cas, closer, err := provider.CAS()
 	if err != nil {
 		return nil, nil, fmt.Errorf("building backend: %w", err)
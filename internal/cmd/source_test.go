@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestParseOptions(t *testing.T) {
+	testCases := map[string]struct {
+		values  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		"none": {
+			want: map[string]string{},
+		},
+		"single": {
+			values: []string{"cas-algorithm=sha512"},
+			want:   map[string]string{"cas-algorithm": "sha512"},
+		},
+		"list value": {
+			values: []string{"cas-aliases=sha384,sha512"},
+			want:   map[string]string{"cas-aliases": "sha384,sha512"},
+		},
+		"several options": {
+			values: []string{"cas-aliases=sha384,sha512,cas-algorithm=sha512"},
+			want:   map[string]string{"cas-aliases": "sha384,sha512", "cas-algorithm": "sha512"},
+		},
+		"repeated values are merged": {
+			values: []string{"cas-aliases=sha384", "cas-algorithm=sha512", "cas-aliases=sha512"},
+			want:   map[string]string{"cas-aliases": "sha512", "cas-algorithm": "sha512"},
+		},
+		"missing value": {
+			values:  []string{"cas-algorithm"},
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseOptions(tc.values...)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseOptions() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseOptions() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetSourceOptions(t *testing.T) {
+	testCases := map[string]struct {
+		args    []string
+		sources int
+		want    []map[string]string
+		wantErr bool
+	}{
+		"single source merges repeated flags": {
+			args:    []string{"--source-option", "cas-aliases=sha384", "--source-option", "cas-algorithm=sha512"},
+			sources: 1,
+			want:    []map[string]string{{"cas-aliases": "sha384", "cas-algorithm": "sha512"}},
+		},
+		"once for all sources": {
+			args:    []string{"--source-option", "cas-algorithm=sha512"},
+			sources: 2,
+			want:    []map[string]string{{"cas-algorithm": "sha512"}, {"cas-algorithm": "sha512"}},
+		},
+		"once per source": {
+			args:    []string{"--source-option", "cas-algorithm=sha512", "--source-option", "keep-prefix=true"},
+			sources: 2,
+			want:    []map[string]string{{"cas-algorithm": "sha512"}, {"keep-prefix": "true"}},
+		},
+		"not set": {
+			sources: 2,
+			want:    []map[string]string{{}, {}},
+		},
+		"wrong count": {
+			args:    []string{"--source-option", "a=1", "--source-option", "b=2", "--source-option", "c=3"},
+			sources: 2,
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().StringArray("source-option", nil, "")
+			if err := cmd.Flags().Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			got, err := getSourceOptions(cmd, tc.sources)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("getSourceOptions() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("getSourceOptions() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/cas/tiered"
+)
+
+const tieredBackendType = "tiered"
+
+// getTieredBackend builds a tiered CAS from backend options.
+//
+//   - tiers: comma separated backend types, from the fastest to the slowest
+//   - write: write mode (write-through or write-back)
+//   - promote: promote blobs into faster tiers on reads (default true)
+//   - <type>.<option> or <index>.<option>: option of the tiers of the type or the tier at the index (starting at 0)
+func getTieredBackend(opts map[string]string) (api.CAS, api.CloseWaitFunc, error) {
+	var tierTypes []string
+	var tieredOpts tiered.Options
+	tierOpts := make(map[string]map[string]string)
+	for key, value := range opts {
+		switch key {
+		case "tiers":
+			tierTypes = strings.Split(value, ",")
+		case "write":
+			tieredOpts.WriteMode = value
+		case "promote":
+			promote, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid value for promote: %w", err)
+			}
+			tieredOpts.DisablePromotion = !promote
+		default:
+			tier, option, ok := strings.Cut(key, ".")
+			if !ok {
+				return nil, nil, fmt.Errorf("unknown option %q (valid options are tiers, write, promote and <tier>.<option>)", key)
+			}
+			if tierOpts[tier] == nil {
+				tierOpts[tier] = make(map[string]string)
+			}
+			tierOpts[tier][option] = value
+		}
+	}
+	if len(tierTypes) == 0 {
+		return nil, nil, errors.New("tiered cas: missing tiers option")
+	}
+
+	var tiers []tiered.Tier
+	var closers []api.CloseWaitFunc
+	closeTiers := func() error {
+		var errs []error
+		for _, closer := range closers {
+			errs = append(errs, closer())
+		}
+		return errors.Join(errs...)
+	}
+	for i, tierType := range tierTypes {
+		if tierType == tieredBackendType {
+			return nil, nil, errors.New("tiered cas: tiers cannot be tiered")
+		}
+		// options of the index override options of the type
+		backendOpts := make(map[string]string)
+		for key, value := range tierOpts[tierType] {
+			backendOpts[key] = value
+		}
+		for key, value := range tierOpts[strconv.Itoa(i)] {
+			backendOpts[key] = value
+		}
+		backend, closer, err := getCASBackend(tierType, backendOpts)
+		if err != nil {
+			closeTiers()
+			return nil, nil, fmt.Errorf("tier %d (%s): %w", i, tierType, err)
+		}
+		closers = append(closers, closer)
+		tiers = append(tiers, tiered.Tier{Name: fmt.Sprintf("%d-%s", i, tierType), CAS: backend})
+	}
+	for tier := range tierOpts {
+		if !isTier(tier, tierTypes) {
+			closeTiers()
+			return nil, nil, fmt.Errorf("tiered cas: options for unknown tier %q", tier)
+		}
+	}
+
+	cas, err := tiered.New(tieredOpts, tiers...)
+	if err != nil {
+		closeTiers()
+		return nil, nil, err
+	}
+	return cas, func() error {
+		return errors.Join(cas.Close(), closeTiers())
+	}, nil
+}
+
+// isTier returns true if the name is the type or the index of a tier.
+func isTier(name string, tierTypes []string) bool {
+	if i, err := strconv.Atoi(name); err == nil {
+		return i >= 0 && i < len(tierTypes)
+	}
+	for _, tierType := range tierTypes {
+		if name == tierType {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,35 @@
 package providers
 
 import (
+	"reflect"
+
 	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/cas/dir"
+	cashttp "github.com/malt3/abstractfs/cas/http"
 	"github.com/malt3/abstractfs/cas/memory"
+	"github.com/malt3/abstractfs/cas/s3"
 )
 
 var CAS = map[string]provider.Provider{
 	"memory": &memory.Provider{},
+	"dir":    &dir.Provider{},
+	"http":   &cashttp.Provider{},
+	"s3":     &s3.Provider{},
+}
+
+// NewCAS returns a copy of the registered CAS provider.
+// Options set on the copy do not affect the registered provider or other copies,
+// so a provider can be used several times in the same process (for example for multiple tiers).
+func NewCAS(name string) (provider.Provider, bool) {
+	registered, ok := CAS[name]
+	if !ok {
+		return nil, false
+	}
+	value := reflect.ValueOf(registered)
+	if value.Kind() != reflect.Pointer {
+		return registered, true
+	}
+	fresh := reflect.New(value.Elem().Type())
+	fresh.Elem().Set(value.Elem())
+	return fresh.Interface().(provider.Provider), true
 }
@@ -2,11 +2,19 @@ package providers
 
 import (
 	"github.com/malt3/abstractfs-core/provider"
+	"github.com/malt3/abstractfs/fs/cpio"
 	"github.com/malt3/abstractfs/fs/dir"
+	"github.com/malt3/abstractfs/fs/json"
+	"github.com/malt3/abstractfs/fs/oci"
 	"github.com/malt3/abstractfs/fs/tar"
+	"github.com/malt3/abstractfs/fs/zip"
 )
 
 var All = map[string]provider.Provider{
-	"dir": &dir.Provider{},
-	"tar": &tar.Provider{},
+	"cpio": &cpio.Provider{},
+	"dir":  &dir.Provider{},
+	"json": &json.Provider{},
+	"oci":  &oci.Provider{},
+	"tar":  &tar.Provider{},
+	"zip":  &zip.Provider{},
 }
@@ -0,0 +1,30 @@
+package kind
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DevicePayload encodes a device number as payload of a device node.
+// The format is "<major>:<minor>" (like in /sys/dev).
+func DevicePayload(major, minor uint32) string {
+	return strconv.FormatUint(uint64(major), 10) + ":" + strconv.FormatUint(uint64(minor), 10)
+}
+
+// ParseDevice decodes the payload of a device node into major and minor number.
+func ParseDevice(payload string) (major, minor uint32, err error) {
+	rawMajor, rawMinor, ok := strings.Cut(payload, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid device number %q", payload)
+	}
+	major64, err := strconv.ParseUint(rawMajor, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device major %q: %w", rawMajor, err)
+	}
+	minor64, err := strconv.ParseUint(rawMinor, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid device minor %q: %w", rawMinor, err)
+	}
+	return uint32(major64), uint32(minor64), nil
+}
@@ -0,0 +1,71 @@
+// Package kind defines node kinds in addition to the ones of the core api.
+package kind
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/malt3/abstractfs-core/api"
+	corekind "github.com/malt3/abstractfs-core/kind"
+)
+
+const (
+	// Hardlink is the kind of a node that shares its inode with another node.
+	// The payload is the absolute path of the link target within the tree.
+	// The link target is always a regular file.
+	Hardlink = "hardlink"
+	// CharDevice is the kind of a character device node.
+	// The payload holds the device number (see DevicePayload).
+	CharDevice = "chardevice"
+	// BlockDevice is the kind of a block device node.
+	// The payload holds the device number (see DevicePayload).
+	BlockDevice = "blockdevice"
+	// FIFO is the kind of a named pipe.
+	FIFO = "fifo"
+	// Socket is the kind of a unix domain socket.
+	Socket = "socket"
+	// Whiteout is the kind of a node of a layer that hides the node
+	// with the same name in lower layers. It has no payload.
+	Whiteout = "whiteout"
+)
+
+// OpaquePayload is the payload of an opaque directory of a layer:
+// it hides all children of the directory in lower layers.
+// Other directories have no payload.
+const OpaquePayload = "opaque"
+
+// IsOpaque returns true if the node is an opaque directory.
+func IsOpaque(stat api.Stat) bool {
+	return stat.Kind == api.KindDirectory && stat.Payload == OpaquePayload
+}
+
+// ErrUnsupported is returned by sinks for nodes of a kind that their format cannot represent.
+// Sinks skip such nodes instead if their skip-unsupported option is set.
+var ErrUnsupported = errors.New("unsupported kind")
+
+// Unsupported returns an ErrUnsupported for the node at path.
+func Unsupported(path, nodeKind string) error {
+	return fmt.Errorf("writing %q: %w %q", path, ErrUnsupported, nodeKind)
+}
+
+// FromMode returns the kind of a node with the given mode.
+// In addition to the kinds of the core api, it detects devices, FIFOs and sockets.
+func FromMode(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		return CharDevice
+	case mode&fs.ModeDevice != 0:
+		return BlockDevice
+	case mode&fs.ModeNamedPipe != 0:
+		return FIFO
+	case mode&fs.ModeSocket != 0:
+		return Socket
+	}
+	return corekind.FromMode(mode)
+}
+
+// IsDevice returns true for character and block devices.
+func IsDevice(kind string) bool {
+	return kind == CharDevice || kind == BlockDevice
+}
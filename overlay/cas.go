@@ -0,0 +1,47 @@
+package overlay
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/malt3/abstractfs-core/api"
+	"github.com/malt3/abstractfs/sparse"
+)
+
+// CASReader reads blobs from multiple CAS readers.
+// Like layers, later readers take precedence over earlier ones.
+type CASReader []api.CASReader
+
+// Open opens the blob from the last reader that has it.
+func (r CASReader) Open(sri string) (io.ReadCloser, error) {
+	var errs []error
+	for i := len(r) - 1; i >= 0; i-- {
+		blob, err := r[i].Open(sri)
+		if err == nil {
+			return blob, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, fs.ErrNotExist
+}
+
+// Holes returns the holes of the blob from the last reader that knows them.
+func (r CASReader) Holes(sri string) sparse.HoleMap {
+	for i := len(r) - 1; i >= 0; i-- {
+		if holes := sparse.HolesOf(r[i], sri); holes != nil {
+			return holes
+		}
+	}
+	return nil
+}
+
+var (
+	_ api.CASReader     = CASReader(nil)
+	_ sparse.HoleReader = CASReader(nil)
+)
@@ -0,0 +1,221 @@
+// Package overlay merges multiple trees of the intermediate representation into one tree.
+//
+// Layers are merged in order, later layers override earlier ones by path.
+// A layer can be mounted at a sub-path of the merged tree.
+// The conflict policy decides what happens if a path exists in multiple layers.
+package overlay
+
+import (
+	"fmt"
+	stdpath "path"
+	"sort"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/kind"
+)
+
+// Policies for paths that exist in multiple layers.
+const (
+	// PolicyMergeDirs merges directories that exist in multiple layers recursively.
+	// Other nodes of later layers replace the nodes of earlier layers.
+	// The metadata of merged directories is the one of the latest layer.
+	PolicyMergeDirs = "merge-dirs"
+	// PolicyLastWins replaces nodes (including directories and everything below them)
+	// of earlier layers with the nodes of later layers, like a mount hides the mount point.
+	PolicyLastWins = "last-wins"
+	// PolicyKeepFirst keeps the nodes (including directories and everything below them)
+	// of earlier layers and ignores the nodes of later layers.
+	PolicyKeepFirst = "keep-first"
+	// PolicyError merges directories that exist in multiple layers recursively
+	// and fails for all other paths that exist in multiple layers.
+	PolicyError = "error"
+)
+
+// Layer is a tree that is mounted at a path of the merged tree.
+type Layer struct {
+	Tree api.Tree
+	// Mount is the absolute path the root of the tree is mounted at.
+	// By default, the tree is mounted at the root.
+	Mount string
+}
+
+// Merge merges the layers into a new tree.
+// The layers are not modified.
+// Hardlinks of layers are rewritten to the mount point of their layer.
+// Hardlinks whose target is overridden by a later layer are turned into regular files.
+func Merge(policy string, layers ...Layer) (api.Tree, error) {
+	switch policy {
+	case PolicyMergeDirs, PolicyLastWins, PolicyKeepFirst, PolicyError:
+	default:
+		return api.Tree{}, fmt.Errorf("unsupported conflict policy %q", policy)
+	}
+	m := &merger{
+		policy:   policy,
+		tree:     api.Tree{Root: &api.Node{Stat: implicitDir("")}},
+		links:    make(map[string]hardlink),
+		implicit: map[string]struct{}{"/": {}},
+	}
+	for _, layer := range layers {
+		if err := m.mount(layer); err != nil {
+			return api.Tree{}, err
+		}
+	}
+	m.resolveHardlinks()
+	return m.tree, nil
+}
+
+type merger struct {
+	policy string
+	tree   api.Tree
+	// links maps the path of hardlinks in the merged tree to their target in their own layer.
+	links map[string]hardlink
+	// implicit holds the directories that were created for mount points.
+	// They do not conflict with directories of layers.
+	implicit map[string]struct{}
+}
+
+type hardlink struct {
+	// target is the path of the target in the merged tree.
+	target string
+	// stat is the stat of the target in the layer of the hardlink.
+	stat api.Stat
+}
+
+func (m *merger) mount(layer Layer) error {
+	mount := stdpath.Clean("/" + layer.Mount)
+	// parents of the mount point must be directories
+	var parents []string
+	for dir := stdpath.Dir(mount); dir != "/"; dir = stdpath.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		node := coretree.Get(m.tree, parents[i])
+		if node == nil {
+			coretree.Insert(m.tree, stdpath.Dir(parents[i]), implicitDir(stdpath.Base(parents[i])))
+			m.implicit[parents[i]] = struct{}{}
+			continue
+		}
+		if node.Stat.Kind != api.KindDirectory {
+			return fmt.Errorf("mounting at %q: %q is not a directory", mount, parents[i])
+		}
+	}
+	l := &layerMerger{merger: m, layer: layer, mount: mount}
+	return l.merge(mount, layer.Tree.Root)
+}
+
+// layerMerger merges the nodes of a single layer.
+type layerMerger struct {
+	*merger
+	layer Layer
+	mount string
+}
+
+// merge merges the node of the layer into the merged tree at the given path.
+func (l *layerMerger) merge(path string, incoming *api.Node) error {
+	existing := coretree.Get(l.tree, path)
+	if existing == nil {
+		l.insert(path, incoming)
+		return nil
+	}
+	bothDirs := existing.Stat.Kind == api.KindDirectory && incoming.Stat.Kind == api.KindDirectory
+	_, isImplicit := l.implicit[path]
+	delete(l.implicit, path)
+	switch {
+	case bothDirs && (isImplicit || l.policy == PolicyMergeDirs || l.policy == PolicyError):
+		existing.Stat = l.stat(path, incoming.Stat)
+		for _, child := range incoming.Children {
+			if err := l.merge(stdpath.Join(path, child.Stat.Name), child); err != nil {
+				return err
+			}
+		}
+		return nil
+	case l.policy == PolicyError:
+		return fmt.Errorf("conflict at %q: %s replaces %s", path, incoming.Stat.Kind, existing.Stat.Kind)
+	case l.policy == PolicyKeepFirst:
+		return nil
+	}
+	existing.Stat = l.stat(path, incoming.Stat)
+	existing.Children = l.copyChildren(path, incoming)
+	return nil
+}
+
+// insert copies the node of the layer and everything below it into the merged tree.
+func (l *layerMerger) insert(path string, incoming *api.Node) {
+	coretree.Insert(l.tree, stdpath.Dir(path), l.stat(path, incoming.Stat))
+	coretree.Get(l.tree, path).Children = l.copyChildren(path, incoming)
+}
+
+func (l *layerMerger) copyChildren(path string, node *api.Node) []*api.Node {
+	if len(node.Children) == 0 {
+		return nil
+	}
+	children := make([]*api.Node, 0, len(node.Children))
+	for _, child := range node.Children {
+		childPath := stdpath.Join(path, child.Stat.Name)
+		children = append(children, &api.Node{
+			Stat:     l.stat(childPath, child.Stat),
+			Children: l.copyChildren(childPath, child),
+		})
+	}
+	return children
+}
+
+// stat returns the stat of a node of the layer at the given path of the merged tree.
+// Hardlinks are rewritten to the mount point of the layer.
+func (l *layerMerger) stat(path string, stat api.Stat) api.Stat {
+	if path == "/" {
+		stat.Name = ""
+	} else {
+		stat.Name = stdpath.Base(path)
+	}
+	if stat.Kind != kind.Hardlink {
+		delete(l.links, path)
+		return stat
+	}
+	target := stdpath.Clean("/" + stat.Payload)
+	stat.Payload = stdpath.Join(l.mount, target)
+	if targetNode := coretree.Get(l.layer.Tree, target); targetNode != nil {
+		l.links[path] = hardlink{target: stat.Payload, stat: targetNode.Stat}
+	}
+	return stat
+}
+
+// resolveHardlinks turns hardlinks whose target was overridden by a later layer into regular files.
+// Later hardlinks to the same target link to the first one.
+func (m *merger) resolveHardlinks() {
+	paths := make([]string, 0, len(m.links))
+	for path := range m.links {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	relinked := make(map[string]string)
+	for _, path := range paths {
+		link := m.links[path]
+		node := coretree.Get(m.tree, path)
+		if node == nil || node.Stat.Kind != kind.Hardlink || node.Stat.Payload != link.target {
+			continue
+		}
+		target := coretree.Get(m.tree, link.target)
+		if target != nil && target.Stat.Kind == api.KindRegular && target.Stat.Payload == link.stat.Payload {
+			continue
+		}
+		if first, ok := relinked[link.target]; ok {
+			node.Stat.Payload = first
+			continue
+		}
+		relinked[link.target] = path
+		stat := link.stat
+		stat.Name = node.Stat.Name
+		node.Stat = stat
+	}
+}
+
+// implicitDir returns the stat of a directory that is created for a mount point.
+func implicitDir(name string) api.Stat {
+	return api.Stat{
+		Name:       name,
+		Kind:       api.KindDirectory,
+		Attributes: api.NodeAttributes{Mode: "0o755"},
+	}
+}
@@ -0,0 +1,215 @@
+package overlay_test
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+	"github.com/malt3/abstractfs/kind"
+	"github.com/malt3/abstractfs/overlay"
+)
+
+func TestMergePolicies(t *testing.T) {
+	lower := []api.Stat{
+		dir("/etc", "0o755"),
+		regular("/etc/hosts", "sha256-lower-hosts"),
+		regular("/etc/lower", "sha256-lower"),
+		regular("/bin", "sha256-lower-bin"),
+	}
+	upper := []api.Stat{
+		dir("/etc", "0o700"),
+		regular("/etc/hosts", "sha256-upper-hosts"),
+		regular("/etc/upper", "sha256-upper"),
+		dir("/bin", "0o755"),
+		regular("/bin/sh", "sha256-sh"),
+	}
+	testCases := map[string]struct {
+		policy  string
+		want    []string
+		wantErr bool
+	}{
+		overlay.PolicyMergeDirs: {
+			policy: overlay.PolicyMergeDirs,
+			want: []string{
+				"/bin directory 0o755", "/bin/sh regular sha256-sh",
+				"/etc directory 0o700", "/etc/hosts regular sha256-upper-hosts", "/etc/lower regular sha256-lower", "/etc/upper regular sha256-upper",
+			},
+		},
+		overlay.PolicyLastWins: {
+			policy: overlay.PolicyLastWins,
+			want: []string{
+				"/bin directory 0o755", "/bin/sh regular sha256-sh",
+				"/etc directory 0o700", "/etc/hosts regular sha256-upper-hosts", "/etc/upper regular sha256-upper",
+			},
+		},
+		overlay.PolicyKeepFirst: {
+			policy: overlay.PolicyKeepFirst,
+			want: []string{
+				"/bin regular sha256-lower-bin",
+				"/etc directory 0o755", "/etc/hosts regular sha256-lower-hosts", "/etc/lower regular sha256-lower",
+			},
+		},
+		overlay.PolicyError: {
+			policy:  overlay.PolicyError,
+			wantErr: true,
+		},
+		"unsupported": {
+			policy:  "unsupported",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			merged, err := overlay.Merge(tc.policy, layer(lower, ""), layer(upper, ""))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Merge() = %q, want error", describe(merged))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(merged); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Merge() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeErrorPolicyMergesDirs(t *testing.T) {
+	merged, err := overlay.Merge(overlay.PolicyError,
+		layer([]api.Stat{dir("/etc", "0o755"), regular("/etc/a", "sha256-a")}, ""),
+		layer([]api.Stat{dir("/etc", "0o700"), regular("/etc/b", "sha256-b")}, ""),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/etc directory 0o700", "/etc/a regular sha256-a", "/etc/b regular sha256-b"}
+	if got := describe(merged); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %q, want %q", got, want)
+	}
+}
+
+func TestMergeMount(t *testing.T) {
+	testCases := map[string]struct {
+		layers  []overlay.Layer
+		want    []string
+		wantErr bool
+	}{
+		"implicit parents": {
+			layers: []overlay.Layer{layer([]api.Stat{regular("/f", "sha256-f")}, "/opt/app")},
+			want:   []string{"/opt directory 0o755", "/opt/app directory ", "/opt/app/f regular sha256-f"},
+		},
+		"implicit parents do not conflict": {
+			layers: []overlay.Layer{
+				layer([]api.Stat{regular("/f", "sha256-f")}, "/opt/app"),
+				layer([]api.Stat{dir("/opt", "0o700"), regular("/opt/g", "sha256-g")}, ""),
+			},
+			want: []string{"/opt directory 0o700", "/opt/app directory ", "/opt/app/f regular sha256-f", "/opt/g regular sha256-g"},
+		},
+		"mount below a file": {
+			layers: []overlay.Layer{
+				layer([]api.Stat{regular("/opt", "sha256-opt")}, ""),
+				layer([]api.Stat{regular("/f", "sha256-f")}, "/opt/app"),
+			},
+			wantErr: true,
+		},
+		"hardlinks are rewritten to the mount point": {
+			layers: []overlay.Layer{layer([]api.Stat{regular("/f", "sha256-f"), hardlink("/l", "/f")}, "/mnt")},
+			want:   []string{"/mnt directory ", "/mnt/f regular sha256-f", "/mnt/l hardlink /mnt/f"},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			merged, err := overlay.Merge(overlay.PolicyError, tc.layers...)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Merge() = %q, want error", describe(merged))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(merged); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Merge() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeOverriddenHardlinkTarget(t *testing.T) {
+	lower := []api.Stat{
+		regular("/f", "sha256-old"),
+		hardlink("/l1", "/f"),
+		hardlink("/l2", "/f"),
+		regular("/g", "sha256-g"),
+		hardlink("/l3", "/g"),
+	}
+	upper := []api.Stat{regular("/f", "sha256-new")}
+	merged, err := overlay.Merge(overlay.PolicyMergeDirs, layer(lower, ""), layer(upper, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first link keeps the old contents and later links link to it
+	want := []string{
+		"/f regular sha256-new",
+		"/g regular sha256-g",
+		"/l1 regular sha256-old",
+		"/l2 hardlink /l1",
+		"/l3 hardlink /g",
+	}
+	if got := describe(merged); !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %q, want %q", got, want)
+	}
+}
+
+func TestMergeDoesNotModifyLayers(t *testing.T) {
+	lower := coretree.Unflatten(api.Flat{Files: []api.Stat{dir("/etc", "0o755"), regular("/etc/a", "sha256-a")}})
+	upper := coretree.Unflatten(api.Flat{Files: []api.Stat{dir("/etc", "0o700"), regular("/etc/b", "sha256-b")}})
+	before := describe(lower)
+	if _, err := overlay.Merge(overlay.PolicyMergeDirs, overlay.Layer{Tree: lower}, overlay.Layer{Tree: upper}); err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(lower); !reflect.DeepEqual(got, before) {
+		t.Errorf("lower layer changed to %q, want %q", got, before)
+	}
+}
+
+func layer(files []api.Stat, mount string) overlay.Layer {
+	return overlay.Layer{Tree: coretree.Unflatten(api.Flat{Files: files}), Mount: mount}
+}
+
+// describe returns the sorted nodes of the tree without the root as "<path> <kind> <mode or payload>".
+func describe(tree api.Tree) []string {
+	var nodes []string
+	for _, stat := range coretree.Flatten(tree).Files {
+		if stat.Name == "/" {
+			continue
+		}
+		description := stat.Name + " " + stat.Kind + " "
+		if stat.Kind == api.KindDirectory {
+			description += stat.Attributes.Mode
+		} else {
+			description += stat.Payload
+		}
+		nodes = append(nodes, description)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func dir(name, mode string) api.Stat {
+	return api.Stat{Name: name, Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: mode}}
+}
+
+func regular(name, payload string) api.Stat {
+	return api.Stat{Name: name, Kind: api.KindRegular, Payload: payload, Attributes: api.NodeAttributes{Mode: "0o644"}}
+}
+
+func hardlink(name, target string) api.Stat {
+	return api.Stat{Name: name, Kind: kind.Hardlink, Payload: target}
+}
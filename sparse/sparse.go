@@ -0,0 +1,234 @@
+// Package sparse describes the holes of sparse files.
+//
+// The api.Stat of the core module has no field for the layout of a file.
+// The hole map is a property of the file contents instead: sources that know
+// the holes of their files implement HoleReader next to api.CASReader,
+// and sinks look up the holes of a regular file by its payload to write them efficiently.
+// The metadata of nodes (and therefore diffs, transforms and json output) is not affected.
+package sparse
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+)
+
+// HoleReader is implemented by CAS readers that know the holes of the blobs they serve.
+type HoleReader interface {
+	// Holes returns the holes of the blob.
+	// It returns nil if the blob has no holes or the holes are unknown.
+	Holes(sri string) HoleMap
+}
+
+// HolesOf returns the holes of the blob if the CAS reader implements HoleReader.
+func HolesOf(c api.CASReader, sri string) HoleMap {
+	holeReader, ok := c.(HoleReader)
+	if !ok {
+		return nil
+	}
+	return holeReader.Holes(sri)
+}
+
+// Region is a contiguous byte range of a file.
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// HoleMap is a sorted list of non-overlapping holes of a file.
+// Holes are not backed by storage and read as zeros.
+type HoleMap []Region
+
+// String encodes the hole map as comma separated list of "offset:length" pairs.
+func (m HoleMap) String() string {
+	parts := make([]string, len(m))
+	for i, hole := range m {
+		parts[i] = strconv.FormatInt(hole.Offset, 10) + ":" + strconv.FormatInt(hole.Length, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse decodes a hole map in the format produced by HoleMap.String.
+func Parse(raw string) (HoleMap, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	parts := strings.Split(raw, ",")
+	holes := make(HoleMap, 0, len(parts))
+	for _, part := range parts {
+		rawOffset, rawLength, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid hole %q", part)
+		}
+		offset, err := strconv.ParseInt(rawOffset, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hole offset %q: %w", rawOffset, err)
+		}
+		length, err := strconv.ParseInt(rawLength, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hole length %q: %w", rawLength, err)
+		}
+		holes = append(holes, Region{Offset: offset, Length: length})
+	}
+	return holes, nil
+}
+
+// Validate checks that the holes are sorted, non-overlapping and
+// within a file of the given size.
+func (m HoleMap) Validate(size int64) error {
+	var end int64
+	for _, hole := range m {
+		if hole.Offset < end || hole.Length <= 0 {
+			return fmt.Errorf("invalid hole %d:%d", hole.Offset, hole.Length)
+		}
+		end = hole.Offset + hole.Length
+		if end > size || end < 0 {
+			return fmt.Errorf("hole %d:%d exceeds file size %d", hole.Offset, hole.Length, size)
+		}
+	}
+	return nil
+}
+
+// HoleBytes returns the number of bytes in holes.
+func (m HoleMap) HoleBytes() int64 {
+	var total int64
+	for _, hole := range m {
+		total += hole.Length
+	}
+	return total
+}
+
+// Data returns the data regions of a file of the given size.
+// It is the complement of the hole map.
+func (m HoleMap) Data(size int64) []Region {
+	var data []Region
+	var offset int64
+	for _, hole := range m {
+		if hole.Offset > offset {
+			data = append(data, Region{Offset: offset, Length: hole.Offset - offset})
+		}
+		offset = hole.Offset + hole.Length
+	}
+	if offset < size {
+		data = append(data, Region{Offset: offset, Length: size - offset})
+	}
+	return data
+}
+
+// FromData returns the hole map of a file of the given size with the given data regions.
+// Data regions may be unsorted, adjacent or empty.
+func FromData(data []Region, size int64) HoleMap {
+	sorted := make([]Region, len(data))
+	copy(sorted, data)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Offset < sorted[j].Offset })
+	var holes HoleMap
+	var offset int64
+	for _, region := range sorted {
+		if region.Length == 0 {
+			continue
+		}
+		if region.Offset > offset {
+			holes = append(holes, Region{Offset: offset, Length: region.Offset - offset})
+		}
+		if end := region.Offset + region.Length; end > offset {
+			offset = end
+		}
+	}
+	if offset < size {
+		holes = append(holes, Region{Offset: offset, Length: size - offset})
+	}
+	return holes
+}
+
+// FromFS returns the hole map of a regular file of a tree.
+// The holes are looked up by the payload of the file in the CAS reader of a coretree.TreeFS.
+// It returns nil for other file systems or if the holes are unknown.
+func FromFS(in fs.FS, stat api.Stat) (HoleMap, error) {
+	treeFS, ok := in.(*coretree.TreeFS)
+	if !ok || stat.Kind != api.KindRegular {
+		return nil, nil
+	}
+	holes := HolesOf(treeFS.CASReader, stat.Payload)
+	if err := holes.Validate(stat.Size); err != nil {
+		return nil, fmt.Errorf("holes of %s: %w", stat.Payload, err)
+	}
+	return holes, nil
+}
+
+// NewReader expands the data regions of a sparse file into the full file contents.
+// The data reader yields the data regions back to back.
+func NewReader(data io.Reader, holes HoleMap, size int64) io.Reader {
+	var readers []io.Reader
+	var offset int64
+	for _, region := range holes.Data(size) {
+		if region.Offset > offset {
+			readers = append(readers, io.LimitReader(zeroReader{}, region.Offset-offset))
+		}
+		readers = append(readers, io.LimitReader(data, region.Length))
+		offset = region.Offset + region.Length
+	}
+	if offset < size {
+		readers = append(readers, io.LimitReader(zeroReader{}, size-offset))
+	}
+	return io.MultiReader(readers...)
+}
+
+// CopyData copies the data regions of the full file contents in src to dst.
+// The holes in src are skipped.
+func CopyData(dst io.Writer, src io.Reader, holes HoleMap, size int64) error {
+	var offset int64
+	for _, region := range holes.Data(size) {
+		if _, err := io.CopyN(io.Discard, src, region.Offset-offset); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, region.Length); err != nil {
+			return err
+		}
+		offset = region.Offset + region.Length
+	}
+	_, err := io.CopyN(io.Discard, src, size-offset)
+	return err
+}
+
+// File is a file that holes can be punched into by seeking past the end.
+type File interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+}
+
+// Copy writes the full file contents in src to dst, creating holes instead of writing zeros.
+func Copy(dst File, src io.Reader, holes HoleMap, size int64) error {
+	var offset int64
+	for _, region := range holes.Data(size) {
+		if _, err := io.CopyN(io.Discard, src, region.Offset-offset); err != nil {
+			return err
+		}
+		if _, err := dst.Seek(region.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, region.Length); err != nil {
+			return err
+		}
+		offset = region.Offset + region.Length
+	}
+	if _, err := io.CopyN(io.Discard, src, size-offset); err != nil {
+		return err
+	}
+	// a trailing hole is created by extending the file
+	return dst.Truncate(size)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,136 @@
+package transform
+
+import (
+	"fmt"
+	stdpath "path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of the built-in transforms, as used by Parse.
+const (
+	NameMtimeClamp  = "mtime-clamp"
+	NameMtimeSet    = "mtime-set"
+	NameUID         = "uid"
+	NameGID         = "gid"
+	NameUname       = "uname"
+	NameGname       = "gname"
+	NameXAttrsStrip = "xattrs-strip"
+	NameXAttrsAllow = "xattrs-allow"
+	NameUmask       = "umask"
+)
+
+// SourceDateEpochValue is the value of the mtime transforms
+// that uses the time of the SOURCE_DATE_EPOCH environment variable.
+const SourceDateEpochValue = "source-date-epoch"
+
+// Parse parses a transform of the form "name" or "name=value":
+//
+//	mtime-clamp=<time>         clamp mtimes to the time
+//	mtime-set=<time>           set all mtimes to the time
+//	uid=<uid>, gid=<gid>       force the numeric owner
+//	uname=<name>, gname=<name> force the owner names (empty removes them)
+//	xattrs-strip               remove all xattrs
+//	xattrs-allow=<pattern,...> remove all xattrs that match none of the patterns
+//	umask=<octal mask>         clear permission bits
+//
+// A time is a unix timestamp in seconds, an RFC 3339 timestamp
+// or "source-date-epoch" for the time of the SOURCE_DATE_EPOCH environment variable.
+func Parse(spec string) (Transform, error) {
+	name, value, hasValue := strings.Cut(spec, "=")
+	requireValue := func() error {
+		if !hasValue {
+			return fmt.Errorf("transform %q requires a value", name)
+		}
+		return nil
+	}
+	switch name {
+	case NameMtimeClamp, NameMtimeSet:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		t, err := parseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", name, err)
+		}
+		if name == NameMtimeClamp {
+			return ClampMtime(t), nil
+		}
+		return SetMtime(t), nil
+	case NameUID, NameGID:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+			return nil, fmt.Errorf("transform %q: invalid id %q", name, value)
+		}
+		if name == NameUID {
+			return SetUserID(value), nil
+		}
+		return SetGroupID(value), nil
+	case NameUname:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		return SetUserName(value), nil
+	case NameGname:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		return SetGroupName(value), nil
+	case NameXAttrsStrip:
+		if hasValue {
+			return nil, fmt.Errorf("transform %q does not take a value", name)
+		}
+		return StripXAttrs(), nil
+	case NameXAttrsAllow:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		patterns := strings.Split(value, ",")
+		for _, pattern := range patterns {
+			if _, err := stdpath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("transform %q: invalid pattern %q", name, pattern)
+			}
+		}
+		return AllowXAttrs(patterns...), nil
+	case NameUmask:
+		if err := requireValue(); err != nil {
+			return nil, err
+		}
+		mask, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+		if err != nil || mask > 0o7777 {
+			return nil, fmt.Errorf("transform %q: invalid mask %q", name, value)
+		}
+		return Umask(uint32(mask)), nil
+	}
+	return nil, fmt.Errorf("unknown transform %q", name)
+}
+
+// ParseAll parses a list of transforms (see Parse).
+func ParseAll(specs []string) ([]Transform, error) {
+	transforms := make([]Transform, 0, len(specs))
+	for _, spec := range specs {
+		transform, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, transform)
+	}
+	return transforms, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == SourceDateEpochValue {
+		return SourceDateEpoch()
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", value)
+	}
+	return t, nil
+}
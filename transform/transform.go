@@ -0,0 +1,184 @@
+// Package transform modifies the metadata of trees of the intermediate representation.
+//
+// Transforms run between building the tree of a source and consuming it with a sink.
+// The built-in transforms normalize metadata for reproducible outputs:
+// they clamp or set mtimes, force ownership, strip xattrs and apply a umask.
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	stdpath "path"
+	"strconv"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+)
+
+// SourceDateEpochEnv is the environment variable that holds the timestamp
+// of reproducible builds (see https://reproducible-builds.org/specs/source-date-epoch/).
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// Transform modifies a tree.
+type Transform interface {
+	// Apply modifies the tree in place.
+	Apply(tree api.Tree) error
+}
+
+// NodeFunc is a Transform that is called for every node of the tree.
+// The path is the absolute path of the node.
+type NodeFunc func(path string, stat *api.Stat) error
+
+// Apply calls the function for every node of the tree, parents before children.
+func (f NodeFunc) Apply(tree api.Tree) error {
+	return f.walk("/", tree.Root)
+}
+
+func (f NodeFunc) walk(path string, node *api.Node) error {
+	if err := f(path, &node.Stat); err != nil {
+		return fmt.Errorf("transforming %q: %w", path, err)
+	}
+	for _, child := range node.Children {
+		if err := f.walk(stdpath.Join(path, child.Stat.Name), child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply applies the transforms to the tree in order.
+func Apply(tree api.Tree, transforms ...Transform) error {
+	for _, transform := range transforms {
+		if err := transform.Apply(tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClampMtime sets all mtimes later than max to max.
+func ClampMtime(max time.Time) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		if stat.Attributes.Mtime.After(max) {
+			stat.Attributes.Mtime = max
+		}
+		return nil
+	})
+}
+
+// SetMtime sets all mtimes to t.
+func SetMtime(t time.Time) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		stat.Attributes.Mtime = t
+		return nil
+	})
+}
+
+// SourceDateEpoch returns the time of the SOURCE_DATE_EPOCH environment variable.
+func SourceDateEpoch() (time.Time, error) {
+	raw, ok := os.LookupEnv(SourceDateEpochEnv)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s is not set", SourceDateEpochEnv)
+	}
+	seconds, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: %w", SourceDateEpochEnv, raw, err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// SetUserID sets the numeric user id of all nodes.
+func SetUserID(uid string) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		stat.Attributes.UserID = uid
+		return nil
+	})
+}
+
+// SetGroupID sets the numeric group id of all nodes.
+func SetGroupID(gid string) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		stat.Attributes.GroupID = gid
+		return nil
+	})
+}
+
+// SetUserName sets the user name of all nodes.
+// An empty name removes the user name.
+func SetUserName(name string) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		stat.Attributes.UserName = name
+		return nil
+	})
+}
+
+// SetGroupName sets the group name of all nodes.
+// An empty name removes the group name.
+func SetGroupName(name string) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		stat.Attributes.GroupName = name
+		return nil
+	})
+}
+
+// StripXAttrs removes all xattrs.
+func StripXAttrs() Transform {
+	return AllowXAttrs()
+}
+
+// AllowXAttrs removes all xattrs whose key matches none of the patterns.
+// Patterns use the syntax of path.Match (e.g. "user.*" or "security.capability").
+func AllowXAttrs(patterns ...string) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		if len(stat.Attributes.XAttrs) == 0 {
+			return nil
+		}
+		// the map may be shared with other copies of the stat
+		xattrs := make(map[string]string, len(stat.Attributes.XAttrs))
+		for key, value := range stat.Attributes.XAttrs {
+			allowed, err := matchAny(patterns, key)
+			if err != nil {
+				return err
+			}
+			if allowed {
+				xattrs[key] = value
+			}
+		}
+		if len(xattrs) == 0 {
+			xattrs = nil
+		}
+		stat.Attributes.XAttrs = xattrs
+		return nil
+	})
+}
+
+// Umask clears the permission bits of the mask in the mode of all nodes.
+// Symlinks are not changed, since their mode has no meaning.
+func Umask(mask uint32) Transform {
+	return NodeFunc(func(_ string, stat *api.Stat) error {
+		if stat.Attributes.Mode == "" || stat.Kind == api.KindSymlink {
+			return nil
+		}
+		mode, err := strconv.ParseInt(stat.Attributes.Mode, 0, 64)
+		if err != nil {
+			return fmt.Errorf("parsing mode: %w", err)
+		}
+		mode &^= int64(mask & 0o7777)
+		stat.Attributes.Mode = "0o" + strconv.FormatInt(mode, 8)
+		return nil
+	})
+}
+
+func matchAny(patterns []string, key string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := stdpath.Match(pattern, key)
+		if err != nil {
+			return false, errors.New("invalid xattr pattern " + strconv.Quote(pattern))
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
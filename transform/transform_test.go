@@ -0,0 +1,216 @@
+package transform
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/malt3/abstractfs-core/api"
+	coretree "github.com/malt3/abstractfs-core/tree"
+)
+
+var (
+	early = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	late  = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func TestParse(t *testing.T) {
+	file := api.Stat{
+		Name: "/f",
+		Kind: api.KindRegular,
+		Attributes: api.NodeAttributes{
+			Mtime:     late,
+			UserID:    "1000",
+			GroupID:   "100",
+			UserName:  "user",
+			GroupName: "users",
+			Mode:      "0o4777",
+			XAttrs:    map[string]string{"user.a": "a", "security.capability": "cap", "trusted.b": "b"},
+		},
+	}
+	testCases := map[string]struct {
+		spec    string
+		stat    api.Stat
+		env     string
+		want    func(*api.NodeAttributes)
+		wantErr bool
+	}{
+		"clamp unix timestamp": {
+			spec: "mtime-clamp=1577836800",
+			want: func(a *api.NodeAttributes) { a.Mtime = early },
+		},
+		"clamp keeps earlier mtimes": {
+			spec: "mtime-clamp=2031-01-01T00:00:00Z",
+		},
+		"set rfc 3339": {
+			spec: "mtime-set=2020-01-01T00:00:00Z",
+			want: func(a *api.NodeAttributes) { a.Mtime = early },
+		},
+		"set source date epoch": {
+			spec: "mtime-set=source-date-epoch",
+			env:  "1577836800",
+			want: func(a *api.NodeAttributes) { a.Mtime = early },
+		},
+		"invalid source date epoch": {
+			spec:    "mtime-set=source-date-epoch",
+			env:     "yesterday",
+			wantErr: true,
+		},
+		"invalid time": {
+			spec:    "mtime-clamp=yesterday",
+			wantErr: true,
+		},
+		"missing time": {
+			spec:    "mtime-set",
+			wantErr: true,
+		},
+		"uid": {
+			spec: "uid=0",
+			want: func(a *api.NodeAttributes) { a.UserID = "0" },
+		},
+		"gid": {
+			spec: "gid=0",
+			want: func(a *api.NodeAttributes) { a.GroupID = "0" },
+		},
+		"invalid uid": {
+			spec:    "uid=root",
+			wantErr: true,
+		},
+		"uname": {
+			spec: "uname=root",
+			want: func(a *api.NodeAttributes) { a.UserName = "root" },
+		},
+		"empty gname": {
+			spec: "gname=",
+			want: func(a *api.NodeAttributes) { a.GroupName = "" },
+		},
+		"strip xattrs": {
+			spec: "xattrs-strip",
+			want: func(a *api.NodeAttributes) { a.XAttrs = nil },
+		},
+		"strip xattrs with value": {
+			spec:    "xattrs-strip=user.*",
+			wantErr: true,
+		},
+		"allow xattrs": {
+			spec: "xattrs-allow=user.*,security.capability",
+			want: func(a *api.NodeAttributes) {
+				a.XAttrs = map[string]string{"user.a": "a", "security.capability": "cap"}
+			},
+		},
+		"allow no matching xattrs": {
+			spec: "xattrs-allow=system.*",
+			want: func(a *api.NodeAttributes) { a.XAttrs = nil },
+		},
+		"invalid xattr pattern": {
+			spec:    "xattrs-allow=[",
+			wantErr: true,
+		},
+		"umask": {
+			spec: "umask=022",
+			want: func(a *api.NodeAttributes) { a.Mode = "0o4755" },
+		},
+		"umask with prefix clears special bits": {
+			spec: "umask=0o4022",
+			want: func(a *api.NodeAttributes) { a.Mode = "0o755" },
+		},
+		"umask keeps symlinks": {
+			spec: "umask=022",
+			stat: api.Stat{Name: "/f", Kind: api.KindSymlink, Payload: "target", Attributes: api.NodeAttributes{Mode: "0o777"}},
+		},
+		"invalid umask": {
+			spec:    "umask=8",
+			wantErr: true,
+		},
+		"umask too large": {
+			spec:    "umask=17777",
+			wantErr: true,
+		},
+		"unknown": {
+			spec:    "chmod=755",
+			wantErr: true,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if tc.env != "" {
+				t.Setenv(SourceDateEpochEnv, tc.env)
+			}
+			stat := tc.stat
+			if stat.Name == "" {
+				stat = file
+			}
+			transform, err := Parse(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) succeeded, want error", tc.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the tree shares the xattrs map of the stat, which must not be modified
+			xattrs := make(map[string]string)
+			for key, value := range stat.Attributes.XAttrs {
+				xattrs[key] = value
+			}
+			tree := coretree.Unflatten(api.Flat{Files: []api.Stat{stat}})
+			if err := transform.Apply(tree); err != nil {
+				t.Fatal(err)
+			}
+			want := stat.Attributes
+			if tc.want != nil {
+				tc.want(&want)
+			}
+			if got := coretree.Get(tree, "/f").Stat.Attributes; !reflect.DeepEqual(got, want) {
+				t.Errorf("got attributes %+v, want %+v", got, want)
+			}
+			if len(stat.Attributes.XAttrs) != len(xattrs) {
+				t.Errorf("xattrs of the input changed to %v", stat.Attributes.XAttrs)
+			}
+		})
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	transforms, err := ParseAll([]string{"mtime-set=1577836800", "uid=0", "umask=077"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := coretree.Unflatten(api.Flat{Files: []api.Stat{
+		{Name: "/", Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755"}},
+		{Name: "/d", Kind: api.KindDirectory, Attributes: api.NodeAttributes{Mode: "0o755", Mtime: late}},
+		{Name: "/d/f", Kind: api.KindRegular, Attributes: api.NodeAttributes{Mode: "0o644", Mtime: late, UserID: "1000"}},
+	}})
+	if err := Apply(tree, transforms...); err != nil {
+		t.Fatal(err)
+	}
+	// every node is transformed, including the root
+	for _, stat := range coretree.Flatten(tree).Files {
+		attributes := stat.Attributes
+		if !attributes.Mtime.Equal(early) || attributes.UserID != "0" || (attributes.Mode != "0o700" && attributes.Mode != "0o600") {
+			t.Errorf("%s has attributes %+v, want all transforms applied", stat.Name, attributes)
+		}
+	}
+
+	if _, err := ParseAll([]string{"uid=0", "unknown"}); err == nil {
+		t.Error("ParseAll() succeeded with an unknown transform")
+	}
+}
+
+func TestSourceDateEpochUnset(t *testing.T) {
+	t.Setenv(SourceDateEpochEnv, "")
+	if _, err := Parse("mtime-clamp=source-date-epoch"); err == nil {
+		t.Error("Parse() succeeded with an empty SOURCE_DATE_EPOCH")
+	}
+}
+
+func TestInvalidMode(t *testing.T) {
+	tree := coretree.Unflatten(api.Flat{Files: []api.Stat{
+		{Name: "/f", Kind: api.KindRegular, Attributes: api.NodeAttributes{Mode: "rwxr-xr-x"}},
+	}})
+	if err := Umask(0o022).Apply(tree); err == nil {
+		t.Error("Apply() succeeded for an invalid mode")
+	}
+}